@@ -0,0 +1,107 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activeactive
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/echovault/sugardb/internal/clock"
+)
+
+// Timestamp is a hybrid logical clock timestamp. Timestamps are totally ordered by wall time, then logical
+// counter, then site ID, so two sites never produce equal timestamps.
+type Timestamp struct {
+	Wall    int64  // Unix milliseconds.
+	Logical uint32 // Orders the events that happen within the same millisecond.
+	Site    string
+}
+
+// Compare returns -1 if ts is before other, 1 if it's after and 0 if they're equal.
+func (ts Timestamp) Compare(other Timestamp) int {
+	if c := cmp.Compare(ts.Wall, other.Wall); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(ts.Logical, other.Logical); c != 0 {
+		return c
+	}
+	return strings.Compare(ts.Site, other.Site)
+}
+
+// IsZero returns true for the zero timestamp, which is before any timestamp produced by a clock.
+func (ts Timestamp) IsZero() bool {
+	return ts == Timestamp{}
+}
+
+// String encodes the timestamp as <wall>.<logical>.<site>.
+func (ts Timestamp) String() string {
+	return fmt.Sprintf("%d.%d.%s", ts.Wall, ts.Logical, ts.Site)
+}
+
+// ParseTimestamp decodes a timestamp encoded with Timestamp.String.
+func ParseTimestamp(s string) (Timestamp, error) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) != 3 {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	wall, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	logical, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return Timestamp{Wall: wall, Logical: uint32(logical), Site: parts[2]}, nil
+}
+
+// Clock is a hybrid logical clock. Its timestamps follow the physical clock, but never go backwards and are
+// always after the timestamps received from other sites, so a write is always ordered after the writes it observed.
+type Clock struct {
+	mut   sync.Mutex
+	site  string
+	clock clock.Clock
+	last  Timestamp
+}
+
+// NewClock returns a hybrid logical clock for the site.
+func NewClock(site string, clock clock.Clock) *Clock {
+	return &Clock{site: site, clock: clock}
+}
+
+// Now returns a timestamp after every timestamp returned or observed so far.
+func (c *Clock) Now() Timestamp {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	wall := c.clock.Now().UnixMilli()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall, Site: c.site}
+	} else {
+		c.last = Timestamp{Wall: c.last.Wall, Logical: c.last.Logical + 1, Site: c.site}
+	}
+	return c.last
+}
+
+// Observe advances the clock past a timestamp received from another site.
+func (c *Clock) Observe(ts Timestamp) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if ts.Wall > c.last.Wall || (ts.Wall == c.last.Wall && ts.Logical > c.last.Logical) {
+		c.last = Timestamp{Wall: ts.Wall, Logical: ts.Logical, Site: c.site}
+	}
+}
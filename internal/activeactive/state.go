@@ -0,0 +1,743 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package activeactive implements the conflict resolution of active-active replication between sites.
+//
+// Each site records the effect of its local writes as a stream of effects, stamped with a hybrid logical clock,
+// which its peers pull and merge into their own state. Effects are merged per type, so that every site
+// converges to the same state regardless of the order in which concurrent effects are received:
+//
+//	Strings    Last writer wins on the whole value.
+//	Sets       Add wins: an element added concurrently with its removal is kept.
+//	Hashes     Last writer wins on each field.
+//	Counters   The INCR family updates a PN counter per site, which is added to the last written value.
+//
+// A write that changes the type of a key replaces the key if it's the latest write to the key.
+//
+// The effects are encoded as commands, where each timestamp is encoded with Timestamp.String:
+//
+//	REG <key> <timestamp> <value> <expire-at-ms>
+//	DEL <key> <timestamp>
+//	SADD <key> <tag> <element> [<element> ...]
+//	SREM <key> <element> [<tag> ...]
+//	HSET <key> <timestamp> <field> <value> [<field> <value> ...]
+//	HDEL <key> <timestamp> <field> [<field> ...]
+//	CNT <key> <base-timestamp> <site> <increments> <decrements>
+//
+// The metadata of deleted keys, deleted hash fields and counters added to replaced writes is only needed to order
+// the effects written before them, so it's pruned once it's older than the delay of any effect still in flight.
+package activeactive
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/echovault/sugardb/internal"
+)
+
+// Kind is the type of a key, as far as conflict resolution is concerned.
+type Kind int
+
+const (
+	KindNone   Kind = iota // The key doesn't exist.
+	KindString             // Strings, integers and floats.
+	KindSet
+	KindHash
+	KindOther // Types that are not replicated.
+)
+
+// Value is a copy of the value of a key.
+type Value struct {
+	Kind     Kind
+	String   string
+	ExpireAt int64 // Unix milliseconds, 0 when the key doesn't expire.
+	Members  []string
+	Fields   map[string]string
+}
+
+var errMalformedEffect = errors.New("malformed active-active effect")
+
+var (
+	keyStateSize   = int64(unsafe.Sizeof(keyState{}))
+	timestampSize  = int64(unsafe.Sizeof(Timestamp{}))
+	counterSize    = int64(unsafe.Sizeof(counter{}))
+	fieldStateSize = int64(unsafe.Sizeof(fieldState{}))
+)
+
+type counter struct {
+	increments int64
+	decrements int64
+}
+
+type fieldState struct {
+	written Timestamp
+	deleted bool
+}
+
+// keyState holds the conflict resolution metadata of a key.
+type keyState struct {
+	kind    Kind
+	latest  Timestamp // The latest timestamp of any write to the key.
+	written Timestamp // The timestamp of the latest write that replaced the whole key.
+	deleted bool      // Whether the latest write that replaced the whole key deleted it.
+	value   string    // The value written at the written timestamp, to which the counters are added.
+	// counters holds the PN counter of each site, by the timestamp of the write they're added to.
+	counters map[Timestamp]map[string]counter
+	// elements holds the add tags of each set element that weren't removed. Elements without tags are removed.
+	elements map[string]map[Timestamp]struct{}
+	fields   map[string]fieldState
+	mem      int64 // The memory accounted for the metadata of the key.
+}
+
+// newer returns true if a write at ts replaces the whole key. Writes replace keys that were never written,
+// even with the zero timestamp of untracked values.
+func (ks *keyState) newer(ts Timestamp) bool {
+	c := ts.Compare(ks.latest)
+	return c > 0 || (c == 0 && ks.kind == KindNone)
+}
+
+func (ks *keyState) observe(ts Timestamp) {
+	if ts.Compare(ks.latest) > 0 {
+		ks.latest = ts
+	}
+}
+
+// replace resets the key to the kind, for a write at ts that replaces the whole key.
+func (ks *keyState) replace(kind Kind, ts Timestamp) {
+	ks.kind = kind
+	ks.written = ts
+	ks.deleted = false
+	ks.value = ""
+	ks.elements = make(map[string]map[Timestamp]struct{})
+	ks.fields = make(map[string]fieldState)
+	// Only keep the counters added to this write, which may have been received before it.
+	for base := range ks.counters {
+		if base != ts {
+			delete(ks.counters, base)
+		}
+	}
+	ks.observe(ts)
+}
+
+// deletedKey returns true if the key doesn't exist, in which case its metadata only orders the writes made before
+// its latest write. Keys of the types that are not replicated don't hold any ordering. Counters are kept until
+// they're pruned, as they may be added to a write that hasn't been received yet.
+func (ks *keyState) deletedKey() bool {
+	if len(ks.counters) > 0 {
+		return false
+	}
+	switch ks.kind {
+	case KindString:
+		return ks.deleted
+	case KindSet:
+		return len(ks.elements) == 0
+	case KindHash:
+		for _, f := range ks.fields {
+			if !f.deleted {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// size returns the memory used by the metadata of the key, apart from its entry in the map of the database.
+// The site IDs of the timestamps are shared with the clock, so only their headers are counted.
+func (ks *keyState) size(key string) int64 {
+	size := int64(len(key)) + keyStateSize + int64(len(ks.value))
+	size += internal.MapSize(len(ks.counters), timestampSize, 8)
+	for _, sites := range ks.counters {
+		size += internal.MapSize(len(sites), internal.StringHeaderSize, counterSize)
+		for site := range sites {
+			size += int64(len(site))
+		}
+	}
+	size += internal.MapSize(len(ks.elements), internal.StringHeaderSize, 8)
+	for member, tags := range ks.elements {
+		size += int64(len(member)) + internal.MapSize(len(tags), timestampSize, 0)
+	}
+	size += internal.MapSize(len(ks.fields), internal.StringHeaderSize, fieldStateSize)
+	for field := range ks.fields {
+		size += int64(len(field))
+	}
+	return size
+}
+
+// counterValue returns the value of the key with the counters added, and false if the value is not an integer.
+func (ks *keyState) counterValue() (string, bool) {
+	base := int64(0)
+	if !ks.deleted && ks.kind == KindString {
+		var err error
+		if base, err = strconv.ParseInt(ks.value, 10, 64); err != nil {
+			return "", false
+		}
+	}
+	for _, c := range ks.counters[ks.written] {
+		base += c.increments - c.decrements
+	}
+	return strconv.FormatInt(base, 10), true
+}
+
+// State holds the conflict resolution metadata of every key of a site.
+// The metadata is kept in memory, so it's rebuilt from the values of the keys after a restart.
+type State struct {
+	mut       sync.Mutex
+	site      string
+	clock     *Clock
+	databases map[int]map[string]*keyState
+	bytes     int64 // The memory accounted for the metadata of all the keys.
+}
+
+// NewState returns an empty state for the site.
+func NewState(site string, clock *Clock) *State {
+	return &State{
+		site:      site,
+		clock:     clock,
+		databases: make(map[int]map[string]*keyState),
+	}
+}
+
+// get returns the metadata of the key, initialised from its current value if the key is not tracked yet.
+// Untracked values are older than any write from a peer, and set elements are tagged with the zero timestamp
+// of this site so that their removal can be shipped.
+func (state *State) get(database int, key string, current Value) *keyState {
+	if state.databases[database] == nil {
+		state.databases[database] = make(map[string]*keyState)
+	}
+	ks, ok := state.databases[database][key]
+	if ok {
+		return ks
+	}
+	ks = &keyState{counters: make(map[Timestamp]map[string]counter)}
+	ks.replace(current.Kind, Timestamp{})
+	switch current.Kind {
+	case KindNone:
+		ks.deleted = true
+	case KindString:
+		ks.value = current.String
+	case KindSet:
+		tag := Timestamp{Site: state.site}
+		for _, member := range current.Members {
+			ks.elements[member] = map[Timestamp]struct{}{tag: {}}
+		}
+	case KindHash:
+		for field := range current.Fields {
+			ks.fields[field] = fieldState{}
+		}
+	}
+	state.databases[database][key] = ks
+	return ks
+}
+
+// account updates the memory accounted for the metadata of the key. As the metadata is mutated in place, it must be
+// called after every change to the metadata of the key.
+func (state *State) account(ks *keyState, key string) {
+	size := ks.size(key)
+	state.bytes += size - ks.mem
+	ks.mem = size
+}
+
+// Prune removes the metadata that only orders the writes made before the cutoff, in Unix milliseconds: the keys
+// deleted before the cutoff, the hash fields deleted before it and the counters added to writes the key no longer
+// holds. An effect written before the cutoff and received afterwards is merged as if the key, field or counter
+// had never been written. It returns the number of keys removed.
+func (state *State) Prune(cutoff int64) int {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+
+	pruned := 0
+	for database, keys := range state.databases {
+		for key, ks := range keys {
+			for base := range ks.counters {
+				if base != ks.written && base.Wall < cutoff {
+					delete(ks.counters, base)
+				}
+			}
+			for field, f := range ks.fields {
+				if f.deleted && f.written.Wall < cutoff {
+					delete(ks.fields, field)
+				}
+			}
+			if ks.latest.Wall < cutoff && ks.deletedKey() {
+				state.bytes -= ks.mem
+				delete(keys, key)
+				pruned++
+				continue
+			}
+			state.account(ks, key)
+		}
+		if len(keys) == 0 {
+			delete(state.databases, database)
+		}
+	}
+	return pruned
+}
+
+// Stats returns the number of keys with metadata and the memory used by the metadata.
+func (state *State) Stats() (int, int64) {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+
+	keys, bytes := 0, state.bytes+internal.MapSize(len(state.databases), 8, 8)
+	for _, db := range state.databases {
+		keys += len(db)
+		bytes += internal.MapSize(len(db), internal.StringHeaderSize, 8)
+	}
+	return keys, bytes
+}
+
+// Local records the write of a key by a local command, from the before value to the after value, and returns
+// the effects to ship to the peers.
+func (state *State) Local(database int, key string, before, after Value, command []string) [][]string {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+
+	ks := state.get(database, key, before)
+	defer state.account(ks, key)
+	name := strings.ToUpper(command[0])
+	increment := slices.Contains([]string{"INCR", "INCRBY", "DECR", "DECRBY"}, name)
+
+	if increment && after.Kind == KindString && (before.Kind == KindNone || before.Kind == KindString) {
+		prev := int64(0)
+		if before.Kind == KindString {
+			prev, _ = strconv.ParseInt(before.String, 10, 64)
+		}
+		next, err := strconv.ParseInt(after.String, 10, 64)
+		if err == nil && ks.kind != KindSet && ks.kind != KindHash {
+			ks.kind = KindString
+			if ks.counters[ks.written] == nil {
+				ks.counters[ks.written] = make(map[string]counter)
+			}
+			c := ks.counters[ks.written][state.site]
+			if delta := next - prev; delta > 0 {
+				c.increments += delta
+			} else {
+				c.decrements -= delta
+			}
+			ks.counters[ks.written][state.site] = c
+			return [][]string{{"CNT", key, ks.written.String(), state.site,
+				strconv.FormatInt(c.increments, 10), strconv.FormatInt(c.decrements, 10)}}
+		}
+	}
+
+	switch after.Kind {
+	case KindNone:
+		return state.localDelete(ks, key, before)
+	case KindString:
+		if before.Kind == KindString && before.String == after.String && before.ExpireAt == after.ExpireAt {
+			return nil
+		}
+		ts := state.clock.Now()
+		ks.replace(KindString, ts)
+		ks.value = after.String
+		return [][]string{{"REG", key, ts.String(), after.String, strconv.FormatInt(after.ExpireAt, 10)}}
+	case KindSet:
+		ts := state.clock.Now()
+		var effects [][]string
+		added := after.Members
+		if before.Kind == KindSet {
+			effects = state.removeElements(ks, key, difference(before.Members, after.Members))
+			added = difference(after.Members, before.Members)
+			if name == "SADD" && len(command) > 2 && command[1] == key {
+				// Adding an existing element tags it again, so that the addition wins over a concurrent removal.
+				added = slices.Compact(slices.Sorted(slices.Values(command[2:])))
+			}
+		} else {
+			ks.replace(KindSet, ts)
+		}
+		if len(added) > 0 {
+			for _, member := range added {
+				if ks.elements[member] == nil {
+					ks.elements[member] = make(map[Timestamp]struct{})
+				}
+				ks.elements[member][ts] = struct{}{}
+			}
+			ks.observe(ts)
+			effects = append(effects, append([]string{"SADD", key, ts.String()}, added...))
+		}
+		return effects
+	case KindHash:
+		ts := state.clock.Now()
+		var effects [][]string
+		fields := after.Fields
+		if before.Kind != KindHash {
+			ks.replace(KindHash, ts)
+		} else {
+			fields = make(map[string]string)
+			for field, value := range after.Fields {
+				if prev, ok := before.Fields[field]; !ok || prev != value {
+					fields[field] = value
+				}
+			}
+			var removed []string
+			for field := range before.Fields {
+				if _, ok := after.Fields[field]; !ok {
+					removed = append(removed, field)
+				}
+			}
+			if len(removed) > 0 {
+				slices.Sort(removed)
+				for _, field := range removed {
+					ks.fields[field] = fieldState{written: ts, deleted: true}
+				}
+				effects = append(effects, append([]string{"HDEL", key, ts.String()}, removed...))
+			}
+		}
+		if len(fields) > 0 {
+			effect := []string{"HSET", key, ts.String()}
+			for _, field := range slices.Sorted(maps.Keys(fields)) {
+				ks.fields[field] = fieldState{written: ts}
+				effect = append(effect, field, fields[field])
+			}
+			effects = append(effects, effect)
+		}
+		ks.observe(ts)
+		return effects
+	}
+	return nil
+}
+
+func (state *State) localDelete(ks *keyState, key string, before Value) [][]string {
+	switch before.Kind {
+	case KindString:
+		ts := state.clock.Now()
+		ks.replace(KindString, ts)
+		ks.deleted = true
+		return [][]string{{"DEL", key, ts.String()}}
+	case KindSet:
+		// Only remove the elements observed by this site, so that concurrent additions win.
+		return state.removeElements(ks, key, before.Members)
+	case KindHash:
+		if len(before.Fields) == 0 {
+			return nil
+		}
+		ts := state.clock.Now()
+		removed := slices.Sorted(maps.Keys(before.Fields))
+		for _, field := range removed {
+			ks.fields[field] = fieldState{written: ts, deleted: true}
+		}
+		ks.observe(ts)
+		return [][]string{append([]string{"HDEL", key, ts.String()}, removed...)}
+	}
+	return nil
+}
+
+func (state *State) removeElements(ks *keyState, key string, members []string) [][]string {
+	effects := make([][]string, 0, len(members))
+	for _, member := range members {
+		effect := []string{"SREM", key, member}
+		for tag := range ks.elements[member] {
+			effect = append(effect, tag.String())
+		}
+		delete(ks.elements, member)
+		effects = append(effects, effect)
+	}
+	return effects
+}
+
+// Apply merges an effect received from a peer into the state of a key. The current value of the key is used
+// when the key is not tracked yet. It returns the commands that bring the value of the key in line with the
+// merged state.
+func (state *State) Apply(database int, effect []string, current Value) ([][]string, error) {
+	if len(effect) < 2 {
+		return nil, errMalformedEffect
+	}
+	key := effect[1]
+
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	ks := state.get(database, key, current)
+	defer state.account(ks, key)
+
+	switch strings.ToUpper(effect[0]) {
+	case "REG":
+		if len(effect) != 5 {
+			return nil, errMalformedEffect
+		}
+		ts, err := state.timestamp(effect[2])
+		if err != nil {
+			return nil, err
+		}
+		expireAt, err := strconv.ParseInt(effect[4], 10, 64)
+		if err != nil {
+			return nil, errMalformedEffect
+		}
+		if !ks.newer(ts) {
+			return nil, nil
+		}
+		ks.replace(KindString, ts)
+		ks.value = effect[3]
+		value := ks.value
+		if len(ks.counters[ts]) > 0 {
+			if v, ok := ks.counterValue(); ok {
+				value = v
+			}
+		}
+		commands := replaceCommands(key, current, KindString)
+		if expireAt > 0 {
+			return append(commands, []string{"SET", key, value, "PXAT", strconv.FormatInt(expireAt, 10)}), nil
+		}
+		commands = append(commands, []string{"SET", key, value})
+		if current.ExpireAt > 0 && current.Kind == KindString {
+			commands = append(commands, []string{"PERSIST", key})
+		}
+		return commands, nil
+
+	case "DEL":
+		if len(effect) != 3 {
+			return nil, errMalformedEffect
+		}
+		ts, err := state.timestamp(effect[2])
+		if err != nil {
+			return nil, err
+		}
+		if !ks.newer(ts) {
+			return nil, nil
+		}
+		ks.replace(KindString, ts)
+		ks.deleted = true
+		if v, ok := ks.counterValue(); ok && len(ks.counters[ts]) > 0 {
+			return append(replaceCommands(key, current, KindString), []string{"SET", key, v}), nil
+		}
+		if current.Kind == KindNone {
+			return nil, nil
+		}
+		return [][]string{{"DEL", key}}, nil
+
+	case "SADD":
+		if len(effect) < 4 {
+			return nil, errMalformedEffect
+		}
+		tag, err := state.timestamp(effect[2])
+		if err != nil {
+			return nil, err
+		}
+		var commands [][]string
+		if ks.kind != KindSet {
+			if !ks.newer(tag) {
+				return nil, nil
+			}
+			ks.replace(KindSet, tag)
+			commands = replaceCommands(key, current, KindSet)
+		}
+		var added []string
+		for _, member := range effect[3:] {
+			if len(ks.elements[member]) == 0 {
+				ks.elements[member] = make(map[Timestamp]struct{})
+				added = append(added, member)
+			}
+			ks.elements[member][tag] = struct{}{}
+		}
+		ks.observe(tag)
+		if len(added) > 0 {
+			commands = append(commands, append([]string{"SADD", key}, added...))
+		}
+		return commands, nil
+
+	case "SREM":
+		if len(effect) < 3 {
+			return nil, errMalformedEffect
+		}
+		member := effect[2]
+		if ks.kind != KindSet || len(ks.elements[member]) == 0 {
+			return nil, nil
+		}
+		for _, s := range effect[3:] {
+			tag, err := ParseTimestamp(s)
+			if err != nil {
+				return nil, errMalformedEffect
+			}
+			delete(ks.elements[member], tag)
+		}
+		if len(ks.elements[member]) > 0 {
+			// The element was added concurrently, so the addition wins.
+			return nil, nil
+		}
+		delete(ks.elements, member)
+		if len(ks.elements) == 0 {
+			// Removing every element deletes the set, as a set can only be emptied by a delete on another site
+			// after the removals it observed.
+			return [][]string{{"DEL", key}}, nil
+		}
+		return [][]string{{"SREM", key, member}}, nil
+
+	case "HSET", "HDEL":
+		set := strings.EqualFold(effect[0], "HSET")
+		if len(effect) < 4 || (set && len(effect)%2 != 1) {
+			return nil, errMalformedEffect
+		}
+		ts, err := state.timestamp(effect[2])
+		if err != nil {
+			return nil, err
+		}
+		var commands [][]string
+		if ks.kind != KindHash {
+			if !ks.newer(ts) {
+				return nil, nil
+			}
+			ks.replace(KindHash, ts)
+			commands = replaceCommands(key, current, KindHash)
+			current = Value{Kind: KindHash, Fields: map[string]string{}}
+		}
+		ks.observe(ts)
+		if set {
+			command := []string{"HSET", key}
+			for i := 3; i < len(effect); i += 2 {
+				field := effect[i]
+				if f, ok := ks.fields[field]; !ok || ts.Compare(f.written) > 0 {
+					ks.fields[field] = fieldState{written: ts}
+					command = append(command, field, effect[i+1])
+				}
+			}
+			if len(command) > 2 {
+				commands = append(commands, command)
+			}
+			return commands, nil
+		}
+		command := []string{"HDEL", key}
+		for _, field := range effect[3:] {
+			if f, ok := ks.fields[field]; !ok || ts.Compare(f.written) > 0 {
+				ks.fields[field] = fieldState{written: ts, deleted: true}
+				if _, ok := current.Fields[field]; ok {
+					command = append(command, field)
+				}
+			}
+		}
+		if len(command) > 2 {
+			commands = append(commands, command)
+		}
+		return commands, nil
+
+	case "CNT":
+		if len(effect) != 6 {
+			return nil, errMalformedEffect
+		}
+		base, err := state.timestamp(effect[2])
+		if err != nil {
+			return nil, err
+		}
+		increments, err := strconv.ParseInt(effect[4], 10, 64)
+		if err != nil {
+			return nil, errMalformedEffect
+		}
+		decrements, err := strconv.ParseInt(effect[5], 10, 64)
+		if err != nil {
+			return nil, errMalformedEffect
+		}
+		if ks.kind == KindSet || ks.kind == KindHash || base.Compare(ks.written) < 0 {
+			// The counter was reset by a later write.
+			return nil, nil
+		}
+		if ks.counters[base] == nil {
+			ks.counters[base] = make(map[string]counter)
+		}
+		// Each site only ever grows its own counter, so the latest state is the largest.
+		c := ks.counters[base][effect[3]]
+		c.increments = max(c.increments, increments)
+		c.decrements = max(c.decrements, decrements)
+		ks.counters[base][effect[3]] = c
+		if base != ks.written {
+			// The write the counter is added to hasn't been received yet.
+			return nil, nil
+		}
+		ks.kind = KindString
+		value, ok := ks.counterValue()
+		if !ok || (current.Kind == KindString && current.String == value) {
+			return nil, nil
+		}
+		return [][]string{{"SET", key, value}}, nil
+	}
+
+	return nil, fmt.Errorf("unknown active-active effect %s", effect[0])
+}
+
+// Snapshot returns the effects that reproduce the state of the key at a peer.
+func (state *State) Snapshot(database int, key string, current Value) [][]string {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	ks := state.get(database, key, current)
+	defer state.account(ks, key)
+
+	var effects [][]string
+	switch current.Kind {
+	case KindString:
+		value := ks.value
+		if ks.deleted {
+			value = "0"
+		}
+		effects = append(effects, []string{"REG", key, ks.written.String(), value, strconv.FormatInt(current.ExpireAt, 10)})
+		for _, site := range slices.Sorted(maps.Keys(ks.counters[ks.written])) {
+			c := ks.counters[ks.written][site]
+			effects = append(effects, []string{"CNT", key, ks.written.String(), site,
+				strconv.FormatInt(c.increments, 10), strconv.FormatInt(c.decrements, 10)})
+		}
+	case KindSet:
+		for _, member := range current.Members {
+			for tag := range ks.elements[member] {
+				effects = append(effects, []string{"SADD", key, tag.String(), member})
+			}
+		}
+	case KindHash:
+		for _, field := range slices.Sorted(maps.Keys(ks.fields)) {
+			f := ks.fields[field]
+			if value, ok := current.Fields[field]; ok && !f.deleted {
+				effects = append(effects, []string{"HSET", key, f.written.String(), field, value})
+			} else if f.deleted {
+				effects = append(effects, []string{"HDEL", key, f.written.String(), field})
+			}
+		}
+	}
+	return effects
+}
+
+// timestamp parses a timestamp received from a peer and advances the clock past it.
+func (state *State) timestamp(s string) (Timestamp, error) {
+	ts, err := ParseTimestamp(s)
+	if err != nil {
+		return Timestamp{}, errMalformedEffect
+	}
+	state.clock.Observe(ts)
+	return ts, nil
+}
+
+// replaceCommands returns the commands that delete the current value of the key when its kind changes.
+func replaceCommands(key string, current Value, kind Kind) [][]string {
+	if current.Kind == KindNone || current.Kind == kind {
+		return nil
+	}
+	return [][]string{{"DEL", key}}
+}
+
+// difference returns the members of a that are not in b.
+func difference(a, b []string) []string {
+	set := make(map[string]struct{}, len(b))
+	for _, member := range b {
+		set[member] = struct{}{}
+	}
+	var diff []string
+	for _, member := range a {
+		if _, ok := set[member]; !ok {
+			diff = append(diff, member)
+		}
+	}
+	return diff
+}
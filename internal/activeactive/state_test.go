@@ -0,0 +1,366 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package activeactive
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// site simulates the keyspace of a site with the commands returned by State.Apply.
+type site struct {
+	clock   *testClock
+	state   *State
+	values  map[string]Value
+	effects [][]string // The effects of the local writes, to be sent to the other sites.
+}
+
+func newSite(id string, now time.Time) *site {
+	c := &testClock{now: now}
+	return &site{
+		clock:  c,
+		state:  NewState(id, NewClock(id, c)),
+		values: make(map[string]Value),
+	}
+}
+
+// write applies a local command that changes the key to the after value.
+func (s *site) write(key string, after Value, command ...string) {
+	before := s.values[key]
+	if after.Kind == KindNone {
+		delete(s.values, key)
+	} else {
+		s.values[key] = after
+	}
+	s.effects = append(s.effects, s.state.Local(0, key, before, after, command)...)
+}
+
+func (s *site) receive(t *testing.T, effects [][]string) {
+	t.Helper()
+	for _, effect := range effects {
+		commands, err := s.state.Apply(0, effect, s.values[effect[1]])
+		if err != nil {
+			t.Fatalf("apply %v: %v", effect, err)
+		}
+		for _, command := range commands {
+			s.execute(command)
+		}
+	}
+}
+
+func (s *site) execute(command []string) {
+	key := command[1]
+	value := s.values[key]
+	switch command[0] {
+	case "SET":
+		value = Value{Kind: KindString, String: command[2], ExpireAt: value.ExpireAt}
+		if len(command) == 5 {
+			value.ExpireAt, _ = strconv.ParseInt(command[4], 10, 64)
+		}
+	case "PERSIST":
+		value.ExpireAt = 0
+	case "DEL":
+		value = Value{}
+	case "SADD":
+		value.Kind = KindSet
+		value.Members = slices.Compact(slices.Sorted(slices.Values(append(slices.Clone(value.Members), command[2:]...))))
+	case "SREM":
+		value.Members = slices.DeleteFunc(slices.Clone(value.Members), func(member string) bool {
+			return slices.Contains(command[2:], member)
+		})
+	case "HSET":
+		fields := maps.Clone(value.Fields)
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		for i := 2; i < len(command); i += 2 {
+			fields[command[i]] = command[i+1]
+		}
+		value = Value{Kind: KindHash, Fields: fields}
+	case "HDEL":
+		fields := maps.Clone(value.Fields)
+		for _, field := range command[2:] {
+			delete(fields, field)
+		}
+		value.Fields = fields
+	}
+	if value.Kind == KindNone {
+		delete(s.values, key)
+		return
+	}
+	s.values[key] = value
+}
+
+// exchange sends the pending effects of each site to the other, as if the writes were concurrent.
+func exchange(t *testing.T, a, b *site) {
+	t.Helper()
+	aEffects, bEffects := a.effects, b.effects
+	a.effects, b.effects = nil, nil
+	a.receive(t, bEffects)
+	b.receive(t, aEffects)
+	if diff := deep.Equal(a.values, b.values); diff != nil {
+		t.Errorf("sites diverged: %v", diff)
+	}
+}
+
+func Test_Clock(t *testing.T) {
+	now := time.UnixMilli(1000)
+	c := &testClock{now: now}
+	clock := NewClock("a", c)
+
+	first := clock.Now()
+	second := clock.Now()
+	if first.Compare(second) >= 0 {
+		t.Errorf("expected %s to be before %s", first, second)
+	}
+
+	// The clock never goes backwards, and moves past the timestamps it observes.
+	c.now = now.Add(-time.Second)
+	clock.Observe(Timestamp{Wall: 5000, Logical: 3, Site: "b"})
+	third := clock.Now()
+	if third.Compare(Timestamp{Wall: 5000, Logical: 3, Site: "b"}) <= 0 || third.Site != "a" {
+		t.Errorf("expected %s to be after the observed timestamp", third)
+	}
+
+	parsed, err := ParseTimestamp(third.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != third {
+		t.Errorf("expected %s, got %s", third, parsed)
+	}
+	if _, err = ParseTimestamp("1.x.a"); err == nil {
+		t.Error("expected error parsing invalid timestamp")
+	}
+}
+
+func Test_State(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	t.Run("Strings are last writer wins", func(t *testing.T) {
+		a, b := newSite("a", now), newSite("b", now.Add(time.Millisecond))
+		a.write("key", Value{Kind: KindString, String: "a"}, "SET", "key", "a")
+		b.write("key", Value{Kind: KindString, String: "b", ExpireAt: 5000}, "SET", "key", "b", "PXAT", "5000")
+		exchange(t, a, b)
+		if value := a.values["key"]; value.String != "b" || value.ExpireAt != 5000 {
+			t.Errorf("expected the latest write to win, got %+v", value)
+		}
+
+		// A delete is a write like any other.
+		a.clock.now = now.Add(time.Second)
+		a.write("key", Value{}, "DEL", "key")
+		exchange(t, a, b)
+		if _, ok := b.values["key"]; ok {
+			t.Error("expected key to be deleted")
+		}
+	})
+
+	t.Run("Sets are add wins", func(t *testing.T) {
+		a, b := newSite("a", now.Add(time.Millisecond)), newSite("b", now)
+		a.write("set", Value{Kind: KindSet, Members: []string{"one", "two"}}, "SADD", "set", "one", "two")
+		exchange(t, a, b)
+
+		// The removal on a is concurrent with the addition of the same element on b, so the element is kept.
+		// Concurrent additions and removals of different elements are all applied.
+		a.write("set", Value{Kind: KindSet, Members: []string{"two"}}, "SREM", "set", "one")
+		b.write("set", Value{Kind: KindSet, Members: []string{"one", "three", "two"}}, "SADD", "set", "one", "three")
+		a.write("set", Value{Kind: KindSet, Members: []string{}}, "SREM", "set", "two")
+		exchange(t, a, b)
+		if diff := deep.Equal(a.values["set"].Members, []string{"one", "three"}); diff != nil {
+			t.Error(diff)
+		}
+
+		// Removals of elements that were observed win.
+		b.write("set", Value{}, "DEL", "set")
+		exchange(t, a, b)
+		if len(a.values["set"].Members) != 0 {
+			t.Errorf("expected empty set, got %v", a.values["set"].Members)
+		}
+	})
+
+	t.Run("Hashes are last writer wins per field", func(t *testing.T) {
+		a, b := newSite("a", now), newSite("b", now.Add(time.Millisecond))
+		a.write("hash", Value{Kind: KindHash, Fields: map[string]string{"f1": "a", "f2": "a"}}, "HSET", "hash", "f1", "a", "f2", "a")
+		b.write("hash", Value{Kind: KindHash, Fields: map[string]string{"f2": "b", "f3": "b"}}, "HSET", "hash", "f2", "b", "f3", "b")
+		exchange(t, a, b)
+		if diff := deep.Equal(a.values["hash"].Fields, map[string]string{"f1": "a", "f2": "b", "f3": "b"}); diff != nil {
+			t.Error(diff)
+		}
+
+		b.clock.now = now.Add(time.Second)
+		b.write("hash", Value{Kind: KindHash, Fields: map[string]string{"f2": "b", "f3": "b"}}, "HDEL", "hash", "f1")
+		a.write("hash", Value{Kind: KindHash, Fields: map[string]string{"f1": "a", "f2": "b", "f3": "c"}}, "HSET", "hash", "f3", "c")
+		exchange(t, a, b)
+		if diff := deep.Equal(b.values["hash"].Fields, map[string]string{"f2": "b", "f3": "c"}); diff != nil {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("Counters add the increments of every site", func(t *testing.T) {
+		a, b := newSite("a", now), newSite("b", now)
+		a.write("counter", Value{Kind: KindString, String: "5"}, "INCRBY", "counter", "5")
+		b.write("counter", Value{Kind: KindString, String: "-2"}, "DECRBY", "counter", "2")
+		b.write("counter", Value{Kind: KindString, String: "-1"}, "INCR", "counter")
+		effects := slices.Clone(b.effects)
+		exchange(t, a, b)
+		if value := a.values["counter"].String; value != "4" {
+			t.Errorf("expected counter 4, got %s", value)
+		}
+
+		// Effects are idempotent.
+		a.receive(t, effects)
+		if value := a.values["counter"].String; value != "4" {
+			t.Errorf("expected counter 4, got %s", value)
+		}
+
+		// Setting the counter resets the increments made before it.
+		a.clock.now = now.Add(time.Second)
+		a.write("counter", Value{Kind: KindString, String: "10"}, "SET", "counter", "10")
+		b.write("counter", Value{Kind: KindString, String: "5"}, "INCR", "counter")
+		exchange(t, a, b)
+		if value := b.values["counter"].String; value != "10" {
+			t.Errorf("expected counter 10, got %s", value)
+		}
+		a.write("counter", Value{Kind: KindString, String: "11"}, "INCR", "counter")
+		exchange(t, a, b)
+		if value := b.values["counter"].String; value != "11" {
+			t.Errorf("expected counter 11, got %s", value)
+		}
+	})
+
+	t.Run("Writes changing the type replace the key", func(t *testing.T) {
+		a, b := newSite("a", now), newSite("b", now.Add(time.Millisecond))
+		a.write("key", Value{Kind: KindString, String: "a"}, "SET", "key", "a")
+		b.write("key", Value{Kind: KindSet, Members: []string{"b"}}, "SADD", "key", "b")
+		exchange(t, a, b)
+		if value := a.values["key"]; value.Kind != KindSet {
+			t.Errorf("expected the set to replace the string, got %+v", value)
+		}
+	})
+
+	t.Run("Snapshot reproduces the state", func(t *testing.T) {
+		a, b := newSite("a", now), newSite("b", now)
+		a.values["untracked"] = Value{Kind: KindString, String: "value"}
+		a.write("string", Value{Kind: KindString, String: "value", ExpireAt: 5000}, "SET", "string", "value")
+		a.write("set", Value{Kind: KindSet, Members: []string{"one", "two"}}, "SADD", "set", "one", "two")
+		a.write("hash", Value{Kind: KindHash, Fields: map[string]string{"field": "value"}}, "HSET", "hash", "field", "value")
+		a.write("counter", Value{Kind: KindString, String: "3"}, "INCRBY", "counter", "3")
+
+		var snapshot [][]string
+		for _, key := range slices.Sorted(maps.Keys(a.values)) {
+			snapshot = append(snapshot, a.state.Snapshot(0, key, a.values[key])...)
+		}
+		// The stream of the site continues after the snapshot.
+		a.effects = nil
+		b.receive(t, snapshot)
+		if diff := deep.Equal(a.values, b.values); diff != nil {
+			t.Error(diff)
+		}
+
+		// The merged state resolves later conflicts like the original.
+		b.write("set", Value{Kind: KindSet, Members: []string{"two"}}, "SREM", "set", "one")
+		b.write("counter", Value{Kind: KindString, String: "4"}, "INCR", "counter")
+		exchange(t, a, b)
+		if value := a.values["counter"].String; value != "4" {
+			t.Errorf("expected counter 4, got %s", value)
+		}
+	})
+
+	t.Run("Prune removes the metadata of deleted keys", func(t *testing.T) {
+		a := newSite("a", now)
+		a.write("live", Value{Kind: KindString, String: "value"}, "SET", "live", "value")
+		a.write("deleted", Value{Kind: KindString, String: "value"}, "SET", "deleted", "value")
+		a.write("deleted", Value{}, "DEL", "deleted")
+		a.write("set", Value{Kind: KindSet, Members: []string{"one"}}, "SADD", "set", "one")
+		a.write("set", Value{}, "SREM", "set", "one")
+		a.write("hash", Value{Kind: KindHash, Fields: map[string]string{"f1": "a", "f2": "a"}}, "HSET", "hash", "f1", "a", "f2", "a")
+		a.write("hash", Value{Kind: KindHash, Fields: map[string]string{"f2": "a"}}, "HDEL", "hash", "f1")
+		// A counter added to a write that was never received.
+		a.receive(t, [][]string{{"CNT", "pending", Timestamp{Wall: now.UnixMilli(), Site: "b"}.String(), "b", "1", "0"}})
+
+		accounted := func() {
+			t.Helper()
+			var size int64
+			for key, ks := range a.state.databases[0] {
+				size += ks.size(key)
+			}
+			if size != a.state.bytes {
+				t.Errorf("expected %d bytes to be accounted, got %d", size, a.state.bytes)
+			}
+		}
+		accounted()
+		keys, before := a.state.Stats()
+		if keys != 5 {
+			t.Errorf("expected 5 keys with metadata, got %d", keys)
+		}
+
+		if pruned := a.state.Prune(now.UnixMilli()); pruned != 0 {
+			t.Errorf("expected the metadata written at the cutoff to be kept, pruned %d keys", pruned)
+		}
+		if pruned := a.state.Prune(now.Add(time.Second).UnixMilli()); pruned != 3 {
+			t.Errorf("expected 3 keys to be pruned, got %d", pruned)
+		}
+		accounted()
+		if diff := deep.Equal(slices.Sorted(maps.Keys(a.state.databases[0])), []string{"hash", "live"}); diff != nil {
+			t.Error(diff)
+		}
+		if diff := deep.Equal(slices.Collect(maps.Keys(a.state.databases[0]["hash"].fields)), []string{"f2"}); diff != nil {
+			t.Error(diff)
+		}
+		if keys, after := a.state.Stats(); keys != 2 || after >= before {
+			t.Errorf("expected 2 keys using less than %d bytes, got %d keys using %d bytes", before, keys, after)
+		}
+
+		// The pruned keys are written like new keys.
+		b := newSite("b", now.Add(2*time.Second))
+		b.write("deleted", Value{Kind: KindString, String: "b"}, "SET", "deleted", "b")
+		exchange(t, a, b)
+		if value := a.values["deleted"].String; value != "b" {
+			t.Errorf("expected deleted=b, got %s", value)
+		}
+	})
+
+	t.Run("Malformed effects are rejected", func(t *testing.T) {
+		a := newSite("a", now)
+		for _, effect := range [][]string{
+			{"REG"},
+			{"REG", "key", "invalid", "value", "0"},
+			{"CNT", "key", "1.0.a", "a", "x", "0"},
+			{"HSET", "key", "1.0.a", "field"},
+			{"UNKNOWN", "key"},
+		} {
+			if _, err := a.state.Apply(0, effect, Value{}); err == nil {
+				t.Errorf("expected error applying %v", effect)
+			}
+		}
+	})
+}
@@ -17,11 +17,13 @@
 package aof
 
 import (
+	"context"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	logstore "github.com/echovault/sugardb/internal/aof/log"
 	"github.com/echovault/sugardb/internal/aof/preamble"
 	"github.com/echovault/sugardb/internal/clock"
+	"github.com/echovault/sugardb/internal/encryption"
 	"log"
 	"sync"
 )
@@ -30,6 +32,7 @@ type Engine struct {
 	clock        clock.Clock
 	syncStrategy string
 	directory    string
+	keyring      *encryption.Keyring
 	preambleRW   preamble.ReadWriter
 	appendRW     logstore.ReadWriter
 
@@ -40,7 +43,7 @@ type Engine struct {
 
 	startRewriteFunc  func()
 	finishRewriteFunc func()
-	getStateFunc      func() map[int]map[string]internal.KeyData
+	getStateFunc      func() (map[int]map[string]internal.KeyData, error)
 	setKeyDataFunc    func(database int, key string, data internal.KeyData)
 	handleCommand     func(database int, command []byte)
 }
@@ -63,6 +66,14 @@ func WithDirectory(directory string) func(engine *Engine) {
 	}
 }
 
+// WithKeyring encrypts the preamble and the AOF log with the keyring's active key.
+// Files written with a previous key are re-encrypted with the active key on the next rewrite.
+func WithKeyring(keyring *encryption.Keyring) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.keyring = keyring
+	}
+}
+
 func WithStartRewriteFunc(f func()) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.startRewriteFunc = f
@@ -75,7 +86,7 @@ func WithFinishRewriteFunc(f func()) func(engine *Engine) {
 	}
 }
 
-func WithGetStateFunc(f func() map[int]map[string]internal.KeyData) func(engine *Engine) {
+func WithGetStateFunc(f func() (map[int]map[string]internal.KeyData, error)) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.getStateFunc = f
 	}
@@ -114,7 +125,7 @@ func NewAOFEngine(options ...func(engine *Engine)) (*Engine, error) {
 		logCount:          0,
 		startRewriteFunc:  func() {},
 		finishRewriteFunc: func() {},
-		getStateFunc:      func() map[int]map[string]internal.KeyData { return nil },
+		getStateFunc:      func() (map[int]map[string]internal.KeyData, error) { return nil, nil },
 		setKeyDataFunc:    func(database int, key string, data internal.KeyData) {},
 		handleCommand:     func(database int, command []byte) {},
 	}
@@ -130,6 +141,7 @@ func NewAOFEngine(options ...func(engine *Engine)) (*Engine, error) {
 		preamble.WithClock(engine.clock),
 		preamble.WithDirectory(engine.directory),
 		preamble.WithReadWriter(engine.preambleRW),
+		preamble.WithKeyring(engine.keyring),
 		preamble.WithGetStateFunc(engine.getStateFunc),
 		preamble.WithSetKeyDataFunc(engine.setKeyDataFunc),
 	)
@@ -144,6 +156,7 @@ func NewAOFEngine(options ...func(engine *Engine)) (*Engine, error) {
 		logstore.WithDirectory(engine.directory),
 		logstore.WithStrategy(engine.syncStrategy),
 		logstore.WithReadWriter(engine.appendRW),
+		logstore.WithKeyring(engine.keyring),
 		logstore.WithHandleCommandFunc(engine.handleCommand),
 	)
 	if err != nil {
@@ -154,10 +167,20 @@ func NewAOFEngine(options ...func(engine *Engine)) (*Engine, error) {
 	return engine, nil
 }
 
-func (engine *Engine) LogCommand(database int, command []byte) {
-	if err := engine.appendStore.Write(database, command); err != nil {
+// LogCommand appends the command to the AOF log and returns the offset of the logged command.
+// Returns 0 if the command could not be logged.
+func (engine *Engine) LogCommand(database int, command []byte) uint64 {
+	offset, err := engine.appendStore.Write(database, command)
+	if err != nil {
 		log.Printf("log command error: %+v\n", err)
 	}
+	return offset
+}
+
+// WaitForSync blocks until the AOF log has been fsynced up to the offset returned by LogCommand,
+// or until the context is done.
+func (engine *Engine) WaitForSync(ctx context.Context, offset uint64) error {
+	return engine.appendStore.WaitForSync(ctx, offset)
 }
 
 func (engine *Engine) RewriteLog() error {
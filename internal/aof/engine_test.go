@@ -92,8 +92,8 @@ func Test_AOFEngine(t *testing.T) {
 		},
 	}
 
-	getStateFunc := func() map[int]map[string]internal.KeyData {
-		return state
+	getStateFunc := func() (map[int]map[string]internal.KeyData, error) {
+		return state, nil
 	}
 
 	setKeyDataFunc := func(database int, key string, data internal.KeyData) {
@@ -15,9 +15,12 @@
 package log
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
+	"github.com/echovault/sugardb/internal/encryption"
 	"github.com/tidwall/resp"
 	"io"
 	"log"
@@ -36,18 +39,53 @@ type ReadWriter interface {
 	Sync() error
 }
 
+// record is a command waiting to be group committed along with the database it was executed against.
+type record struct {
+	database int
+	command  []byte
+}
+
+// commitBatch is a group of records that are written and synced together when using the "always" strategy.
+type commitBatch struct {
+	records []record      // The records in the order they were enqueued.
+	offset  uint64        // The offset of the last record in the batch.
+	done    chan struct{} // Closed once the batch has been written and synced.
+	err     error         // The error encountered while flushing the batch, if any.
+}
+
 type Store struct {
 	clock clock.Clock
-	// Keeps track of the current database that we're logging commands for.
+	// Keeps track of the current database that we're logging commands for. Guarded by ioMut.
 	currentDatabase int
 	// Append file sync strategy. Can only be "always", "everysec", or "no".
 	strategy string
 	// Store mutex.
 	mut sync.Mutex
+	// Serialises reads and writes on the ReadWriter. When both locks are required, mut is acquired first.
+	ioMut sync.Mutex
+	// The batch of records waiting to be flushed by the group commit goroutine when the strategy is "always".
+	batch *commitBatch
+	// Wakes up the group commit goroutine when a new batch is available.
+	notify chan struct{}
+	// Closed when the store is closed to stop the group commit goroutine.
+	stop chan struct{}
+	// Whether the store has been closed.
+	closed bool
+	// The offset of the latest record appended to the log.
+	// Offsets are never reset, even when the log is truncated.
+	offset uint64
+	// Guards syncedOffset and synced. No other lock is acquired while holding syncMut.
+	syncMut sync.Mutex
+	// The offset of the latest record that has been fsynced.
+	syncedOffset uint64
+	// Closed and replaced whenever syncedOffset advances to wake up writers waiting in WaitForSync.
+	synced chan struct{}
 	// The ReadWriter used to persist and load the log.
 	rw ReadWriter
 	// The directory for the AOF file if we must create one.
 	directory string
+	// The keyring used to encrypt the log. Nil when encryption at rest is disabled.
+	keyring *encryption.Keyring
 	// Function to handle command read from AOF log after restore.
 	handleCommand func(database int, command []byte)
 }
@@ -76,6 +114,13 @@ func WithDirectory(directory string) func(store *Store) {
 	}
 }
 
+// WithKeyring encrypts the log with the keyring's active key.
+func WithKeyring(keyring *encryption.Keyring) func(store *Store) {
+	return func(store *Store) {
+		store.keyring = keyring
+	}
+}
+
 func WithHandleCommandFunc(f func(database int, command []byte)) func(store *Store) {
 	return func(store *Store) {
 		store.handleCommand = f
@@ -90,6 +135,10 @@ func NewAppendStore(options ...func(store *Store)) (*Store, error) {
 		strategy:        "everysec",
 		rw:              nil,
 		mut:             sync.Mutex{},
+		ioMut:           sync.Mutex{},
+		notify:          make(chan struct{}, 1),
+		stop:            make(chan struct{}),
+		synced:          make(chan struct{}),
 		handleCommand:   func(database int, command []byte) {},
 	}
 
@@ -104,6 +153,10 @@ func NewAppendStore(options ...func(store *Store)) (*Store, error) {
 		if err != nil {
 			return nil, fmt.Errorf("new append store -> mkdir error: %+v", err)
 		}
+		// Files written before encryption was enabled are encrypted before they are opened.
+		if err = encryption.EncryptFile(path.Join(store.directory, "aof", "log.aof"), store.keyring); err != nil {
+			return nil, fmt.Errorf("new append store -> encryption error: %+v", err)
+		}
 		f, err := os.OpenFile(path.Join(store.directory, "aof", "log.aof"), os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
 		if err != nil {
 			return nil, fmt.Errorf("new append store -> open file error: %+v", err)
@@ -111,6 +164,14 @@ func NewAppendStore(options ...func(store *Store)) (*Store, error) {
 		store.rw = f
 	}
 
+	if store.rw != nil {
+		rw, err := encryption.NewReadWriter(store.rw, store.keyring)
+		if err != nil {
+			return nil, fmt.Errorf("new append store -> encryption error: %+v", err)
+		}
+		store.rw = rw
+	}
+
 	// Start another goroutine that takes handles syncing the content to the file system.
 	// No need to start this goroutine if sync strategy is anything other than 'everysec'.
 	if strings.EqualFold(store.strategy, "everysec") {
@@ -121,51 +182,227 @@ func NewAppendStore(options ...func(store *Store)) (*Store, error) {
 			}()
 			for {
 				store.mut.Lock()
+				offset := store.offset
 				if err := store.Sync(); err != nil {
 					store.mut.Unlock()
 					log.Println(fmt.Errorf("new append store error: %+v", err))
 					break
 				}
+				store.setSyncedOffset(offset)
 				store.mut.Unlock()
 				<-ticker.C
 			}
 		}()
 	}
 
+	// With the 'always' strategy, start the goroutine that group commits the records enqueued by concurrent writers.
+	if strings.EqualFold(store.strategy, "always") {
+		go store.groupCommit()
+	}
+
 	return store, nil
 }
 
-func (store *Store) Write(database int, command []byte) error {
+// Write appends the command to the log and returns the offset of the record.
+// The offset can be passed to WaitForSync to wait until the record is durable.
+func (store *Store) Write(database int, command []byte) (uint64, error) {
 	// Skip operation if ReadWriter is not defined.
 	if store.rw == nil {
-		return nil
+		return 0, nil
+	}
+
+	if strings.EqualFold(store.strategy, "always") {
+		return store.commit(database, command)
 	}
 
 	store.mut.Lock()
 	defer store.mut.Unlock()
 
+	store.ioMut.Lock()
+	defer store.ioMut.Unlock()
+
 	// If the database parameter is different from the current database index,
 	// log the SELECT command before logging the incoming command.
 	// This allows us to switch databases appropriately when restoring the state on startup.
 	if database != store.currentDatabase {
-		_, err := store.rw.Write([]byte(fmt.Sprintf("*2\r\n$6\r\nSELECT\r\n$1\r\n%s\r\n", strconv.Itoa(database))))
+		_, err := store.rw.Write(selectCommand(database))
 		if err != nil {
-			return fmt.Errorf("log select error: %+v", err)
+			return 0, fmt.Errorf("log select error: %+v", err)
 		}
 		store.currentDatabase = database
 	}
 
 	if _, err := store.rw.Write(command); err != nil {
-		return fmt.Errorf("log command error: %+v", err)
+		return 0, fmt.Errorf("log command error: %+v", err)
 	}
 
-	if strings.EqualFold(store.strategy, "always") {
-		if err := store.Sync(); err != nil {
-			return fmt.Errorf("log file sync error: %+v", err)
+	store.offset += 1
+	return store.offset, nil
+}
+
+// commit enqueues the command in the current batch and blocks until the batch has been written and synced
+// by the group commit goroutine. This allows concurrent writers to share a single write and fsync while still
+// only returning once their command is durable.
+func (store *Store) commit(database int, command []byte) (uint64, error) {
+	store.mut.Lock()
+
+	if store.closed {
+		store.mut.Unlock()
+		return 0, errors.New("log command error: store is closed")
+	}
+
+	if store.batch == nil {
+		store.batch = &commitBatch{done: make(chan struct{})}
+	}
+	batch := store.batch
+	batch.records = append(batch.records, record{database: database, command: command})
+	store.offset += 1
+	batch.offset = store.offset
+	offset := store.offset
+
+	store.mut.Unlock()
+
+	// Wake up the group commit goroutine if it's not already scheduled to run.
+	select {
+	case store.notify <- struct{}{}:
+	default:
+	}
+
+	<-batch.done
+	if batch.err != nil {
+		return 0, batch.err
+	}
+	return offset, nil
+}
+
+// groupCommit flushes pending batches until the store is closed.
+// Records enqueued while a batch is being flushed are collected into the next batch.
+func (store *Store) groupCommit() {
+	for {
+		select {
+		case <-store.stop:
+			return
+		case <-store.notify:
+		}
+
+		store.mut.Lock()
+		batch := store.batch
+		store.batch = nil
+		if batch == nil {
+			store.mut.Unlock()
+			continue
 		}
+		// Acquire the I/O lock before releasing the store lock so that Truncate, Restore and Close
+		// cannot run between taking the batch and writing it.
+		store.ioMut.Lock()
+		store.mut.Unlock()
+
+		store.flush(batch)
+		store.ioMut.Unlock()
 	}
+}
 
-	return nil
+// flush writes and syncs the batch, then releases the writers waiting on it.
+// The caller must hold ioMut.
+func (store *Store) flush(batch *commitBatch) {
+	defer close(batch.done)
+
+	var buf []byte
+	for _, r := range batch.records {
+		// If the record's database is different from the current database index,
+		// log the SELECT command before logging the record.
+		if r.database != store.currentDatabase {
+			buf = append(buf, selectCommand(r.database)...)
+			store.currentDatabase = r.database
+		}
+		buf = append(buf, r.command...)
+	}
+
+	if _, err := store.rw.Write(buf); err != nil {
+		batch.err = fmt.Errorf("log command error: %+v", err)
+	} else if err = store.Sync(); err != nil {
+		batch.err = fmt.Errorf("log file sync error: %+v", err)
+	}
+
+	if batch.err != nil {
+		// The SELECT command may not have been persisted, make sure the next record selects its database again.
+		store.currentDatabase = -1
+		return
+	}
+
+	store.setSyncedOffset(batch.offset)
+}
+
+// flushPending flushes the batch that has not yet been picked up by the group commit goroutine.
+// The caller must hold both mut and ioMut.
+func (store *Store) flushPending() {
+	if store.batch == nil {
+		return
+	}
+	batch := store.batch
+	store.batch = nil
+	store.flush(batch)
+}
+
+// setSyncedOffset records that all the records up to the offset have been fsynced
+// and wakes up the writers waiting in WaitForSync.
+func (store *Store) setSyncedOffset(offset uint64) {
+	store.syncMut.Lock()
+	defer store.syncMut.Unlock()
+	if offset <= store.syncedOffset {
+		return
+	}
+	store.syncedOffset = offset
+	close(store.synced)
+	store.synced = make(chan struct{})
+}
+
+// WaitForSync blocks until all the records up to the offset have been fsynced or until the context is done.
+// With the "always" and "everysec" strategies, it waits for the records to be synced by the store.
+// With the "no" strategy, the log is synced immediately.
+func (store *Store) WaitForSync(ctx context.Context, offset uint64) error {
+	if store.rw == nil {
+		return errors.New("append only file is disabled")
+	}
+
+	for {
+		store.syncMut.Lock()
+		syncedOffset, synced := store.syncedOffset, store.synced
+		store.syncMut.Unlock()
+
+		if syncedOffset >= offset {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !strings.EqualFold(store.strategy, "always") && !strings.EqualFold(store.strategy, "everysec") {
+			// The store never syncs the log on its own with this strategy.
+			store.mut.Lock()
+			target := store.offset
+			store.ioMut.Lock()
+			err := store.Sync()
+			store.ioMut.Unlock()
+			store.mut.Unlock()
+			if err != nil {
+				return fmt.Errorf("log file sync error: %+v", err)
+			}
+			store.setSyncedOffset(target)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-synced:
+		}
+	}
+}
+
+// selectCommand returns the RESP encoded SELECT command for the database.
+func selectCommand(database int) []byte {
+	return []byte(fmt.Sprintf("*2\r\n$6\r\nSELECT\r\n$1\r\n%s\r\n", strconv.Itoa(database)))
 }
 
 func (store *Store) Sync() error {
@@ -179,6 +416,11 @@ func (store *Store) Restore() error {
 	store.mut.Lock()
 	defer store.mut.Unlock()
 
+	store.ioMut.Lock()
+	defer store.ioMut.Unlock()
+
+	store.flushPending()
+
 	// Move cursor to the beginning of the file
 	if _, err := store.rw.Seek(0, 0); err != nil {
 		return fmt.Errorf("restore aof: %v", err)
@@ -227,6 +469,12 @@ func (store *Store) Truncate() error {
 	store.mut.Lock()
 	defer store.mut.Unlock()
 
+	store.ioMut.Lock()
+	defer store.ioMut.Unlock()
+
+	// Records enqueued before the truncation are flushed first so that their writers are released.
+	store.flushPending()
+
 	if err := store.rw.Truncate(0); err != nil {
 		return fmt.Errorf("truncate: truncate error: %+v", err)
 	}
@@ -237,8 +485,7 @@ func (store *Store) Truncate() error {
 	}
 
 	// Add command to select the current database at the top of the file.
-	_, err := store.rw.Write([]byte(
-		fmt.Sprintf("*2\r\n$6\r\nSELECT\r\n$1\r\n%s\r\n", strconv.Itoa(store.currentDatabase))))
+	_, err := store.rw.Write(selectCommand(store.currentDatabase))
 	if err != nil {
 		return fmt.Errorf("truncate: log select error: %+v", err)
 	}
@@ -246,6 +493,7 @@ func (store *Store) Truncate() error {
 	if err = store.rw.Sync(); err != nil {
 		return fmt.Errorf("truncate: sync error: %+v", err)
 	}
+	store.setSyncedOffset(store.offset)
 
 	return nil
 }
@@ -253,9 +501,20 @@ func (store *Store) Truncate() error {
 func (store *Store) Close() error {
 	store.mut.Lock()
 	defer store.mut.Unlock()
+
+	store.ioMut.Lock()
+	defer store.ioMut.Unlock()
+
+	if store.closed {
+		return nil
+	}
+	store.closed = true
+	close(store.stop)
+
 	if store.rw == nil {
 		return nil
 	}
+	store.flushPending()
 	if err := store.rw.Close(); err != nil {
 		return err
 	}
@@ -21,6 +21,7 @@ import (
 	"github.com/echovault/sugardb/internal/clock"
 	"os"
 	"path"
+	"sync"
 	"testing"
 	"time"
 )
@@ -120,7 +121,7 @@ func Test_AppendStore(t *testing.T) {
 
 			for _, command := range test.commands {
 				b := marshalRespCommand(command)
-				if err = store.Write(0, b); err != nil {
+				if _, err = store.Write(0, b); err != nil {
 					t.Error(err)
 				}
 			}
@@ -147,3 +148,101 @@ func Test_AppendStore(t *testing.T) {
 	}
 
 }
+
+// syncTrackingReadWriter records the bytes that have been made durable by a call to Sync.
+type syncTrackingReadWriter struct {
+	*os.File
+	mut       sync.Mutex
+	written   []byte
+	synced    int
+	syncCount int
+}
+
+func (rw *syncTrackingReadWriter) Write(p []byte) (int, error) {
+	rw.mut.Lock()
+	defer rw.mut.Unlock()
+	rw.written = append(rw.written, p...)
+	return rw.File.Write(p)
+}
+
+func (rw *syncTrackingReadWriter) Sync() error {
+	// Simulate a slow disk so that concurrent writers pile up behind the fsync.
+	<-time.After(5 * time.Millisecond)
+	rw.mut.Lock()
+	defer rw.mut.Unlock()
+	rw.synced = len(rw.written)
+	rw.syncCount += 1
+	return rw.File.Sync()
+}
+
+func (rw *syncTrackingReadWriter) isDurable(b []byte) bool {
+	rw.mut.Lock()
+	defer rw.mut.Unlock()
+	return bytes.Contains(rw.written[:rw.synced], b)
+}
+
+func Test_AppendStoreGroupCommit(t *testing.T) {
+	directory := path.Join(".", "testdata", "log", "group_commit")
+	t.Cleanup(func() {
+		_ = os.RemoveAll(path.Join(".", "testdata"))
+	})
+
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path.Join(directory, "log.aof"), os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := &syncTrackingReadWriter{File: f}
+
+	restored := make(map[string]int)
+	store, err := log.NewAppendStore(
+		log.WithClock(clock.NewClock()),
+		log.WithStrategy("always"),
+		log.WithReadWriter(rw),
+		log.WithHandleCommandFunc(func(database int, command []byte) {
+			restored[fmt.Sprintf("%d:%s", database, string(command))] += 1
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writers := 100
+	wg := sync.WaitGroup{}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			command := marshalRespCommand([]string{"SET", fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)})
+			if _, err := store.Write(i%3, command); err != nil {
+				t.Error(err)
+				return
+			}
+			// The write must only return once the command has been synced.
+			if !rw.isDurable(command) {
+				t.Errorf("write for key%d returned before the command was synced", i)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if rw.syncCount >= writers {
+		t.Errorf("expected concurrent writes to share syncs, got %d syncs for %d writes", rw.syncCount, writers)
+	}
+
+	if err = store.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < writers; i++ {
+		command := marshalRespCommand([]string{"SET", fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)})
+		if count := restored[fmt.Sprintf("%d:%s", i%3, string(command))]; count != 1 {
+			t.Errorf("expected key%d to be restored once in database %d, got %d", i, i%3, count)
+		}
+	}
+
+	if err = store.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
+	"github.com/echovault/sugardb/internal/encryption"
 	"io"
 	"os"
 	"path"
@@ -37,7 +38,8 @@ type Store struct {
 	rw             ReadWriter
 	mut            sync.Mutex
 	directory      string
-	getStateFunc   func() map[int]map[string]internal.KeyData
+	keyring        *encryption.Keyring
+	getStateFunc   func() (map[int]map[string]internal.KeyData, error)
 	setKeyDataFunc func(database int, key string, data internal.KeyData)
 }
 
@@ -53,7 +55,14 @@ func WithReadWriter(rw ReadWriter) func(store *Store) {
 	}
 }
 
-func WithGetStateFunc(f func() map[int]map[string]internal.KeyData) func(store *Store) {
+// WithKeyring encrypts the preamble with the keyring's active key.
+func WithKeyring(keyring *encryption.Keyring) func(store *Store) {
+	return func(store *Store) {
+		store.keyring = keyring
+	}
+}
+
+func WithGetStateFunc(f func() (map[int]map[string]internal.KeyData, error)) func(store *Store) {
 	return func(store *Store) {
 		store.getStateFunc = f
 	}
@@ -77,9 +86,9 @@ func NewPreambleStore(options ...func(store *Store)) (*Store, error) {
 		rw:        nil,
 		mut:       sync.Mutex{},
 		directory: "",
-		getStateFunc: func() map[int]map[string]internal.KeyData {
+		getStateFunc: func() (map[int]map[string]internal.KeyData, error) {
 			// No-Op by default
-			return nil
+			return nil, nil
 		},
 		setKeyDataFunc: func(database int, key string, data internal.KeyData) {},
 	}
@@ -94,6 +103,10 @@ func NewPreambleStore(options ...func(store *Store)) (*Store, error) {
 		if err != nil {
 			return nil, fmt.Errorf("new preamble store -> mkdir error: %+v", err)
 		}
+		// Files written before encryption was enabled are encrypted before they are opened.
+		if err = encryption.EncryptFile(path.Join(store.directory, "aof", "preamble.bin"), store.keyring); err != nil {
+			return nil, fmt.Errorf("new preamble store -> encryption error: %+v", err)
+		}
 		f, err := os.OpenFile(path.Join(store.directory, "aof", "preamble.bin"), os.O_RDWR|os.O_CREATE, os.ModePerm)
 		if err != nil {
 			return nil, fmt.Errorf("new preamble store -> open file error: %+v", err)
@@ -101,6 +114,14 @@ func NewPreambleStore(options ...func(store *Store)) (*Store, error) {
 		store.rw = f
 	}
 
+	if store.rw != nil {
+		rw, err := encryption.NewReadWriter(store.rw, store.keyring)
+		if err != nil {
+			return nil, fmt.Errorf("new preamble store -> encryption error: %+v", err)
+		}
+		store.rw = rw
+	}
+
 	return store, nil
 }
 
@@ -109,8 +130,11 @@ func (store *Store) CreatePreamble() error {
 	store.mut.Unlock()
 
 	// Get current state.
-	state := internal.FilterExpiredKeys(store.clock.Now(), store.getStateFunc())
-	o, err := json.Marshal(state)
+	state, err := store.getStateFunc()
+	if err != nil {
+		return err
+	}
+	o, err := json.Marshal(internal.FilterExpiredKeys(store.clock.Now(), state))
 	if err != nil {
 		return err
 	}
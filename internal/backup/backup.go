@@ -0,0 +1,210 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package backup reads and writes the tar archives produced by online backups.
+//
+// An archive contains the following entries, in order:
+//
+//	metadata.json       The Metadata header describing the backup.
+//	snapshot.json       The point-in-time snapshot of all the databases, encoded like a standalone snapshot.
+//	acl.json            The ACL users.
+//	libraries/<n>       The content of each loaded script or plugin, in the order listed in the metadata.
+//
+// When encryption at rest is enabled, every entry except the metadata is encrypted with the active key.
+package backup
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/encryption"
+)
+
+// Version is the version of the archive format.
+const Version = 1
+
+const (
+	metadataFile  = "metadata.json"
+	snapshotFile  = "snapshot.json"
+	aclFile       = "acl.json"
+	librariesPath = "libraries/"
+)
+
+// Metadata is the header of the archive.
+type Metadata struct {
+	Version       int      `json:"Version"`       // The version of the archive format.
+	ServerVersion string   `json:"ServerVersion"` // The version of the server that produced the backup.
+	ServerID      string   `json:"ServerID"`      // The ID of the server that produced the backup.
+	Mode          string   `json:"Mode"`          // The mode of the server: "standalone" or "cluster".
+	CreatedAt     int64    `json:"CreatedAt"`     // Unix milliseconds timestamp of the state copy.
+	Keys          int      `json:"Keys"`          // The total number of keys across all databases.
+	Libraries     []string `json:"Libraries"`     // The paths the libraries were loaded from.
+	Encrypted     bool     `json:"Encrypted"`
+}
+
+// Library is a script or plugin that was loaded into the server when the backup was taken.
+type Library struct {
+	Path    string
+	Content []byte
+}
+
+// Backup holds the content of a backup archive.
+type Backup struct {
+	Metadata  Metadata
+	State     map[int]map[string]internal.KeyData
+	Users     []byte // The JSON encoded ACL users.
+	Libraries []Library
+}
+
+// Write writes the backup to w as a tar archive.
+// If the keyring is not nil, the content of the archive is encrypted with its active key.
+func Write(w io.Writer, backup Backup, keyring *encryption.Keyring) error {
+	tw := tar.NewWriter(w)
+	modTime := time.UnixMilli(backup.Metadata.CreatedAt)
+
+	writeEntry := func(name string, content []byte, encrypt bool) error {
+		if encrypt {
+			var err error
+			if content, err = keyring.Encrypt(content); err != nil {
+				return err
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(content)),
+			ModTime: modTime,
+		}); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	metadata := backup.Metadata
+	metadata.Version = Version
+	metadata.Encrypted = keyring != nil
+	metadata.Libraries = make([]string, len(backup.Libraries))
+	for i, library := range backup.Libraries {
+		metadata.Libraries[i] = library.Path
+	}
+	metadata.Keys = 0
+	for _, data := range backup.State {
+		metadata.Keys += len(data)
+	}
+
+	m, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err = writeEntry(metadataFile, m, false); err != nil {
+		return fmt.Errorf("write backup metadata: %v", err)
+	}
+
+	snapshot, err := json.Marshal(internal.SnapshotObject{
+		State:                      backup.State,
+		LatestSnapshotMilliseconds: backup.Metadata.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if err = writeEntry(snapshotFile, snapshot, true); err != nil {
+		return fmt.Errorf("write backup snapshot: %v", err)
+	}
+
+	if err = writeEntry(aclFile, backup.Users, true); err != nil {
+		return fmt.Errorf("write backup ACL users: %v", err)
+	}
+
+	for i, library := range backup.Libraries {
+		if err = writeEntry(librariesPath+strconv.Itoa(i), library.Content, true); err != nil {
+			return fmt.Errorf("write backup library %s: %v", library.Path, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// Read reads a backup archive produced by Write.
+func Read(r io.Reader, keyring *encryption.Keyring) (Backup, error) {
+	backup := Backup{}
+	tr := tar.NewReader(r)
+
+	var libraries [][]byte
+	var readMetadata, readSnapshot bool
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Backup{}, fmt.Errorf("read backup: %v", err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return Backup{}, fmt.Errorf("read backup entry %s: %v", header.Name, err)
+		}
+
+		if header.Name == metadataFile {
+			if err = json.Unmarshal(content, &backup.Metadata); err != nil {
+				return Backup{}, fmt.Errorf("read backup metadata: %v", err)
+			}
+			if backup.Metadata.Version > Version {
+				return Backup{}, fmt.Errorf("unsupported backup version %d", backup.Metadata.Version)
+			}
+			readMetadata = true
+			continue
+		}
+
+		if content, err = keyring.Decrypt(content); err != nil {
+			return Backup{}, fmt.Errorf("decrypt backup entry %s: %v", header.Name, err)
+		}
+
+		switch {
+		case header.Name == snapshotFile:
+			snapshot := internal.SnapshotObject{}
+			if err = json.Unmarshal(content, &snapshot); err != nil {
+				return Backup{}, fmt.Errorf("read backup snapshot: %v", err)
+			}
+			backup.State = snapshot.State
+			readSnapshot = true
+		case header.Name == aclFile:
+			backup.Users = content
+		case strings.HasPrefix(header.Name, librariesPath):
+			libraries = append(libraries, content)
+		}
+	}
+
+	if !readMetadata || !readSnapshot {
+		return Backup{}, errors.New("read backup: archive is missing the metadata or the snapshot")
+	}
+	if len(libraries) != len(backup.Metadata.Libraries) {
+		return Backup{}, fmt.Errorf("read backup: expected %d libraries, found %d",
+			len(backup.Metadata.Libraries), len(libraries))
+	}
+	for i, path := range backup.Metadata.Libraries {
+		backup.Libraries = append(backup.Libraries, Library{Path: path, Content: libraries[i]})
+	}
+
+	return backup, nil
+}
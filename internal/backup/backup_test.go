@@ -0,0 +1,104 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/backup"
+	"github.com/echovault/sugardb/internal/encryption"
+)
+
+func Test_Backup(t *testing.T) {
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := backup.Backup{
+		Metadata: backup.Metadata{ServerID: "server-1", Mode: "standalone", CreatedAt: 1136189045000},
+		State: map[int]map[string]internal.KeyData{
+			0: {"key1": {Value: "value1"}},
+			1: {"key2": {Value: "value2"}, "key3": {Value: "value3"}},
+		},
+		Users: []byte(`[{"Username":"default"}]`),
+		Libraries: []backup.Library{
+			{Path: "/modules/hash.lua", Content: []byte("-- hash")},
+			{Path: "/modules/set.lua", Content: []byte("-- set")},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		keyring     *encryption.Keyring
+		readKeyring *encryption.Keyring
+		wantErr     string
+	}{
+		{
+			name: "1. Write and read a plaintext backup",
+		},
+		{
+			name:        "2. Write and read an encrypted backup",
+			keyring:     keyring,
+			readKeyring: keyring,
+		},
+		{
+			name:    "3. Reading an encrypted backup without the key returns an error",
+			keyring: keyring,
+			wantErr: encryption.ErrNoKey.Error(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := backup.Write(buf, b, test.keyring); err != nil {
+				t.Fatal(err)
+			}
+			if test.keyring != nil && bytes.Contains(buf.Bytes(), []byte("value1")) {
+				t.Error("expected encrypted backup not to contain plaintext values")
+			}
+
+			got, err := backup.Read(buf, test.readKeyring)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Metadata.Version != backup.Version || got.Metadata.Keys != 3 ||
+				got.Metadata.Encrypted != (test.keyring != nil) || got.Metadata.ServerID != "server-1" {
+				t.Errorf("unexpected metadata %+v", got.Metadata)
+			}
+			if !reflect.DeepEqual(got.State, b.State) {
+				t.Errorf("expected state %v, got %v", b.State, got.State)
+			}
+			if !bytes.Equal(got.Users, b.Users) {
+				t.Errorf("expected users %s, got %s", b.Users, got.Users)
+			}
+			if !reflect.DeepEqual(got.Libraries, b.Libraries) {
+				t.Errorf("expected libraries %v, got %v", b.Libraries, got.Libraries)
+			}
+		})
+	}
+}
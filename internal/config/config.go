@@ -22,9 +22,11 @@ import (
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
 	"log"
+	"net"
 	"os"
 	"path"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,33 +34,127 @@ import (
 )
 
 type Config struct {
-	TLS               bool          `json:"TLS" yaml:"TLS"`
-	MTLS              bool          `json:"MTLS" yaml:"MTLS"`
-	CertKeyPairs      [][]string    `json:"CertKeyPairs" yaml:"CertKeyPairs"`
-	ClientCAs         []string      `json:"ClientCAs" yaml:"ClientCAs"`
-	Port              uint16        `json:"Port" yaml:"Port"`
-	ServerID          string        `json:"ServerId" yaml:"ServerId"`
-	JoinAddr          string        `json:"JoinAddr" yaml:"JoinAddr"`
-	BindAddr          string        `json:"BindAddr" yaml:"BindAddr"`
-	DataDir           string        `json:"DataDir" yaml:"DataDir"`
-	BootstrapCluster  bool          `json:"BootstrapCluster" yaml:"BootstrapCluster"`
-	AclConfig         string        `json:"AclConfig" yaml:"AclConfig"`
-	ForwardCommand    bool          `json:"ForwardCommand" yaml:"ForwardCommand"`
-	RequirePass       bool          `json:"RequirePass" yaml:"RequirePass"`
-	Password          string        `json:"Password" yaml:"Password"`
-	SnapShotThreshold uint64        `json:"SnapshotThreshold" yaml:"SnapshotThreshold"`
-	SnapshotInterval  time.Duration `json:"SnapshotInterval" yaml:"SnapshotInterval"`
-	RestoreSnapshot   bool          `json:"RestoreSnapshot" yaml:"RestoreSnapshot"`
-	RestoreAOF        bool          `json:"RestoreAOF" yaml:"RestoreAOF"`
-	AOFSyncStrategy   string        `json:"AOFSyncStrategy" yaml:"AOFSyncStrategy"`
-	MaxMemory         uint64        `json:"MaxMemory" yaml:"MaxMemory"`
-	EvictionPolicy    string        `json:"EvictionPolicy" yaml:"EvictionPolicy"`
-	EvictionSample    uint          `json:"EvictionSample" yaml:"EvictionSample"`
-	EvictionInterval  time.Duration `json:"EvictionInterval" yaml:"EvictionInterval"`
-	Modules           []string      `json:"Plugins" yaml:"Plugins"`
-	DiscoveryPort     uint16        `json:"DiscoveryPort" yaml:"DiscoveryPort"`
-	RaftBindAddr      string
-	RaftBindPort      uint16
+	TLS                    bool          `json:"TLS" yaml:"TLS"`
+	MTLS                   bool          `json:"MTLS" yaml:"MTLS"`
+	CertKeyPairs           [][]string    `json:"CertKeyPairs" yaml:"CertKeyPairs"`
+	ClientCAs              []string      `json:"ClientCAs" yaml:"ClientCAs"`
+	Port                   uint16        `json:"Port" yaml:"Port"`
+	ServerID               string        `json:"ServerId" yaml:"ServerId"`
+	JoinAddr               string        `json:"JoinAddr" yaml:"JoinAddr"`
+	BindAddr               string        `json:"BindAddr" yaml:"BindAddr"`
+	DataDir                string        `json:"DataDir" yaml:"DataDir"`
+	BootstrapCluster       bool          `json:"BootstrapCluster" yaml:"BootstrapCluster"`
+	ShardID                string        `json:"ShardID" yaml:"ShardID"`
+	RaftRole               string        `json:"RaftRole" yaml:"RaftRole"`
+	RaftTLS                bool          `json:"RaftTLS" yaml:"RaftTLS"`
+	RaftMTLS               bool          `json:"RaftMTLS" yaml:"RaftMTLS"`
+	RaftCertKeyPairs       [][]string    `json:"RaftCertKeyPairs" yaml:"RaftCertKeyPairs"`
+	RaftCAs                []string      `json:"RaftCAs" yaml:"RaftCAs"`
+	RaftTLSServerName      string        `json:"RaftTLSServerName" yaml:"RaftTLSServerName"`
+	GossipKeyFile          string        `json:"GossipKeyFile" yaml:"GossipKeyFile"`
+	AclConfig              string        `json:"AclConfig" yaml:"AclConfig"`
+	ForwardCommand         bool          `json:"ForwardCommand" yaml:"ForwardCommand"`
+	ForwardTimeout         time.Duration `json:"ForwardTimeout" yaml:"ForwardTimeout"`
+	RaftBatchWindow        time.Duration `json:"RaftBatchWindow" yaml:"RaftBatchWindow"`
+	RaftBatchSize          uint          `json:"RaftBatchSize" yaml:"RaftBatchSize"`
+	RaftApplyTimeout       time.Duration `json:"RaftApplyTimeout" yaml:"RaftApplyTimeout"`
+	ReadConsistency        string        `json:"ReadConsistency" yaml:"ReadConsistency"`
+	RequirePass            bool          `json:"RequirePass" yaml:"RequirePass"`
+	Password               string        `json:"Password" yaml:"Password"`
+	SnapShotThreshold      uint64        `json:"SnapshotThreshold" yaml:"SnapshotThreshold"`
+	SnapshotInterval       time.Duration `json:"SnapshotInterval" yaml:"SnapshotInterval"`
+	RestoreSnapshot        bool          `json:"RestoreSnapshot" yaml:"RestoreSnapshot"`
+	RestoreAOF             bool          `json:"RestoreAOF" yaml:"RestoreAOF"`
+	AOFSyncStrategy        string        `json:"AOFSyncStrategy" yaml:"AOFSyncStrategy"`
+	EncryptionKeyFile      string        `json:"EncryptionKeyFile" yaml:"EncryptionKeyFile"`
+	RestoreBackup          string        `json:"RestoreBackup" yaml:"RestoreBackup"`
+	ReplicaOf              string        `json:"ReplicaOf" yaml:"ReplicaOf"`
+	ReplBacklogSize        uint64        `json:"ReplBacklogSize" yaml:"ReplBacklogSize"`
+	PrimaryUser            string        `json:"PrimaryUser" yaml:"PrimaryUser"`
+	PrimaryAuth            string        `json:"PrimaryAuth" yaml:"PrimaryAuth"`
+	PrimaryTLS             bool          `json:"PrimaryTLS" yaml:"PrimaryTLS"`
+	PrimaryCAs             []string      `json:"PrimaryCAs" yaml:"PrimaryCAs"`
+	SiteID                 string        `json:"SiteID" yaml:"SiteID"`
+	Peers                  []string      `json:"Peers" yaml:"Peers"`
+	TombstoneTTL           time.Duration `json:"TombstoneTTL" yaml:"TombstoneTTL"`
+	MaxMemory              uint64        `json:"MaxMemory" yaml:"MaxMemory"`
+	EvictionPolicy         string        `json:"EvictionPolicy" yaml:"EvictionPolicy"`
+	EvictionSample         uint          `json:"EvictionSample" yaml:"EvictionSample"`
+	EvictionInterval       time.Duration `json:"EvictionInterval" yaml:"EvictionInterval"`
+	LFULogFactor           uint          `json:"LFULogFactor" yaml:"LFULogFactor"`
+	LFUDecayTime           uint          `json:"LFUDecayTime" yaml:"LFUDecayTime"`
+	LazyFreeEviction       bool          `json:"LazyFreeEviction" yaml:"LazyFreeEviction"`
+	LazyFreeExpire         bool          `json:"LazyFreeExpire" yaml:"LazyFreeExpire"`
+	LazyFreeServerDel      bool          `json:"LazyFreeServerDel" yaml:"LazyFreeServerDel"`
+	HashMaxListpackEntries uint          `json:"HashMaxListpackEntries" yaml:"HashMaxListpackEntries"`
+	HashMaxListpackValue   uint          `json:"HashMaxListpackValue" yaml:"HashMaxListpackValue"`
+	SetMaxIntsetEntries    uint          `json:"SetMaxIntsetEntries" yaml:"SetMaxIntsetEntries"`
+	SetMaxListpackEntries  uint          `json:"SetMaxListpackEntries" yaml:"SetMaxListpackEntries"`
+	SetMaxListpackValue    uint          `json:"SetMaxListpackValue" yaml:"SetMaxListpackValue"`
+	ZSetMaxListpackEntries uint          `json:"ZSetMaxListpackEntries" yaml:"ZSetMaxListpackEntries"`
+	ZSetMaxListpackValue   uint          `json:"ZSetMaxListpackValue" yaml:"ZSetMaxListpackValue"`
+	ListMaxListpackEntries uint          `json:"ListMaxListpackEntries" yaml:"ListMaxListpackEntries"`
+	ListMaxListpackValue   uint          `json:"ListMaxListpackValue" yaml:"ListMaxListpackValue"`
+	TieredStorage          bool          `json:"TieredStorage" yaml:"TieredStorage"`
+	MemoryQuotas           []MemoryQuota `json:"MemoryQuotas" yaml:"MemoryQuotas"`
+	Modules                []string      `json:"Plugins" yaml:"Plugins"`
+	DiscoveryPort          uint16        `json:"DiscoveryPort" yaml:"DiscoveryPort"`
+	RaftBindAddr           string
+	RaftBindPort           uint16
+}
+
+// MemoryQuota limits the memory used by the keys of a logical database, or by the keys of the database that start
+// with a prefix.
+type MemoryQuota struct {
+	Database int `json:"Database" yaml:"Database"`
+	// The prefix of the keys of the quota, empty for a quota on all the keys of the database.
+	// A trailing '*' is ignored, so that the prefix can be written as a pattern.
+	Prefix string `json:"Prefix" yaml:"Prefix"`
+	// The upper limit of the memory used by the keys of the quota and their values.
+	MaxMemory uint64 `json:"MaxMemory" yaml:"MaxMemory"`
+	// The eviction policy used to remove the keys of the quota when the quota is reached.
+	// With noeviction, the writes to the keys of the quota are rejected instead.
+	Policy string `json:"Policy" yaml:"Policy"`
+}
+
+// ParseMemoryQuota parses a quota in the format "db=0,prefix=tenant:42:*,max-memory=100mb,policy=allkeys-lru".
+// The prefix is optional and the policy defaults to noeviction.
+func ParseMemoryQuota(s string) (MemoryQuota, error) {
+	quota := MemoryQuota{Policy: constants.NoEviction}
+	var hasDatabase bool
+	for _, option := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok {
+			return MemoryQuota{}, fmt.Errorf("memory quota option %s must be in the format name=value", option)
+		}
+		switch strings.ToLower(name) {
+		case "db":
+			database, err := strconv.Atoi(value)
+			if err != nil || database < 0 {
+				return MemoryQuota{}, fmt.Errorf("memory quota db %s must be a non-negative integer", value)
+			}
+			quota.Database, hasDatabase = database, true
+		case "prefix":
+			quota.Prefix = value
+		case "max-memory":
+			b, err := internal.ParseMemory(value)
+			if err != nil {
+				return MemoryQuota{}, err
+			}
+			quota.MaxMemory = b
+		case "policy":
+			quota.Policy = strings.ToLower(value)
+		default:
+			return MemoryQuota{}, fmt.Errorf("unknown memory quota option %s", name)
+		}
+	}
+	if !hasDatabase {
+		return MemoryQuota{}, errors.New("memory quota must have a db")
+	}
+	if quota.MaxMemory == 0 {
+		return MemoryQuota{}, errors.New("memory quota must have a max-memory greater than 0")
+	}
+	return quota, nil
 }
 
 func GetConfig() (Config, error) {
@@ -97,6 +193,107 @@ The options are 'always' for syncing on each command, 'everysec' to sync every s
 			return nil
 		})
 
+	raftRole := constants.RaftRoleVoter
+	flag.Func("raft-role", `The role of the node in the raft cluster.
+The options are 'voter' to take part in elections and commits, and 'nonvoter' to join as a read replica
+that receives the raft log without voting. Non-voters can be promoted with the CLUSTER PROMOTE command.`,
+		func(option string) error {
+			if !slices.ContainsFunc([]string{constants.RaftRoleVoter, constants.RaftRoleNonvoter}, func(s string) bool {
+				return strings.EqualFold(s, option)
+			}) {
+				return errors.New("raftRole must be 'voter' or 'nonvoter'")
+			}
+			raftRole = strings.ToLower(option)
+			return nil
+		})
+
+	var raftCertKeyPairs [][]string
+	var raftCAs []string
+
+	flag.Func("raft-cert-key-pair",
+		`A pair of file paths representing the certificate and key used by the node to authenticate itself to the other
+raft nodes, separated by a comma. The files are reloaded when they change.`,
+		func(s string) error {
+			pair := strings.Split(strings.TrimSpace(s), ",")
+			for i := 0; i < len(pair); i++ {
+				pair[i] = strings.TrimSpace(pair[i])
+			}
+			if len(pair) != 2 {
+				return errors.New("raftCertKeyPair must be 2 comma separated strings")
+			}
+			raftCertKeyPairs = append(raftCertKeyPairs, pair)
+			return nil
+		})
+
+	flag.Func("raft-ca", `Path to a certificate authority used to verify the certificates of the other raft nodes.
+The files are reloaded when they change.`, func(s string) error {
+		raftCAs = append(raftCAs, s)
+		return nil
+	})
+
+	readConsistency := constants.ReadConsistencyStale
+	flag.Func("read-consistency", `The default consistency level of reads in cluster mode.
+The options are 'stale' to serve reads from the local node, 'leader' to forward reads to the cluster leader,
+and 'linearizable' to serve reads only after confirming the leader's commit index has been applied locally.
+Connections can change their level with the READCONSISTENCY command.`,
+		func(option string) error {
+			if !slices.ContainsFunc([]string{
+				constants.ReadConsistencyStale,
+				constants.ReadConsistencyLeader,
+				constants.ReadConsistencyLinearizable,
+			}, func(s string) bool {
+				return strings.EqualFold(s, option)
+			}) {
+				return errors.New("readConsistency must be 'stale', 'leader' or 'linearizable'")
+			}
+			readConsistency = strings.ToLower(option)
+			return nil
+		})
+
+	var replicaOf string
+	flag.Func("replica-of", `The address (host:port) of the primary to replicate on startup in standalone mode.
+The replica receives a snapshot of the primary's state followed by its stream of write commands, and rejects writes from clients.`,
+		func(addr string) error {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("replicaOf must be in the format host:port: %v", err)
+			}
+			replicaOf = addr
+			return nil
+		})
+
+	var replBacklogSize uint64 = 1024 * 1024
+	flag.Func("repl-backlog-size", `The size of the replication backlog that holds the latest write commands sent to replicas.
+Replicas that reconnect within the backlog resume the stream instead of performing a full resynchronisation.
+Supported units (kb, mb, gb, tb, pb). The default is 1mb.`, func(size string) error {
+		b, err := internal.ParseMemory(size)
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			return errors.New("replBacklogSize must be greater than 0")
+		}
+		replBacklogSize = b
+		return nil
+	})
+
+	var primaryCAs []string
+	flag.Func("primary-ca", `Path to a certificate authority used to verify the certificate of the primary and of the active-active peers.
+Repeat the flag for each certificate authority. The system roots are used when none is provided.`, func(s string) error {
+		primaryCAs = append(primaryCAs, s)
+		return nil
+	})
+
+	var peers []string
+	flag.Func("peer", `The address (host:port) of a peer instance in another site for active-active replication in standalone mode.
+Repeat the flag for each peer. Every site accepts writes, and the peers exchange their write effects and resolve conflicts.`,
+		func(addr string) error {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				return fmt.Errorf("peer must be in the format host:port: %v", err)
+			}
+			peers = append(peers, addr)
+			return nil
+		})
+
 	var maxMemory uint64 = 0
 	flag.Func("max-memory", `Upper memory limit before triggering eviction. 
 Supported units (kb, mb, gb, tb, pb). When 0 is passed, there will be no memory limit.
@@ -118,11 +315,12 @@ There is no limit by default.`, func(memory string) error {
 4) volatile-lfu - Evict the least frequently used keys with an expiration.
 5) volatile-lru - Evict the least recently used keys with an expiration.
 6) allkeys-random - Evict random keys until we get under the max-memory limit.
-7) volatile-random - Evict random keys with an expiration.`, func(policy string) error {
+7) volatile-random - Evict random keys with an expiration.
+8) volatile-ttl - Evict the keys with an expiration that are closest to expiring.`, func(policy string) error {
 			policies := []string{
 				constants.NoEviction,
 				constants.AllKeysLFU, constants.AllKeysLRU, constants.AllKeysRandom,
-				constants.VolatileLFU, constants.VolatileLRU, constants.VolatileRandom,
+				constants.VolatileLFU, constants.VolatileLRU, constants.VolatileRandom, constants.VolatileTTL,
 			}
 			policyIdx := slices.Index(policies, strings.ToLower(policy))
 			if policyIdx == -1 {
@@ -132,6 +330,20 @@ There is no limit by default.`, func(memory string) error {
 			return nil
 		})
 
+	var memoryQuotas []MemoryQuota
+	flag.Func("memory-quota", `A memory quota on the keys of a logical database, or on the keys of the database that start with a prefix,
+in the format "db=0,prefix=tenant:42:*,max-memory=100mb,policy=allkeys-lru". Repeat the flag for each quota.
+When the quota is reached, its keys are evicted with its own eviction policy, or with the noeviction policy,
+the writes to its keys are rejected. The prefix is optional and the policy defaults to noeviction.`,
+		func(s string) error {
+			quota, err := ParseMemoryQuota(s)
+			if err != nil {
+				return err
+			}
+			memoryQuotas = append(memoryQuotas, quota)
+			return nil
+		})
+
 	var modules []string
 	flag.Func(
 		"loadmodule",
@@ -146,6 +358,21 @@ There is no limit by default.`, func(memory string) error {
 
 	tls := flag.Bool("tls", false, "Start the echovault in TLS mode. Default is false.")
 	mtls := flag.Bool("mtls", false, "Use mTLS to verify the client.")
+	raftTLS := flag.Bool("raft-tls", false, "Encrypt the raft traffic between cluster nodes with TLS. Default is false.")
+	primaryUser := flag.String("primary-user", "", "The ACL user that authenticates the replica with the primary and with the active-active peers.")
+	primaryAuth := flag.String("primary-auth", "", "The password that authenticates the replica with the primary and with the active-active peers.")
+	primaryTLS := flag.Bool("primary-tls", false, "Connect to the primary and to the active-active peers over TLS. The cert-key-pair certificates are presented when the primary requests them.")
+	raftMTLS := flag.Bool("raft-mtls", false, "Use mTLS so that raft nodes verify each other's certificates. Implies raft-tls.")
+	raftTLSServerName := flag.String("raft-tls-server-name", "", `The name expected in the certificates of the other raft nodes.
+If not provided, the host of the node's raft address is verified instead.`)
+	gossipKeyFile := flag.String("gossip-key-file", "", `Path to the file containing the AES keys used to encrypt the memberlist gossip between cluster nodes.
+The file contains one hex or base64 encoded key per line. The key on the first line is used for encryption and the remaining keys are only used for decryption.
+The file is reloaded when it changes, which allows the keys to be rotated without a restart.
+If not provided, the keys are read from the SUGARDB_GOSSIP_KEY environment variable as a comma-separated list. Gossip encryption is disabled when neither is set.`)
+	siteID := flag.String("site-id", "", `The unique and stable ID of this site in active-active replication. Required when a peer is configured.`)
+	tombstoneTTL := flag.Duration("tombstone-ttl", 24*time.Hour, `The time active-active replication keeps the metadata of deleted keys,
+deleted hash fields and replaced counters. Effects written before it and received later are merged as new writes,
+so it must be longer than the time a peer can stay disconnected. Default is 24 hours.`)
 	port := flag.Int("port", 7480, "Port to use. Default is 7480")
 	serverId := flag.String("server-id", "1", "SugarDB ID in raft cluster. Leave empty for client.")
 	joinAddr := flag.String("join-addr", "", "Address of cluster member in a cluster to you want to join.")
@@ -153,17 +380,58 @@ There is no limit by default.`, func(memory string) error {
 	discoveryPort := flag.Uint("discovery-port", 7946, "Port to use for memberlist cluster discovery.")
 	dataDir := flag.String("data-dir", ".", "Directory to store snapshots and logs.")
 	bootstrapCluster := flag.Bool("bootstrap-cluster", false, "Whether this instance should bootstrap a new cluster.")
+	shardID := flag.String("shard-id", "", `The ID of the shard this instance belongs to. Each shard is a separate raft group that owns a set of hash slots.
+Hash slot sharding is enabled when this is set. One node in each shard should bootstrap the shard's raft group.`)
 	aclConfig := flag.String("acl-config", "", "ACL config file path.")
 	snapshotThreshold := flag.Uint64("snapshot-threshold", 1000, "The number of entries that trigger a snapshot. Default is 1000.")
 	snapshotInterval := flag.Duration("snapshot-interval", 5*time.Minute, "The time interval between snapshots (in seconds). Default is 5 minutes.")
 	restoreSnapshot := flag.Bool("restore-snapshot", false, "This flag prompts the echovault to restore state from snapshot when set to true. Only works in standalone mode. Higher priority than restoreAOF.")
+	restoreBackup := flag.String("restore-backup", "", "Path to a backup archive created with the BACKUP command to restore on startup. Only works in standalone mode. Higher priority than restoreSnapshot and restoreAOF.")
+	encryptionKeyFile := flag.String("encryption-key-file", "", `Path to the file containing the AES keys used to encrypt snapshots, AOF files and raft data at rest.
+The file contains one hex or base64 encoded key per line. The key on the first line is used for encryption and the remaining keys are only used to decrypt data written before a key rotation.
+If not provided, the keys are read from the SUGARDB_ENCRYPTION_KEY environment variable as a comma-separated list. Encryption at rest is disabled when neither is set.`)
 	restoreAOF := flag.Bool("restore-aof", false, "This flag prompts the echovault to restore state from append-only logs. Only works in standalone mode. Lower priority than restoreSnapshot.")
-	evictionSample := flag.Uint("eviction-sample", 20, "An integer specifying the number of keys to sample when checking for expired keys.")
-	evictionInterval := flag.Duration("eviction-interval", 100*time.Millisecond, "The interval between each sampling of keys to evict.")
+	evictionSample := flag.Uint("eviction-sample", 20, `An integer specifying the number of keys to check in each batch of the active expiry,
+and the number of keys to sample when looking for the keys to evict with an LRU or LFU eviction policy.`)
+	evictionInterval := flag.Duration("eviction-interval", 100*time.Millisecond, `The interval between each round of the active expiry of keys.
+An interval of 0 disables the active expiry.`)
+	lfuLogFactor := flag.Uint("lfu-log-factor", 10, `The logarithmic factor of the LFU access counter. The higher the factor, the more accesses
+are needed to increment the counter. With a factor of 10, the counter saturates after about 1M accesses.`)
+	lfuDecayTime := flag.Uint("lfu-decay-time", 1, `The number of minutes after which the LFU access counter of a key that is not accessed is decremented.
+When 0 is passed, the counter never decays.`)
+	lazyFreeEviction := flag.Bool("lazyfree-lazy-eviction", false, `Release the values of evicted keys on a background goroutine.`)
+	lazyFreeExpire := flag.Bool("lazyfree-lazy-expire", false, `Release the values of expired keys on a background goroutine.`)
+	lazyFreeServerDel := flag.Bool("lazyfree-lazy-server-del", false, `Release the values that are implicitly overwritten by commands
+such as SET or RENAME on a background goroutine.`)
+	hashMaxListpackEntries := flag.Uint("hash-max-listpack-entries", 128, `The max number of fields of a hash in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	hashMaxListpackValue := flag.Uint("hash-max-listpack-value", 64, `The max length of a field or value of a hash in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	setMaxIntsetEntries := flag.Uint("set-max-intset-entries", 512, `The max number of members of a set of integers in the compact intset encoding.
+When 0 is passed, the compact encoding is not used.`)
+	setMaxListpackEntries := flag.Uint("set-max-listpack-entries", 128, `The max number of members of a set in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	setMaxListpackValue := flag.Uint("set-max-listpack-value", 64, `The max length of a member of a set in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	zSetMaxListpackEntries := flag.Uint("zset-max-listpack-entries", 128, `The max number of members of a sorted set in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	zSetMaxListpackValue := flag.Uint("zset-max-listpack-value", 64, `The max length of a member of a sorted set in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	listMaxListpackEntries := flag.Uint("list-max-listpack-entries", 128, `The max number of elements of a list in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	listMaxListpackValue := flag.Uint("list-max-listpack-value", 64, `The max length of an element of a list in the compact listpack encoding.
+When 0 is passed, the compact encoding is not used.`)
+	tieredStorage := flag.Bool("tiered-storage", false, `Spill the values of cold keys to a store on disk under the data directory when the max memory is reached,
+instead of evicting the keys. Requires an LFU or LRU eviction policy, and is only supported in standalone mode.`)
 	forwardCommand := flag.Bool(
 		"forward-commands",
 		false,
 		"If the node is a follower, this flag forwards mutation command to the leader when set to true")
+	raftBatchWindow := flag.Duration("raft-batch-window", 0, `How long the leader waits for more writes to add to a raft log entry after the first one.
+When 0, only the writes already waiting are batched together, which adds no latency.`)
+	raftBatchSize := flag.Uint("raft-batch-size", 128, "The maximum number of writes batched into one raft log entry.")
+	raftApplyTimeout := flag.Duration("raft-apply-timeout", 500*time.Millisecond, "The maximum time the leader waits to append a batch of writes to the raft log.")
+	forwardTimeout := flag.Duration("forward-timeout", 5*time.Second, "The maximum time a follower waits for the leader to respond to a forwarded command, including retries while a leader is elected.")
 	requirePass := flag.Bool(
 		"require-pass",
 		false,
@@ -194,33 +462,73 @@ It is a plain text value by default but you can provide a SHA256 hash by adding
 	}
 
 	conf := Config{
-		CertKeyPairs:      certKeyPairs,
-		ClientCAs:         clientCAs,
-		TLS:               *tls,
-		MTLS:              *mtls,
-		Port:              uint16(*port),
-		ServerID:          *serverId,
-		JoinAddr:          *joinAddr,
-		BindAddr:          *bindAddr,
-		DataDir:           *dataDir,
-		BootstrapCluster:  *bootstrapCluster,
-		AclConfig:         *aclConfig,
-		ForwardCommand:    *forwardCommand,
-		RequirePass:       *requirePass,
-		Password:          *password,
-		SnapShotThreshold: *snapshotThreshold,
-		SnapshotInterval:  *snapshotInterval,
-		RestoreSnapshot:   *restoreSnapshot,
-		RestoreAOF:        *restoreAOF,
-		AOFSyncStrategy:   aofSyncStrategy,
-		MaxMemory:         maxMemory,
-		EvictionPolicy:    evictionPolicy,
-		EvictionSample:    *evictionSample,
-		EvictionInterval:  *evictionInterval,
-		Modules:           modules,
-		DiscoveryPort:     uint16(*discoveryPort),
-		RaftBindAddr:      raftBindAddr,
-		RaftBindPort:      uint16(raftBindPort),
+		CertKeyPairs:           certKeyPairs,
+		ClientCAs:              clientCAs,
+		TLS:                    *tls,
+		MTLS:                   *mtls,
+		Port:                   uint16(*port),
+		ServerID:               *serverId,
+		JoinAddr:               *joinAddr,
+		BindAddr:               *bindAddr,
+		DataDir:                *dataDir,
+		BootstrapCluster:       *bootstrapCluster,
+		ShardID:                *shardID,
+		RaftRole:               raftRole,
+		RaftTLS:                *raftTLS || *raftMTLS,
+		RaftMTLS:               *raftMTLS,
+		RaftCertKeyPairs:       raftCertKeyPairs,
+		RaftCAs:                raftCAs,
+		RaftTLSServerName:      *raftTLSServerName,
+		GossipKeyFile:          *gossipKeyFile,
+		AclConfig:              *aclConfig,
+		ForwardCommand:         *forwardCommand,
+		ForwardTimeout:         *forwardTimeout,
+		RaftBatchWindow:        *raftBatchWindow,
+		RaftBatchSize:          *raftBatchSize,
+		RaftApplyTimeout:       *raftApplyTimeout,
+		ReadConsistency:        readConsistency,
+		RequirePass:            *requirePass,
+		Password:               *password,
+		SnapShotThreshold:      *snapshotThreshold,
+		SnapshotInterval:       *snapshotInterval,
+		RestoreSnapshot:        *restoreSnapshot,
+		RestoreAOF:             *restoreAOF,
+		AOFSyncStrategy:        aofSyncStrategy,
+		EncryptionKeyFile:      *encryptionKeyFile,
+		RestoreBackup:          *restoreBackup,
+		ReplicaOf:              replicaOf,
+		ReplBacklogSize:        replBacklogSize,
+		PrimaryUser:            *primaryUser,
+		PrimaryAuth:            *primaryAuth,
+		PrimaryTLS:             *primaryTLS,
+		PrimaryCAs:             primaryCAs,
+		SiteID:                 *siteID,
+		Peers:                  peers,
+		TombstoneTTL:           *tombstoneTTL,
+		MaxMemory:              maxMemory,
+		EvictionPolicy:         evictionPolicy,
+		EvictionSample:         *evictionSample,
+		EvictionInterval:       *evictionInterval,
+		LFULogFactor:           *lfuLogFactor,
+		LFUDecayTime:           *lfuDecayTime,
+		LazyFreeEviction:       *lazyFreeEviction,
+		LazyFreeExpire:         *lazyFreeExpire,
+		LazyFreeServerDel:      *lazyFreeServerDel,
+		HashMaxListpackEntries: *hashMaxListpackEntries,
+		HashMaxListpackValue:   *hashMaxListpackValue,
+		SetMaxIntsetEntries:    *setMaxIntsetEntries,
+		SetMaxListpackEntries:  *setMaxListpackEntries,
+		SetMaxListpackValue:    *setMaxListpackValue,
+		ZSetMaxListpackEntries: *zSetMaxListpackEntries,
+		ZSetMaxListpackValue:   *zSetMaxListpackValue,
+		ListMaxListpackEntries: *listMaxListpackEntries,
+		ListMaxListpackValue:   *listMaxListpackValue,
+		TieredStorage:          *tieredStorage,
+		MemoryQuotas:           memoryQuotas,
+		Modules:                modules,
+		DiscoveryPort:          uint16(*discoveryPort),
+		RaftBindAddr:           raftBindAddr,
+		RaftBindPort:           uint16(raftBindPort),
 	}
 
 	if len(*config) > 0 {
@@ -13,3 +13,73 @@
 // limitations under the License.
 
 package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/echovault/sugardb/internal/constants"
+)
+
+func Test_ParseMemoryQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   string
+		want    MemoryQuota
+		wantErr string
+	}{
+		{
+			name:  "1. Quota on the keys of a database with a prefix",
+			quota: "db=3,prefix=tenant:42:*,max-memory=10mb,policy=ALLKEYS-LRU",
+			want:  MemoryQuota{Database: 3, Prefix: "tenant:42:*", MaxMemory: 10 * 1024 * 1024, Policy: constants.AllKeysLRU},
+		},
+		{
+			name:  "2. Quota on all the keys of a database defaults to noeviction",
+			quota: "db=0, max-memory=1kb",
+			want:  MemoryQuota{Database: 0, MaxMemory: 1024, Policy: constants.NoEviction},
+		},
+		{
+			name:    "3. Quota without a database",
+			quota:   "max-memory=1kb",
+			wantErr: "memory quota must have a db",
+		},
+		{
+			name:    "4. Quota without a max memory",
+			quota:   "db=0,prefix=tenant:",
+			wantErr: "memory quota must have a max-memory greater than 0",
+		},
+		{
+			name:    "5. Quota with a negative database",
+			quota:   "db=-1,max-memory=1kb",
+			wantErr: "memory quota db -1 must be a non-negative integer",
+		},
+		{
+			name:    "6. Quota with an unknown option",
+			quota:   "db=0,max-memory=1kb,samples=5",
+			wantErr: "unknown memory quota option samples",
+		},
+		{
+			name:    "7. Quota with an option that is not a name and value pair",
+			quota:   "db=0,max-memory=1kb,lru",
+			wantErr: "memory quota option lru must be in the format name=value",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			quota, err := ParseMemoryQuota(test.quota)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error \"%s\", got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quota != test.want {
+				t.Errorf("expected quota %+v, got %+v", test.want, quota)
+			}
+		})
+	}
+}
@@ -11,32 +11,72 @@ func DefaultConfig() Config {
 	raftBindPort, _ := internal.GetFreePort()
 
 	return Config{
-		TLS:               false,
-		MTLS:              false,
-		CertKeyPairs:      make([][]string, 0),
-		ClientCAs:         make([]string, 0),
-		Port:              7480,
-		ServerID:          "",
-		JoinAddr:          "",
-		BindAddr:          "localhost",
-		RaftBindAddr:      raftBindAddr,
-		RaftBindPort:      uint16(raftBindPort),
-		DiscoveryPort:     7946,
-		DataDir:           ".",
-		BootstrapCluster:  false,
-		AclConfig:         "",
-		ForwardCommand:    false,
-		RequirePass:       false,
-		Password:          "",
-		SnapShotThreshold: 1000,
-		SnapshotInterval:  5 * time.Minute,
-		RestoreAOF:        false,
-		RestoreSnapshot:   false,
-		AOFSyncStrategy:   "everysec",
-		MaxMemory:         0,
-		EvictionPolicy:    constants.NoEviction,
-		EvictionSample:    20,
-		EvictionInterval:  100 * time.Millisecond,
-		Modules:           make([]string, 0),
+		TLS:                    false,
+		MTLS:                   false,
+		CertKeyPairs:           make([][]string, 0),
+		ClientCAs:              make([]string, 0),
+		Port:                   7480,
+		ServerID:               "",
+		JoinAddr:               "",
+		BindAddr:               "localhost",
+		RaftBindAddr:           raftBindAddr,
+		RaftBindPort:           uint16(raftBindPort),
+		DiscoveryPort:          7946,
+		DataDir:                ".",
+		BootstrapCluster:       false,
+		ShardID:                "",
+		RaftRole:               constants.RaftRoleVoter,
+		RaftTLS:                false,
+		RaftMTLS:               false,
+		RaftCertKeyPairs:       make([][]string, 0),
+		RaftCAs:                make([]string, 0),
+		RaftTLSServerName:      "",
+		GossipKeyFile:          "",
+		AclConfig:              "",
+		ForwardCommand:         false,
+		ForwardTimeout:         5 * time.Second,
+		RaftBatchWindow:        0,
+		RaftBatchSize:          128,
+		RaftApplyTimeout:       500 * time.Millisecond,
+		ReadConsistency:        constants.ReadConsistencyStale,
+		RequirePass:            false,
+		Password:               "",
+		SnapShotThreshold:      1000,
+		SnapshotInterval:       5 * time.Minute,
+		RestoreAOF:             false,
+		RestoreSnapshot:        false,
+		AOFSyncStrategy:        "everysec",
+		EncryptionKeyFile:      "",
+		RestoreBackup:          "",
+		ReplicaOf:              "",
+		ReplBacklogSize:        1024 * 1024,
+		PrimaryUser:            "",
+		PrimaryAuth:            "",
+		PrimaryTLS:             false,
+		PrimaryCAs:             make([]string, 0),
+		SiteID:                 "",
+		Peers:                  make([]string, 0),
+		TombstoneTTL:           24 * time.Hour,
+		MaxMemory:              0,
+		EvictionPolicy:         constants.NoEviction,
+		EvictionSample:         20,
+		EvictionInterval:       100 * time.Millisecond,
+		LFULogFactor:           10,
+		LFUDecayTime:           1,
+		LazyFreeEviction:       false,
+		LazyFreeExpire:         false,
+		LazyFreeServerDel:      false,
+		HashMaxListpackEntries: 128,
+		HashMaxListpackValue:   64,
+		SetMaxIntsetEntries:    512,
+		SetMaxListpackEntries:  128,
+		SetMaxListpackValue:    64,
+		ZSetMaxListpackEntries: 128,
+		ZSetMaxListpackValue:   64,
+		ListMaxListpackEntries: 128,
+		ListMaxListpackValue:   64,
+		TieredStorage:          false,
+		MemoryQuotas:           make([]MemoryQuota, 0),
+		Modules:                make([]string, 0),
 	}
 }
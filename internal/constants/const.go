@@ -19,6 +19,7 @@ const Version = "0.13.1" // Next SugarDB version. Update this before each releas
 const (
 	ACLModule        = "acl"
 	AdminModule      = "admin"
+	ClusterModule    = "cluster"
 	ConnectionModule = "connection"
 	GenericModule    = "generic"
 	HashModule       = "hash"
@@ -68,6 +69,28 @@ const (
 	VolatileLFU    = "volatile-lfu"
 	AllKeysRandom  = "allkeys-random"
 	VolatileRandom = "volatile-random"
+	VolatileTTL    = "volatile-ttl"
+)
+
+const (
+	RaftRoleVoter    = "voter"    // Takes part in elections and counts towards the commit quorum.
+	RaftRoleNonvoter = "nonvoter" // Replicates the raft log and serves reads without voting.
+)
+
+const (
+	ReadConsistencyStale        = "stale"        // Serve reads from the local node's state.
+	ReadConsistencyLeader       = "leader"       // Forward reads to the cluster leader.
+	ReadConsistencyLinearizable = "linearizable" // Serve reads after confirming leadership and catching up to the leader's commit index.
+)
+
+// The encodings of values reported by OBJECT ENCODING.
+const (
+	EncodingInt       = "int"       // An integer.
+	EncodingRaw       = "raw"       // A string, a float, or a value of a type set by a module.
+	EncodingListpack  = "listpack"  // A small hash, set, sorted set or list packed in a contiguous array.
+	EncodingIntset    = "intset"    // A small set of integers kept in a sorted array.
+	EncodingHashtable = "hashtable" // A hash, set or sorted set held in a map.
+	EncodingArray     = "array"     // A list held in a slice.
 )
 
 // CompositeTypes are SugarDB KeyData Value types like set, sorted set, etc.
@@ -0,0 +1,327 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryption provides AES-GCM encryption at rest for the files written to the data directory.
+//
+// Data is encrypted in frames. Each frame has the following layout:
+//
+//	magic (4 bytes) | key id (4 bytes) | ciphertext length (4 bytes) | nonce (12 bytes) | ciphertext
+//
+// The key id is derived from the key that sealed the frame, which allows frames sealed with previous keys
+// to be decrypted after a key rotation. Data that does not start with the magic bytes is treated as plaintext
+// so that existing data directories can be migrated to encryption transparently.
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeyEnvVar is the environment variable used to provide the encryption keys when no key file is configured.
+// It holds a comma-separated list of keys with the active key first.
+const KeyEnvVar = "SUGARDB_ENCRYPTION_KEY"
+
+var magic = []byte("SDBE")
+
+const (
+	keyIDSize  = 4
+	lengthSize = 4
+	nonceSize  = 12
+	headerSize = 4 + keyIDSize + lengthSize + nonceSize
+	// maxFrameSize guards against allocating huge buffers when reading a corrupted frame header.
+	maxFrameSize = 1 << 31
+)
+
+// ErrNoKey is returned when encrypted data is read but no encryption key has been configured.
+var ErrNoKey = errors.New("data is encrypted but no encryption key is configured")
+
+type key struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+// Keyring holds the keys used to encrypt and decrypt data.
+// The first key is the active key used to seal new data. The remaining keys are previous keys that
+// are only used to open data sealed before a key rotation.
+// A nil Keyring is valid and leaves data unencrypted.
+type Keyring struct {
+	active key
+	keys   map[[keyIDSize]byte]cipher.AEAD
+}
+
+// NewKeyring creates a keyring from the provided raw keys. The first key is the active key.
+// Each key must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+	keyring := &Keyring{keys: make(map[[keyIDSize]byte]cipher.AEAD)}
+	for i, k := range keys {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %v", i, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %v", i, err)
+		}
+		sum := sha256.Sum256(k)
+		var id [keyIDSize]byte
+		copy(id[:], sum[:keyIDSize])
+		if i == 0 {
+			keyring.active = key{id: id, aead: aead}
+		}
+		keyring.keys[id] = aead
+	}
+	return keyring, nil
+}
+
+// LoadKeyring loads the keyring from the key file if provided, otherwise from the KeyEnvVar environment variable.
+// The key file contains one key per line with the active key on the first line. Empty lines and lines starting
+// with '#' are ignored. Keys are hex or base64 encoded.
+// Returns a nil keyring when neither the key file nor the environment variable is set.
+func LoadKeyring(keyFile string) (*Keyring, error) {
+	keys, err := ReadKeys(keyFile, KeyEnvVar)
+	if err != nil {
+		return nil, fmt.Errorf("load encryption key file: %v", err)
+	}
+	if keys == nil {
+		return nil, nil
+	}
+	return NewKeyring(keys...)
+}
+
+// ReadKeys reads the AES keys from the key file if provided, otherwise from the comma-separated list in the
+// environment variable. The key file uses the format described in LoadKeyring.
+// Returns nil when neither the key file nor the environment variable is set.
+func ReadKeys(keyFile string, envVar string) ([][]byte, error) {
+	var encoded []string
+
+	if keyFile != "" {
+		b, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			encoded = append(encoded, line)
+		}
+		if len(encoded) == 0 {
+			return nil, fmt.Errorf("no keys found in %s", keyFile)
+		}
+	} else if env := os.Getenv(envVar); env != "" {
+		for _, k := range strings.Split(env, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				encoded = append(encoded, k)
+			}
+		}
+	} else {
+		return nil, nil
+	}
+
+	keys := make([][]byte, len(encoded))
+	for i, e := range encoded {
+		k, err := decodeKey(e)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i, err)
+		}
+		keys[i] = k
+	}
+
+	return keys, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	validLength := func(b []byte) bool {
+		return len(b) == 16 || len(b) == 24 || len(b) == 32
+	}
+	if b, err := hex.DecodeString(s); err == nil && validLength(b) {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && validLength(b) {
+		return b, nil
+	}
+	return nil, errors.New("key must be a hex or base64 encoded 16, 24 or 32 byte key")
+}
+
+// IsEncrypted reports whether the data starts with an encrypted frame.
+func IsEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, magic)
+}
+
+// UsesActiveKey reports whether the data was encrypted with the keyring's active key.
+// If the keyring is nil, it reports whether the data is in plaintext.
+// This is used to determine whether data must be re-written after a key rotation.
+func (keyring *Keyring) UsesActiveKey(b []byte) bool {
+	if keyring == nil {
+		return !IsEncrypted(b)
+	}
+	return IsEncrypted(b) && len(b) >= headerSize && bytes.Equal(b[4:4+keyIDSize], keyring.active.id[:])
+}
+
+// seal encrypts the plaintext into a single frame using the active key.
+func (keyring *Keyring) seal(plaintext []byte) ([]byte, error) {
+	aead := keyring.active.aead
+	frame := make([]byte, headerSize, headerSize+len(plaintext)+aead.Overhead())
+	copy(frame, magic)
+	copy(frame[4:], keyring.active.id[:])
+	binary.BigEndian.PutUint32(frame[4+keyIDSize:], uint32(len(plaintext)+aead.Overhead()))
+	nonce := frame[headerSize-nonceSize : headerSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The header is authenticated along with the ciphertext.
+	return aead.Seal(frame, nonce, plaintext, frame[:headerSize-nonceSize]), nil
+}
+
+// readFrame reads and decrypts the next frame from the reader.
+// Returns io.EOF when there are no more frames. An incomplete frame at the end of the stream is the
+// torn tail of a write that was interrupted by a crash, so it is dropped and io.EOF is returned as well.
+func (keyring *Keyring) readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read encrypted frame header: %v", err)
+	}
+	if !IsEncrypted(header) {
+		return nil, errors.New("read encrypted frame: invalid frame header")
+	}
+	if keyring == nil {
+		return nil, ErrNoKey
+	}
+
+	var id [keyIDSize]byte
+	copy(id[:], header[4:4+keyIDSize])
+	aead, ok := keyring.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("read encrypted frame: no key with id %x", id)
+	}
+
+	length := binary.BigEndian.Uint32(header[4+keyIDSize:])
+	if length > maxFrameSize {
+		return nil, errors.New("read encrypted frame: invalid frame length")
+	}
+	ciphertext := make([]byte, length)
+	if _, err := io.ReadFull(r, ciphertext); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read encrypted frame: %v", err)
+	}
+
+	plaintext, err := aead.Open(nil, header[headerSize-nonceSize:], ciphertext, header[:headerSize-nonceSize])
+	if err != nil {
+		return nil, fmt.Errorf("decrypt frame: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Encrypt seals the data into a single frame with the active key.
+// If the keyring is nil, the data is returned as is.
+func (keyring *Keyring) Encrypt(b []byte) ([]byte, error) {
+	if keyring == nil {
+		return b, nil
+	}
+	return keyring.seal(b)
+}
+
+// Decrypt opens all the frames in the data and returns the concatenated plaintext.
+// Data that is not encrypted is returned as is.
+func (keyring *Keyring) Decrypt(b []byte) ([]byte, error) {
+	if !IsEncrypted(b) {
+		return b, nil
+	}
+	return io.ReadAll(NewReader(bytes.NewReader(b), keyring))
+}
+
+// Reader decrypts a stream of frames.
+// If the stream does not start with an encrypted frame, it is read as plaintext.
+type Reader struct {
+	r       *bufio.Reader
+	keyring *Keyring
+	buf     []byte
+	started bool
+	plain   bool
+}
+
+// NewReader returns a reader that decrypts the frames read from r.
+func NewReader(r io.Reader, keyring *Keyring) *Reader {
+	return &Reader{r: bufio.NewReader(r), keyring: keyring}
+}
+
+func (reader *Reader) Read(p []byte) (int, error) {
+	if !reader.started {
+		reader.started = true
+		head, err := reader.r.Peek(len(magic))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		reader.plain = !IsEncrypted(head)
+	}
+
+	if reader.plain {
+		return reader.r.Read(p)
+	}
+
+	for len(reader.buf) == 0 {
+		plaintext, err := reader.keyring.readFrame(reader.r)
+		if err != nil {
+			return 0, err
+		}
+		reader.buf = plaintext
+	}
+
+	n := copy(p, reader.buf)
+	reader.buf = reader.buf[n:]
+	return n, nil
+}
+
+// Writer seals each call to Write into its own frame.
+type Writer struct {
+	w       io.Writer
+	keyring *Keyring
+}
+
+// NewWriter returns a writer that encrypts the data written to w.
+func NewWriter(w io.Writer, keyring *Keyring) *Writer {
+	return &Writer{w: w, keyring: keyring}
+}
+
+func (writer *Writer) Write(p []byte) (int, error) {
+	frame, err := writer.keyring.Encrypt(p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = writer.w.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,302 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/echovault/sugardb/internal/encryption"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func Test_Keyring(t *testing.T) {
+	plaintext := []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")
+
+	oldKeyring, err := encryption.NewKeyring(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedKeyring, err := encryption.NewKeyring(key2, key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := oldKeyring.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.IsEncrypted(ciphertext) || bytes.Contains(ciphertext, []byte("value")) {
+		t.Errorf("expected ciphertext not to contain the plaintext, got %q", ciphertext)
+	}
+
+	tests := []struct {
+		name    string
+		keyring *encryption.Keyring
+		data    []byte
+		want    []byte
+		wantErr string
+	}{
+		{
+			name:    "1. Decrypt data with the key that encrypted it",
+			keyring: oldKeyring,
+			data:    ciphertext,
+			want:    plaintext,
+		},
+		{
+			name:    "2. Decrypt data encrypted with a previous key after rotation",
+			keyring: rotatedKeyring,
+			data:    ciphertext,
+			want:    plaintext,
+		},
+		{
+			name:    "3. Plaintext data is returned as is",
+			keyring: rotatedKeyring,
+			data:    plaintext,
+			want:    plaintext,
+		},
+		{
+			name:    "4. Return error when decrypting without a keyring",
+			keyring: nil,
+			data:    ciphertext,
+			wantErr: encryption.ErrNoKey.Error(),
+		},
+		{
+			name: "5. Return error when the key that encrypted the data is missing",
+			keyring: func() *encryption.Keyring {
+				keyring, _ := encryption.NewKeyring(key2)
+				return keyring
+			}(),
+			data:    ciphertext,
+			wantErr: "no key with id",
+		},
+		{
+			name:    "6. Return error when the ciphertext has been tampered with",
+			keyring: oldKeyring,
+			data: func() []byte {
+				b := bytes.Clone(ciphertext)
+				b[len(b)-1] ^= 0xff
+				return b
+			}(),
+			wantErr: "decrypt frame",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.keyring.Decrypt(test.data)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func Test_LoadKeyring(t *testing.T) {
+	directory := path.Join(".", "testdata")
+	t.Cleanup(func() {
+		_ = os.RemoveAll(directory)
+	})
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := path.Join(directory, "keys")
+	content := "# active key\n" + hex.EncodeToString(key2) + "\n\n" + hex.EncodeToString(key1) + "\n"
+	if err := os.WriteFile(keyFile, []byte(content), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := encryption.LoadKeyring(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Data encrypted with the first key in the file must be decryptable with only that key.
+	ciphertext, err := keyring.Encrypt([]byte("value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeKeyring, _ := encryption.NewKeyring(key2)
+	if _, err = activeKeyring.Decrypt(ciphertext); err != nil {
+		t.Errorf("expected data to be encrypted with the first key in the file: %v", err)
+	}
+
+	t.Setenv(encryption.KeyEnvVar, "")
+	if keyring, err = encryption.LoadKeyring(""); err != nil || keyring != nil {
+		t.Errorf("expected nil keyring and no error, got %v, %v", keyring, err)
+	}
+
+	t.Setenv(encryption.KeyEnvVar, "not-a-key")
+	if _, err = encryption.LoadKeyring(""); err == nil {
+		t.Error("expected error when loading an invalid key")
+	}
+}
+
+func Test_ReadWriter(t *testing.T) {
+	directory := path.Join(".", "testdata", "read_writer")
+	t.Cleanup(func() {
+		_ = os.RemoveAll(path.Join(".", "testdata"))
+	})
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := encryption.NewKeyring(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a plaintext file as it would exist before encryption at rest was enabled.
+	name := path.Join(directory, "log.aof")
+	if err = os.WriteFile(name, []byte("plaintext-1;"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the plaintext file without migrating it first should fail.
+	if _, err = encryption.NewReadWriter(f, keyring); err == nil {
+		t.Error("expected error when opening a plaintext file with a keyring")
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = encryption.EncryptFile(name, keyring); err != nil {
+		t.Fatal(err)
+	}
+	// The migration goes through a temporary file, which must not be left behind.
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the migrated file in the directory, got %d entries", len(entries))
+	}
+
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw, err := encryption.NewReadWriter(f, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Write([]byte("encrypted-2;")); err != nil {
+		t.Fatal(err)
+	}
+	if err = rw.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path.Join(directory, "log.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("plaintext")) || bytes.Contains(raw, []byte("encrypted")) {
+		t.Errorf("expected file to be encrypted, got %q", raw)
+	}
+
+	if _, err = rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "plaintext-1;encrypted-2;" {
+		t.Errorf("expected \"plaintext-1;encrypted-2;\", got %q", got)
+	}
+
+	// Opening the encrypted file without a key should fail.
+	if _, err = encryption.NewReadWriter(f, nil); !errors.Is(err, encryption.ErrNoKey) {
+		t.Errorf("expected error %v, got %v", encryption.ErrNoKey, err)
+	}
+
+	if err = rw.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// Simulate a crash in the middle of a write by appending an incomplete frame.
+	frame, err := keyring.Encrypt([]byte("torn-3;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_APPEND, os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write(frame[:len(frame)-4]); err != nil {
+		t.Fatal(err)
+	}
+
+	// The torn frame is dropped when reading.
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(encryption.NewReader(f, keyring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "plaintext-1;encrypted-2;" {
+		t.Errorf("expected \"plaintext-1;encrypted-2;\", got %q", got)
+	}
+
+	// Reopening the file truncates the torn frame so that new writes can be read back.
+	rw, err = encryption.NewReadWriter(f, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Write([]byte("encrypted-4;")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rw.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "plaintext-1;encrypted-2;encrypted-4;" {
+		t.Errorf("expected \"plaintext-1;encrypted-2;encrypted-4;\", got %q", got)
+	}
+
+	if err = rw.Close(); err != nil {
+		t.Error(err)
+	}
+}
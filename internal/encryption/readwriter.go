@@ -0,0 +1,229 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File is the interface implemented by the files used by the AOF engine.
+// It matches both the preamble.ReadWriter and log.ReadWriter interfaces.
+type File interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// ReadWriter transparently encrypts every Write into its own frame and decrypts the frames when reading.
+// The AOF stores only read a file from the beginning and only truncate it to 0, so seeking and truncating
+// are only supported for offset 0.
+type ReadWriter struct {
+	rw      File
+	keyring *Keyring
+	reader  *Reader
+}
+
+// EncryptFile encrypts the plaintext file at the path as a single frame so that files created before encryption
+// was enabled keep working. The encrypted copy is written to a temporary file, synced and renamed over the
+// original, so a crash during the migration leaves either the plaintext or the encrypted file intact.
+// Files that do not exist, are empty or are already encrypted are left as they are.
+func EncryptFile(name string, keyring *Keyring) error {
+	if keyring == nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	if len(content) == 0 || IsEncrypted(content) {
+		return nil
+	}
+
+	frame, err := keyring.Encrypt(content)
+	if err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err = f.Write(frame); err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+
+	// Sync the directory so that the rename survives a crash.
+	dir, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	defer func() {
+		_ = dir.Close()
+	}()
+	if err = dir.Sync(); err != nil {
+		return fmt.Errorf("encrypt existing file: %v", err)
+	}
+	return nil
+}
+
+// NewReadWriter wraps the file so that its content is encrypted with the keyring.
+//
+// Files that contain plaintext must be migrated with EncryptFile before they are opened.
+// An incomplete frame at the end of the file, left behind by a crash during a write, is truncated so that
+// the frames appended after it can be read back.
+// If the keyring is nil, the file is returned as is unless it contains encrypted data, in which case ErrNoKey
+// is returned.
+func NewReadWriter(rw File, keyring *Keyring) (File, error) {
+	// Only the start of the file is needed to determine whether it is encrypted.
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(magic))
+	n, err := io.ReadFull(rw, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	head = head[:n]
+	if _, err = rw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if keyring == nil {
+		if IsEncrypted(head) {
+			return nil, ErrNoKey
+		}
+		return rw, nil
+	}
+
+	if len(head) > 0 && !IsEncrypted(head) {
+		return nil, errors.New("file contains plaintext data, it must be encrypted with EncryptFile first")
+	}
+
+	end, size, err := completeFrames(rw)
+	if err != nil {
+		return nil, err
+	}
+	if end < size {
+		if err = rw.Truncate(end); err != nil {
+			return nil, fmt.Errorf("truncate incomplete frame: %v", err)
+		}
+		if err = rw.Sync(); err != nil {
+			return nil, fmt.Errorf("truncate incomplete frame: %v", err)
+		}
+	}
+	if _, err = rw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return &ReadWriter{rw: rw, keyring: keyring}, nil
+}
+
+// completeFrames walks the frame headers from the start of the file and returns the offset at which the
+// last complete frame ends along with the size of the file.
+func completeFrames(rw File) (int64, int64, error) {
+	r := bufio.NewReader(rw)
+	header := make([]byte, headerSize)
+	var end, size int64
+	for {
+		n, err := io.ReadFull(r, header)
+		size += int64(n)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return end, size, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if !IsEncrypted(header) {
+			return 0, 0, errors.New("read encrypted frame: invalid frame header")
+		}
+		length := int64(binary.BigEndian.Uint32(header[4+keyIDSize:]))
+		skipped, err := r.Discard(int(length))
+		size += int64(skipped)
+		if errors.Is(err, io.EOF) {
+			return end, size, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		end = size
+	}
+}
+
+func (rw *ReadWriter) Read(p []byte) (int, error) {
+	if rw.reader == nil {
+		rw.reader = NewReader(rw.rw, rw.keyring)
+	}
+	return rw.reader.Read(p)
+}
+
+func (rw *ReadWriter) Write(p []byte) (int, error) {
+	frame, err := rw.keyring.Encrypt(p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = rw.rw.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rw *ReadWriter) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("encrypted file only supports seeking to the start of the file")
+	}
+	rw.reader = nil
+	return rw.rw.Seek(offset, whence)
+}
+
+func (rw *ReadWriter) Truncate(size int64) error {
+	if size != 0 {
+		return errors.New("encrypted file can only be truncated to size 0")
+	}
+	rw.reader = nil
+	return rw.rw.Truncate(size)
+}
+
+func (rw *ReadWriter) Sync() error {
+	return rw.rw.Sync()
+}
+
+func (rw *ReadWriter) Close() error {
+	return rw.rw.Close()
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eviction implements the approximate LRU and LFU eviction of keys. Like Redis, each key holds 24 bits of
+// access metadata, and keys are evicted by sampling the keyspace instead of keeping every key in an ordered structure.
+// With an LRU policy, the metadata is the time of the latest access. With an LFU policy, the 16 high bits are the
+// time of the latest decrement of the access counter in minutes, and the 8 low bits are a logarithmic access counter.
+package eviction
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// LRUClockResolution is the resolution of the LRU clock. The 24-bit clock wraps around every 19 days.
+	LRUClockResolution = 100 * time.Millisecond
+	lruClockMax        = 1<<24 - 1
+
+	// LFUInitVal is the counter of new keys, so that they're not evicted before they have a chance to be accessed.
+	LFUInitVal = 5
+	lfuTimeMax = 1<<16 - 1
+)
+
+// LRUClock returns the LRU clock at the time now.
+func LRUClock(now time.Time) uint32 {
+	return uint32(now.UnixMilli()/LRUClockResolution.Milliseconds()) & lruClockMax
+}
+
+// IdleTime returns the time elapsed since the access recorded in the LRU metadata.
+func IdleTime(access uint32, now time.Time) time.Duration {
+	clock := LRUClock(now)
+	if clock >= access {
+		return time.Duration(clock-access) * LRUClockResolution
+	}
+	return time.Duration(lruClockMax-access+clock) * LRUClockResolution
+}
+
+// NewLFU returns the LFU metadata of a new key.
+func NewLFU(now time.Time) uint32 {
+	return lfuMinutes(now)<<8 | LFUInitVal
+}
+
+// LFUCounter returns the access counter of the LFU metadata, decremented once for every decayTime minutes
+// elapsed since its latest decrement. A decayTime of 0 never decrements the counter.
+func LFUCounter(access uint32, now time.Time, decayTime uint) uint8 {
+	counter := uint8(access & 0xff)
+	if decayTime == 0 {
+		return counter
+	}
+	last, minutes := access>>8, lfuMinutes(now)
+	elapsed := minutes - last
+	if minutes < last {
+		elapsed = lfuTimeMax - last + minutes
+	}
+	periods := elapsed / uint32(decayTime)
+	if periods > uint32(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// LFUTouch returns the LFU metadata after count accesses. The counter is decayed first, and then incremented
+// logarithmically: the higher the counter and the log factor, the less likely an access increments it.
+// A log factor of 0 increments the counter on every access.
+func LFUTouch(access uint32, count int, now time.Time, logFactor uint, decayTime uint) uint32 {
+	counter := LFUCounter(access, now, decayTime)
+	for i := 0; i < count && counter < 255; i++ {
+		base := 0.0
+		if counter > LFUInitVal {
+			base = float64(counter - LFUInitVal)
+		}
+		if rand.Float64() < 1/(base*float64(logFactor)+1) {
+			counter++
+		}
+	}
+	return lfuMinutes(now)<<8 | uint32(counter)
+}
+
+func lfuMinutes(now time.Time) uint32 {
+	return uint32(now.Unix()/60) & lfuTimeMax
+}
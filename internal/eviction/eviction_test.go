@@ -0,0 +1,155 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eviction_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/echovault/sugardb/internal/eviction"
+)
+
+func Test_LRU(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	access := eviction.LRUClock(now)
+	if idle := eviction.IdleTime(access, now.Add(3*time.Second)); idle != 3*time.Second {
+		t.Errorf("expected idle time of 3s, got %v", idle)
+	}
+
+	// The idle time is still correct after the clock wraps around.
+	wrap := time.UnixMilli(int64(1<<24) * eviction.LRUClockResolution.Milliseconds())
+	access = eviction.LRUClock(wrap.Add(-time.Second))
+	if idle := eviction.IdleTime(access, wrap.Add(time.Second)); idle < 1900*time.Millisecond || idle > 2*time.Second {
+		t.Errorf("expected idle time of about 2s, got %v", idle)
+	}
+}
+
+func Test_LFU(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	access := eviction.NewLFU(now)
+	if counter := eviction.LFUCounter(access, now, 1); counter != eviction.LFUInitVal {
+		t.Errorf("expected counter %d, got %d", eviction.LFUInitVal, counter)
+	}
+
+	// A log factor of 0 increments the counter on every access, up to 255.
+	access = eviction.LFUTouch(access, 10, now, 0, 1)
+	if counter := eviction.LFUCounter(access, now, 1); counter != eviction.LFUInitVal+10 {
+		t.Errorf("expected counter %d, got %d", eviction.LFUInitVal+10, counter)
+	}
+	if counter := eviction.LFUCounter(eviction.LFUTouch(access, 1000, now, 0, 1), now, 1); counter != 255 {
+		t.Errorf("expected counter 255, got %d", counter)
+	}
+
+	// The counter decays by 1 for every decay period elapsed since the latest access.
+	if counter := eviction.LFUCounter(access, now.Add(3*time.Minute), 1); counter != eviction.LFUInitVal+7 {
+		t.Errorf("expected counter %d, got %d", eviction.LFUInitVal+7, counter)
+	}
+	if counter := eviction.LFUCounter(access, now.Add(3*time.Minute), 2); counter != eviction.LFUInitVal+9 {
+		t.Errorf("expected counter %d, got %d", eviction.LFUInitVal+9, counter)
+	}
+	if counter := eviction.LFUCounter(access, now.Add(time.Hour), 1); counter != 0 {
+		t.Errorf("expected counter 0, got %d", counter)
+	}
+	if counter := eviction.LFUCounter(access, now.Add(time.Hour), 0); counter != eviction.LFUInitVal+10 {
+		t.Errorf("expected counter %d without decay, got %d", eviction.LFUInitVal+10, counter)
+	}
+
+	// With the default log factor, the counter grows logarithmically.
+	access = eviction.NewLFU(now)
+	access = eviction.LFUTouch(access, 100, now, 10, 1)
+	if counter := eviction.LFUCounter(access, now, 1); counter < 7 || counter > 20 {
+		t.Errorf("expected counter between 7 and 20 after 100 accesses, got %d", counter)
+	}
+	access = eviction.LFUTouch(access, 100_000, now, 10, 1)
+	if counter := eviction.LFUCounter(access, now, 1); counter < 100 || counter > 200 {
+		t.Errorf("expected counter between 100 and 200 after 100K accesses, got %d", counter)
+	}
+}
+
+func Test_Pool(t *testing.T) {
+	pool := eviction.NewPool()
+	for i := 0; i < 2*eviction.PoolSize; i++ {
+		pool.Insert(string(rune('a'+i)), uint64(i))
+	}
+	if pool.Len() != eviction.PoolSize {
+		t.Errorf("expected %d candidates, got %d", eviction.PoolSize, pool.Len())
+	}
+
+	// Updating the score of a candidate doesn't duplicate it.
+	pool.Insert("z", 100)
+	pool.Insert("z", 1000)
+	// Candidates with a score below the worst candidate of a full pool are dropped.
+	pool.Insert("0", 0)
+
+	expected := []string{"z"}
+	for i := 2*eviction.PoolSize - 1; len(expected) < eviction.PoolSize; i-- {
+		if key := string(rune('a' + i)); key != "z" {
+			expected = append(expected, key)
+		}
+	}
+	for _, want := range expected {
+		key, ok := pool.Pop()
+		if !ok || key != want {
+			t.Errorf("expected candidate %s, got %s", want, key)
+		}
+	}
+	if _, ok := pool.Pop(); ok {
+		t.Error("expected empty pool")
+	}
+}
+
+func Test_ExpiryIndex(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+	index := eviction.NewExpiryIndex()
+	for i, key := range []string{"key1", "key2", "key3", "key4", "key5"} {
+		index.Set(key, now.Add(time.Duration(5-i)*time.Second))
+	}
+
+	// Updating and removing keys keeps the index ordered by expiry.
+	index.Set("key1", now.Add(500*time.Millisecond))
+	index.Remove("key4")
+	index.Remove("key6")
+	if index.Len() != 4 {
+		t.Errorf("expected 4 keys, got %d", index.Len())
+	}
+	if expireAt, ok := index.Get("key3"); !ok || !expireAt.Equal(now.Add(3*time.Second)) {
+		t.Errorf("expected key3 to expire at %v, got %v", now.Add(3*time.Second), expireAt)
+	}
+	if _, ok := index.Get("key4"); ok {
+		t.Error("expected key4 to be removed")
+	}
+
+	var got []string
+	for {
+		key, _, ok := index.Peek()
+		if !ok {
+			break
+		}
+		got = append(got, key)
+		index.Remove(key)
+	}
+	want := []string{"key1", "key5", "key3", "key2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected keys in order %v, got %v", want, got)
+	}
+
+	index.Set("key1", now)
+	index.Clear()
+	if _, _, ok := index.Peek(); ok || index.Len() != 0 {
+		t.Errorf("expected empty index after clear, got %d keys", index.Len())
+	}
+}
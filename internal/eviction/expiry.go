@@ -0,0 +1,135 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eviction
+
+import (
+	"container/heap"
+	"time"
+)
+
+type expiryEntry struct {
+	key      string
+	expireAt int64 // Unix time in nanoseconds at which the key or one of its hash fields expires.
+	index    int   // The index of the entry in the heap.
+}
+
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int {
+	return len(h)
+}
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expireAt < h[j].expireAt
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	entry := x.(*expiryEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
+
+// ExpiryIndex is a min-heap of the keys of a database ordered by their next expiry, indexed by key so that the
+// expiry of a key is updated or removed in logarithmic time. The next expiry of a key is the earliest of the expiry
+// of the key and the expiries of its hash fields.
+type ExpiryIndex struct {
+	entries map[string]*expiryEntry
+	heap    expiryHeap
+}
+
+func NewExpiryIndex() *ExpiryIndex {
+	return &ExpiryIndex{
+		entries: make(map[string]*expiryEntry),
+		heap:    make(expiryHeap, 0),
+	}
+}
+
+// Set adds the key to the index, or updates the next expiry of the key if it's already in the index.
+func (index *ExpiryIndex) Set(key string, expireAt time.Time) {
+	if entry, ok := index.entries[key]; ok {
+		entry.expireAt = expireAt.UnixNano()
+		heap.Fix(&index.heap, entry.index)
+		return
+	}
+	entry := &expiryEntry{key: key, expireAt: expireAt.UnixNano()}
+	index.entries[key] = entry
+	heap.Push(&index.heap, entry)
+}
+
+// Get returns the next expiry of the key. It returns false when the key is not in the index.
+func (index *ExpiryIndex) Get(key string) (time.Time, bool) {
+	entry, ok := index.entries[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, entry.expireAt), true
+}
+
+// Remove removes the key from the index.
+func (index *ExpiryIndex) Remove(key string) {
+	entry, ok := index.entries[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&index.heap, entry.index)
+	delete(index.entries, key)
+}
+
+// Peek returns the key with the earliest next expiry. It returns false when the index is empty.
+func (index *ExpiryIndex) Peek() (string, time.Time, bool) {
+	if len(index.heap) == 0 {
+		return "", time.Time{}, false
+	}
+	return index.heap[0].key, time.Unix(0, index.heap[0].expireAt), true
+}
+
+// Key returns the key at position i of the index, where 0 <= i < Len(). The keys are not sorted by expiry, so
+// a random position returns a random key.
+func (index *ExpiryIndex) Key(i int) string {
+	return index.heap[i].key
+}
+
+// Len returns the number of keys in the index.
+func (index *ExpiryIndex) Len() int {
+	return len(index.heap)
+}
+
+// Cap returns the capacity of the heap of the index.
+func (index *ExpiryIndex) Cap() int {
+	return cap(index.heap)
+}
+
+// Clear removes all the keys from the index.
+func (index *ExpiryIndex) Clear() {
+	clear(index.entries)
+	clear(index.heap)
+	index.heap = index.heap[:0]
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eviction
+
+import (
+	"cmp"
+	"slices"
+)
+
+// PoolSize is the number of eviction candidates kept in a pool.
+const PoolSize = 16
+
+type candidate struct {
+	key   string
+	score uint64 // The higher the score, the better the candidate.
+}
+
+// Pool holds the best eviction candidates found in the samples of the keyspace. Keeping the candidates across
+// evictions improves the approximation of the eviction policy without sampling more keys.
+type Pool struct {
+	candidates []candidate // Sorted by ascending score.
+}
+
+func NewPool() *Pool {
+	return &Pool{candidates: make([]candidate, 0, PoolSize)}
+}
+
+// Insert adds a sampled key to the pool if its score is higher than the worst candidate, or if the pool isn't full.
+// The score of a key that is already in the pool is updated.
+func (p *Pool) Insert(key string, score uint64) {
+	p.candidates = slices.DeleteFunc(p.candidates, func(c candidate) bool {
+		return c.key == key
+	})
+	if len(p.candidates) == PoolSize {
+		if score <= p.candidates[0].score {
+			return
+		}
+		p.candidates = slices.Delete(p.candidates, 0, 1)
+	}
+	i, _ := slices.BinarySearchFunc(p.candidates, score, func(c candidate, score uint64) int {
+		return cmp.Compare(c.score, score)
+	})
+	p.candidates = slices.Insert(p.candidates, i, candidate{key: key, score: score})
+}
+
+// Pop removes the best candidate from the pool. It returns false when the pool is empty.
+func (p *Pool) Pop() (string, bool) {
+	if len(p.candidates) == 0 {
+		return "", false
+	}
+	c := p.candidates[len(p.candidates)-1]
+	p.candidates = p.candidates[:len(p.candidates)-1]
+	return c.key, true
+}
+
+// Len returns the number of candidates in the pool.
+func (p *Pool) Len() int {
+	return len(p.candidates)
+}
+
+// Clear removes all the candidates.
+func (p *Pool) Clear() {
+	p.candidates = p.candidates[:0]
+}
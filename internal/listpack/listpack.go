@@ -0,0 +1,192 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package listpack implements the contiguous array used by the compact encodings of small hashes, sets, sorted sets
+// and lists.
+package listpack
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+)
+
+// The tag that precedes each entry.
+const (
+	tagString byte = iota
+	tagInt
+	tagFloat
+)
+
+// Listpack is a contiguous array of strings, integers and floats. Each entry is a tag byte followed by the varint
+// length and the bytes of a string, the zig-zag varint of an integer, or the 8 bytes of a float. Unlike a map or a
+// slice of interfaces, the entries don't need a header or a pointer each, which makes small collections several
+// times smaller. Entries are looked up by scanning the array, so it's only suited to small collections.
+type Listpack struct {
+	data []byte
+	len  int
+}
+
+// Fits returns true when the value can be stored in a listpack: a string of at most maxValue bytes, an int or a
+// float64. Values are read back with the same type.
+func Fits(value interface{}, maxValue int) bool {
+	switch v := value.(type) {
+	case string:
+		return len(v) <= maxValue
+	case int, float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// Len returns the number of entries.
+func (lp *Listpack) Len() int {
+	return lp.len
+}
+
+// Copy returns a copy of the listpack that doesn't share its entries.
+func (lp *Listpack) Copy() Listpack {
+	return Listpack{data: slices.Clone(lp.data), len: lp.len}
+}
+
+// Size returns the memory used by the entries of the listpack, excluding the listpack itself.
+func (lp *Listpack) Size() int64 {
+	return int64(cap(lp.data))
+}
+
+// Append adds the value at the end of the listpack. It returns false when the value can't be stored in a listpack.
+func (lp *Listpack) Append(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		lp.AppendString(v)
+	case int:
+		lp.data = append(lp.data, tagInt)
+		lp.data = binary.AppendVarint(lp.data, int64(v))
+		lp.len++
+	case float64:
+		lp.data = append(lp.data, tagFloat)
+		lp.data = binary.LittleEndian.AppendUint64(lp.data, math.Float64bits(v))
+		lp.len++
+	default:
+		return false
+	}
+	return true
+}
+
+// AppendString adds the string at the end of the listpack.
+func (lp *Listpack) AppendString(s string) {
+	lp.data = append(lp.data, tagString)
+	lp.data = binary.AppendUvarint(lp.data, uint64(len(s)))
+	lp.data = append(lp.data, s...)
+	lp.len++
+}
+
+// next returns the value of the entry at the offset, and the offset of the next entry.
+func (lp *Listpack) next(offset int) (interface{}, int) {
+	tag := lp.data[offset]
+	offset++
+	switch tag {
+	case tagInt:
+		n, size := binary.Varint(lp.data[offset:])
+		return int(n), offset + size
+	case tagFloat:
+		return math.Float64frombits(binary.LittleEndian.Uint64(lp.data[offset:])), offset + 8
+	default:
+		length, size := binary.Uvarint(lp.data[offset:])
+		offset += size
+		return string(lp.data[offset : offset+int(length)]), offset + int(length)
+	}
+}
+
+// skip returns the offset of the entry that follows the entry at the offset, without decoding its value.
+func (lp *Listpack) skip(offset int) int {
+	tag := lp.data[offset]
+	offset++
+	switch tag {
+	case tagInt:
+		_, size := binary.Varint(lp.data[offset:])
+		return offset + size
+	case tagFloat:
+		return offset + 8
+	default:
+		length, size := binary.Uvarint(lp.data[offset:])
+		return offset + size + int(length)
+	}
+}
+
+// Each calls fn with the index and the value of each entry in order, until fn returns false.
+func (lp *Listpack) Each(fn func(i int, value interface{}) bool) {
+	offset := 0
+	for i := 0; i < lp.len; i++ {
+		var value interface{}
+		value, offset = lp.next(offset)
+		if !fn(i, value) {
+			return
+		}
+	}
+}
+
+// Values returns the values of all the entries in order.
+func (lp *Listpack) Values() []interface{} {
+	values := make([]interface{}, 0, lp.len)
+	lp.Each(func(_ int, value interface{}) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// Index returns the index of the first entry at or after start, stepping by step entries, that holds the string s.
+// It returns -1 when there is no such entry. A step of 2 searches the keys of a listpack of key and value pairs.
+func (lp *Listpack) Index(s string, start int, step int) int {
+	offset := 0
+	for i := 0; i < lp.len; i++ {
+		if i < start || (i-start)%step != 0 || lp.data[offset] != tagString {
+			offset = lp.skip(offset)
+			continue
+		}
+		length, size := binary.Uvarint(lp.data[offset+1:])
+		b := lp.data[offset+1+size : offset+1+size+int(length)]
+		if string(b) == s {
+			return i
+		}
+		offset += 1 + size + int(length)
+	}
+	return -1
+}
+
+// Get returns the value of the entry at index i.
+func (lp *Listpack) Get(i int) interface{} {
+	offset := 0
+	for j := 0; j < i; j++ {
+		offset = lp.skip(offset)
+	}
+	value, _ := lp.next(offset)
+	return value
+}
+
+// Delete removes n entries starting at index i.
+func (lp *Listpack) Delete(i int, n int) {
+	start := 0
+	for j := 0; j < i; j++ {
+		start = lp.skip(start)
+	}
+	end := start
+	for j := 0; j < n; j++ {
+		end = lp.skip(end)
+	}
+	lp.data = append(lp.data[:start], lp.data[end:]...)
+	lp.len -= n
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listpack
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Listpack(t *testing.T) {
+	values := []interface{}{"field", 42, -7, 3.5, "", math.Inf(-1), strings.Repeat("x", 300), 0}
+
+	var lp Listpack
+	for _, value := range values {
+		if !lp.Append(value) {
+			t.Fatalf("expected %v to be appended", value)
+		}
+	}
+	if lp.Append(int64(1)) || lp.Append([]string{}) {
+		t.Error("expected values of other types not to be appended")
+	}
+	if lp.Len() != len(values) {
+		t.Errorf("expected %d entries, got %d", len(values), lp.Len())
+	}
+	if got := lp.Values(); !reflect.DeepEqual(got, values) {
+		t.Errorf("expected values %v, got %v", values, got)
+	}
+	if got := lp.Get(3); got != 3.5 {
+		t.Errorf("expected entry 3 to be 3.5, got %v", got)
+	}
+
+	// Index only matches strings, at the entries selected by the start and the step.
+	if i := lp.Index("field", 0, 1); i != 0 {
+		t.Errorf("expected \"field\" at index 0, got %d", i)
+	}
+	if i := lp.Index("field", 1, 2); i != -1 {
+		t.Errorf("expected \"field\" not to be found at odd indexes, got %d", i)
+	}
+	if i := lp.Index("42", 0, 1); i != -1 {
+		t.Errorf("expected the integer 42 not to match the string \"42\", got %d", i)
+	}
+
+	// Deleting entries keeps the others in order.
+	lp.Delete(1, 3)
+	want := []interface{}{"field", "", math.Inf(-1), strings.Repeat("x", 300), 0}
+	if got := lp.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected values %v after the deletion, got %v", want, got)
+	}
+	lp.Delete(lp.Len()-1, 1)
+	if lp.Len() != 4 || lp.Get(3) != strings.Repeat("x", 300) {
+		t.Errorf("expected the last entry to be deleted, got %v", lp.Values())
+	}
+
+	if !Fits("short", 5) || Fits("longer", 5) || !Fits(1, 0) || Fits(true, 64) {
+		t.Error("expected only strings up to the max value, ints and floats to fit")
+	}
+}
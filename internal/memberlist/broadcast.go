@@ -26,6 +26,12 @@ type BroadcastMessage struct {
 	Content     []byte   `json:"Content"`
 	ContentHash [16]byte `json:"ContentHash"`
 	ConnId      string   `json:"ConnId"`
+	// The following fields are only used by forwarded commands and their responses.
+	RequestID string `json:"RequestID"` // Matches a forwarded command's response to its request.
+	Database  int    `json:"Database"`  // The database the forwarded command is executed against.
+	Protocol  int    `json:"Protocol"`  // The RESP protocol of the client that sent the forwarded command.
+	Index     uint64 `json:"Index"`     // The raft log index of the forwarded command once applied by the leader.
+	Error     string `json:"Error"`     // The error returned by the leader when executing the forwarded command.
 }
 
 // Invalidates Implements Broadcast interface
@@ -20,9 +20,12 @@ import (
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/config"
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/slots"
 	"github.com/hashicorp/memberlist"
 	"github.com/hashicorp/raft"
 	"log"
+	"strconv"
 	"time"
 )
 
@@ -34,9 +37,24 @@ type DelegateOpts struct {
 	config         config.Config
 	broadcastQueue *memberlist.TransmitLimitedQueue
 	addVoter       func(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) error
+	addNonvoter    func(id raft.ServerID, address raft.ServerAddress) error
 	isRaftLeader   func() bool
 	applyMutate    func(ctx context.Context, cmd []string) ([]byte, error)
 	applyDeleteKey func(ctx context.Context, key string) error
+	// Records the keys read on a follower in the leader's eviction stats.
+	applyAccessReport func(report []byte)
+	// Sends the index of the latest raft log entry applied by this node to the provided node.
+	sendAppliedIndex func(to raft.ServerID)
+	// Records the index of the latest raft log entry applied by the provided node.
+	setAppliedIndex func(id raft.ServerID, index uint64)
+	// Executes a command forwarded by a follower and sends the response back to the follower.
+	handleForwardedCommand func(msg BroadcastMessage)
+	// Passes the leader's response to a forwarded command back to the waiting caller.
+	setForwardResult func(msg BroadcastMessage)
+	// Returns the shard announcements known by this node.
+	getShards func() []slots.Shard
+	// Merges shard announcements received from other nodes.
+	updateShards func(shards []slots.Shard)
 }
 
 func NewDelegate(opts DelegateOpts) *Delegate {
@@ -52,6 +70,9 @@ func (delegate *Delegate) NodeMeta(limit int) []byte {
 		RaftAddr: raft.ServerAddress(
 			fmt.Sprintf("%s:%d", delegate.options.config.RaftBindAddr, delegate.options.config.RaftBindPort)),
 		MemberlistAddr: fmt.Sprintf("%s:%d", delegate.options.config.BindAddr, delegate.options.config.DiscoveryPort),
+		ShardID:        delegate.options.config.ShardID,
+		ClientAddr:     fmt.Sprintf("%s:%d", delegate.options.config.BindAddr, delegate.options.config.Port),
+		Nonvoter:       delegate.options.config.RaftRole == constants.RaftRoleNonvoter,
 	}
 
 	b, err := json.Marshal(&meta)
@@ -73,19 +94,24 @@ func (delegate *Delegate) NotifyMsg(msgBytes []byte) {
 
 	switch msg.Action {
 	case "RaftJoin":
-		// If the current node is not the cluster leader, re-broadcast the message.
-		if !delegate.options.isRaftLeader() {
+		// If the current node is not the leader of the joining node's shard, re-broadcast the message.
+		if msg.ShardID != delegate.options.config.ShardID || !delegate.options.isRaftLeader() {
 			delegate.options.broadcastQueue.QueueBroadcast(&msg)
 			return
 		}
-		err := delegate.options.addVoter(msg.NodeMeta.ServerID, msg.NodeMeta.RaftAddr, 0, 0)
+		var err error
+		if msg.NodeMeta.Nonvoter {
+			err = delegate.options.addNonvoter(msg.NodeMeta.ServerID, msg.NodeMeta.RaftAddr)
+		} else {
+			err = delegate.options.addVoter(msg.NodeMeta.ServerID, msg.NodeMeta.RaftAddr, 0, 0)
+		}
 		if err != nil {
 			log.Println(err)
 		}
 
 	case "DeleteKey":
-		// If the current node is not a cluster leader, re-broadcast the message.
-		if !delegate.options.isRaftLeader() {
+		// If the current node is not the leader of the sender's shard, re-broadcast the message.
+		if msg.ShardID != delegate.options.config.ShardID || !delegate.options.isRaftLeader() {
 			delegate.options.broadcastQueue.QueueBroadcast(&msg)
 			return
 		}
@@ -93,14 +119,27 @@ func (delegate *Delegate) NotifyMsg(msgBytes []byte) {
 		ctx := context.WithValue(
 			context.WithValue(context.Background(), internal.ContextServerID("ServerID"), string(msg.ServerID)),
 			internal.ContextConnID("ConnectionID"), msg.ConnId)
+		ctx = context.WithValue(ctx, "Database", msg.Database)
 
 		key := string(msg.Content)
 
-		if err := delegate.options.applyDeleteKey(ctx, key); err != nil {
-			log.Println(err)
+		// Delete in a separate goroutine as NotifyMsg must not block.
+		go func() {
+			if err := delegate.options.applyDeleteKey(ctx, key); err != nil {
+				log.Println(err)
+			}
+		}()
+
+	case "AccessReport":
+		// Access stats are only kept by the leader. Reports sent to a node that is no longer the leader are dropped.
+		if msg.ShardID != delegate.options.config.ShardID || !delegate.options.isRaftLeader() ||
+			delegate.options.applyAccessReport == nil {
+			return
 		}
+		go delegate.options.applyAccessReport(msg.Content)
 
 	case "MutateData":
+		// Mutations are forwarded with the ForwardCommand action. This is kept for nodes running older versions.
 		// If the current node is not a cluster leader, re-broadcast the message.
 		if !delegate.options.isRaftLeader() {
 			delegate.options.broadcastQueue.QueueBroadcast(&msg)
@@ -120,6 +159,28 @@ func (delegate *Delegate) NotifyMsg(msgBytes []byte) {
 		if _, err := delegate.options.applyMutate(ctx, cmd); err != nil {
 			log.Println(err)
 		}
+
+	case "ShardUpdate":
+		delegate.mergeShards(msg.Content)
+
+	case "ForwardCommand", "ForwardRead", "ReadIndex", "SlotRequest":
+		// Execute in a separate goroutine as NotifyMsg must not block.
+		go delegate.options.handleForwardedCommand(msg)
+
+	case "ForwardCommandResponse":
+		delegate.options.setForwardResult(msg)
+
+	case "AppliedIndexRequest":
+		// Reply in a separate goroutine as NotifyMsg must not block.
+		go delegate.options.sendAppliedIndex(msg.ServerID)
+
+	case "AppliedIndexResponse":
+		index, err := strconv.ParseUint(string(msg.Content), 10, 64)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		delegate.options.setAppliedIndex(msg.ServerID, index)
 	}
 }
 
@@ -129,12 +190,33 @@ func (delegate *Delegate) GetBroadcasts(overhead, limit int) [][]byte {
 }
 
 // LocalState implements Delegate interface
+// The shard announcements are exchanged during push/pull syncs so that nodes that missed
+// a ShardUpdate broadcast, or that have just joined, eventually learn the slot map.
 func (delegate *Delegate) LocalState(join bool) []byte {
-	// No-Op
-	return []byte("")
+	if delegate.options.getShards == nil {
+		return []byte("")
+	}
+	b, err := json.Marshal(delegate.options.getShards())
+	if err != nil {
+		log.Printf("local state: %v\n", err)
+		return []byte("")
+	}
+	return b
 }
 
 // MergeRemoteState implements Delegate interface
 func (delegate *Delegate) MergeRemoteState(buf []byte, join bool) {
-	// No-Op
+	delegate.mergeShards(buf)
+}
+
+func (delegate *Delegate) mergeShards(b []byte) {
+	if delegate.options.updateShards == nil || len(b) == 0 {
+		return
+	}
+	var shards []slots.Shard
+	if err := json.Unmarshal(b, &shards); err != nil {
+		log.Printf("merge shards: %v\n", err)
+		return
+	}
+	delegate.options.updateShards(shards)
 }
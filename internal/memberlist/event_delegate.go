@@ -27,6 +27,7 @@ type EventDelegate struct {
 type EventDelegateOpts struct {
 	incrementNodes   func()
 	decrementNodes   func()
+	shardID          string // Nodes from other shards belong to other raft groups.
 	removeRaftServer func(meta NodeMeta) error
 }
 
@@ -54,6 +55,10 @@ func (eventDelegate *EventDelegate) NotifyLeave(node *memberlist.Node) {
 		return
 	}
 
+	if meta.ShardID != eventDelegate.options.shardID {
+		return
+	}
+
 	err = eventDelegate.options.removeRaftServer(meta)
 
 	if err != nil {
@@ -15,13 +15,23 @@
 package memberlist
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/config"
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/encryption"
+	"github.com/echovault/sugardb/internal/slots"
 	"log"
+	"os"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/memberlist"
@@ -33,16 +43,56 @@ type NodeMeta struct {
 	ServerID       raft.ServerID      `json:"ServerID"`
 	MemberlistAddr string             `json:"MemberlistAddr"`
 	RaftAddr       raft.ServerAddress `json:"RaftAddr"`
+	ShardID        string             `json:"ShardID,omitempty"`    // The shard (raft group) the node belongs to.
+	ClientAddr     string             `json:"ClientAddr,omitempty"` // The address clients connect to.
+	Nonvoter       bool               `json:"Nonvoter,omitempty"`   // Whether the node joins the raft cluster as a non-voter.
 }
 
 type Opts struct {
 	Config           config.Config
 	HasJoinedCluster func() bool
 	AddVoter         func(id raft.ServerID, address raft.ServerAddress, prevIndex uint64, timeout time.Duration) error
+	AddNonvoter      func(id raft.ServerID, address raft.ServerAddress) error
 	RemoveRaftServer func(meta NodeMeta) error
 	IsRaftLeader     func() bool
 	ApplyMutate      func(ctx context.Context, cmd []string) ([]byte, error)
 	ApplyDeleteKey   func(ctx context.Context, key string) error
+	// ApplyAccessReport records the keys read on a follower in the leader's eviction stats.
+	ApplyAccessReport func(report []byte)
+	AppliedIndex      func() uint64
+	// ApplyCommand applies a command forwarded by a follower and returns the response and raft log index.
+	ApplyCommand func(ctx context.Context, cmd []string) ([]byte, uint64, error)
+	// ReadCommand executes a read command forwarded by a follower against the local state.
+	ReadCommand func(ctx context.Context, cmd []string) ([]byte, error)
+	// ReadIndex confirms leadership and returns the commit index that linearizable reads must wait for.
+	ReadIndex func() (uint64, error)
+	// GetShards returns the shard announcements known by the node, so they can be gossiped to other nodes.
+	GetShards func() []slots.Shard
+	// UpdateShards merges shard announcements received from other nodes.
+	UpdateShards func(shards []slots.Shard)
+	// ApplySlotRequest handles a slot migration request sent by the leader of another shard.
+	ApplySlotRequest func(content []byte) ([]byte, error)
+}
+
+// GossipKeyEnvVar is the environment variable used to provide the gossip encryption keys when no key file is
+// configured. It holds a comma-separated list of keys with the primary key first.
+const GossipKeyEnvVar = "SUGARDB_GOSSIP_KEY"
+
+// gossipKeyReloadInterval is how often the gossip key file is checked for changes.
+var gossipKeyReloadInterval = 10 * time.Second
+
+var (
+	// ErrNotLeader is returned when a command is forwarded to a node that is not the cluster leader.
+	ErrNotLeader = errors.New("not cluster leader, cannot carry out command")
+	// ErrForwardNotSent is returned when a command could not be forwarded to the leader.
+	ErrForwardNotSent = errors.New("could not forward command to leader")
+)
+
+// ForwardResult is the leader's result for a forwarded command.
+type ForwardResult struct {
+	Response []byte // The RESP response returned by the leader.
+	Index    uint64 // The raft log index of the command.
+	Err      error  // The error returned by the leader.
 }
 
 type MemberList struct {
@@ -51,6 +101,16 @@ type MemberList struct {
 	noOfNodesMut   sync.RWMutex
 	noOfNodes      int
 	memberList     *memberlist.Memberlist
+
+	appliedMut     sync.Mutex
+	appliedIndexes map[raft.ServerID]uint64 // The latest applied raft log index reported by each node.
+	appliedUpdated chan struct{}            // Closed and replaced whenever a node reports its applied index.
+
+	forwardMut      sync.Mutex
+	forwardRequests map[string]chan ForwardResult // The forwarded commands waiting for a response from the leader.
+	forwardID       atomic.Uint64                 // Used to generate the request IDs of forwarded commands.
+
+	stopKeyReload chan struct{} // Closed on shutdown to stop reloading the gossip key file.
 }
 
 func NewMemberList(opts Opts) *MemberList {
@@ -59,6 +119,14 @@ func NewMemberList(opts Opts) *MemberList {
 		broadcastQueue: new(memberlist.TransmitLimitedQueue),
 		noOfNodesMut:   sync.RWMutex{},
 		noOfNodes:      0,
+		appliedMut:     sync.Mutex{},
+		appliedIndexes: make(map[raft.ServerID]uint64),
+		appliedUpdated: make(chan struct{}),
+
+		forwardMut:      sync.Mutex{},
+		forwardRequests: make(map[string]chan ForwardResult),
+
+		stopKeyReload: make(chan struct{}),
 	}
 }
 
@@ -69,12 +137,24 @@ func (m *MemberList) MemberListInit(ctx context.Context) {
 	cfg.BindAddr = m.options.Config.BindAddr
 	cfg.BindPort = int(m.options.Config.DiscoveryPort)
 	cfg.Delegate = NewDelegate(DelegateOpts{
-		config:         m.options.Config,
-		broadcastQueue: m.broadcastQueue,
-		addVoter:       m.options.AddVoter,
-		isRaftLeader:   m.options.IsRaftLeader,
-		applyMutate:    m.options.ApplyMutate,
-		applyDeleteKey: m.options.ApplyDeleteKey,
+		config:            m.options.Config,
+		broadcastQueue:    m.broadcastQueue,
+		addVoter:          m.options.AddVoter,
+		addNonvoter:       m.options.AddNonvoter,
+		isRaftLeader:      m.options.IsRaftLeader,
+		applyMutate:       m.options.ApplyMutate,
+		applyDeleteKey:    m.options.ApplyDeleteKey,
+		applyAccessReport: m.options.ApplyAccessReport,
+		sendAppliedIndex: func(to raft.ServerID) {
+			m.sendAppliedIndex(to)
+		},
+		setAppliedIndex: m.setAppliedIndex,
+		handleForwardedCommand: func(msg BroadcastMessage) {
+			m.handleForwardedCommand(msg)
+		},
+		setForwardResult: m.setForwardResult,
+		getShards:        m.options.GetShards,
+		updateShards:     m.options.UpdateShards,
 	})
 	cfg.Events = NewEventDelegate(EventDelegateOpts{
 		incrementNodes: func() {
@@ -87,9 +167,24 @@ func (m *MemberList) MemberListInit(ctx context.Context) {
 			defer m.noOfNodesMut.Unlock()
 			m.noOfNodes -= 1
 		},
+		shardID:          m.options.Config.ShardID,
 		removeRaftServer: m.options.RemoveRaftServer,
 	})
 
+	// Encrypt the gossip and the messages sent between nodes when gossip keys are configured.
+	keys, err := encryption.ReadKeys(m.options.Config.GossipKeyFile, GossipKeyEnvVar)
+	if err != nil {
+		log.Fatalf("load gossip key file: %v", err)
+	}
+	if keys != nil {
+		if cfg.Keyring, err = memberlist.NewKeyring(keys[1:], keys[0]); err != nil {
+			log.Fatalf("gossip keyring: %v", err)
+		}
+		if m.options.Config.GossipKeyFile != "" {
+			go m.reloadGossipKeys(cfg.Keyring)
+		}
+	}
+
 	m.broadcastQueue.RetransmitMult = 1
 	m.broadcastQueue.NumNodes = func() int {
 		m.noOfNodesMut.RLock()
@@ -120,8 +215,77 @@ func (m *MemberList) MemberListInit(ctx context.Context) {
 			log.Fatal(err)
 		}
 
-		m.broadcastRaftAddress()
+		// A node that bootstraps its own raft group, such as the first node of a shard, does not join
+		// an existing raft group.
+		if !m.options.Config.BootstrapCluster {
+			m.broadcastRaftAddress()
+		}
+	}
+}
+
+// reloadGossipKeys installs the keys from the gossip key file into the keyring whenever the file changes.
+// Keys are rotated without downtime in three steps, waiting for the change to reach every node before the next:
+//
+//  1. Append the new key to the file on every node, so that all nodes can decrypt it.
+//  2. Move the new key to the first line on every node, so that all nodes encrypt with it.
+//  3. Remove the old key from the file on every node.
+func (m *MemberList) reloadGossipKeys(keyring *memberlist.Keyring) {
+	file := m.options.Config.GossipKeyFile
+	var modTime time.Time
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(gossipKeyReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopKeyReload:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			log.Printf("reload gossip key file: %v\n", err)
+			continue
+		}
+		if !info.ModTime().After(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+
+		keys, err := encryption.ReadKeys(file, "")
+		if err != nil {
+			log.Printf("reload gossip key file: %v\n", err)
+			continue
+		}
+		if err = updateKeyring(keyring, keys); err != nil {
+			log.Printf("reload gossip key file: %v\n", err)
+			continue
+		}
+		log.Printf("reloaded %d gossip keys\n", len(keys))
+	}
+}
+
+// updateKeyring installs the keys, makes the first key the primary key and removes the keys that are not listed.
+func updateKeyring(keyring *memberlist.Keyring, keys [][]byte) error {
+	for _, key := range keys {
+		if err := keyring.AddKey(key); err != nil {
+			return err
+		}
+	}
+	if err := keyring.UseKey(keys[0]); err != nil {
+		return err
+	}
+	for _, key := range keyring.GetKeys() {
+		if !slices.ContainsFunc(keys, func(k []byte) bool { return bytes.Equal(k, key) }) {
+			if err := keyring.RemoveKey(key); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
 func (m *MemberList) broadcastRaftAddress() {
@@ -131,46 +295,329 @@ func (m *MemberList) broadcastRaftAddress() {
 			ServerID: raft.ServerID(m.options.Config.ServerID),
 			RaftAddr: raft.ServerAddress(fmt.Sprintf("%s:%d",
 				m.options.Config.RaftBindAddr, m.options.Config.RaftBindPort)),
+			ShardID:  m.options.Config.ShardID,
+			Nonvoter: m.options.Config.RaftRole == constants.RaftRoleNonvoter,
 		},
 	}
 	m.broadcastQueue.QueueBroadcast(&msg)
 }
 
-// The ForwardDeleteKey function is only called by non-leaders.
-// It uses the broadcast queue to forward a key eviction command within the cluster.
-func (m *MemberList) ForwardDeleteKey(ctx context.Context, key string) {
+// ForwardDeleteKey is only called by non-leaders.
+// It asks the leader to remove a key that has expired. The leader only removes the key if it has also expired
+// according to the leader's state.
+func (m *MemberList) ForwardDeleteKey(ctx context.Context, leader raft.ServerID, key string) error {
 	connId, _ := ctx.Value(internal.ContextConnID("ConnectionID")).(string)
-	m.broadcastQueue.QueueBroadcast(&BroadcastMessage{
+	database, _ := ctx.Value("Database").(int)
+	return m.sendToNode(leader, &BroadcastMessage{
 		Action:      "DeleteKey",
 		Content:     []byte(key),
 		ContentHash: md5.Sum([]byte(key)),
 		ConnId:      connId,
+		Database:    database,
 		NodeMeta: NodeMeta{
 			ServerID: raft.ServerID(m.options.Config.ServerID),
 			RaftAddr: raft.ServerAddress(fmt.Sprintf("%s:%d",
 				m.options.Config.BindAddr, m.options.Config.RaftBindPort)),
+			ShardID: m.options.Config.ShardID,
 		},
 	})
 }
 
-// The ForwardDataMutation function is only called by non-leaders.
-// It uses the broadcast queue to forward a data mutation within the cluster.
-func (m *MemberList) ForwardDataMutation(ctx context.Context, cmd []byte) {
-	connId, _ := ctx.Value(internal.ContextConnID("ConnectionID")).(string)
+// ReportAccess sends the keys read on a follower to the leader, so that the leader's eviction decisions account
+// for the reads served by its followers.
+func (m *MemberList) ReportAccess(leader raft.ServerID, report []byte) error {
+	return m.sendToNode(leader, &BroadcastMessage{
+		Action:  "AccessReport",
+		Content: report,
+		NodeMeta: NodeMeta{
+			ServerID: raft.ServerID(m.options.Config.ServerID),
+			ShardID:  m.options.Config.ShardID,
+		},
+	})
+}
+
+// ForwardCommand sends the command directly to the leader and blocks until the leader responds or the context is done.
+// The leader executes the command through the raft log and responds with the command's actual response.
+//
+// ErrNotLeader and ErrForwardNotSent mean that the command has not been applied, so it's safe to retry it.
+// If the context is done before the leader responds, the command may or may not have been applied.
+func (m *MemberList) ForwardCommand(ctx context.Context, leader raft.ServerID, cmd []byte) ForwardResult {
+	res := m.forward(ctx, leader, "ForwardCommand", cmd)
+	if errors.Is(res.Err, context.DeadlineExceeded) || errors.Is(res.Err, context.Canceled) {
+		res.Err = fmt.Errorf("no response from leader, the command may or may not have been applied: %w", res.Err)
+	}
+	return res
+}
+
+// ForwardRead sends the read command to the leader and blocks until the leader responds or the context is done.
+// The leader executes the command against its local state without going through the raft log.
+func (m *MemberList) ForwardRead(ctx context.Context, leader raft.ServerID, cmd []byte) ForwardResult {
+	return m.forward(ctx, leader, "ForwardRead", cmd)
+}
+
+// SlotRequest sends a slot migration request to the leader of another shard and waits for the leader's response.
+func (m *MemberList) SlotRequest(ctx context.Context, leader raft.ServerID, content []byte) ForwardResult {
+	return m.forward(ctx, leader, "SlotRequest", content)
+}
+
+// BroadcastShard gossips the shard announcement to the rest of the cluster.
+func (m *MemberList) BroadcastShard(shard slots.Shard) {
+	b, err := json.Marshal([]slots.Shard{shard})
+	if err != nil {
+		log.Printf("broadcast shard: %v\n", err)
+		return
+	}
 	m.broadcastQueue.QueueBroadcast(&BroadcastMessage{
-		Action:      "MutateData",
-		Content:     cmd,
-		ContentHash: md5.Sum(cmd),
-		ConnId:      connId,
+		Action:      "ShardUpdate",
+		Content:     b,
+		ContentHash: md5.Sum(b),
 		NodeMeta: NodeMeta{
 			ServerID: raft.ServerID(m.options.Config.ServerID),
-			RaftAddr: raft.ServerAddress(fmt.Sprintf("%s:%d",
-				m.options.Config.BindAddr, m.options.Config.RaftBindPort)),
+			ShardID:  m.options.Config.ShardID,
 		},
 	})
 }
 
+// ClientAddr returns the address clients use to connect to the node with the provided server ID.
+func (m *MemberList) ClientAddr(id string) (string, bool) {
+	for _, meta := range m.Members() {
+		if string(meta.ServerID) == id && meta.ClientAddr != "" {
+			return meta.ClientAddr, true
+		}
+	}
+	return "", false
+}
+
+// Members returns the metadata of all the live nodes in the cluster, including the local node.
+func (m *MemberList) Members() []NodeMeta {
+	var members []NodeMeta
+	for _, node := range m.memberList.Members() {
+		var meta NodeMeta
+		if err := json.Unmarshal(node.Meta, &meta); err != nil {
+			continue
+		}
+		members = append(members, meta)
+	}
+	return members
+}
+
+// Health returns the health of each live node in the cluster, keyed by server ID.
+// Nodes that are alive are "online" and nodes that failed to respond to probes are "suspect".
+// Nodes that are not returned are considered "offline".
+func (m *MemberList) Health() map[raft.ServerID]string {
+	health := make(map[raft.ServerID]string)
+	for _, node := range m.memberList.Members() {
+		var meta NodeMeta
+		if err := json.Unmarshal(node.Meta, &meta); err != nil {
+			continue
+		}
+		switch node.State {
+		case memberlist.StateAlive:
+			health[meta.ServerID] = "online"
+		case memberlist.StateSuspect:
+			health[meta.ServerID] = "suspect"
+		}
+	}
+	return health
+}
+
+// ReadIndex asks the leader to confirm its leadership and returns the leader's commit index.
+// Reads served after the local FSM has applied the returned index are linearizable.
+func (m *MemberList) ReadIndex(ctx context.Context, leader raft.ServerID) ForwardResult {
+	return m.forward(ctx, leader, "ReadIndex", nil)
+}
+
+// forward sends a request with the provided action to the leader and waits for the leader's response.
+func (m *MemberList) forward(ctx context.Context, leader raft.ServerID, action string, cmd []byte) ForwardResult {
+	connId, _ := ctx.Value(internal.ContextConnID("ConnectionID")).(string)
+	database, _ := ctx.Value("Database").(int)
+	protocol, _ := ctx.Value("Protocol").(int)
+
+	requestID := fmt.Sprintf("%s-%d", m.options.Config.ServerID, m.forwardID.Add(1))
+	result := make(chan ForwardResult, 1)
+
+	m.forwardMut.Lock()
+	m.forwardRequests[requestID] = result
+	m.forwardMut.Unlock()
+
+	defer func() {
+		m.forwardMut.Lock()
+		delete(m.forwardRequests, requestID)
+		m.forwardMut.Unlock()
+	}()
+
+	if err := m.sendToNode(leader, &BroadcastMessage{
+		Action:    action,
+		Content:   cmd,
+		ConnId:    connId,
+		RequestID: requestID,
+		Database:  database,
+		Protocol:  protocol,
+		NodeMeta: NodeMeta{
+			ServerID: raft.ServerID(m.options.Config.ServerID),
+		},
+	}); err != nil {
+		return ForwardResult{Err: fmt.Errorf("%w: %v", ErrForwardNotSent, err)}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ForwardResult{Err: ctx.Err()}
+	case res := <-result:
+		return res
+	}
+}
+
+// handleForwardedCommand handles a request forwarded by a follower and sends the result back to the follower.
+func (m *MemberList) handleForwardedCommand(msg BroadcastMessage) {
+	res := &BroadcastMessage{
+		Action:    "ForwardCommandResponse",
+		RequestID: msg.RequestID,
+		NodeMeta: NodeMeta{
+			ServerID: raft.ServerID(m.options.Config.ServerID),
+		},
+	}
+
+	ctx := context.WithValue(context.Background(), internal.ContextServerID("ServerID"), string(msg.ServerID))
+	ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), msg.ConnId)
+	ctx = context.WithValue(ctx, "Database", msg.Database)
+	ctx = context.WithValue(ctx, "Protocol", msg.Protocol)
+
+	var err error
+	if !m.options.IsRaftLeader() {
+		err = ErrNotLeader
+	} else if msg.Action == "ReadIndex" {
+		res.Index, err = m.options.ReadIndex()
+	} else if msg.Action == "SlotRequest" {
+		res.Content, err = m.options.ApplySlotRequest(msg.Content)
+	} else {
+		var cmd []string
+		if cmd, err = internal.Decode(msg.Content); err == nil {
+			if msg.Action == "ForwardRead" {
+				res.Content, err = m.options.ReadCommand(ctx, cmd)
+			} else {
+				res.Content, res.Index, err = m.options.ApplyCommand(ctx, cmd)
+			}
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	if err = m.sendToNode(msg.ServerID, res); err != nil {
+		log.Printf("send forwarded command response: %v\n", err)
+	}
+}
+
+// setForwardResult passes the leader's response to the forwarded command waiting for it.
+func (m *MemberList) setForwardResult(msg BroadcastMessage) {
+	m.forwardMut.Lock()
+	result, ok := m.forwardRequests[msg.RequestID]
+	m.forwardMut.Unlock()
+	if !ok {
+		// The request has already timed out.
+		return
+	}
+
+	res := ForwardResult{Response: msg.Content, Index: msg.Index}
+	if msg.Error == ErrNotLeader.Error() {
+		res.Err = ErrNotLeader
+	} else if msg.Error != "" {
+		res.Err = errors.New(msg.Error)
+	}
+
+	select {
+	case result <- res:
+	default:
+	}
+}
+
+// WaitForApplied blocks until at least numReplicas of the provided servers have applied the raft log
+// up to the index, or until the context is done. It returns the number of servers that have applied the index.
+func (m *MemberList) WaitForApplied(ctx context.Context, servers []raft.ServerID, index uint64, numReplicas int) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	m.requestAppliedIndex(servers)
+
+	for {
+		m.appliedMut.Lock()
+		count := 0
+		for _, id := range servers {
+			if m.appliedIndexes[id] >= index {
+				count += 1
+			}
+		}
+		updated := m.appliedUpdated
+		m.appliedMut.Unlock()
+
+		if count >= numReplicas {
+			return count
+		}
+
+		select {
+		case <-ctx.Done():
+			return count
+		case <-updated:
+		case <-ticker.C:
+			// Ask the servers again in case a request or response was lost.
+			m.requestAppliedIndex(servers)
+		}
+	}
+}
+
+// requestAppliedIndex asks each of the servers to report the index of the latest raft log entry they have applied.
+func (m *MemberList) requestAppliedIndex(servers []raft.ServerID) {
+	for _, id := range servers {
+		msg := &BroadcastMessage{
+			Action: "AppliedIndexRequest",
+			NodeMeta: NodeMeta{
+				ServerID: raft.ServerID(m.options.Config.ServerID),
+			},
+		}
+		if err := m.sendToNode(id, msg); err != nil {
+			log.Printf("request applied index: %v\n", err)
+		}
+	}
+}
+
+// sendAppliedIndex reports the index of the latest raft log entry applied by this node to the requesting node.
+func (m *MemberList) sendAppliedIndex(to raft.ServerID) {
+	msg := &BroadcastMessage{
+		Action:  "AppliedIndexResponse",
+		Content: []byte(strconv.FormatUint(m.options.AppliedIndex(), 10)),
+		NodeMeta: NodeMeta{
+			ServerID: raft.ServerID(m.options.Config.ServerID),
+		},
+	}
+	if err := m.sendToNode(to, msg); err != nil {
+		log.Printf("send applied index: %v\n", err)
+	}
+}
+
+func (m *MemberList) setAppliedIndex(id raft.ServerID, index uint64) {
+	m.appliedMut.Lock()
+	defer m.appliedMut.Unlock()
+	if index > m.appliedIndexes[id] {
+		m.appliedIndexes[id] = index
+	}
+	close(m.appliedUpdated)
+	m.appliedUpdated = make(chan struct{})
+}
+
+// sendToNode sends the message directly to the node with the provided server ID.
+func (m *MemberList) sendToNode(id raft.ServerID, msg *BroadcastMessage) error {
+	for _, node := range m.memberList.Members() {
+		if node.Name == string(id) {
+			return m.memberList.SendReliable(node, msg.Message())
+		}
+	}
+	return fmt.Errorf("node %s not found", id)
+}
+
 func (m *MemberList) MemberListShutdown() {
+	close(m.stopKeyReload)
+
 	// Gracefully leave memberlist cluster
 	err := m.memberList.Leave(500 * time.Millisecond)
 	if err != nil {
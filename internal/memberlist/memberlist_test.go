@@ -0,0 +1,56 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memberlist
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+)
+
+func Test_updateKeyring(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	keyring, err := memberlist.NewKeyring(nil, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step of a key rotation, as the key file is updated.
+	steps := []struct {
+		name        string
+		keys        [][]byte
+		wantPrimary []byte
+		wantKeys    int
+	}{
+		{name: "1. Install the new key", keys: [][]byte{oldKey, newKey}, wantPrimary: oldKey, wantKeys: 2},
+		{name: "2. Use the new key", keys: [][]byte{newKey, oldKey}, wantPrimary: newKey, wantKeys: 2},
+		{name: "3. Remove the old key", keys: [][]byte{newKey}, wantPrimary: newKey, wantKeys: 1},
+	}
+
+	for _, step := range steps {
+		if err = updateKeyring(keyring, step.keys); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !bytes.Equal(keyring.GetPrimaryKey(), step.wantPrimary) {
+			t.Errorf("%s: expected primary key %v, got %v", step.name, step.wantPrimary, keyring.GetPrimaryKey())
+		}
+		if len(keyring.GetKeys()) != step.wantKeys {
+			t.Errorf("%s: expected %d keys, got %d", step.name, step.wantKeys, len(keyring.GetKeys()))
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"reflect"
+	"unsafe"
+
+	"github.com/echovault/sugardb/internal/constants"
+)
+
+// The sizes below follow the memory layout of the Go runtime on 64-bit platforms.
+const (
+	// StringHeaderSize is the size of a string header, which points to the bytes of the string.
+	StringHeaderSize = int64(unsafe.Sizeof(""))
+	// SliceHeaderSize is the size of a slice header, which points to the backing array of the slice.
+	SliceHeaderSize = int64(unsafe.Sizeof([]string{}))
+
+	mapHeaderSize     = 48  // The size of the runtime's hmap struct.
+	mapBucketEntries  = 8   // The number of entries held by a map bucket.
+	mapBucketOverhead = 16  // The tophash array and the overflow pointer of a map bucket.
+	mapLoadFactor     = 6.5 // The average number of entries per bucket that triggers the growth of a map.
+)
+
+// MapSize returns the memory used by a map that held up to n entries, excluding the memory referenced by the
+// entries. Maps never shrink, so n should be the peak number of entries of the map.
+func MapSize(n int, keySize int64, valueSize int64) int64 {
+	// The buckets of a map are allocated on the first insertion.
+	if n == 0 {
+		return mapHeaderSize
+	}
+	buckets := int64(1)
+	for float64(n) > mapLoadFactor*float64(buckets) {
+		buckets *= 2
+	}
+	return mapHeaderSize + buckets*(mapBucketOverhead+mapBucketEntries*(keySize+valueSize))
+}
+
+// ValueSize returns the memory referenced by a value held in an interface, such as the value of a key or the value
+// of a hash field. The size of the interface itself is not included.
+func ValueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case nil:
+		return 0
+	// Numbers are boxed when they're stored in an interface.
+	case int, int64, float64:
+		return 8
+	case string:
+		return StringHeaderSize + int64(len(v))
+	case []string:
+		size := SliceHeaderSize + int64(cap(v))*StringHeaderSize
+		for _, s := range v {
+			size += int64(len(s))
+		}
+		return size
+	// Hash, set and sorted set values report their own size.
+	case constants.CompositeType:
+		return v.GetMem()
+	default:
+		// Other types can only be set by modules and scripts. Their size is estimated from their type, which
+		// does not include the memory they reference.
+		return int64(reflect.TypeOf(v).Size())
+	}
+}
@@ -456,6 +456,41 @@ func (acl *ACL) AuthorizeConnection(conn *net.Conn, cmd []string, command intern
 	return nil
 }
 
+// ExportUsers returns the JSON encoded list of ACL users.
+func (acl *ACL) ExportUsers() ([]byte, error) {
+	acl.RLockUsers()
+	defer acl.RUnlockUsers()
+	return json.Marshal(acl.Users)
+}
+
+// ImportUsers loads the JSON encoded list of ACL users produced by ExportUsers.
+// Users that already exist are replaced and the remaining users are added.
+func (acl *ACL) ImportUsers(b []byte) error {
+	var users []*User
+	if err := json.Unmarshal(b, &users); err != nil {
+		return err
+	}
+
+	acl.LockUsers()
+	defer acl.UnlockUsers()
+
+	for _, user := range users {
+		user.Normalise()
+		idx := slices.IndexFunc(acl.Users, func(u *User) bool {
+			return u.Username == user.Username
+		})
+		if idx == -1 {
+			acl.Users = append(acl.Users, user)
+			continue
+		}
+		acl.Users[idx].Replace(user)
+	}
+
+	acl.CompileGlobs()
+
+	return nil
+}
+
 func (acl *ACL) CompileGlobs() {
 	// Extract all the relevant globs from all the users
 	var allGlobs []string
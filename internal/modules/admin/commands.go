@@ -20,10 +20,143 @@ import (
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
 	"github.com/gobwas/glob"
+	"net"
+	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 )
 
+func handleBackup(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	target := params.Command[1]
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	// Write the archive to a temporary file first so that a failed backup never replaces a previous one.
+	f, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}()
+
+	if err = params.Backup(f); err != nil {
+		return nil, err
+	}
+	if err = f.Sync(); err != nil {
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(f.Name(), target); err != nil {
+		return nil, err
+	}
+
+	return []byte(constants.OkResponse), nil
+}
+
+func handleReplicaOf(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	if strings.EqualFold(params.Command[1], "no") && strings.EqualFold(params.Command[2], "one") {
+		if err := params.ReplicaOf("", 0); err != nil {
+			return nil, err
+		}
+		return []byte(constants.OkResponse), nil
+	}
+
+	port, err := strconv.Atoi(params.Command[2])
+	if err != nil || port < 1 || port > 65535 {
+		return nil, errors.New("port must be an integer between 1 and 65535")
+	}
+	if err = params.ReplicaOf(params.Command[1], port); err != nil {
+		return nil, err
+	}
+
+	return []byte(constants.OkResponse), nil
+}
+
+func handlePSync(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	offset, err := strconv.ParseInt(params.Command[2], 10, 64)
+	if err != nil || offset < -1 {
+		return nil, errors.New("offset must be an integer greater than or equal to -1")
+	}
+
+	// The replication stream is written directly to the connection, so this only returns once the link is closed.
+	if err = params.SyncReplica(params.Context, params.Connection, params.Command[1], offset); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func handlePeerSync(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 4 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	offset, err := strconv.ParseInt(params.Command[3], 10, 64)
+	if err != nil || offset < -1 {
+		return nil, errors.New("offset must be an integer greater than or equal to -1")
+	}
+
+	// The effect stream is written directly to the connection, so this only returns once the link is closed.
+	if err = params.SyncPeer(params.Context, params.Connection, params.Command[1], params.Command[2], offset); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func handleRole(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 1 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	info, err := params.GetReplicationInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Role == "replica" {
+		return []byte(fmt.Sprintf("*5\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n$%d\r\n%s\r\n:%d\r\n",
+			len(info.Role), info.Role,
+			len(info.PrimaryHost), info.PrimaryHost,
+			info.PrimaryPort,
+			len(info.LinkState), info.LinkState,
+			info.Offset,
+		)), nil
+	}
+
+	res := fmt.Sprintf("*3\r\n$%d\r\n%s\r\n:%d\r\n*%d\r\n", len(info.Role), info.Role, info.Offset, len(info.Replicas))
+	for _, replica := range info.Replicas {
+		host, port, err := net.SplitHostPort(replica.Addr)
+		if err != nil {
+			host, port = replica.Addr, ""
+		}
+		offset := strconv.FormatUint(replica.Offset, 10)
+		res += fmt.Sprintf("*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+			len(host), host, len(port), port, len(offset), offset)
+	}
+
+	return []byte(res), nil
+}
+
 func handleGetAllCommands(params internal.HandlerFuncParams) ([]byte, error) {
 	commands := params.GetAllCommands()
 
@@ -189,6 +322,165 @@ func handleCommandDocs(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte("*0\r\n"), nil
 }
 
+func handleMemoryUsage(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 && len(params.Command) != 5 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	// The size of each key is tracked on every write, so the SAMPLES option doesn't change the result.
+	// It's only validated for compatibility with the clients that send it.
+	if len(params.Command) == 5 {
+		if !strings.EqualFold(params.Command[3], "samples") {
+			return nil, fmt.Errorf("unknown option %s", params.Command[3])
+		}
+		if samples, err := strconv.Atoi(params.Command[4]); err != nil || samples < 0 {
+			return nil, errors.New("samples must be a non-negative integer")
+		}
+	}
+
+	size, ok := params.GetMemoryUsage(params.Context, params.Command[2])
+	if !ok {
+		return []byte("$-1\r\n"), nil
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", size)), nil
+}
+
+func handleMemoryStats(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	stats := params.GetMemoryStats()
+
+	bytesPerKey, datasetPercentage, peakPercentage := int64(0), float64(0), float64(0)
+	if stats.Keys > 0 {
+		bytesPerKey = stats.TotalAllocated / int64(stats.Keys)
+	}
+	if stats.TotalAllocated > 0 {
+		datasetPercentage = float64(stats.DatasetBytes) * 100 / float64(stats.TotalAllocated)
+	}
+	if stats.PeakAllocated > 0 {
+		peakPercentage = float64(stats.TotalAllocated) * 100 / float64(stats.PeakAllocated)
+	}
+
+	databases := make([]int, 0, len(stats.Databases))
+	for database := range stats.Databases {
+		databases = append(databases, database)
+	}
+	slices.Sort(databases)
+
+	bulk := func(s string) string {
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	}
+	integer := func(n any) string {
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+
+	res := fmt.Sprintf("*%d\r\n", 2*(21+len(databases)))
+	res += bulk("peak.allocated") + integer(stats.PeakAllocated)
+	res += bulk("total.allocated") + integer(stats.TotalAllocated)
+	res += bulk("maxmemory") + integer(stats.MaxMemory)
+	for _, database := range databases {
+		db := stats.Databases[database]
+		res += bulk(fmt.Sprintf("db.%d", database)) + "*8\r\n"
+		res += bulk("overhead.hashtable.main") + integer(db.OverheadMain)
+		res += bulk("overhead.hashtable.expires") + integer(db.OverheadExpires)
+		res += bulk("keys.count") + integer(db.Keys)
+		res += bulk("volatile.count") + integer(db.VolatileKeys)
+	}
+	res += bulk("overhead.total") + integer(stats.OverheadBytes)
+	res += bulk("keys.count") + integer(stats.Keys)
+	res += bulk("keys.bytes-per-key") + integer(bytesPerKey)
+	res += bulk("dataset.bytes") + integer(stats.DatasetBytes)
+	res += bulk("dataset.percentage") + bulk(strconv.FormatFloat(datasetPercentage, 'f', 2, 64))
+	res += bulk("peak.percentage") + bulk(strconv.FormatFloat(peakPercentage, 'f', 2, 64))
+	res += bulk("runtime.heap.alloc") + integer(stats.HeapAlloc)
+	res += bulk("lazyfree.pending.objects") + integer(stats.LazyFreePendingObjects)
+	res += bulk("lazyfreed.objects") + integer(stats.LazyFreedObjects)
+	res += bulk("tiered.keys") + integer(stats.Tiered.Keys)
+	res += bulk("tiered.hits") + integer(stats.Tiered.Hits)
+	res += bulk("tiered.misses") + integer(stats.Tiered.Misses)
+	res += bulk("tiered.faults") + integer(stats.Tiered.Faults)
+	res += bulk("tiered.spills") + integer(stats.Tiered.Spills)
+	res += bulk("tiered.disk-bytes") + integer(stats.Tiered.DiskBytes)
+	res += bulk("activeactive.keys") + integer(stats.ActiveActive.Keys)
+	res += bulk("activeactive.bytes") + integer(stats.ActiveActive.Bytes)
+	res += bulk("runtime.heap.sys") + integer(stats.HeapSys)
+
+	return []byte(res), nil
+}
+
+func handleMemoryQuotas(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	bulk := func(s string) string {
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+	}
+	integer := func(n any) string {
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+
+	quotas := params.GetMemoryQuotas()
+	res := fmt.Sprintf("*%d\r\n", len(quotas))
+	for _, quota := range quotas {
+		res += "*14\r\n"
+		res += bulk("db") + integer(quota.Database)
+		res += bulk("prefix") + bulk(quota.Prefix)
+		res += bulk("maxmemory") + integer(quota.MaxMemory)
+		res += bulk("maxmemory-policy") + bulk(quota.Policy)
+		res += bulk("used") + integer(quota.Used)
+		res += bulk("evicted.keys") + integer(quota.Evicted)
+		res += bulk("rejected.writes") + integer(quota.Rejected)
+	}
+
+	return []byte(res), nil
+}
+
+func handleMemoryDoctor(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	stats := params.GetMemoryStats()
+	var issues []string
+
+	if stats.MaxMemory == 0 {
+		issues = append(issues, "No max memory is set, so the store can grow until the host runs out of memory. "+
+			"Set --max-memory along with an eviction policy to bound the memory usage.")
+	} else if float64(stats.TotalAllocated) >= 0.9*float64(stats.MaxMemory) {
+		issues = append(issues, fmt.Sprintf("The store uses %d bytes, %.2f%% of the max memory of %d bytes. "+
+			"Writes are rejected with the noeviction policy, and keys are evicted with the other policies "+
+			"once the max memory is reached.",
+			stats.TotalAllocated, float64(stats.TotalAllocated)*100/float64(stats.MaxMemory), stats.MaxMemory))
+	}
+	if stats.TotalAllocated > 0 && float64(stats.PeakAllocated) > 1.5*float64(stats.TotalAllocated) {
+		issues = append(issues, fmt.Sprintf("The peak memory usage of %d bytes is more than 150%% of the "+
+			"current memory usage of %d bytes. The keyspace maps keep the capacity of the peak number of keys, "+
+			"and the Go runtime may not return the freed memory to the host right away.",
+			stats.PeakAllocated, stats.TotalAllocated))
+	}
+	if stats.Keys > 0 && stats.OverheadBytes > stats.DatasetBytes {
+		issues = append(issues, fmt.Sprintf("The overhead of the keyspace of %d bytes is larger than the "+
+			"dataset of %d bytes. This happens when many small keys are stored, or when most keys were deleted "+
+			"since the peak, as maps never shrink.", stats.OverheadBytes, stats.DatasetBytes))
+	}
+	if stats.TotalAllocated > 0 && stats.HeapAlloc > 4*uint64(stats.TotalAllocated) {
+		issues = append(issues, fmt.Sprintf("The Go heap holds %d bytes, which is more than 4 times the memory "+
+			"used by the store. The difference is made of connection buffers, scripts, and garbage that "+
+			"hasn't been collected yet. Account for it when sizing the instance.", stats.HeapAlloc))
+	}
+
+	report := "The memory usage looks healthy, no issues were detected."
+	if stats.Keys == 0 && stats.TotalAllocated < 1024*1024 {
+		report = "The instance is empty or uses very little memory, there's nothing to report."
+	} else if len(issues) > 0 {
+		report = strings.Join(issues, "\n\n")
+	}
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(report), report)), nil
+}
+
 func Commands() []internal.Command {
 	return []internal.Command{
 		{
@@ -318,6 +610,151 @@ Get the list of command names. Allows for filtering by ACL category or glob patt
 				return []byte(constants.OkResponse), nil
 			},
 		},
+		{
+			Command:    "backup",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(BACKUP path) Write a consistent backup archive of the data, ACL users and loaded libraries
+to the path on the server. The archive can be restored with the --restore-backup flag.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleBackup,
+		},
+		{
+			Command:    "replicaof",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(REPLICAOF host port | NO ONE) Make the server a read-only replica of the primary at host:port,
+or stop the replication and turn the server into a primary with REPLICAOF NO ONE. Only works in standalone mode.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleReplicaOf,
+		},
+		{
+			Command:    "psync",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(PSYNC replicationid offset) Internal command used by replicas to synchronise with the primary.
+The connection then receives the replication stream until it's closed.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handlePSync,
+		},
+		{
+			Command:    "peersync",
+			Module:     constants.AdminModule,
+			Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+			Description: `(PEERSYNC siteid replicationid offset) Internal command used by the peer sites of active-active replication
+to synchronise with this site. The connection then receives the stream of the effects of the local writes until it's closed.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handlePeerSync,
+		},
+		{
+			Command:     "memory",
+			Module:      constants.AdminModule,
+			Categories:  []string{},
+			Description: "Memory commands",
+			Type:        "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []internal.SubCommand{
+				{
+					Command:    "usage",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.ReadCategory, constants.SlowCategory},
+					Description: `(MEMORY USAGE key [SAMPLES count]) Return the number of bytes used by the key and its value.
+The SAMPLES option is accepted for compatibility, the size of each key is tracked on every write.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						if len(cmd) != 3 && len(cmd) != 5 {
+							return internal.KeyExtractionFuncResult{}, errors.New(constants.WrongArgsResponse)
+						}
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: cmd[2:3], WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleMemoryUsage,
+				},
+				{
+					Command:     "stats",
+					Module:      constants.AdminModule,
+					Categories:  []string{constants.SlowCategory},
+					Description: `(MEMORY STATS) Return the memory usage of the store and of each database.`,
+					Sync:        false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleMemoryStats,
+				},
+				{
+					Command:    "quotas",
+					Module:     constants.AdminModule,
+					Categories: []string{constants.SlowCategory},
+					Description: `(MEMORY QUOTAS) Return the usage of each memory quota on the keys of a database,
+or on the keys of a database that start with a prefix.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleMemoryQuotas,
+				},
+				{
+					Command:     "doctor",
+					Module:      constants.AdminModule,
+					Categories:  []string{constants.SlowCategory},
+					Description: `(MEMORY DOCTOR) Return a report of the memory issues detected in the store.`,
+					Sync:        false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleMemoryDoctor,
+				},
+			},
+		},
+		{
+			Command:     "role",
+			Module:      constants.AdminModule,
+			Categories:  []string{constants.AdminCategory, constants.FastCategory, constants.DangerousCategory},
+			Description: "(ROLE) Return the replication role of the server along with the state of the replication.",
+			Sync:        false,
+			Type:        "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleRole,
+		},
 		{
 			Command:     "module",
 			Module:      constants.AdminModule,
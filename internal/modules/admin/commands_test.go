@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
+	"github.com/echovault/sugardb/internal/config"
 	"github.com/echovault/sugardb/internal/constants"
 	"github.com/echovault/sugardb/internal/modules/acl"
 	"github.com/echovault/sugardb/internal/modules/admin"
+	"github.com/echovault/sugardb/internal/modules/cluster"
 	"github.com/echovault/sugardb/internal/modules/connection"
 	"github.com/echovault/sugardb/internal/modules/generic"
 	"github.com/echovault/sugardb/internal/modules/hash"
@@ -35,6 +37,7 @@ import (
 	"os"
 	"path"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -96,6 +99,7 @@ func Test_AdminCommands(t *testing.T) {
 		var commands []internal.Command
 		commands = append(commands, acl.Commands()...)
 		commands = append(commands, admin.Commands()...)
+		commands = append(commands, cluster.Commands()...)
 		commands = append(commands, generic.Commands()...)
 		commands = append(commands, hash.Commands()...)
 		commands = append(commands, list.Commands()...)
@@ -148,6 +152,7 @@ func Test_AdminCommands(t *testing.T) {
 		var commands []internal.Command
 		commands = append(commands, acl.Commands()...)
 		commands = append(commands, admin.Commands()...)
+		commands = append(commands, cluster.Commands()...)
 		commands = append(commands, generic.Commands()...)
 		commands = append(commands, hash.Commands()...)
 		commands = append(commands, list.Commands()...)
@@ -189,6 +194,7 @@ func Test_AdminCommands(t *testing.T) {
 		var allCommands []internal.Command
 		allCommands = append(allCommands, acl.Commands()...)
 		allCommands = append(allCommands, admin.Commands()...)
+		allCommands = append(allCommands, cluster.Commands()...)
 		allCommands = append(allCommands, generic.Commands()...)
 		allCommands = append(allCommands, hash.Commands()...)
 		allCommands = append(allCommands, list.Commands()...)
@@ -1074,4 +1080,330 @@ func Test_AdminCommands(t *testing.T) {
 		_ = conn.Close()
 		mockServer.ShutDown()
 	})
+
+	t.Run("Test REPLICAOF/PSYNC/ROLE commands", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := internal.GetConnection("localhost", port)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		client := resp.NewConn(conn)
+
+		tests := []struct {
+			name    string
+			command []string
+			wantErr string
+		}{
+			{
+				name:    "1. REPLICAOF with wrong number of args",
+				command: []string{"REPLICAOF", "localhost"},
+				wantErr: constants.WrongArgsResponse,
+			},
+			{
+				name:    "2. REPLICAOF with invalid port",
+				command: []string{"REPLICAOF", "localhost", "port"},
+				wantErr: "port must be an integer between 1 and 65535",
+			},
+			{
+				name:    "3. PSYNC with invalid offset",
+				command: []string{"PSYNC", "?", "offset"},
+				wantErr: "offset must be an integer greater than or equal to -1",
+			},
+			{
+				name:    "4. ROLE with wrong number of args",
+				command: []string{"ROLE", "extra"},
+				wantErr: constants.WrongArgsResponse,
+			},
+		}
+
+		for _, test := range tests {
+			command := make([]resp.Value, len(test.command))
+			for i, c := range test.command {
+				command[i] = resp.StringValue(c)
+			}
+			if err = client.WriteArray(command); err != nil {
+				t.Error(err)
+				return
+			}
+			res, _, err := client.ReadValue()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !strings.Contains(res.Error().Error(), test.wantErr) {
+				t.Errorf("%s: expected error \"%s\", got \"%s\"", test.name, test.wantErr, res.String())
+			}
+		}
+
+		// REPLICAOF NO ONE on a primary is a no-op.
+		if err = client.WriteArray([]resp.Value{
+			resp.StringValue("REPLICAOF"), resp.StringValue("NO"), resp.StringValue("ONE"),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, _, err := client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.EqualFold(res.String(), "ok") {
+			t.Errorf("expected response OK, got \"%s\"", res.String())
+		}
+
+		if err = client.WriteArray([]resp.Value{resp.StringValue("ROLE")}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, _, err = client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		role := res.Array()
+		if len(role) != 3 || role[0].String() != "master" || len(role[2].Array()) != 0 {
+			t.Errorf("expected master role without replicas, got %v", role)
+		}
+	})
+
+	t.Run("Test MEMORY USAGE/STATS/DOCTOR commands", func(t *testing.T) {
+		conn, err := internal.GetConnection("localhost", port)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		client := resp.NewConn(conn)
+
+		do := func(cmd ...string) resp.Value {
+			command := make([]resp.Value, len(cmd))
+			for i, c := range cmd {
+				command[i] = resp.StringValue(c)
+			}
+			if err = client.WriteArray(command); err != nil {
+				t.Fatal(err)
+			}
+			res, _, err := client.ReadValue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res
+		}
+
+		if res := do("SET", "MemoryKey1", strings.Repeat("x", 1000)); !strings.EqualFold(res.String(), "ok") {
+			t.Fatalf("expected response OK, got \"%s\"", res.String())
+		}
+
+		tests := []struct {
+			name    string
+			command []string
+			want    int
+			wantNil bool
+			wantErr string
+		}{
+			{
+				name:    "1. MEMORY USAGE of a string",
+				command: []string{"MEMORY", "USAGE", "MemoryKey1"},
+				// The key, the key data struct, and the value.
+				want: 16 + 10 + 56 + 16 + 1000,
+			},
+			{
+				name:    "2. MEMORY USAGE with SAMPLES",
+				command: []string{"MEMORY", "USAGE", "MemoryKey1", "SAMPLES", "0"},
+				want:    16 + 10 + 56 + 16 + 1000,
+			},
+			{
+				name:    "3. MEMORY USAGE of a key that doesn't exist",
+				command: []string{"MEMORY", "USAGE", "MemoryKey2"},
+				wantNil: true,
+			},
+			{
+				name:    "4. MEMORY USAGE with invalid SAMPLES",
+				command: []string{"MEMORY", "USAGE", "MemoryKey1", "SAMPLES", "-1"},
+				wantErr: "samples must be a non-negative integer",
+			},
+			{
+				name:    "5. MEMORY USAGE with wrong number of args",
+				command: []string{"MEMORY", "USAGE"},
+				wantErr: constants.WrongArgsResponse,
+			},
+		}
+
+		for _, test := range tests {
+			res := do(test.command...)
+			if test.wantErr != "" {
+				if res.Error() == nil || !strings.Contains(res.Error().Error(), test.wantErr) {
+					t.Errorf("%s: expected error \"%s\", got \"%s\"", test.name, test.wantErr, res.String())
+				}
+				continue
+			}
+			if res.IsNull() != test.wantNil {
+				t.Errorf("%s: expected nil %v, got \"%s\"", test.name, test.wantNil, res.String())
+			}
+			if !test.wantNil && res.Integer() != test.want {
+				t.Errorf("%s: expected %d, got %d", test.name, test.want, res.Integer())
+			}
+		}
+
+		stats := make(map[string]resp.Value)
+		values := do("MEMORY", "STATS").Array()
+		for i := 0; i+1 < len(values); i += 2 {
+			stats[values[i].String()] = values[i+1]
+		}
+		for _, name := range []string{
+			"peak.allocated", "total.allocated", "maxmemory", "db.0", "overhead.total", "keys.count",
+			"keys.bytes-per-key", "dataset.bytes", "dataset.percentage", "peak.percentage",
+			"runtime.heap.alloc", "runtime.heap.sys", "tiered.keys", "tiered.hits", "tiered.misses", "tiered.faults",
+			"tiered.spills", "tiered.disk-bytes", "activeactive.keys", "activeactive.bytes",
+		} {
+			if _, ok := stats[name]; !ok {
+				t.Errorf("expected MEMORY STATS to contain %s, got %v", name, values)
+			}
+		}
+		if stats["keys.count"].Integer() < 1 {
+			t.Errorf("expected at least 1 key, got %d", stats["keys.count"].Integer())
+		}
+		if total, dataset, overhead := stats["total.allocated"].Integer(), stats["dataset.bytes"].Integer(),
+			stats["overhead.total"].Integer(); total != dataset+overhead {
+			t.Errorf("expected total %d to be the sum of the dataset %d and the overhead %d", total, dataset, overhead)
+		}
+
+		// Growing an existing set or sorted set in place is accounted for in the key's usage and the total.
+		totalAllocated := func() int {
+			values := do("MEMORY", "STATS").Array()
+			for i := 0; i+1 < len(values); i += 2 {
+				if values[i].String() == "total.allocated" {
+					return values[i+1].Integer()
+				}
+			}
+			t.Fatalf("expected MEMORY STATS to contain total.allocated, got %v", values)
+			return 0
+		}
+		for _, grow := range []struct {
+			key  string
+			args func(i int) []string
+		}{
+			{key: "MemorySet", args: func(i int) []string {
+				return []string{"SADD", "MemorySet", fmt.Sprintf("member-%d", i)}
+			}},
+			{key: "MemoryZSet", args: func(i int) []string {
+				return []string{"ZADD", "MemoryZSet", strconv.Itoa(i), fmt.Sprintf("member-%d", i)}
+			}},
+		} {
+			do(grow.args(0)...)
+			usage, total := do("MEMORY", "USAGE", grow.key).Integer(), totalAllocated()
+			for i := 1; i <= 100; i++ {
+				if res := do(grow.args(i)...); res.Integer() != 1 {
+					t.Fatalf("expected %v to add 1 member, got \"%s\"", grow.args(i), res.String())
+				}
+			}
+			if grown := do("MEMORY", "USAGE", grow.key).Integer(); grown <= usage {
+				t.Errorf("expected MEMORY USAGE of %s to grow from %d, got %d", grow.key, usage, grown)
+			}
+			if grown := totalAllocated(); grown <= total {
+				t.Errorf("expected total.allocated to grow from %d after growing %s, got %d", total, grow.key, grown)
+			}
+		}
+
+		// No max memory is set.
+		if res := do("MEMORY", "DOCTOR"); !strings.Contains(res.String(), "No max memory is set") {
+			t.Errorf("expected MEMORY DOCTOR to report the missing max memory, got \"%s\"", res.String())
+		}
+	})
+
+	t.Run("Test MEMORY QUOTAS command", func(t *testing.T) {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := sugardb.DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = "localhost"
+		conf.Port = uint16(port)
+		conf.EvictionPolicy = constants.NoEviction
+		conf.MemoryQuotas = []config.MemoryQuota{
+			{Database: 0, Prefix: "tenant:1:", MaxMemory: 2000, Policy: constants.NoEviction},
+			{Database: 0, MaxMemory: 1 << 20, Policy: constants.AllKeysRandom},
+		}
+		mockServer, err := sugardb.NewSugarDB(sugardb.WithConfig(conf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			mockServer.Start()
+		}()
+		t.Cleanup(mockServer.ShutDown)
+
+		conn, err := internal.GetConnection("localhost", port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		client := resp.NewConn(conn)
+
+		do := func(cmd ...string) resp.Value {
+			command := make([]resp.Value, len(cmd))
+			for i, c := range cmd {
+				command[i] = resp.StringValue(c)
+			}
+			if err = client.WriteArray(command); err != nil {
+				t.Fatal(err)
+			}
+			res, _, err := client.ReadValue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res
+		}
+
+		// The quota of the prefix rejects the writes to its keys once it's reached.
+		value := strings.Repeat("x", 1000)
+		for _, key := range []string{"tenant:1:a", "tenant:1:b"} {
+			if res := do("SET", key, value); !strings.EqualFold(res.String(), "ok") {
+				t.Fatalf("expected response OK, got \"%s\"", res.String())
+			}
+		}
+		if res := do("SET", "tenant:1:c", value); res.Error() == nil ||
+			!strings.Contains(res.Error().Error(), "memory quota db 0 prefix tenant:1: reached") {
+			t.Errorf("expected the write to be rejected by the quota, got \"%s\"", res.String())
+		}
+		if res := do("SET", "tenant:2:a", value); !strings.EqualFold(res.String(), "ok") {
+			t.Errorf("expected the write outside of the quota to succeed, got \"%s\"", res.String())
+		}
+
+		quotas := do("MEMORY", "QUOTAS").Array()
+		if len(quotas) != 2 {
+			t.Fatalf("expected 2 quotas, got %v", quotas)
+		}
+		stats := make([]map[string]resp.Value, len(quotas))
+		for i, quota := range quotas {
+			values := quota.Array()
+			stats[i] = make(map[string]resp.Value)
+			for j := 0; j+1 < len(values); j += 2 {
+				stats[i][values[j].String()] = values[j+1]
+			}
+		}
+		if stats[0]["prefix"].String() != "" || stats[0]["maxmemory-policy"].String() != constants.AllKeysRandom ||
+			stats[0]["maxmemory"].Integer() != 1<<20 {
+			t.Errorf("expected the quota of the database first, got %v", quotas[0])
+		}
+		if stats[1]["prefix"].String() != "tenant:1:" || stats[1]["rejected.writes"].Integer() != 1 ||
+			stats[1]["evicted.keys"].Integer() != 0 {
+			t.Errorf("expected the quota of the prefix to have rejected 1 write, got %v", quotas[1])
+		}
+		// Each key counts towards the quotas it belongs to.
+		if db, prefix := stats[0]["used"].Integer(), stats[1]["used"].Integer(); prefix < 2000 || db <= prefix {
+			t.Errorf("expected the usage of the prefix to reach its max memory and be below the usage of the database, "+
+				"got %d and %d", prefix, db)
+		}
+	})
 }
@@ -0,0 +1,590 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/slots"
+)
+
+func handleClusterSlots(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	shards, err := params.GetShards()
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	var res string
+	for _, shard := range shards {
+		if len(shard.Nodes) == 0 {
+			continue
+		}
+		for _, r := range shard.Ranges {
+			res += fmt.Sprintf("*%d\r\n:%d\r\n:%d\r\n", 2+len(shard.Nodes), r.Start, r.End)
+			for _, node := range shard.Nodes {
+				res += fmt.Sprintf("*3\r\n$%d\r\n%s\r\n:%d\r\n$%d\r\n%s\r\n",
+					len(node.Host), node.Host, node.Port, len(node.ID), node.ID)
+			}
+			count += 1
+		}
+	}
+
+	return []byte(fmt.Sprintf("*%d\r\n%s", count, res)), nil
+}
+
+func handleClusterShards(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	shards, err := params.GetShards()
+	if err != nil {
+		return nil, err
+	}
+
+	res := fmt.Sprintf("*%d\r\n", len(shards))
+	for _, shard := range shards {
+		res += "*6\r\n"
+		res += fmt.Sprintf("$2\r\nid\r\n$%d\r\n%s\r\n", len(shard.ID), shard.ID)
+
+		res += fmt.Sprintf("$5\r\nslots\r\n*%d\r\n", 2*len(shard.Ranges))
+		for _, r := range shard.Ranges {
+			res += fmt.Sprintf(":%d\r\n:%d\r\n", r.Start, r.End)
+		}
+
+		res += fmt.Sprintf("$5\r\nnodes\r\n*%d\r\n", len(shard.Nodes))
+		for _, node := range shard.Nodes {
+			role := "replica"
+			if node.Leader {
+				role = "master"
+			}
+			res += "*10\r\n"
+			res += fmt.Sprintf("$2\r\nid\r\n$%d\r\n%s\r\n", len(node.ID), node.ID)
+			res += fmt.Sprintf("$4\r\nport\r\n:%d\r\n", node.Port)
+			res += fmt.Sprintf("$2\r\nip\r\n$%d\r\n%s\r\n", len(node.Host), node.Host)
+			res += fmt.Sprintf("$4\r\nrole\r\n$%d\r\n%s\r\n", len(role), role)
+			res += "$6\r\nhealth\r\n$6\r\nonline\r\n"
+		}
+	}
+
+	return []byte(res), nil
+}
+
+func handleClusterKeySlot(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", slots.KeySlot(params.Command[2]))), nil
+}
+
+func handleClusterMyShardID(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	id, err := params.GetShardID()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(id), id)), nil
+}
+
+func handleClusterAddSlots(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) < 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	var slotList []int
+	for _, s := range params.Command[2:] {
+		slot, err := slots.ParseSlot(s)
+		if err != nil {
+			return nil, err
+		}
+		slotList = append(slotList, slot)
+	}
+	if err := params.AddSlots(slotList); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterAddSlotsRange(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) < 4 || len(params.Command)%2 != 0 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	var slotList []int
+	for i := 2; i < len(params.Command); i += 2 {
+		r, err := slots.ParseRange(fmt.Sprintf("%s-%s", params.Command[i], params.Command[i+1]))
+		if err != nil {
+			return nil, err
+		}
+		slotList = append(slotList, slots.FromRanges([]slots.Range{r})...)
+	}
+	if err := params.AddSlots(slotList); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterSetSlot(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) < 4 || len(params.Command) > 5 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	slot, err := slots.ParseSlot(params.Command[2])
+	if err != nil {
+		return nil, err
+	}
+
+	state := strings.ToUpper(params.Command[3])
+	shardID := ""
+	switch state {
+	default:
+		return nil, fmt.Errorf("invalid slot state %s", params.Command[3])
+	case "STABLE":
+		if len(params.Command) != 4 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+	case "MIGRATING", "IMPORTING", "NODE":
+		if len(params.Command) != 5 {
+			return nil, errors.New(constants.WrongArgsResponse)
+		}
+		shardID = params.Command[4]
+	}
+
+	if err = params.SetSlot(slot, state, shardID); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterGetKeysInSlot(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 4 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	slot, err := slots.ParseSlot(params.Command[2])
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(params.Command[3])
+	if err != nil || count < 0 {
+		return nil, errors.New("count must be an integer >= 0")
+	}
+	if count == 0 {
+		return []byte("*0\r\n"), nil
+	}
+
+	keys := params.GetKeysInSlot(params.Context, slot, count)
+	res := fmt.Sprintf("*%d\r\n", len(keys))
+	for _, key := range keys {
+		res += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+	}
+	return []byte(res), nil
+}
+
+func handleClusterCountKeysInSlot(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	slot, err := slots.ParseSlot(params.Command[2])
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", len(params.GetKeysInSlot(params.Context, slot, 0)))), nil
+}
+
+func handleClusterInfo(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	info, err := params.GetClusterInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Enabled {
+		res := "cluster_enabled:0\r\n"
+		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(res), res)), nil
+	}
+
+	state := "ok"
+	if info.LeaderID == "" {
+		state = "fail"
+	}
+	res := "cluster_enabled:1\r\n"
+	res += fmt.Sprintf("cluster_state:%s\r\n", state)
+	res += fmt.Sprintf("cluster_known_nodes:%d\r\n", info.Members)
+	res += fmt.Sprintf("server_id:%s\r\n", info.ServerID)
+	if info.ShardID != "" {
+		res += fmt.Sprintf("shard_id:%s\r\n", info.ShardID)
+	}
+	res += fmt.Sprintf("raft_state:%s\r\n", strings.ToLower(info.State))
+	res += fmt.Sprintf("raft_suffrage:%s\r\n", info.Suffrage)
+	res += fmt.Sprintf("raft_leader:%s\r\n", info.LeaderID)
+	res += fmt.Sprintf("raft_term:%d\r\n", info.Term)
+	res += fmt.Sprintf("raft_commit_index:%d\r\n", info.CommitIndex)
+	res += fmt.Sprintf("raft_applied_index:%d\r\n", info.AppliedIndex)
+	res += fmt.Sprintf("raft_last_index:%d\r\n", info.LastIndex)
+	res += fmt.Sprintf("raft_voters:%d\r\n", info.Voters)
+	res += fmt.Sprintf("raft_nonvoters:%d\r\n", info.Nonvoters)
+
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(res), res)), nil
+}
+
+func handleClusterNodes(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 2 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	nodes, err := params.GetClusterNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var res string
+	for _, node := range nodes {
+		var flags []string
+		if node.Myself {
+			flags = append(flags, "myself")
+		}
+		if node.Leader {
+			flags = append(flags, "leader")
+		} else {
+			flags = append(flags, "follower")
+		}
+		clientAddr := node.ClientAddr
+		if clientAddr == "" {
+			clientAddr = "-"
+		}
+		res += fmt.Sprintf("%s %s %s %s %s %s\n",
+			node.ID, clientAddr, node.RaftAddr, strings.Join(flags, ","), node.Suffrage, node.Health)
+	}
+
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(res), res)), nil
+}
+
+func handleClusterForget(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := params.ForgetNode(params.Command[2]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterTransferLeadership(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) > 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	id := ""
+	if len(params.Command) == 3 {
+		id = params.Command[2]
+	}
+	if err := params.TransferLeadership(id); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterAddNonvoter(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 4 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := params.AddNonvoter(params.Command[2], params.Command[3]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleClusterPromote(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	if err := params.PromoteNode(params.Command[2]); err != nil {
+		return nil, err
+	}
+	return []byte(constants.OkResponse), nil
+}
+
+func handleAsking(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 1 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+	params.SetAsking(params.Connection)
+	return []byte(constants.OkResponse), nil
+}
+
+func Commands() []internal.Command {
+	return []internal.Command{
+		{
+			Command:     "cluster",
+			Module:      constants.ClusterModule,
+			Categories:  []string{},
+			Description: "Commands pertaining to cluster membership, leadership and hash slot sharding in cluster mode.",
+			Sync:        false,
+			Type:        "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []internal.SubCommand{
+				{
+					Command:    "slots",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.SlowCategory},
+					Description: `(CLUSTER SLOTS) Returns the mapping of hash slot ranges to nodes.
+Each range is listed with the shard leader first, followed by the other nodes of the shard.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterSlots,
+				},
+				{
+					Command:    "shards",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.SlowCategory},
+					Description: `(CLUSTER SHARDS) Returns the shards of the cluster.
+Each shard is listed with its ID, the hash slot ranges it owns and its nodes.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterShards,
+				},
+				{
+					Command:     "keyslot",
+					Module:      constants.ClusterModule,
+					Categories:  []string{constants.SlowCategory},
+					Description: `(CLUSTER KEYSLOT key) Returns the hash slot of the key.`,
+					Sync:        false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterKeySlot,
+				},
+				{
+					Command:     "myshardid",
+					Module:      constants.ClusterModule,
+					Categories:  []string{constants.SlowCategory},
+					Description: `(CLUSTER MYSHARDID) Returns the ID of the shard the node belongs to.`,
+					Sync:        false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterMyShardID,
+				},
+				{
+					Command:    "addslots",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER ADDSLOTS slot [slot ...]) Assigns the hash slots to the node's shard.
+Must be executed on the shard leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterAddSlots,
+				},
+				{
+					Command:    "addslotsrange",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER ADDSLOTSRANGE start-slot end-slot [start-slot end-slot ...])
+Assigns the inclusive ranges of hash slots to the node's shard. Must be executed on the shard leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterAddSlotsRange,
+				},
+				{
+					Command:    "setslot",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER SETSLOT slot <MIGRATING shard-id | IMPORTING shard-id | STABLE | NODE shard-id>)
+Changes the migration state of the hash slot. Must be executed on the shard leader.
+MIGRATING starts moving the slot's keys to the target shard. Once all the keys are moved, the slot is handed over
+to the target shard. IMPORTING, STABLE and NODE can be used to repair the state of an interrupted migration.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterSetSlot,
+				},
+				{
+					Command:     "getkeysinslot",
+					Module:      constants.ClusterModule,
+					Categories:  []string{constants.SlowCategory},
+					Description: `(CLUSTER GETKEYSINSLOT slot count) Returns up to count keys of the current database in the hash slot.`,
+					Sync:        false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterGetKeysInSlot,
+				},
+				{
+					Command:     "countkeysinslot",
+					Module:      constants.ClusterModule,
+					Categories:  []string{constants.SlowCategory},
+					Description: `(CLUSTER COUNTKEYSINSLOT slot) Returns the number of keys of the current database in the hash slot.`,
+					Sync:        false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterCountKeysInSlot,
+				},
+				{
+					Command:    "info",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.SlowCategory},
+					Description: `(CLUSTER INFO) Returns the raft state of the node.
+This includes the raft role, term, commit and applied indexes of the node as well as the number of voters,
+non-voters and live cluster members.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterInfo,
+				},
+				{
+					Command:    "nodes",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.SlowCategory},
+					Description: `(CLUSTER NODES) Returns the nodes in the raft configuration of the node.
+Each line describes a node with its ID, client address, raft address, flags, suffrage and memberlist health.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterNodes,
+				},
+				{
+					Command:    "forget",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER FORGET node-id) Removes the node from the raft configuration.
+Must be executed on the cluster leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterForget,
+				},
+				{
+					Command:    "remove",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER REMOVE node-id) Alias of CLUSTER FORGET. Removes the node from the raft configuration.
+Must be executed on the cluster leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterForget,
+				},
+				{
+					Command:    "transfer-leadership",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER TRANSFER-LEADERSHIP [node-id]) Hands over leadership to the voter with the provided ID.
+If no ID is provided, the most up-to-date voter is picked. Must be executed on the cluster leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterTransferLeadership,
+				},
+				{
+					Command:    "add-nonvoter",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER ADD-NONVOTER node-id raft-address) Adds a node that replicates the raft log
+without taking part in elections. Must be executed on the cluster leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterAddNonvoter,
+				},
+				{
+					Command:    "promote",
+					Module:     constants.ClusterModule,
+					Categories: []string{constants.AdminCategory, constants.SlowCategory, constants.DangerousCategory},
+					Description: `(CLUSTER PROMOTE node-id) Turns the non-voter into a voter that takes part in elections and commits.
+Must be executed on the cluster leader.`,
+					Sync: false,
+					KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+						return internal.KeyExtractionFuncResult{
+							Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+						}, nil
+					},
+					HandlerFunc: handleClusterPromote,
+				},
+			},
+		},
+		{
+			Command:    "asking",
+			Module:     constants.ClusterModule,
+			Categories: []string{constants.ConnectionCategory, constants.FastCategory},
+			Description: `(ASKING) Allows the next command of the connection to access a hash slot that's being imported
+by the node's shard. Sent by cluster clients when following an ASK redirection.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleAsking,
+		},
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/config"
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/slots"
+	"github.com/echovault/sugardb/sugardb"
+	"github.com/tidwall/resp"
+)
+
+func setUpServer(port int) (*sugardb.SugarDB, error) {
+	return sugardb.NewSugarDB(
+		sugardb.WithConfig(config.Config{
+			BindAddr:       "localhost",
+			Port:           uint16(port),
+			DataDir:        "",
+			EvictionPolicy: constants.NoEviction,
+		}),
+	)
+}
+
+func Test_Cluster(t *testing.T) {
+	port, err := internal.GetFreePort()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	mockServer, err := setUpServer(port)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	go func() {
+		mockServer.Start()
+	}()
+
+	t.Cleanup(func() {
+		mockServer.ShutDown()
+	})
+
+	conn, err := internal.GetConnection("localhost", port)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	client := resp.NewConn(conn)
+
+	for _, key := range []string{"{user1000}.following", "{user1000}.followers", "foo"} {
+		if err = client.WriteArray([]resp.Value{
+			resp.StringValue("SET"), resp.StringValue(key), resp.StringValue("value"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = client.ReadValue(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		command []string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "1. Get the slot of a key",
+			command: []string{"CLUSTER", "KEYSLOT", "foo"},
+			want:    []string{"12182"},
+		},
+		{
+			name:    "2. Keys with the same hashtag have the same slot",
+			command: []string{"CLUSTER", "KEYSLOT", "{user1000}.following"},
+			want:    []string{"3443"},
+		},
+		{
+			name:    "3. Count the keys in a slot",
+			command: []string{"CLUSTER", "COUNTKEYSINSLOT", "3443"},
+			want:    []string{"2"},
+		},
+		{
+			name:    "4. Get the keys in a slot",
+			command: []string{"CLUSTER", "GETKEYSINSLOT", "3443", "10"},
+			want:    []string{"{user1000}.followers", "{user1000}.following"},
+		},
+		{
+			name:    "5. Return error when the slot is out of range",
+			command: []string{"CLUSTER", "COUNTKEYSINSLOT", "16384"},
+			wantErr: "invalid slot 16384",
+		},
+		{
+			name:    "6. Return error for slot commands when sharding is disabled",
+			command: []string{"CLUSTER", "SHARDS"},
+			wantErr: slots.ErrShardingDisabled.Error(),
+		},
+		{
+			name:    "7. Return error when adding slots with sharding disabled",
+			command: []string{"CLUSTER", "ADDSLOTSRANGE", "0", "100"},
+			wantErr: slots.ErrShardingDisabled.Error(),
+		},
+		{
+			name:    "8. Return error when setting the state of a slot with an invalid state",
+			command: []string{"CLUSTER", "SETSLOT", "100", "MOVING", "shard-1"},
+			wantErr: "invalid slot state MOVING",
+		},
+		{
+			name:    "9. ASKING returns OK",
+			command: []string{"ASKING"},
+			want:    []string{"OK"},
+		},
+		{
+			name:    "10. CLUSTER INFO reports that cluster mode is disabled in standalone mode",
+			command: []string{"CLUSTER", "INFO"},
+			want:    []string{"cluster_enabled:0\r\n"},
+		},
+		{
+			name:    "11. Return error for CLUSTER NODES in standalone mode",
+			command: []string{"CLUSTER", "NODES"},
+			wantErr: "this instance has cluster mode disabled",
+		},
+		{
+			name:    "12. Return error for CLUSTER FORGET in standalone mode",
+			command: []string{"CLUSTER", "FORGET", "node-1"},
+			wantErr: "this instance has cluster mode disabled",
+		},
+		{
+			name:    "13. Return error for CLUSTER TRANSFER-LEADERSHIP in standalone mode",
+			command: []string{"CLUSTER", "TRANSFER-LEADERSHIP"},
+			wantErr: "this instance has cluster mode disabled",
+		},
+		{
+			name:    "14. Return error when CLUSTER ADD-NONVOTER is missing the raft address",
+			command: []string{"CLUSTER", "ADD-NONVOTER", "node-1"},
+			wantErr: constants.WrongArgsResponse,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command := make([]resp.Value, len(test.command))
+			for i, c := range test.command {
+				command[i] = resp.StringValue(c)
+			}
+			if err = client.WriteArray(command); err != nil {
+				t.Error(err)
+				return
+			}
+
+			res, _, err := client.ReadValue()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if test.wantErr != "" {
+				if !strings.Contains(res.Error().Error(), test.wantErr) {
+					t.Errorf("expected error to contain \"%s\", got \"%s\"", test.wantErr, res.Error())
+				}
+				return
+			}
+
+			var got []string
+			if res.Type() == resp.Array {
+				for _, item := range res.Array() {
+					got = append(got, item.String())
+				}
+				slices.Sort(got)
+			} else {
+				got = []string{res.String()}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("expected response %v, got %v", test.want, got)
+			}
+		})
+	}
+}
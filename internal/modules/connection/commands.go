@@ -15,11 +15,13 @@
 package connection
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal/modules/acl"
 	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
@@ -147,6 +149,30 @@ func handleSelect(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte(constants.OkResponse), nil
 }
 
+func handleReadConsistency(params internal.HandlerFuncParams) ([]byte, error) {
+	switch len(params.Command) {
+	default:
+		return nil, errors.New(constants.WrongArgsResponse)
+	case 1:
+		level := params.GetConnectionInfo(params.Connection).ReadConsistency
+		if level == "" {
+			level = constants.ReadConsistencyStale
+		}
+		return []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(level), level)), nil
+	case 2:
+		level := strings.ToLower(params.Command[1])
+		if !slices.Contains([]string{
+			constants.ReadConsistencyStale,
+			constants.ReadConsistencyLeader,
+			constants.ReadConsistencyLinearizable,
+		}, level) {
+			return nil, errors.New("read consistency must be 'stale', 'leader' or 'linearizable'")
+		}
+		params.SetReadConsistency(params.Connection, level)
+		return []byte(constants.OkResponse), nil
+	}
+}
+
 func handleSwapDB(params internal.HandlerFuncParams) ([]byte, error) {
 	if len(params.Command) != 3 {
 		return nil, errors.New(constants.WrongArgsResponse)
@@ -171,6 +197,75 @@ func handleSwapDB(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte(constants.OkResponse), nil
 }
 
+func handleWait(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 3 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	numReplicas, err := strconv.Atoi(params.Command[1])
+	if err != nil || numReplicas < 0 {
+		return nil, errors.New("numreplicas must be an integer >= 0")
+	}
+
+	ctx, cancel, err := waitContext(params.Context, params.Command[2])
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	count, err := params.WaitForReplicas(ctx, params.Connection, numReplicas)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf(":%d\r\n", count)), nil
+}
+
+func handleWaitAOF(params internal.HandlerFuncParams) ([]byte, error) {
+	if len(params.Command) != 4 {
+		return nil, errors.New(constants.WrongArgsResponse)
+	}
+
+	numLocal, err := strconv.Atoi(params.Command[1])
+	if err != nil || !slices.Contains([]int{0, 1}, numLocal) {
+		return nil, errors.New("numlocal must be either 0 or 1")
+	}
+
+	numReplicas, err := strconv.Atoi(params.Command[2])
+	if err != nil || numReplicas < 0 {
+		return nil, errors.New("numreplicas must be an integer >= 0")
+	}
+
+	ctx, cancel, err := waitContext(params.Context, params.Command[3])
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	local := 0
+	if numLocal > 0 {
+		if err = params.WaitForAOF(ctx, params.Connection); err == nil {
+			local = 1
+		} else if ctx.Err() == nil {
+			return nil, err
+		}
+	} else {
+		// Report whether the write is already durable without waiting for it.
+		expired, cancelExpired := context.WithCancel(ctx)
+		cancelExpired()
+		if params.WaitForAOF(expired, params.Connection) == nil {
+			local = 1
+		}
+	}
+
+	replicas, err := params.WaitForReplicas(ctx, params.Connection, numReplicas)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("*2\r\n:%d\r\n:%d\r\n", local, replicas)), nil
+}
+
 func Commands() []internal.Command {
 	return []internal.Command{
 		{
@@ -259,6 +354,65 @@ This command returns a contextual client report.`,
 			},
 			HandlerFunc: handleSelect,
 		},
+		{
+			Command:    "readconsistency",
+			Module:     constants.ConnectionModule,
+			Categories: []string{constants.ConnectionCategory, constants.FastCategory},
+			Description: `(READCONSISTENCY [stale | leader | linearizable])
+Sets the consistency level of reads made by the current connection in cluster mode.
+"stale" serves reads from the local node, "leader" forwards reads to the cluster leader and "linearizable"
+only serves reads once the local node has applied every write committed by the leader before the read.
+If the level is not provided, the current level is returned. Reads in standalone mode are not affected.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleReadConsistency,
+		},
+		{
+			Command:    "wait",
+			Module:     constants.ConnectionModule,
+			Categories: []string{constants.ConnectionCategory, constants.SlowCategory},
+			Description: `(WAIT numreplicas timeout)
+Blocks until at least numreplicas replicas have applied the latest write made by the current connection,
+or until the timeout in milliseconds is reached. A timeout of 0 blocks forever.
+Returns the number of replicas that applied the write. In standalone mode, the replicas are the nodes started with REPLICAOF.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleWait,
+		},
+		{
+			Command:    "waitaof",
+			Module:     constants.ConnectionModule,
+			Categories: []string{constants.ConnectionCategory, constants.SlowCategory},
+			Description: `(WAITAOF numlocal numreplicas timeout)
+Blocks until the latest write made by the current connection has been fsynced to the local append only log
+(when numlocal is 1) and applied by at least numreplicas replicas, or until the timeout in milliseconds is reached.
+A timeout of 0 blocks forever. Returns an array with the number of local nodes (0 or 1) and the number of replicas
+that acknowledged the write.`,
+			Sync: false,
+			Type: "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels:  make([]string, 0),
+					ReadKeys:  make([]string, 0),
+					WriteKeys: make([]string, 0),
+				}, nil
+			},
+			HandlerFunc: handleWaitAOF,
+		},
 		{
 			Command: "swapdb",
 			Module:  constants.ConnectionModule,
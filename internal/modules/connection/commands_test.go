@@ -715,6 +715,82 @@ func Test_Connection(t *testing.T) {
 		}
 	})
 
+	t.Run("Test_HandleReadConsistency", func(t *testing.T) {
+		t.Parallel()
+		conn, err := internal.GetConnection("localhost", port)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		client := resp.NewConn(conn)
+
+		tests := []struct {
+			command     []resp.Value
+			expected    string
+			expectedErr error
+		}{
+			{
+				command:  []resp.Value{resp.StringValue("AUTH"), resp.StringValue("password1")},
+				expected: "OK",
+			},
+			{
+				command:  []resp.Value{resp.StringValue("READCONSISTENCY")},
+				expected: constants.ReadConsistencyStale,
+			},
+			{
+				command:  []resp.Value{resp.StringValue("READCONSISTENCY"), resp.StringValue("LINEARIZABLE")},
+				expected: "OK",
+			},
+			{
+				command:  []resp.Value{resp.StringValue("READCONSISTENCY")},
+				expected: constants.ReadConsistencyLinearizable,
+			},
+			{
+				// Reads in standalone mode are not affected by the read consistency.
+				command:  []resp.Value{resp.StringValue("GET"), resp.StringValue("ReadConsistencyKey")},
+				expected: "",
+			},
+			{
+				command:     []resp.Value{resp.StringValue("READCONSISTENCY"), resp.StringValue("eventual")},
+				expectedErr: errors.New("read consistency must be 'stale', 'leader' or 'linearizable'"),
+			},
+			{
+				command: []resp.Value{
+					resp.StringValue("READCONSISTENCY"),
+					resp.StringValue("leader"),
+					resp.StringValue("stale"),
+				},
+				expectedErr: errors.New(constants.WrongArgsResponse),
+			},
+		}
+
+		for _, test := range tests {
+			if err = client.WriteArray(test.command); err != nil {
+				t.Error(err)
+				return
+			}
+
+			res, _, err := client.ReadValue()
+			if err != nil {
+				t.Error(err)
+			}
+
+			if test.expectedErr != nil {
+				if !strings.Contains(res.Error().Error(), test.expectedErr.Error()) {
+					t.Errorf("expected error \"%s\", got \"%s\"", test.expectedErr.Error(), res.Error().Error())
+				}
+				continue
+			}
+
+			if res.String() != test.expected {
+				t.Errorf("expected response \"%s\", got \"%s\"", test.expected, res.String())
+			}
+		}
+	})
+
 	t.Run("Test_HandleSwapDBs", func(t *testing.T) {
 		t.Parallel()
 
@@ -997,4 +1073,167 @@ func Test_Connection(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("Test_HandleWaitAOF", func(t *testing.T) {
+		t.Parallel()
+
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mockServer, err := sugardb.NewSugarDB(
+			sugardb.WithConfig(config.Config{
+				BindAddr:        "localhost",
+				Port:            uint16(port),
+				DataDir:         t.TempDir(),
+				EvictionPolicy:  constants.NoEviction,
+				AOFSyncStrategy: "everysec",
+			}),
+		)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			mockServer.Start()
+		}()
+		t.Cleanup(func() {
+			mockServer.ShutDown()
+		})
+
+		// Server without a data directory, the append only log is disabled.
+		noAOFPort, err := internal.GetFreePort()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		noAOFServer, err := setUpServer(noAOFPort, false, "")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			noAOFServer.Start()
+		}()
+		t.Cleanup(func() {
+			noAOFServer.ShutDown()
+		})
+
+		tests := []struct {
+			name    string
+			port    int
+			preset  []string
+			command []string
+			want    string
+			wantErr error
+		}{
+			{
+				name:    "1. WAITAOF blocks until the connection's last write is fsynced",
+				port:    port,
+				preset:  []string{"SET", "WaitAOFKey1", "value1"},
+				command: []string{"WAITAOF", "1", "0", "5000"},
+				want:    "[1 0]",
+			},
+			{
+				name:    "2. WAITAOF without numlocal reports the local status without waiting",
+				port:    port,
+				command: []string{"WAITAOF", "0", "0", "0"},
+				want:    "[1 0]",
+			},
+			{
+				name:    "3. WAIT returns 0 in standalone mode",
+				port:    port,
+				preset:  []string{"SET", "WaitAOFKey3", "value3"},
+				command: []string{"WAIT", "1", "100"},
+				want:    "0",
+			},
+			{
+				name:    "4. WAITAOF returns error when numlocal is not 0 or 1",
+				port:    port,
+				command: []string{"WAITAOF", "2", "0", "0"},
+				wantErr: errors.New("numlocal must be either 0 or 1"),
+			},
+			{
+				name:    "5. WAITAOF returns error when timeout is negative",
+				port:    port,
+				command: []string{"WAITAOF", "1", "0", "-1"},
+				wantErr: errors.New("timeout must be >= 0"),
+			},
+			{
+				name:    "6. WAIT returns error when numreplicas is not an integer",
+				port:    port,
+				command: []string{"WAIT", "one", "0"},
+				wantErr: errors.New("numreplicas must be an integer >= 0"),
+			},
+			{
+				name:    "7. WAITAOF returns error when the append only log is disabled",
+				port:    noAOFPort,
+				preset:  []string{"SET", "WaitAOFKey7", "value7"},
+				command: []string{"WAITAOF", "1", "0", "100"},
+				wantErr: errors.New("WAITAOF cannot be used when numlocal is set"),
+			},
+			{
+				name:    "8. Command too short",
+				port:    port,
+				command: []string{"WAITAOF", "1", "0"},
+				wantErr: errors.New(constants.WrongArgsResponse),
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				conn, err := internal.GetConnection("localhost", test.port)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer func() {
+					_ = conn.Close()
+				}()
+				client := resp.NewConn(conn)
+
+				if test.preset != nil {
+					command := make([]resp.Value, len(test.preset))
+					for i, c := range test.preset {
+						command[i] = resp.StringValue(c)
+					}
+					if err = client.WriteArray(command); err != nil {
+						t.Error(err)
+						return
+					}
+					if _, _, err = client.ReadValue(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+
+				command := make([]resp.Value, len(test.command))
+				for i, c := range test.command {
+					command[i] = resp.StringValue(c)
+				}
+				if err = client.WriteArray(command); err != nil {
+					t.Error(err)
+					return
+				}
+				res, _, err := client.ReadValue()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if test.wantErr != nil {
+					if !strings.Contains(res.Error().Error(), test.wantErr.Error()) {
+						t.Errorf("expected error response to contain \"%s\", got \"%s\"",
+							test.wantErr.Error(), res.Error().Error())
+					}
+					return
+				}
+
+				if res.String() != test.want {
+					t.Errorf("expected response %s, got %s", test.want, res.String())
+				}
+			})
+		}
+	})
 }
@@ -1,10 +1,14 @@
 package connection
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type helloOptions struct {
@@ -64,3 +68,21 @@ func BuildHelloResponse(serverInfo internal.ServerInfo, connectionInfo internal.
 	}
 	return res
 }
+
+// waitContext returns a context that is cancelled after the provided timeout in milliseconds.
+// A timeout of 0 means that the returned context is never cancelled by a timeout.
+func waitContext(ctx context.Context, timeout string) (context.Context, context.CancelFunc, error) {
+	ms, err := strconv.Atoi(timeout)
+	if err != nil {
+		return nil, nil, errors.New("timeout must be an integer")
+	}
+	if ms < 0 {
+		return nil, nil, errors.New("timeout must be >= 0")
+	}
+	if ms == 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+	return ctx, cancel, nil
+}
@@ -86,6 +86,32 @@ func handleSet(params internal.HandlerFuncParams) ([]byte, error) {
 	return res, nil
 }
 
+// rewriteSet converts relative EX and PX expiry options into an absolute PXAT option.
+func rewriteSet(params internal.HandlerFuncParams) (internal.RewriteFuncResult, error) {
+	if _, err := setKeyFunc(params.Command); err != nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	options, err := getSetCommandOptions(params.GetClock(), params.Command[3:], SetOptions{})
+	if err != nil || options.expireAt == nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	cmd := append([]string{}, params.Command[:3]...)
+	for i := 3; i < len(params.Command); i++ {
+		switch strings.ToLower(params.Command[i]) {
+		case "ex", "px", "exat", "pxat":
+			// Skip the expiry option and its value, the absolute expiry time is appended below.
+			i++
+		default:
+			cmd = append(cmd, params.Command[i])
+		}
+	}
+	cmd = append(cmd, "PXAT", strconv.FormatInt(options.expireAt.(time.Time).UnixMilli(), 10))
+
+	return internal.RewriteFuncResult{Command: cmd}, nil
+}
+
 func handleMSet(params internal.HandlerFuncParams) ([]byte, error) {
 	_, err := msetKeyFunc(params.Command)
 	if err != nil {
@@ -122,6 +148,10 @@ func handleGet(params internal.HandlerFuncParams) ([]byte, error) {
 	}
 
 	value := params.GetValues(params.Context, []string{key})[key]
+	// The key expired after the existence check.
+	if value == nil {
+		return []byte("$-1\r\n"), nil
+	}
 
 	return []byte(fmt.Sprintf("+%v\r\n", value)), nil
 }
@@ -174,6 +204,26 @@ func handleDel(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte(fmt.Sprintf(":%d\r\n", count)), nil
 }
 
+func handleUnlink(params internal.HandlerFuncParams) ([]byte, error) {
+	keys, err := unlinkKeyFunc(params.Command)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for key, exists := range params.KeysExist(params.Context, keys.WriteKeys) {
+		if !exists {
+			continue
+		}
+		err = params.UnlinkKey(params.Context, key)
+		if err != nil {
+			log.Printf("could not unlink key %s due to error: %+v\n", key, err)
+			continue
+		}
+		count += 1
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", count)), nil
+}
+
 func handlePersist(params internal.HandlerFuncParams) ([]byte, error) {
 	keys, err := persistKeyFunc(params.Command)
 	if err != nil {
@@ -324,6 +374,30 @@ func handleExpire(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte(":1\r\n"), nil
 }
 
+// rewriteExpire converts EXPIRE and PEXPIRE into PEXPIREAT with the absolute expiry time in milliseconds.
+func rewriteExpire(params internal.HandlerFuncParams) (internal.RewriteFuncResult, error) {
+	if _, err := expireKeyFunc(params.Command); err != nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	n, err := strconv.ParseInt(params.Command[2], 10, 64)
+	if err != nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	var expireAt time.Time
+	if strings.ToLower(params.Command[0]) == "pexpire" {
+		expireAt = params.GetClock().Now().Add(time.Duration(n) * time.Millisecond)
+	} else {
+		expireAt = params.GetClock().Now().Add(time.Duration(n) * time.Second)
+	}
+
+	cmd := []string{"PEXPIREAT", params.Command[1], strconv.FormatInt(expireAt.UnixMilli(), 10)}
+	cmd = append(cmd, params.Command[3:]...)
+
+	return internal.RewriteFuncResult{Command: cmd}, nil
+}
+
 func handleExpireAt(params internal.HandlerFuncParams) ([]byte, error) {
 	keys, err := expireKeyFunc(params.Command)
 	if err != nil {
@@ -711,17 +785,28 @@ func handleExists(params internal.HandlerFuncParams) ([]byte, error) {
 }
 
 func handleFlush(params internal.HandlerFuncParams) ([]byte, error) {
-	if len(params.Command) != 1 {
+	if len(params.Command) > 2 {
 		return nil, errors.New(constants.WrongArgsResponse)
 	}
 
+	flush := params.Flush
+	if len(params.Command) == 2 {
+		switch strings.ToUpper(params.Command[1]) {
+		case "ASYNC":
+			flush = params.FlushAsync
+		case "SYNC":
+		default:
+			return nil, fmt.Errorf("unknown option %s", strings.ToUpper(params.Command[1]))
+		}
+	}
+
 	if strings.EqualFold(params.Command[0], "flushall") {
-		params.Flush(-1)
+		flush(-1)
 		return []byte(constants.OkResponse), nil
 	}
 
 	database := params.Context.Value("Database").(int)
-	params.Flush(database)
+	flush(database)
 	return []byte(constants.OkResponse), nil
 }
 
@@ -826,6 +911,32 @@ func handleGetex(params internal.HandlerFuncParams) ([]byte, error) {
 
 }
 
+// rewriteGetex converts relative EX and PX expiry options into an absolute PXAT option.
+func rewriteGetex(params internal.HandlerFuncParams) (internal.RewriteFuncResult, error) {
+	if len(params.Command) != 4 {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	n, err := strconv.ParseInt(params.Command[3], 10, 64)
+	if err != nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	var expireAt time.Time
+	switch strings.ToUpper(params.Command[2]) {
+	case "EX":
+		expireAt = params.GetClock().Now().Add(time.Duration(n) * time.Second)
+	case "PX":
+		expireAt = params.GetClock().Now().Add(time.Duration(n) * time.Millisecond)
+	default:
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	return internal.RewriteFuncResult{
+		Command: []string{params.Command[0], params.Command[1], "PXAT", strconv.FormatInt(expireAt.UnixMilli(), 10)},
+	}, nil
+}
+
 func handleType(params internal.HandlerFuncParams) ([]byte, error) {
 	keys, err := getKeyFunc(params.Command)
 	if err != nil {
@@ -913,6 +1024,23 @@ func handleObjIdleTime(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte(fmt.Sprintf("+%v\r\n", idletime)), nil
 }
 
+func handleObjectEncoding(params internal.HandlerFuncParams) ([]byte, error) {
+	key, err := objectEncodingKeyFunc(params.Command)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding, ok, err := params.GetObjectEncoding(params.Context, key.ReadKeys[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return []byte("$-1\r\n"), nil
+	}
+
+	return []byte(fmt.Sprintf("+%s\r\n", encoding)), nil
+}
+
 func handleCopy(params internal.HandlerFuncParams) ([]byte, error) {
 	keys, err := copyKeyFunc(params.Command)
 	if err != nil {
@@ -1022,6 +1150,7 @@ PXAT - Expire at the exat time in unix milliseconds (positive integer).`,
 			Type:              "BUILT_IN",
 			KeyExtractionFunc: setKeyFunc,
 			HandlerFunc:       handleSet,
+			RewriteFunc:       rewriteSet,
 		},
 		{
 			Command:           "mset",
@@ -1063,6 +1192,17 @@ PXAT - Expire at the exat time in unix milliseconds (positive integer).`,
 			KeyExtractionFunc: delKeyFunc,
 			HandlerFunc:       handleDel,
 		},
+		{
+			Command:    "unlink",
+			Module:     constants.GenericModule,
+			Categories: []string{constants.KeyspaceCategory, constants.WriteCategory, constants.FastCategory},
+			Description: `(UNLINK key [key ...]) Removes one or more keys from the store like DEL.
+The keys are removed from the keyspace right away, and large values are released on a background goroutine.`,
+			Sync:              true,
+			Type:              "BUILT_IN",
+			KeyExtractionFunc: unlinkKeyFunc,
+			HandlerFunc:       handleUnlink,
+		},
 		{
 			Command:    "persist",
 			Module:     constants.GenericModule,
@@ -1136,6 +1276,7 @@ LT - Only set the expiry time if the new expiry time is less than the current on
 			Type:              "BUILT_IN",
 			KeyExtractionFunc: expireKeyFunc,
 			HandlerFunc:       handleExpire,
+			RewriteFunc:       rewriteExpire,
 		},
 		{
 			Command:    "pexpire",
@@ -1151,6 +1292,7 @@ LT - Only set the expiry time if the new expiry time is less than the current on
 			Type:              "BUILT_IN",
 			KeyExtractionFunc: expireKeyFunc,
 			HandlerFunc:       handleExpire,
+			RewriteFunc:       rewriteExpire,
 		},
 		{
 			Command:    "expireat",
@@ -1268,9 +1410,10 @@ Renames key to newkey. If newkey already exists, it is overwritten. If key does
 				constants.SlowCategory,
 				constants.DangerousCategory,
 			},
-			Description: `(FLUSHALL) Delete all the keys in all the existing databases. This command is always synchronous.`,
-			Sync:        true,
-			Type:        "BUILT_IN",
+			Description: `(FLUSHALL [ASYNC | SYNC]) Delete all the keys in all the existing databases.
+With ASYNC, the keys are detached from the databases right away and released on a background goroutine.`,
+			Sync: true,
+			Type: "BUILT_IN",
 			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
 				return internal.KeyExtractionFuncResult{
 					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
@@ -1287,8 +1430,9 @@ Renames key to newkey. If newkey already exists, it is overwritten. If key does
 				constants.SlowCategory,
 				constants.DangerousCategory,
 			},
-			Description: `(FLUSHDB)
-Delete all the keys in the currently selected database. This command is always synchronous.`,
+			Description: `(FLUSHDB [ASYNC | SYNC])
+Delete all the keys in the currently selected database.
+With ASYNC, the keys are detached from the database right away and released on a background goroutine.`,
 			Sync: true,
 			Type: "BUILT_IN",
 			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
@@ -1337,6 +1481,7 @@ Delete all the keys in the currently selected database. This command is always s
 			Type:              "BUILT_IN",
 			KeyExtractionFunc: getExKeyFunc,
 			HandlerFunc:       handleGetex,
+			RewriteFunc:       rewriteGetex,
 		},
 		{
 			Command:           "type",
@@ -1381,6 +1526,31 @@ The command is only available when the maxmemory-policy configuration directive
 			KeyExtractionFunc: objIdleTimeKeyFunc,
 			HandlerFunc:       handleObjIdleTime,
 		},
+		{
+			Command:     "object",
+			Module:      constants.GenericModule,
+			Categories:  []string{},
+			Description: "Object commands",
+			Type:        "BUILT_IN",
+			KeyExtractionFunc: func(cmd []string) (internal.KeyExtractionFuncResult, error) {
+				return internal.KeyExtractionFuncResult{
+					Channels: make([]string, 0), ReadKeys: make([]string, 0), WriteKeys: make([]string, 0),
+				}, nil
+			},
+			SubCommands: []internal.SubCommand{
+				{
+					Command:    "encoding",
+					Module:     constants.GenericModule,
+					Categories: []string{constants.KeyspaceCategory, constants.ReadCategory, constants.SlowCategory},
+					Description: `(OBJECT ENCODING key) Return the internal encoding of the value stored at <key>.
+Small hashes, sets, sorted sets and lists are held in compact encodings, which are converted to the full structure
+once they grow past the configured size thresholds.`,
+					Sync:              false,
+					KeyExtractionFunc: objectEncodingKeyFunc,
+					HandlerFunc:       handleObjectEncoding,
+				},
+			},
+		},
 		{
 			Command:    "copy",
 			Module:     constants.GenericModule,
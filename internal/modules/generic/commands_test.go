@@ -902,6 +902,118 @@ func Test_Generic(t *testing.T) {
 		}
 	})
 
+	t.Run("Test_HandleUNLINK", func(t *testing.T) {
+		t.Parallel()
+		conn, err := internal.GetConnection("localhost", port)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		client := resp.NewConn(conn)
+
+		tests := []struct {
+			name             string
+			command          []string
+			presetValues     map[string]string
+			expectedResponse int
+			expectToExist    map[string]bool
+			expectedErr      error
+		}{
+			{
+				name:    "1. Unlink multiple keys",
+				command: []string{"UNLINK", "UnlinkKey1", "UnlinkKey2", "UnlinkKey3", "UnlinkKey4", "UnlinkKey5"},
+				presetValues: map[string]string{
+					"UnlinkKey1": "value1",
+					"UnlinkKey2": "value2",
+					"UnlinkKey3": "value3",
+					"UnlinkKey4": "value4",
+				},
+				expectedResponse: 4,
+				expectToExist: map[string]bool{
+					"UnlinkKey1": false,
+					"UnlinkKey2": false,
+					"UnlinkKey3": false,
+					"UnlinkKey4": false,
+					"UnlinkKey5": false,
+				},
+				expectedErr: nil,
+			},
+			{
+				name:             "2. Return error when UNLINK is called with no keys",
+				command:          []string{"UNLINK"},
+				presetValues:     nil,
+				expectedResponse: 0,
+				expectToExist:    nil,
+				expectedErr:      errors.New(constants.WrongArgsResponse),
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				if test.presetValues != nil {
+					for k, v := range test.presetValues {
+						if err = client.WriteArray([]resp.Value{
+							resp.StringValue("SET"),
+							resp.StringValue(k),
+							resp.StringValue(v),
+						}); err != nil {
+							t.Error(err)
+						}
+						res, _, err := client.ReadValue()
+						if err != nil {
+							t.Error(err)
+						}
+						if !strings.EqualFold(res.String(), "ok") {
+							t.Errorf("expected preset response to be \"OK\", got %s", res.String())
+						}
+					}
+				}
+
+				command := make([]resp.Value, len(test.command))
+				for i, c := range test.command {
+					command[i] = resp.StringValue(c)
+				}
+
+				if err = client.WriteArray(command); err != nil {
+					t.Error(err)
+				}
+
+				res, _, err := client.ReadValue()
+				if err != nil {
+					t.Error(err)
+				}
+
+				if test.expectedErr != nil {
+					if !strings.Contains(res.Error().Error(), test.expectedErr.Error()) {
+						t.Errorf("expected error \"%s\", got \"%s\"", test.expectedErr.Error(), res.Error().Error())
+					}
+					return
+				}
+
+				if res.Integer() != test.expectedResponse {
+					t.Errorf("expected response %d, got %d", test.expectedResponse, res.Integer())
+				}
+
+				for key, expected := range test.expectToExist {
+					if err = client.WriteArray([]resp.Value{resp.StringValue("GET"), resp.StringValue(key)}); err != nil {
+						t.Error(err)
+					}
+					res, _, err = client.ReadValue()
+					if err != nil {
+						t.Error(err)
+					}
+					exists := !res.IsNull()
+					if exists != expected {
+						t.Errorf("expected existence of key %s to be %v, got %v", key, expected, exists)
+					}
+				}
+			})
+		}
+	})
+
 	t.Run("Test_HandlePERSIST", func(t *testing.T) {
 		t.Parallel()
 		conn, err := internal.GetConnection("localhost", port)
@@ -2978,6 +3090,57 @@ func Test_Generic(t *testing.T) {
 				}
 			}
 		}
+
+		// Set the values again and send FLUSHALL ASYNC.
+		// The keys should be removed from all the databases right away.
+		for i := 0; i < noOfDBs; i++ {
+			_ = mockServer.SelectDB(i)
+			for k := 1; k <= 3; k++ {
+				_, _, _ = mockServer.Set(fmt.Sprintf("key%d", k), fmt.Sprintf("value%d", k), sugardb.SETOptions{})
+			}
+		}
+		if err = client.WriteArray([]resp.Value{resp.StringValue("FLUSHALL"), resp.StringValue("ASYNC")}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, _, err = client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.EqualFold(res.String(), "ok") {
+			t.Errorf("expected OK response, got \"%s\"", res.String())
+			return
+		}
+		for i := 0; i < noOfDBs; i++ {
+			_ = mockServer.SelectDB(i)
+			for k := 1; k <= 3; k++ {
+				key := fmt.Sprintf("key%d", k)
+				val, err := mockServer.Get(key)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if val != "" {
+					t.Errorf("expected empty string at key %s, got \"%s\"", key, val)
+					return
+				}
+			}
+		}
+
+		// An unknown option is rejected.
+		if err = client.WriteArray([]resp.Value{resp.StringValue("FLUSHDB"), resp.StringValue("LATER")}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, _, err = client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if res.Error() == nil || !strings.Contains(res.Error().Error(), "unknown option LATER") {
+			t.Errorf("expected unknown option error, got \"%s\"", res.String())
+		}
 	})
 
 	t.Run("Test_HandleRANDOMKEY", func(t *testing.T) {
@@ -3592,6 +3755,120 @@ func Test_Generic(t *testing.T) {
 		}
 	})
 
+	t.Run("Test_HandleOBJECTENCODING", func(t *testing.T) {
+		t.Parallel()
+		conn, err := internal.GetConnection("localhost", port)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		client := resp.NewConn(conn)
+
+		tests := []struct {
+			name             string
+			presetCommand    []string
+			command          []string
+			expectedResponse string
+			expectedError    error
+		}{
+			{
+				name:             "1. Return int for an integer value",
+				presetCommand:    []string{"SET", "ObjectEncodingKey1", "100"},
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey1"},
+				expectedResponse: "int",
+			},
+			{
+				name:             "2. Return raw for a string value",
+				presetCommand:    []string{"SET", "ObjectEncodingKey2", "value"},
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey2"},
+				expectedResponse: "raw",
+			},
+			{
+				// The compact encodings are disabled, as the size thresholds of the test server are 0.
+				name:             "3. Return hashtable for a hash",
+				presetCommand:    []string{"HSET", "ObjectEncodingKey3", "field1", "value1"},
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey3"},
+				expectedResponse: "hashtable",
+			},
+			{
+				name:             "4. Return hashtable for a set",
+				presetCommand:    []string{"SADD", "ObjectEncodingKey4", "1", "2", "3"},
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey4"},
+				expectedResponse: "hashtable",
+			},
+			{
+				name:             "5. Return hashtable for a sorted set",
+				presetCommand:    []string{"ZADD", "ObjectEncodingKey5", "1", "member1"},
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey5"},
+				expectedResponse: "hashtable",
+			},
+			{
+				name:             "6. Return array for a list",
+				presetCommand:    []string{"LPUSH", "ObjectEncodingKey6", "value1", "value2"},
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey6"},
+				expectedResponse: "array",
+			},
+			{
+				name:             "7. Return nil when the key does not exist",
+				command:          []string{"OBJECT", "ENCODING", "ObjectEncodingKey7"},
+				expectedResponse: "",
+			},
+			{
+				name:          "8. Command too short",
+				command:       []string{"OBJECT", "ENCODING"},
+				expectedError: errors.New(constants.WrongArgsResponse),
+			},
+			{
+				name:          "9. Command too long",
+				command:       []string{"OBJECT", "ENCODING", "ObjectEncodingKey1", "ObjectEncodingKey2"},
+				expectedError: errors.New(constants.WrongArgsResponse),
+			},
+		}
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				if test.presetCommand != nil {
+					command := make([]resp.Value, len(test.presetCommand))
+					for i, c := range test.presetCommand {
+						command[i] = resp.StringValue(c)
+					}
+					if err = client.WriteArray(command); err != nil {
+						t.Error(err)
+					}
+					if _, _, err = client.ReadValue(); err != nil {
+						t.Error(err)
+					}
+				}
+
+				command := make([]resp.Value, len(test.command))
+				for i, c := range test.command {
+					command[i] = resp.StringValue(c)
+				}
+				if err = client.WriteArray(command); err != nil {
+					t.Error(err)
+				}
+				res, _, err := client.ReadValue()
+				if err != nil {
+					t.Error(err)
+				}
+
+				if test.expectedError != nil {
+					if !strings.Contains(res.Error().Error(), test.expectedError.Error()) {
+						t.Errorf("expected error \"%s\", got \"%s\"", test.expectedError.Error(), res.Error())
+					}
+					return
+				}
+
+				if res.String() != test.expectedResponse {
+					t.Errorf("expected response \"%s\", got \"%s\"", test.expectedResponse, res.String())
+				}
+			})
+		}
+	})
+
 	t.Run("Test_HandleCOPY", func(t *testing.T) {
 		t.Parallel()
 		conn, err := internal.GetConnection("localhost", port)
@@ -3913,7 +4190,7 @@ func Test_LFU_Generic(t *testing.T) {
 			DataDir:          "",
 			EvictionPolicy:   constants.AllKeysLFU,
 			EvictionInterval: duration,
-			MaxMemory:        550,
+			MaxMemory:        4000000,
 		}),
 	)
 	if err != nil {
@@ -4061,8 +4338,9 @@ func Test_LFU_Generic(t *testing.T) {
 							t.Error(err)
 						}
 
-						if res.Integer() != 3 {
-							t.Errorf("OBJECTFREQ expected frequency of 3, got %v", res.Integer())
+						// New keys start at 5, and a log factor of 0 increments the counter on every access.
+						if res.Integer() != 7 {
+							t.Errorf("OBJECTFREQ expected frequency of 7, got %v", res.Integer())
 						}
 
 					}
@@ -4094,7 +4372,7 @@ func Test_LRU_Generic(t *testing.T) {
 			DataDir:          "",
 			EvictionPolicy:   constants.AllKeysLRU,
 			EvictionInterval: duration,
-			MaxMemory:        550,
+			MaxMemory:        4000000,
 		}),
 	)
 	if err != nil {
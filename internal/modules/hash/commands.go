@@ -79,7 +79,7 @@ func handleHSET(params internal.HandlerFuncParams) ([]byte, error) {
 		// Handle HSET
 		for field, value := range hash {
 			if entries[field].Value == nil {
-				entries[field] = HashValue{Value: value}
+				entries[field] = value
 			}
 		}
 		count = len(entries)
@@ -621,9 +621,14 @@ func handleHEXPIRE(params internal.HandlerFuncParams) ([]byte, error) {
 	key := keys.WriteKeys[0]
 
 	// HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field
+	// HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field
+	absolute := strings.EqualFold(params.Command[0], "hpexpireat")
 	cmdargs := keys.WriteKeys[1:]
 	seconds, err := strconv.ParseInt(cmdargs[0], 10, 64)
 	if err != nil {
+		if absolute {
+			return nil, errors.New(fmt.Sprintf("unix-time-milliseconds must be integer, was provided %q", cmdargs[0]))
+		}
 		return nil, errors.New(fmt.Sprintf("seconds must be integer, was provided %q", cmdargs[0]))
 	}
 
@@ -646,6 +651,9 @@ func handleHEXPIRE(params internal.HandlerFuncParams) ([]byte, error) {
 	fields := cmdargs[fieldsIdx+2 : endIdx]
 
 	expireAt := params.GetClock().Now().Add(time.Duration(seconds) * time.Second)
+	if absolute {
+		expireAt = time.UnixMilli(seconds)
+	}
 
 	// build out response
 	resp := "*" + fmt.Sprintf("%v", len(fields)) + "\r\n"
@@ -665,7 +673,7 @@ func handleHEXPIRE(params internal.HandlerFuncParams) ([]byte, error) {
 	}
 
 	// handle expire time of 0 seconds
-	if seconds == 0 {
+	if !absolute && seconds == 0 {
 		for i := numfields; i > 0; i-- {
 			resp = resp + ":2\r\n"
 		}
@@ -780,6 +788,25 @@ func handleHEXPIRE(params internal.HandlerFuncParams) ([]byte, error) {
 	return []byte(resp), nil
 }
 
+// rewriteHEXPIRE converts HEXPIRE into HPEXPIREAT with the absolute expiry time in milliseconds.
+func rewriteHEXPIRE(params internal.HandlerFuncParams) (internal.RewriteFuncResult, error) {
+	if _, err := hexpireKeyFunc(params.Command); err != nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	seconds, err := strconv.ParseInt(params.Command[2], 10, 64)
+	if err != nil || seconds == 0 {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	expireAt := params.GetClock().Now().Add(time.Duration(seconds) * time.Second)
+
+	cmd := []string{"HPEXPIREAT", params.Command[1], strconv.FormatInt(expireAt.UnixMilli(), 10)}
+	cmd = append(cmd, params.Command[3:]...)
+
+	return internal.RewriteFuncResult{Command: cmd}, nil
+}
+
 func handleHTTL(params internal.HandlerFuncParams) ([]byte, error) {
 	keys, err := httlKeyFunc(params.Command)
 	if err != nil {
@@ -984,6 +1011,16 @@ Return the string length of the values stored at the specified fields. 0 if the
 			Sync:              true,
 			KeyExtractionFunc: hexpireKeyFunc,
 			HandlerFunc:       handleHEXPIRE,
+			RewriteFunc:       rewriteHEXPIRE,
+		},
+		{
+			Command:           "hpexpireat",
+			Module:            constants.HashModule,
+			Categories:        []string{constants.HashCategory, constants.WriteCategory, constants.FastCategory},
+			Description:       `(HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]) Sets the expiration of a field in a hash to the exact unix time in milliseconds.`,
+			Sync:              true,
+			KeyExtractionFunc: hexpireKeyFunc,
+			HandlerFunc:       handleHEXPIRE,
 		},
 		{
 			Command:           "httl",
@@ -2156,6 +2156,36 @@ func Test_Hash(t *testing.T) {
 				command:       []string{"HEXPIRE", "HexpireKey16", "100", "FIELDS", "one", "HexpireK16Field1"},
 				expectedError: errors.New("Error numberfields must be integer, was provided \"one\""),
 			},
+			{
+				name: "17. Set expiration with absolute unix time in milliseconds.",
+				key:  "HexpireKey17",
+				presetValue: hash.Hash{
+					"HexpireK17Field1": hash.HashValue{
+						Value: "default1",
+					},
+					"HexpireK17Field2": hash.HashValue{
+						Value: "default2",
+					},
+				},
+				command: []string{
+					"HPEXPIREAT", "HexpireKey17",
+					strconv.FormatInt(mockClock.Now().Add(100*time.Second).UnixMilli(), 10),
+					"FIELDS", "2", "HexpireK17Field1", "HexpireK17Field2",
+				},
+				expectedValue: "[1 1]",
+				expectedError: nil,
+			},
+			{
+				name: "18. Set expiration with absolute unix time, time is not an integer.",
+				key:  "HexpireKey18",
+				presetValue: hash.Hash{
+					"HexpireK18Field1": hash.HashValue{
+						Value: "default1",
+					},
+				},
+				command:       []string{"HPEXPIREAT", "HexpireKey18", "soon", "FIELDS", "1", "HexpireK18Field1"},
+				expectedError: errors.New("unix-time-milliseconds must be integer"),
+			},
 		}
 
 		for _, test := range tests {
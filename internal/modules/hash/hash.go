@@ -4,6 +4,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
 )
 
@@ -14,33 +15,12 @@ type HashValue struct {
 
 type Hash map[string]HashValue
 
+// GetMem returns the memory used by the hash, including its fields and their values.
 func (h Hash) GetMem() int64 {
-
-	var size int64
-	// Map headers
-	size += int64(unsafe.Sizeof(h))
-
-	for key, val := range h {
-
-		size += int64(unsafe.Sizeof(key))
-		size += int64(len(key))
-
-		size += int64(unsafe.Sizeof(val))
-		size += int64(unsafe.Sizeof(val.ExpireAt))
-
-		switch vt := val.Value.(type) {
-
-		// AdaptType() will always ensure data type is of string, float64 or int.
-		case nil:
-			size += 0
-		case int:
-			size += int64(unsafe.Sizeof(vt))
-		case float64, int64:
-			size += 8
-		case string:
-			size += int64(unsafe.Sizeof(vt))
-			size += int64(len(vt))
-		}
+	size := internal.MapSize(len(h), internal.StringHeaderSize, int64(unsafe.Sizeof(HashValue{})))
+	for field, value := range h {
+		size += int64(len(field))
+		size += internal.ValueSize(value.Value)
 	}
 	return size
 }
@@ -0,0 +1,82 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hash
+
+import (
+	"encoding/json"
+	"time"
+	"unsafe"
+
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/listpack"
+)
+
+// Listpack is the compact encoding of a small hash, which holds its fields and values as pairs in a listpack.
+// Fields with an expiry can't be held in a listpack, so only hashes without field expiries are encoded.
+type Listpack struct {
+	listpack listpack.Listpack
+}
+
+// compile time interface check
+var _ constants.CompositeType = (*Listpack)(nil)
+
+// NewListpack encodes the hash in a listpack. It returns false when the hash has more than maxEntries fields,
+// a field or value longer than maxValue, or a field with an expiry.
+func NewListpack(h Hash, maxEntries int, maxValue int) (*Listpack, bool) {
+	if len(h) > maxEntries {
+		return nil, false
+	}
+	for field, value := range h {
+		if len(field) > maxValue || !listpack.Fits(value.Value, maxValue) || value.ExpireAt != (time.Time{}) {
+			return nil, false
+		}
+	}
+	lp := &Listpack{}
+	for field, value := range h {
+		lp.listpack.AppendString(field)
+		lp.listpack.Append(value.Value)
+	}
+	return lp, true
+}
+
+// Hash decodes the listpack into a new hash.
+func (lp *Listpack) Hash() Hash {
+	h := make(Hash, lp.Len())
+	var field string
+	lp.listpack.Each(func(i int, value interface{}) bool {
+		if i%2 == 0 {
+			field = value.(string)
+		} else {
+			h[field] = HashValue{Value: value}
+		}
+		return true
+	})
+	return h
+}
+
+// Len returns the number of fields in the hash.
+func (lp *Listpack) Len() int {
+	return lp.listpack.Len() / 2
+}
+
+// GetMem returns the memory used by the hash, including its fields and their values.
+func (lp *Listpack) GetMem() int64 {
+	return int64(unsafe.Sizeof(*lp)) + lp.listpack.Size()
+}
+
+// MarshalJSON encodes the hash in the same shape as a Hash, so that persisted hashes don't depend on their encoding.
+func (lp *Listpack) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lp.Hash())
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"encoding/json"
+	"unsafe"
+
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/listpack"
+)
+
+// Listpack is the compact encoding of a small list, which holds its elements in a listpack rather than in a slice
+// of strings.
+type Listpack struct {
+	listpack listpack.Listpack
+}
+
+// compile time interface check
+var _ constants.CompositeType = (*Listpack)(nil)
+
+// NewListpack encodes the list in a listpack. It returns false when the list has more than maxEntries elements,
+// or an element longer than maxValue.
+func NewListpack(list []string, maxEntries int, maxValue int) (*Listpack, bool) {
+	if len(list) > maxEntries {
+		return nil, false
+	}
+	for _, elem := range list {
+		if len(elem) > maxValue {
+			return nil, false
+		}
+	}
+	lp := &Listpack{}
+	for _, elem := range list {
+		lp.listpack.AppendString(elem)
+	}
+	return lp, true
+}
+
+// Strings decodes the listpack into a new list.
+func (lp *Listpack) Strings() []string {
+	list := make([]string, 0, lp.Len())
+	lp.listpack.Each(func(_ int, value interface{}) bool {
+		list = append(list, value.(string))
+		return true
+	})
+	return list
+}
+
+// Len returns the number of elements in the list.
+func (lp *Listpack) Len() int {
+	return lp.listpack.Len()
+}
+
+// GetMem returns the memory used by the list, including its elements.
+func (lp *Listpack) GetMem() int64 {
+	return int64(unsafe.Sizeof(*lp)) + lp.listpack.Size()
+}
+
+// MarshalJSON encodes the list in the same shape as a slice of strings, so that persisted lists don't depend on
+// their encoding.
+func (lp *Listpack) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lp.Strings())
+}
@@ -415,6 +415,8 @@ func handleSPOP(params internal.HandlerFuncParams) ([]byte, error) {
 	key := keys.WriteKeys[0]
 	keyExists := params.KeysExist(params.Context, keys.WriteKeys)[key]
 	count := 1
+	// The deterministic form of SPOP written by rewriteSPOP lists the members to pop after the MEMBERS keyword.
+	deterministic := len(params.Command) > 3
 
 	if len(params.Command) == 3 {
 		c, ok := internal.AdaptType(params.Command[2]).(int)
@@ -433,19 +435,69 @@ func handleSPOP(params internal.HandlerFuncParams) ([]byte, error) {
 		return nil, fmt.Errorf("value at %s is not a set", key)
 	}
 
-	members := set.Pop(count)
-
-	res := fmt.Sprintf("*%d", len(members))
-	for i, m := range members {
-		res = fmt.Sprintf("%s\r\n$%d\r\n%s", res, len(m), m)
-		if i == len(members)-1 {
-			res += "\r\n"
+	var members []string
+	if deterministic {
+		// Only the members that are still in the set are popped and returned.
+		for _, m := range params.Command[3:] {
+			if set.Contains(m) && !slices.Contains(members, m) {
+				members = append(members, m)
+			}
 		}
+		set.Remove(members)
+	} else {
+		members = set.Pop(count)
+	}
+
+	res := fmt.Sprintf("*%d\r\n", len(members))
+	for _, m := range members {
+		res += fmt.Sprintf("$%d\r\n%s\r\n", len(m), m)
 	}
 
 	return []byte(res), nil
 }
 
+// rewriteSPOP chooses the random members to be popped and converts SPOP into its deterministic form,
+// SPOP key MEMBERS member [member ...]. The members are popped and returned by the handler, so the response
+// only lists the members that were still in the set when the command was applied.
+// The deterministic form is only applied from the AOF log, the raft log and the replication stream, which are
+// not rewritten, so clients can't send it.
+func rewriteSPOP(params internal.HandlerFuncParams) (internal.RewriteFuncResult, error) {
+	keys, err := spopKeyFunc(params.Command)
+	if err != nil {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+	if len(params.Command) > 3 {
+		return internal.RewriteFuncResult{}, errors.New("syntax error")
+	}
+
+	key := keys.WriteKeys[0]
+	count := 1
+
+	if len(params.Command) == 3 {
+		c, ok := internal.AdaptType(params.Command[2]).(int)
+		if !ok || c <= 0 {
+			return internal.RewriteFuncResult{Command: params.Command}, nil
+		}
+		count = c
+	}
+
+	if !params.KeysExist(params.Context, keys.WriteKeys)[key] {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	set, ok := params.GetValues(params.Context, []string{key})[key].(*Set)
+	if !ok {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	members := set.GetRandom(count)
+	if len(members) == 0 {
+		return internal.RewriteFuncResult{Command: params.Command}, nil
+	}
+
+	return internal.RewriteFuncResult{Command: append([]string{params.Command[0], key, "MEMBERS"}, members...)}, nil
+}
+
 func handleSRANDMEMBER(params internal.HandlerFuncParams) ([]byte, error) {
 	keys, err := srandmemberKeyFunc(params.Command)
 	if err != nil {
@@ -694,6 +746,7 @@ Returns the cardinality of the intersection between multiple sets.`,
 			Type:              "BUILT_IN",
 			KeyExtractionFunc: spopKeyFunc,
 			HandlerFunc:       handleSPOP,
+			RewriteFunc:       rewriteSPOP,
 		},
 		{
 			Command:           "srandmember",
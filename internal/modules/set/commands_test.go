@@ -1783,6 +1783,14 @@ func Test_Set(t *testing.T) {
 				expectedValue: 0,
 				expectedError: errors.New("count must be an integer"),
 			},
+			{
+				name:          "6. Reject the deterministic form from clients",
+				key:           "SpopKey6",
+				presetValue:   set.NewSet([]string{"one", "two", "three"}),
+				command:       []string{"SPOP", "SpopKey6", "MEMBERS", "one", "four", "one"},
+				expectedValue: 3,
+				expectedError: errors.New("syntax error"),
+			},
 		}
 
 		for _, test := range tests {
@@ -157,7 +157,7 @@ func smoveKeyFunc(cmd []string) (internal.KeyExtractionFuncResult, error) {
 }
 
 func spopKeyFunc(cmd []string) (internal.KeyExtractionFuncResult, error) {
-	if len(cmd) < 2 || len(cmd) > 3 {
+	if len(cmd) < 2 || len(cmd) > 3 && !strings.EqualFold(cmd[2], "MEMBERS") {
 		return internal.KeyExtractionFuncResult{}, errors.New(constants.WrongArgsResponse)
 	}
 	return internal.KeyExtractionFuncResult{
@@ -15,28 +15,51 @@
 package set
 
 import (
+	"maps"
+	"math"
 	"math/rand"
 	"slices"
+	"strconv"
 	"unsafe"
 
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/listpack"
 )
 
+// Limits are the thresholds of the compact encodings of a set. A set is converted to the next encoding once it has
+// more members than its encoding allows, or a member that the encoding can't hold.
+type Limits struct {
+	MaxIntsetEntries   int // The max number of members of a set of integers in the intset encoding.
+	MaxListpackEntries int // The max number of members of a set in the listpack encoding.
+	MaxListpackValue   int // The max length of a member of a set in the listpack encoding.
+}
+
+// Set is a set of strings. Small sets are held in one of the compact encodings allowed by the limits of the set,
+// which are all disabled for a new set, and larger sets are held in a map.
 type Set struct {
-	members map[string]interface{}
-	length  int
+	encoding string
+	limits   Limits
+	members  map[string]interface{} // The members in the hashtable encoding.
+	intset   []int64                // The members in the intset encoding, in ascending order.
+	listpack listpack.Listpack      // The members in the listpack encoding.
+	length   int
 }
 
+// GetMem returns the memory used by the set, including its members.
 func (set *Set) GetMem() int64 {
-	var size int64
-	size += int64(unsafe.Sizeof(set))
-	// above only gives us the size of the pointer to the map, so we need to add its headers and contents
-	size += int64(unsafe.Sizeof(set.members))
-	for k, v := range set.members {
-		size += int64(unsafe.Sizeof(k))
-		size += int64(len(k))
-		size += int64(unsafe.Sizeof(v))
+	size := int64(unsafe.Sizeof(*set))
+	switch set.encoding {
+	case constants.EncodingIntset:
+		size += int64(cap(set.intset)) * 8
+	case constants.EncodingListpack:
+		size += set.listpack.Size()
+	default:
+		size += internal.MapSize(len(set.members), internal.StringHeaderSize, int64(unsafe.Sizeof(set.members[""])))
+		for member, value := range set.members {
+			size += int64(len(member))
+			size += internal.ValueSize(value)
+		}
 	}
 	return size
 }
@@ -46,33 +69,138 @@ var _ constants.CompositeType = (*Set)(nil)
 
 func NewSet(elems []string) *Set {
 	set := &Set{
-		members: make(map[string]interface{}),
-		length:  0,
+		encoding: constants.EncodingHashtable,
+		members:  make(map[string]interface{}),
+		length:   0,
 	}
 	set.Add(elems)
 	return set
 }
 
+// Copy returns a copy of the set that doesn't share its members, so that the copy isn't changed by writes to the set.
+func (set *Set) Copy() *Set {
+	return &Set{
+		encoding: set.encoding,
+		limits:   set.limits,
+		members:  maps.Clone(set.members),
+		intset:   slices.Clone(set.intset),
+		listpack: set.listpack.Copy(),
+		length:   set.length,
+	}
+}
+
+// Encoding returns the encoding of the set, which is one of intset, listpack or hashtable.
+func (set *Set) Encoding() string {
+	return set.encoding
+}
+
+// SetLimits sets the limits of the compact encodings of the set, and converts the set to the most compact encoding
+// that holds its members within the limits.
+func (set *Set) SetLimits(limits Limits) {
+	set.limits = limits
+	members := set.GetAll()
+	if encoding := set.fit(members); encoding != set.encoding {
+		set.convert(encoding, members)
+	}
+}
+
+// fit returns the most compact encoding that holds the members within the limits of the set.
+func (set *Set) fit(members []string) string {
+	if set.limits.MaxIntsetEntries > 0 && len(members) <= set.limits.MaxIntsetEntries && !slices.ContainsFunc(members, func(member string) bool {
+		_, ok := parseInt(member)
+		return !ok
+	}) {
+		return constants.EncodingIntset
+	}
+	if set.limits.MaxListpackEntries > 0 && len(members) <= set.limits.MaxListpackEntries && !slices.ContainsFunc(members, func(member string) bool {
+		return len(member) > set.limits.MaxListpackValue
+	}) {
+		return constants.EncodingListpack
+	}
+	return constants.EncodingHashtable
+}
+
+// convert holds the members in the encoding.
+func (set *Set) convert(encoding string, members []string) {
+	set.encoding = encoding
+	set.members, set.intset, set.listpack, set.length = nil, nil, listpack.Listpack{}, 0
+	if encoding == constants.EncodingHashtable {
+		set.members = make(map[string]interface{}, len(members))
+	}
+	for _, member := range members {
+		set.insert(member)
+	}
+}
+
+// parseInt returns the integer held by the member, and false when the member is not the canonical string
+// representation of an integer.
+func parseInt(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	return n, err == nil && strconv.FormatInt(n, 10) == member
+}
+
+// insert adds a member that is not in the set to the current encoding of the set.
+func (set *Set) insert(e string) {
+	switch set.encoding {
+	case constants.EncodingIntset:
+		n, _ := parseInt(e)
+		i, _ := slices.BinarySearch(set.intset, n)
+		set.intset = slices.Insert(set.intset, i, n)
+	case constants.EncodingListpack:
+		set.listpack.AppendString(e)
+	default:
+		set.members[e] = struct{}{}
+	}
+	set.length += 1
+}
+
 func (set *Set) Add(elems []string) int {
 	count := 0
 	for _, e := range elems {
-		if !set.Contains(e) {
-			set.members[e] = struct{}{}
-			count += 1
+		if set.Contains(e) {
+			continue
+		}
+		// Convert the set to the next encoding when the current one can't hold the new member.
+		if encoding := set.fit([]string{e}); set.length+1 > set.maxEntries() ||
+			encoding == constants.EncodingHashtable && set.encoding != constants.EncodingHashtable ||
+			encoding == constants.EncodingListpack && set.encoding == constants.EncodingIntset {
+			members := append(set.GetAll(), e)
+			set.convert(set.fit(members), members[:len(members)-1])
 		}
+		set.insert(e)
+		count += 1
 	}
-	set.length += count
 	return count
 }
 
-func (set *Set) get(e string) interface{} {
-	return set.members[e]
+// maxEntries returns the max number of members of the current encoding of the set.
+func (set *Set) maxEntries() int {
+	switch set.encoding {
+	case constants.EncodingIntset:
+		return set.limits.MaxIntsetEntries
+	case constants.EncodingListpack:
+		return set.limits.MaxListpackEntries
+	default:
+		return math.MaxInt
+	}
 }
 
 func (set *Set) GetAll() []string {
 	var res []string
-	for e, _ := range set.members {
-		res = append(res, e)
+	switch set.encoding {
+	case constants.EncodingIntset:
+		for _, n := range set.intset {
+			res = append(res, strconv.FormatInt(n, 10))
+		}
+	case constants.EncodingListpack:
+		set.listpack.Each(func(_ int, value interface{}) bool {
+			res = append(res, value.(string))
+			return true
+		})
+	default:
+		for e, _ := range set.members {
+			res = append(res, e)
+		}
 	}
 	return res
 }
@@ -122,9 +250,23 @@ func (set *Set) GetRandom(count int) []string {
 func (set *Set) Remove(elems []string) int {
 	count := 0
 	for _, e := range elems {
-		if set.get(e) != nil {
-			delete(set.members, e)
-			count += 1
+		switch set.encoding {
+		case constants.EncodingIntset:
+			n, ok := parseInt(e)
+			if i, found := slices.BinarySearch(set.intset, n); ok && found {
+				set.intset = slices.Delete(set.intset, i, i+1)
+				count += 1
+			}
+		case constants.EncodingListpack:
+			if i := set.listpack.Index(e, 0, 1); i >= 0 {
+				set.listpack.Delete(i, 1)
+				count += 1
+			}
+		default:
+			if _, ok := set.members[e]; ok {
+				delete(set.members, e)
+				count += 1
+			}
 		}
 	}
 	set.length -= count
@@ -138,7 +280,20 @@ func (set *Set) Pop(count int) []string {
 }
 
 func (set *Set) Contains(e string) bool {
-	return set.get(e) != nil
+	switch set.encoding {
+	case constants.EncodingIntset:
+		n, ok := parseInt(e)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(set.intset, n)
+		return found
+	case constants.EncodingListpack:
+		return set.listpack.Index(e, 0, 1) >= 0
+	default:
+		_, ok := set.members[e]
+		return ok
+	}
 }
 
 // Subtract received a list of sets and finds the difference between sets provided
@@ -146,7 +301,7 @@ func (set *Set) Subtract(others []*Set) *Set {
 	diff := NewSet(set.GetAll())
 	var remove []string
 	for _, s := range others {
-		for k, _ := range s.members {
+		for _, k := range s.GetAll() {
 			if diff.Contains(k) {
 				remove = append(remove, k)
 			}
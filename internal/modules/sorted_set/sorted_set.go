@@ -17,6 +17,7 @@ package sorted_set
 import (
 	"cmp"
 	"errors"
+	"maps"
 	"math"
 	"math/rand"
 	"slices"
@@ -25,6 +26,7 @@ import (
 
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/listpack"
 )
 
 type Value string
@@ -44,27 +46,33 @@ type MemberParam struct {
 	Score Score
 }
 
+// Limits are the thresholds of the listpack encoding of a sorted set. A sorted set is converted to the hashtable
+// encoding once it has more members than the listpack allows, or a member that is longer than the listpack allows.
+type Limits struct {
+	MaxListpackEntries int // The max number of members of a sorted set in the listpack encoding.
+	MaxListpackValue   int // The max length of a member of a sorted set in the listpack encoding.
+}
+
+// SortedSet is a set of members with a score each. Small sorted sets are held in a listpack of member and score
+// pairs when the limits of the sorted set allow it, which they don't for a new sorted set, and larger sorted sets
+// are held in a map.
 type SortedSet struct {
-	members map[Value]MemberObject
+	limits   Limits
+	members  map[Value]MemberObject // The members in the hashtable encoding.
+	listpack *listpack.Listpack     // The members and scores in the listpack encoding.
 }
 
+// GetMem returns the memory used by the sorted set, including its members.
 func (s *SortedSet) GetMem() int64 {
-	var size int64
-	// map header
-	size += int64(unsafe.Sizeof(s))
-	// map contents
-	for k, v := range s.members {
-		// string header
-		size += int64(unsafe.Sizeof(k))
-		// string
-		size += int64(len(k))
-		// MemberObject
-		size += int64(unsafe.Sizeof(v))
-		// value field
-		size += int64(unsafe.Sizeof(v.Value))
-		size += int64(len(v.Value))
+	size := int64(unsafe.Sizeof(*s))
+	if s.listpack != nil {
+		return size + int64(unsafe.Sizeof(*s.listpack)) + s.listpack.Size()
+	}
+	size += internal.MapSize(len(s.members), int64(unsafe.Sizeof(Value(""))), int64(unsafe.Sizeof(MemberObject{})))
+	for member := range s.members {
+		// The value of the member object shares its bytes with the key of the map.
+		size += int64(len(member))
 	}
-
 	return size
 }
 
@@ -85,12 +93,89 @@ func NewSortedSet(members []MemberParam) *SortedSet {
 	return s
 }
 
+// Copy returns a copy of the sorted set that doesn't share its members, so that the copy isn't changed by writes to
+// the sorted set.
+func (set *SortedSet) Copy() *SortedSet {
+	s := &SortedSet{
+		limits:  set.limits,
+		members: maps.Clone(set.members),
+	}
+	if set.listpack != nil {
+		lp := set.listpack.Copy()
+		s.listpack = &lp
+	}
+	return s
+}
+
+// Encoding returns the encoding of the sorted set, which is either listpack or hashtable.
+func (set *SortedSet) Encoding() string {
+	if set.listpack != nil {
+		return constants.EncodingListpack
+	}
+	return constants.EncodingHashtable
+}
+
+// SetLimits sets the limits of the listpack encoding of the sorted set, and converts the sorted set to the listpack
+// encoding when its members fit within the limits, or to the hashtable encoding when they don't.
+func (set *SortedSet) SetLimits(limits Limits) {
+	set.limits = limits
+	members := set.GetAll()
+	fits := limits.MaxListpackEntries > 0 && len(members) <= limits.MaxListpackEntries &&
+		!slices.ContainsFunc(members, func(m MemberParam) bool { return !set.fits(m.Value) })
+	switch {
+	case fits && set.listpack == nil:
+		set.members, set.listpack = nil, &listpack.Listpack{}
+		for _, m := range members {
+			set.put(m.Value, m.Score)
+		}
+	case !fits && set.listpack != nil:
+		set.toHashtable()
+	}
+}
+
+// fits returns true when the member can be held in the listpack encoding.
+func (set *SortedSet) fits(v Value) bool {
+	return set.limits.MaxListpackEntries > 0 && len(v) <= set.limits.MaxListpackValue
+}
+
+// toHashtable converts the sorted set to the hashtable encoding.
+func (set *SortedSet) toHashtable() {
+	members := set.GetAll()
+	set.members, set.listpack = make(map[Value]MemberObject, len(members)), nil
+	for _, m := range members {
+		set.put(m.Value, m.Score)
+	}
+}
+
+// put sets the score of the member, adding the member when it's not in the sorted set.
+func (set *SortedSet) put(v Value, score Score) {
+	if set.listpack == nil {
+		set.members[v] = MemberObject{Value: v, Score: score, Exists: true}
+		return
+	}
+	if i := set.listpack.Index(string(v), 0, 2); i >= 0 {
+		set.listpack.Delete(i, 2)
+	} else if set.listpack.Len()/2 >= set.limits.MaxListpackEntries || !set.fits(v) {
+		set.toHashtable()
+		set.put(v, score)
+		return
+	}
+	set.listpack.AppendString(string(v))
+	set.listpack.Append(float64(score))
+}
+
 func (set *SortedSet) Contains(m Value) bool {
-	return set.members[m].Exists
+	return set.Get(m).Exists
 }
 
 func (set *SortedSet) Get(v Value) MemberObject {
-	return set.members[v]
+	if set.listpack == nil {
+		return set.members[v]
+	}
+	if i := set.listpack.Index(string(v), 0, 2); i >= 0 {
+		return MemberObject{Value: v, Score: Score(set.listpack.Get(i + 1).(float64)), Exists: true}
+	}
+	return MemberObject{}
 }
 
 func (set *SortedSet) GetRandom(count int) []MemberParam {
@@ -131,6 +216,16 @@ func (set *SortedSet) GetRandom(count int) []MemberParam {
 
 func (set *SortedSet) GetAll() []MemberParam {
 	var res []MemberParam
+	if set.listpack != nil {
+		values := set.listpack.Values()
+		for i := 0; i < len(values); i += 2 {
+			res = append(res, MemberParam{
+				Value: Value(values[i].(string)),
+				Score: Score(values[i+1].(float64)),
+			})
+		}
+		return res
+	}
 	for k, v := range set.members {
 		res = append(res, MemberParam{
 			Value: k,
@@ -141,7 +236,10 @@ func (set *SortedSet) GetAll() []MemberParam {
 }
 
 func (set *SortedSet) Cardinality() int {
-	return len(set.GetAll())
+	if set.listpack != nil {
+		return set.listpack.Len() / 2
+	}
+	return len(set.members)
 }
 
 func (set *SortedSet) AddOrUpdate(
@@ -176,23 +274,15 @@ func (set *SortedSet) AddOrUpdate(
 		for _, m := range members {
 			if !set.Contains(m.Value) {
 				// If the member is not contained, add it with the increment as its Score
-				set.members[m.Value] = MemberObject{
-					Value:  m.Value,
-					Score:  m.Score,
-					Exists: true,
-				}
+				set.put(m.Value, m.Score)
 				// Always add count because this is the addition of a new element
 				count += 1
 				return count, err
 			}
-			if slices.Contains([]Score{Score(math.Inf(-1)), Score(math.Inf(1))}, set.members[m.Value].Score) {
+			if slices.Contains([]Score{Score(math.Inf(-1)), Score(math.Inf(1))}, set.Get(m.Value).Score) {
 				return count, errors.New("cannot increment -inf or +inf")
 			}
-			set.members[m.Value] = MemberObject{
-				Value:  m.Value,
-				Score:  set.members[m.Value].Score + m.Score,
-				Exists: true,
-			}
+			set.put(m.Value, set.Get(m.Value).Score+m.Score)
 			if strings.EqualFold(ch, "ch") {
 				count += 1
 			}
@@ -204,11 +294,7 @@ func (set *SortedSet) AddOrUpdate(
 		if strings.EqualFold(policy, "xx") {
 			// Only update existing elements, do not add new elements
 			if set.Contains(m.Value) {
-				set.members[m.Value] = MemberObject{
-					Value:  m.Value,
-					Score:  compareScores(set.members[m.Value].Score, m.Score, comp),
-					Exists: true,
-				}
+				set.put(m.Value, compareScores(set.Get(m.Value).Score, m.Score, comp))
 				if strings.EqualFold(ch, "ch") {
 					count += 1
 				}
@@ -218,34 +304,30 @@ func (set *SortedSet) AddOrUpdate(
 		if strings.EqualFold(policy, "nx") {
 			// Only add new elements, do not update existing elements
 			if !set.Contains(m.Value) {
-				set.members[m.Value] = MemberObject{
-					Value:  m.Value,
-					Score:  m.Score,
-					Exists: true,
-				}
+				set.put(m.Value, m.Score)
 				count += 1
 			}
 			continue
 		}
 		// Policy not specified, just Set the elements and scores
-		if set.members[m.Value].Score != m.Score || !set.members[m.Value].Exists {
+		if set.Get(m.Value).Score != m.Score || !set.Get(m.Value).Exists {
 			count += 1
 		}
-		set.members[m.Value] = MemberObject{
-			Value:  m.Value,
-			Score:  compareScores(set.members[m.Value].Score, m.Score, comp),
-			Exists: true,
-		}
+		set.put(m.Value, compareScores(set.Get(m.Value).Score, m.Score, comp))
 	}
 	return count, nil
 }
 
 func (set *SortedSet) Remove(v Value) bool {
-	if set.Contains(v) {
+	if !set.Contains(v) {
+		return false
+	}
+	if set.listpack != nil {
+		set.listpack.Delete(set.listpack.Index(string(v), 0, 2), 2)
+	} else {
 		delete(set.members, v)
-		return true
 	}
-	return false
+	return true
 }
 
 func (set *SortedSet) Pop(count int, policy string) (*SortedSet, error) {
@@ -0,0 +1,296 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/hashicorp/raft"
+)
+
+// batchFormat is the first byte of a raft log entry holding a batch of binary encoded commands.
+// Other log entries are JSON encoded apply requests, which always start with '{'.
+const batchFormat byte = 0x01
+
+// BatchResponse is returned by the FSM for a batch log entry. It holds the response of each command in the batch.
+type BatchResponse []internal.ApplyResponse
+
+var errMalformedBatch = errors.New("malformed raft command batch")
+
+// encodeBatch encodes the command requests in the following layout, where each number is a uvarint:
+//
+//	0x01 | count | (server id | connection id | protocol | database | argc | args...) * count
+//
+// Strings are encoded as their length followed by their bytes.
+func encodeBatch(requests []internal.ApplyRequest) []byte {
+	size := 1 + binary.MaxVarintLen64
+	for _, request := range requests {
+		size += len(request.ServerID) + len(request.ConnectionID) + 5*binary.MaxVarintLen64
+		for _, arg := range request.CMD {
+			size += len(arg) + binary.MaxVarintLen64
+		}
+	}
+
+	b := make([]byte, 0, size)
+	b = append(b, batchFormat)
+	b = binary.AppendUvarint(b, uint64(len(requests)))
+	appendString := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	for _, request := range requests {
+		appendString(request.ServerID)
+		appendString(request.ConnectionID)
+		b = binary.AppendUvarint(b, uint64(request.Protocol))
+		b = binary.AppendUvarint(b, uint64(request.Database))
+		b = binary.AppendUvarint(b, uint64(len(request.CMD)))
+		for _, arg := range request.CMD {
+			appendString(arg)
+		}
+	}
+	return b
+}
+
+// decodeBatch decodes a batch log entry into command requests.
+func decodeBatch(b []byte) ([]internal.ApplyRequest, error) {
+	if len(b) == 0 || b[0] != batchFormat {
+		return nil, errMalformedBatch
+	}
+	b = b[1:]
+
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errMalformedBatch
+		}
+		b = b[n:]
+		return v, nil
+	}
+	readString := func() (string, error) {
+		n, err := readUvarint()
+		if err != nil {
+			return "", err
+		}
+		if n > uint64(len(b)) {
+			return "", errMalformedBatch
+		}
+		s := string(b[:n])
+		b = b[n:]
+		return s, nil
+	}
+
+	count, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	// Each request takes at least 5 bytes, which bounds the allocation for corrupted counts.
+	if count > uint64(len(b)) {
+		return nil, errMalformedBatch
+	}
+
+	requests := make([]internal.ApplyRequest, count)
+	for i := range requests {
+		request := internal.ApplyRequest{Type: "command"}
+		if request.ServerID, err = readString(); err != nil {
+			return nil, err
+		}
+		if request.ConnectionID, err = readString(); err != nil {
+			return nil, err
+		}
+		protocol, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		database, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		request.Protocol, request.Database = int(protocol), int(database)
+		argc, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if argc > uint64(len(b)) {
+			return nil, errMalformedBatch
+		}
+		request.CMD = make([]string, argc)
+		for j := range request.CMD {
+			if request.CMD[j], err = readString(); err != nil {
+				return nil, err
+			}
+		}
+		requests[i] = request
+	}
+
+	if len(b) > 0 {
+		return nil, errMalformedBatch
+	}
+	return requests, nil
+}
+
+type pendingCommand struct {
+	request internal.ApplyRequest
+	done    chan commandResult
+}
+
+type commandResult struct {
+	response internal.ApplyResponse
+	index    uint64
+	err      error
+}
+
+// batcher coalesces concurrent commands into batch log entries.
+// The next batch is collected while the previous batches are being committed, so batching never adds
+// a round trip. Once a batch is committed and applied, each caller receives the response of its own command.
+type batcher struct {
+	raft    *raft.Raft
+	window  time.Duration // How long to wait for more commands after the first one. 0 only takes queued commands.
+	size    int           // The maximum number of commands in a batch.
+	timeout time.Duration // The timeout of the raft apply of a batch.
+	// mut is held for reading while a command is queued, and for writing when the commands channel is closed.
+	mut      sync.RWMutex
+	closed   bool
+	commands chan *pendingCommand
+}
+
+func newBatcher(r *raft.Raft, window time.Duration, size int, timeout time.Duration) *batcher {
+	if size <= 0 {
+		size = 1
+	}
+	b := &batcher{
+		raft:     r,
+		window:   window,
+		size:     size,
+		timeout:  timeout,
+		commands: make(chan *pendingCommand, size),
+	}
+	go b.run()
+	return b
+}
+
+// apply appends the command to the next batch and waits for its result.
+func (b *batcher) apply(ctx context.Context, request internal.ApplyRequest) (internal.ApplyResponse, uint64, error) {
+	pending := &pendingCommand{request: request, done: make(chan commandResult, 1)}
+	b.mut.RLock()
+	if b.closed {
+		b.mut.RUnlock()
+		return internal.ApplyResponse{}, 0, raft.ErrRaftShutdown
+	}
+	select {
+	case b.commands <- pending:
+		b.mut.RUnlock()
+	case <-ctx.Done():
+		b.mut.RUnlock()
+		return internal.ApplyResponse{}, 0, ctx.Err()
+	}
+	// The command can't be withdrawn from the batch once it's queued, so it may still be applied after the
+	// context is cancelled.
+	select {
+	case result := <-pending.done:
+		return result.response, result.index, result.err
+	case <-ctx.Done():
+		return internal.ApplyResponse{}, 0, ctx.Err()
+	}
+}
+
+// close stops the batcher. The commands that are already queued are still committed, and new commands are
+// rejected with raft.ErrRaftShutdown.
+func (b *batcher) close() {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	close(b.commands)
+}
+
+func (b *batcher) run() {
+	for first := range b.commands {
+		batch := []*pendingCommand{first}
+		batch = b.collect(batch)
+		b.commit(batch)
+	}
+}
+
+// collect adds commands to the batch until the window elapses or the batch is full.
+func (b *batcher) collect(batch []*pendingCommand) []*pendingCommand {
+	var timeout <-chan time.Time
+	if b.window > 0 {
+		timer := time.NewTimer(b.window)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(batch) < b.size {
+		if timeout == nil {
+			select {
+			case command, ok := <-b.commands:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, command)
+			default:
+				return batch
+			}
+			continue
+		}
+		select {
+		case command, ok := <-b.commands:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, command)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+// commit appends the batch to the raft log and fans out the responses once it's applied, without blocking
+// the collection of the next batch.
+func (b *batcher) commit(batch []*pendingCommand) {
+	requests := make([]internal.ApplyRequest, len(batch))
+	for i, command := range batch {
+		requests[i] = command.request
+	}
+	future := b.raft.Apply(encodeBatch(requests), b.timeout)
+
+	go func() {
+		if err := future.Error(); err != nil {
+			for _, command := range batch {
+				command.done <- commandResult{err: err}
+			}
+			return
+		}
+		responses, ok := future.Response().(BatchResponse)
+		if !ok || len(responses) != len(batch) {
+			err := fmt.Errorf("unprocessable entity %v", future.Response())
+			for _, command := range batch {
+				command.done <- commandResult{err: err}
+			}
+			return
+		}
+		for i, command := range batch {
+			command.done <- commandResult{response: responses[i], index: future.Index()}
+		}
+	}()
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/go-test/deep"
+	"github.com/hashicorp/raft"
+)
+
+func Test_BatchEncoding(t *testing.T) {
+	requests := []internal.ApplyRequest{
+		{Type: "command", ServerID: "server-1", ConnectionID: "1", Protocol: 2, Database: 0, CMD: []string{"SET", "key", "value"}},
+		{Type: "command", ServerID: "server-1", ConnectionID: "", Protocol: 3, Database: 15, CMD: []string{"DEL", "", "\x00\r\n"}},
+	}
+
+	b := encodeBatch(requests)
+	if b[0] != batchFormat {
+		t.Fatalf("expected batch to start with the batch format byte, got %x", b[0])
+	}
+	decoded, err := decodeBatch(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(decoded, requests); diff != nil {
+		t.Error(diff)
+	}
+
+	// Truncated or padded entries are rejected.
+	for _, malformed := range [][]byte{b[:len(b)-1], append(b, 0), {batchFormat, 0xFF}, []byte(`{"Type":"command"}`)} {
+		if _, err = decodeBatch(malformed); err == nil {
+			t.Errorf("expected error decoding malformed batch %q", malformed)
+		}
+	}
+}
+
+// batchFSM records the batch log entries it applies and echoes each command's key as its response.
+type batchFSM struct {
+	mut     sync.Mutex
+	batches [][]internal.ApplyRequest
+}
+
+func (fsm *batchFSM) Apply(log *raft.Log) interface{} {
+	requests, err := decodeBatch(log.Data)
+	if err != nil {
+		return internal.ApplyResponse{Error: err}
+	}
+	fsm.mut.Lock()
+	fsm.batches = append(fsm.batches, requests)
+	fsm.mut.Unlock()
+	responses := make(BatchResponse, len(requests))
+	for i, request := range requests {
+		responses[i] = internal.ApplyResponse{Response: []byte(request.CMD[1])}
+	}
+	return responses
+}
+
+func (fsm *batchFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (fsm *batchFSM) Restore(io.ReadCloser) error {
+	return nil
+}
+
+func Test_Batcher(t *testing.T) {
+	conf := raft.DefaultConfig()
+	conf.LocalID = "node"
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.LogOutput = io.Discard
+
+	fsm := &batchFSM{}
+	store := raft.NewInmemStore()
+	address, transport := raft.NewInmemTransport("")
+	r, err := raft.NewRaft(conf, fsm, store, store, raft.NewInmemSnapshotStore(), transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = r.Shutdown().Error()
+	})
+	if err = r.BootstrapCluster(raft.Configuration{
+		Servers: []raft.Server{{ID: conf.LocalID, Address: address}},
+	}).Error(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); r.State() != raft.Leader; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("node did not become leader")
+		}
+	}
+
+	// Concurrent commands sent within the window are batched and each caller gets its own response.
+	b := newBatcher(r, 50*time.Millisecond, 8, 500*time.Millisecond)
+	wg := sync.WaitGroup{}
+	indexes := make([]uint64, 10)
+	for i := range indexes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			res, index, err := b.apply(context.Background(), internal.ApplyRequest{CMD: []string{"SET", key, "value"}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if string(res.Response) != key {
+				t.Errorf("expected response %s, got %s", key, res.Response)
+			}
+			indexes[i] = index
+		}(i)
+	}
+	wg.Wait()
+
+	fsm.mut.Lock()
+	defer fsm.mut.Unlock()
+	if len(fsm.batches) != 2 || len(fsm.batches[0]) != 8 || len(fsm.batches[1]) != 2 {
+		sizes := make([]int, len(fsm.batches))
+		for i, batch := range fsm.batches {
+			sizes[i] = len(batch)
+		}
+		t.Errorf("expected batches of 8 and 2 commands, got %v", sizes)
+	}
+	for _, index := range indexes {
+		if index == 0 {
+			t.Error("expected each command to receive the index of its log entry")
+		}
+	}
+
+	// A caller whose context is cancelled stops waiting for a command that is queued in a batch.
+	slow := newBatcher(r, time.Second, 8, 500*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err = slow.apply(ctx, internal.ApplyRequest{CMD: []string{"SET", "key", "value"}}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("expected the caller to return before the batch window elapsed, took %v", elapsed)
+	}
+
+	// Commands are rejected once the batcher is closed.
+	slow.close()
+	b.close()
+	if _, _, err = b.apply(context.Background(), internal.ApplyRequest{CMD: []string{"SET", "key", "value"}}); !errors.Is(err, raft.ErrRaftShutdown) {
+		t.Errorf("expected error %v, got %v", raft.ErrRaftShutdown, err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/echovault/sugardb/internal/encryption"
+	"github.com/hashicorp/raft"
+)
+
+// encryptedLogStore encrypts the data of each log entry before it is persisted to the underlying store.
+// Entries persisted before encryption was enabled are read as plaintext.
+type encryptedLogStore struct {
+	raft.LogStore
+	keyring *encryption.Keyring
+}
+
+func (store *encryptedLogStore) GetLog(index uint64, entry *raft.Log) error {
+	if err := store.LogStore.GetLog(index, entry); err != nil {
+		return err
+	}
+	data, err := store.keyring.Decrypt(entry.Data)
+	if err != nil {
+		return err
+	}
+	entry.Data = data
+	return nil
+}
+
+func (store *encryptedLogStore) StoreLog(entry *raft.Log) error {
+	return store.StoreLogs([]*raft.Log{entry})
+}
+
+func (store *encryptedLogStore) StoreLogs(entries []*raft.Log) error {
+	// Copy the entries so that the callers' entries, which may be cached, keep the plaintext data.
+	encrypted := make([]*raft.Log, len(entries))
+	for i, entry := range entries {
+		e := *entry
+		if len(e.Data) > 0 {
+			data, err := store.keyring.Encrypt(e.Data)
+			if err != nil {
+				return err
+			}
+			e.Data = data
+		}
+		encrypted[i] = &e
+	}
+	return store.LogStore.StoreLogs(encrypted)
+}
+
+// encryptedSnapshotStore encrypts snapshots written to the underlying store.
+// Snapshots are decrypted when opened, so the snapshots sent to other nodes are in plaintext and are
+// encrypted by the receiving node's own store.
+type encryptedSnapshotStore struct {
+	raft.SnapshotStore
+	keyring *encryption.Keyring
+}
+
+func (store *encryptedSnapshotStore) Create(
+	version raft.SnapshotVersion,
+	index, term uint64,
+	configuration raft.Configuration,
+	configurationIndex uint64,
+	trans raft.Transport,
+) (raft.SnapshotSink, error) {
+	sink, err := store.SnapshotStore.Create(version, index, term, configuration, configurationIndex, trans)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedSnapshotSink{
+		SnapshotSink: sink,
+		writer:       encryption.NewWriter(sink, store.keyring),
+	}, nil
+}
+
+func (store *encryptedSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, rc, err := store.SnapshotStore.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	data, err := io.ReadAll(encryption.NewReader(rc, store.keyring))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Raft checks the number of bytes read against the size in the metadata,
+	// so report the size of the decrypted snapshot.
+	m := *meta
+	m.Size = int64(len(data))
+	return &m, io.NopCloser(bytes.NewReader(data)), nil
+}
+
+type encryptedSnapshotSink struct {
+	raft.SnapshotSink
+	writer io.Writer
+}
+
+func (sink *encryptedSnapshotSink) Write(p []byte) (int, error) {
+	return sink.writer.Write(p)
+}
@@ -30,7 +30,7 @@ import (
 
 type FSMOpts struct {
 	Config                config.Config
-	GetState              func() map[int]map[string]internal.KeyData
+	GetState              func() (map[int]map[string]internal.KeyData, error)
 	GetCommand            func(command string) (internal.Command, error)
 	SetValues             func(ctx context.Context, entries map[string]interface{}) error
 	SetExpiry             func(ctx context.Context, key string, expire time.Time, touch bool)
@@ -39,6 +39,13 @@ type FSMOpts struct {
 	FinishSnapshot        func()
 	SetLatestSnapshotTime func(msec int64)
 	GetHandlerFuncParams  func(ctx context.Context, cmd []string, conn *net.Conn) internal.HandlerFuncParams
+	Applied               func(index uint64) // Optional: Called after each log entry has been applied.
+	ApplySlots            func(cmd []string) error
+	GetSlotState          func() json.RawMessage
+	RestoreSlotState      func(state json.RawMessage) error
+	ImportKeys            func(ctx context.Context, data []byte) error
+	AccountWrite          func(ctx context.Context, cmd []string) // Optional: Called after each write command is applied.
+	EnterWrite            func() (exit func())                    // Optional: Holds back state copies until exit is called.
 }
 
 type FSM struct {
@@ -53,10 +60,18 @@ func NewFSM(opts FSMOpts) raft.FSM {
 
 // Apply Implements raft.FSM interface
 func (fsm *FSM) Apply(log *raft.Log) interface{} {
+	if fsm.options.Applied != nil {
+		defer fsm.options.Applied(log.Index)
+	}
+
 	switch log.Type {
 	default:
 		// No-Op
 	case raft.LogCommand:
+		if len(log.Data) > 0 && log.Data[0] == batchFormat {
+			return fsm.applyBatch(log.Data)
+		}
+
 		var request internal.ApplyRequest
 
 		if err := json.Unmarshal(log.Data, &request); err != nil {
@@ -66,10 +81,7 @@ func (fsm *FSM) Apply(log *raft.Log) interface{} {
 			}
 		}
 
-		ctx := context.WithValue(context.Background(), internal.ContextServerID("ServerID"), request.ServerID)
-		ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), request.ConnectionID)
-		ctx = context.WithValue(ctx, "Protocol", request.Protocol)
-		ctx = context.WithValue(ctx, "Database", request.Database)
+		ctx := requestContext(request)
 
 		switch strings.ToLower(request.Type) {
 		default:
@@ -90,55 +102,120 @@ func (fsm *FSM) Apply(log *raft.Log) interface{} {
 				Response: []byte("OK"),
 			}
 
-		case "command":
-			// Handle command
-			command, err := fsm.options.GetCommand(request.CMD[0])
-			if err != nil {
+		case "slots":
+			if err := fsm.options.ApplySlots(request.CMD); err != nil {
 				return internal.ApplyResponse{
 					Error:    err,
 					Response: nil,
 				}
 			}
+			return internal.ApplyResponse{
+				Error:    nil,
+				Response: []byte("OK"),
+			}
 
-			handler := command.HandlerFunc
-
-			sc, err := internal.GetSubCommand(command, request.CMD)
-			if err != nil {
+		case "import-keys":
+			// Set the keys migrated from another shard.
+			if err := fsm.options.ImportKeys(ctx, request.Data); err != nil {
 				return internal.ApplyResponse{
 					Error:    err,
 					Response: nil,
 				}
 			}
-			subCommand, ok := sc.(internal.SubCommand)
-			if ok {
-				handler = subCommand.HandlerFunc
+			return internal.ApplyResponse{
+				Error:    nil,
+				Response: []byte("OK"),
 			}
 
-			if res, err := handler(fsm.options.GetHandlerFuncParams(ctx, request.CMD, nil)); err != nil {
-				return internal.ApplyResponse{
-					Error:    err,
-					Response: nil,
-				}
-			} else {
-				return internal.ApplyResponse{
-					Error:    nil,
-					Response: res,
-				}
-			}
+		case "command":
+			return fsm.applyCommand(ctx, request.CMD)
 		}
 	}
 
 	return nil
 }
 
+// applyBatch applies the commands of a batch log entry in order and returns the response of each command.
+func (fsm *FSM) applyBatch(data []byte) interface{} {
+	requests, err := decodeBatch(data)
+	if err != nil {
+		return internal.ApplyResponse{
+			Error:    err,
+			Response: nil,
+		}
+	}
+	responses := make(BatchResponse, len(requests))
+	for i, request := range requests {
+		responses[i] = fsm.applyCommand(requestContext(request), request.CMD)
+	}
+	return responses
+}
+
+func (fsm *FSM) applyCommand(ctx context.Context, cmd []string) internal.ApplyResponse {
+	command, err := fsm.options.GetCommand(cmd[0])
+	if err != nil {
+		return internal.ApplyResponse{
+			Error:    err,
+			Response: nil,
+		}
+	}
+
+	handler := command.HandlerFunc
+
+	sc, err := internal.GetSubCommand(command, cmd)
+	if err != nil {
+		return internal.ApplyResponse{
+			Error:    err,
+			Response: nil,
+		}
+	}
+	subCommand, ok := sc.(internal.SubCommand)
+	if ok {
+		handler = subCommand.HandlerFunc
+	}
+
+	if fsm.options.EnterWrite != nil && internal.IsWriteCommand(command, subCommand) {
+		// The handler may change values in place, so a state copy must not be taken while it runs.
+		defer fsm.options.EnterWrite()()
+	}
+
+	res, err := handler(fsm.options.GetHandlerFuncParams(ctx, cmd, nil))
+	if fsm.options.AccountWrite != nil && internal.IsWriteCommand(command, subCommand) {
+		fsm.options.AccountWrite(ctx, cmd)
+	}
+	if err != nil {
+		return internal.ApplyResponse{
+			Error:    err,
+			Response: nil,
+		}
+	}
+	return internal.ApplyResponse{
+		Error:    nil,
+		Response: res,
+	}
+}
+
+func requestContext(request internal.ApplyRequest) context.Context {
+	ctx := context.WithValue(context.Background(), internal.ContextServerID("ServerID"), request.ServerID)
+	ctx = context.WithValue(ctx, internal.ContextConnID("ConnectionID"), request.ConnectionID)
+	ctx = context.WithValue(ctx, "Protocol", request.Protocol)
+	ctx = context.WithValue(ctx, "Database", request.Database)
+	return ctx
+}
+
 // Snapshot implements raft.FSM interface
 func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	data, err := fsm.options.GetState()
+	if err != nil {
+		return nil, err
+	}
 	return NewFSMSnapshot(SnapshotOpts{
 		config:                fsm.options.Config,
 		startSnapshot:         fsm.options.StartSnapshot,
 		finishSnapshot:        fsm.options.FinishSnapshot,
 		setLatestSnapshotTime: fsm.options.SetLatestSnapshotTime,
-		data:                  fsm.options.GetState(),
+		data:                  data,
+		slots:                 fsm.options.GetSlotState(),
 	}), nil
 }
 
@@ -175,5 +252,15 @@ func (fsm *FSM) Restore(snapshot io.ReadCloser) error {
 	// Set latest snapshot milliseconds.
 	fsm.options.SetLatestSnapshotTime(data.LatestSnapshotMilliseconds)
 
+	if len(data.Slots) > 0 {
+		if err = fsm.options.RestoreSlotState(data.Slots); err != nil {
+			return err
+		}
+	}
+
+	if fsm.options.Applied != nil {
+		fsm.options.Applied(data.LatestSnapshotIndex)
+	}
+
 	return nil
 }
@@ -27,6 +27,7 @@ import (
 type SnapshotOpts struct {
 	config                config.Config
 	data                  map[int]map[string]internal.KeyData
+	slots                 json.RawMessage
 	startSnapshot         func()
 	finishSnapshot        func()
 	setLatestSnapshotTime func(msec int64)
@@ -46,7 +47,14 @@ func NewFSMSnapshot(opts SnapshotOpts) *Snapshot {
 func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	s.options.startSnapshot()
 
-	msec, err := strconv.Atoi(strings.Split(sink.ID(), "-")[2])
+	// The snapshot ID has the format term-index-msec.
+	id := strings.Split(sink.ID(), "-")
+	msec, err := strconv.Atoi(id[2])
+	if err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	index, err := strconv.ParseUint(id[1], 10, 64)
 	if err != nil {
 		_ = sink.Cancel()
 		return err
@@ -55,6 +63,8 @@ func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	snapshotObject := internal.SnapshotObject{
 		State:                      internal.FilterExpiredKeys(time.Now(), s.options.data),
 		LatestSnapshotMilliseconds: int64(msec),
+		LatestSnapshotIndex:        index,
+		Slots:                      s.options.slots,
 	}
 
 	o, err := json.Marshal(snapshotObject)
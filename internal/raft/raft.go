@@ -16,15 +16,19 @@ package raft
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/config"
+	"github.com/echovault/sugardb/internal/encryption"
 	"github.com/echovault/sugardb/internal/memberlist"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -35,23 +39,39 @@ type Opts struct {
 	Config                config.Config
 	SetValues             func(ctx context.Context, entries map[string]interface{}) error
 	SetExpiry             func(ctx context.Context, key string, expire time.Time, touch bool)
-	GetState              func() map[int]map[string]internal.KeyData
+	GetState              func() (map[int]map[string]internal.KeyData, error)
 	GetCommand            func(command string) (internal.Command, error)
 	DeleteKey             func(ctx context.Context, key string) error
 	StartSnapshot         func()
 	FinishSnapshot        func()
 	SetLatestSnapshotTime func(msec int64)
 	GetHandlerFuncParams  func(ctx context.Context, cmd []string, conn *net.Conn) internal.HandlerFuncParams
+	Keyring               *encryption.Keyring // Optional: Encrypts the raft log entries and snapshots when set.
+	ApplySlots            func(cmd []string) error
+	GetSlotState          func() json.RawMessage
+	RestoreSlotState      func(state json.RawMessage) error
+	ImportKeys            func(ctx context.Context, data []byte) error
+	AccountWrite          func(ctx context.Context, cmd []string) // Optional: Recounts the keys written by a command.
+	EnterWrite            func() (exit func())                    // Optional: Holds back state copies during a write.
 }
 
 type Raft struct {
 	options Opts
 	raft    *raft.Raft
+	logs    raft.LogStore
+
+	appliedMut     sync.Mutex
+	applied        uint64        // The index of the latest log entry applied to the local FSM.
+	appliedUpdated chan struct{} // Closed and replaced whenever a log entry is applied to the local FSM.
+
+	batcher *batcher // Coalesces concurrent commands into batch log entries.
 }
 
 func NewRaft(opts Opts) *Raft {
 	return &Raft{
-		options: opts,
+		options:        opts,
+		appliedMut:     sync.Mutex{},
+		appliedUpdated: make(chan struct{}),
 	}
 }
 
@@ -78,7 +98,11 @@ func (r *Raft) RaftInit(ctx context.Context) {
 			log.Fatal(err)
 		}
 
-		logStore, err = raft.NewLogCache(512, boltdb)
+		logStore = boltdb
+		if r.options.Keyring != nil {
+			logStore = &encryptedLogStore{LogStore: boltdb, keyring: r.options.Keyring}
+		}
+		logStore, err = raft.NewLogCache(512, logStore)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -89,6 +113,9 @@ func (r *Raft) RaftInit(ctx context.Context) {
 		if err != nil {
 			log.Fatal(err)
 		}
+		if r.options.Keyring != nil {
+			snapshotStore = &encryptedSnapshotStore{SnapshotStore: snapshotStore, keyring: r.options.Keyring}
+		}
 	}
 
 	bindAddr := fmt.Sprintf("%s:%d", conf.RaftBindAddr, conf.RaftBindPort)
@@ -97,16 +124,24 @@ func (r *Raft) RaftInit(ctx context.Context) {
 		log.Fatal(err)
 	}
 
-	raftTransport, err := raft.NewTCPTransport(
-		bindAddr,
-		advertiseAddr,
-		10,
-		5*time.Second,
-		os.Stdout,
-	)
-
-	if err != nil {
-		log.Fatal(err)
+	var raftTransport raft.Transport
+	if conf.RaftTLS || conf.RaftMTLS {
+		streamLayer, err := newTLSStreamLayer(conf, bindAddr, advertiseAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		raftTransport = raft.NewNetworkTransport(streamLayer, 10, 5*time.Second, os.Stdout)
+	} else {
+		raftTransport, err = raft.NewTCPTransport(
+			bindAddr,
+			advertiseAddr,
+			10,
+			5*time.Second,
+			os.Stdout,
+		)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Start raft echovault
@@ -123,6 +158,13 @@ func (r *Raft) RaftInit(ctx context.Context) {
 			FinishSnapshot:        r.options.FinishSnapshot,
 			SetLatestSnapshotTime: r.options.SetLatestSnapshotTime,
 			GetHandlerFuncParams:  r.options.GetHandlerFuncParams,
+			Applied:               r.setApplied,
+			ApplySlots:            r.options.ApplySlots,
+			GetSlotState:          r.options.GetSlotState,
+			RestoreSlotState:      r.options.RestoreSlotState,
+			ImportKeys:            r.options.ImportKeys,
+			AccountWrite:          r.options.AccountWrite,
+			EnterWrite:            r.options.EnterWrite,
 		}),
 		logStore,
 		stableStore,
@@ -148,12 +190,20 @@ func (r *Raft) RaftInit(ctx context.Context) {
 	}
 
 	r.raft = raftServer
+	r.logs = logStore
+	r.batcher = newBatcher(raftServer, conf.RaftBatchWindow, int(conf.RaftBatchSize), conf.RaftApplyTimeout)
 }
 
 func (r *Raft) Apply(cmd []byte, timeout time.Duration) raft.ApplyFuture {
 	return r.raft.Apply(cmd, timeout)
 }
 
+// ApplyCommand appends the command request to the raft log as part of a batch of concurrent commands.
+// It returns the response of the command and the index of the batch's log entry once the entry is applied.
+func (r *Raft) ApplyCommand(ctx context.Context, request internal.ApplyRequest) (internal.ApplyResponse, uint64, error) {
+	return r.batcher.apply(ctx, request)
+}
+
 func (r *Raft) IsRaftLeader() bool {
 	return r.raft.State() == raft.Leader
 }
@@ -162,6 +212,102 @@ func (r *Raft) isRaftFollower() bool {
 	return r.raft.State() == raft.Follower
 }
 
+// AppliedIndex returns the index of the latest raft log entry applied to the local FSM.
+func (r *Raft) AppliedIndex() uint64 {
+	return r.raft.AppliedIndex()
+}
+
+func (r *Raft) setApplied(index uint64) {
+	r.appliedMut.Lock()
+	defer r.appliedMut.Unlock()
+	if index > r.applied {
+		r.applied = index
+		close(r.appliedUpdated)
+		r.appliedUpdated = make(chan struct{})
+	}
+}
+
+// WaitForAppliedIndex blocks until the log entry at the provided index has been applied to the local FSM,
+// or until the context is done.
+func (r *Raft) WaitForAppliedIndex(ctx context.Context, index uint64) error {
+	// Entries that never reach the FSM, like the no-op appended after an election or membership changes,
+	// don't notify the waiters, so the raft applied index is also polled.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.appliedMut.Lock()
+		applied, updated := r.applied, r.appliedUpdated
+		r.appliedMut.Unlock()
+		if applied >= index || r.appliedThrough(applied, index) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-updated:
+		case <-ticker.C:
+		}
+	}
+}
+
+// appliedThrough returns true when raft has processed every log entry up to index, and none of the entries
+// after the latest entry applied to the FSM is a command that the FSM has yet to apply.
+func (r *Raft) appliedThrough(applied, index uint64) bool {
+	if r.raft.AppliedIndex() < index {
+		return false
+	}
+	for i := applied + 1; i <= index; i++ {
+		var entry raft.Log
+		if err := r.logs.GetLog(i, &entry); err != nil || entry.Type == raft.LogCommand {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadIndex confirms that the node is still the cluster leader and returns its commit index.
+// Once the commit index has been applied to a node's FSM, reads served by that node are linearizable.
+func (r *Raft) ReadIndex() (uint64, error) {
+	index := r.raft.CommitIndex()
+	if err := r.raft.VerifyLeader().Error(); err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// Term returns the current raft term.
+func (r *Raft) Term() uint64 {
+	term, _ := strconv.ParseUint(r.raft.Stats()["term"], 10, 64)
+	return term
+}
+
+// LeaderID returns the ID of the current cluster leader, or an empty ID if there's no known leader.
+func (r *Raft) LeaderID() raft.ServerID {
+	_, id := r.raft.LeaderWithID()
+	return id
+}
+
+// LastIndex returns the index of the latest raft log entry stored locally.
+func (r *Raft) LastIndex() uint64 {
+	return r.raft.LastIndex()
+}
+
+// Followers returns the IDs of all the servers in the raft configuration except the current node.
+func (r *Raft) Followers() ([]raft.ServerID, error) {
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, errors.New("could not retrieve raft config")
+	}
+	var followers []raft.ServerID
+	for _, server := range future.Configuration().Servers {
+		if server.ID == raft.ServerID(r.options.Config.ServerID) {
+			continue
+		}
+		followers = append(followers, server.ID)
+	}
+	return followers, nil
+}
+
 func (r *Raft) HasJoinedCluster() bool {
 	isFollower := r.isRaftFollower()
 
@@ -184,8 +330,9 @@ func (r *Raft) AddVoter(
 		}
 
 		for _, s := range raftConfig.Configuration().Servers {
-			// Check if a node already exists with the current attributes.
-			if s.ID == id && s.Address == address {
+			// Check if a voter already exists with the current attributes.
+			// An existing non-voter with the same attributes is promoted to a voter.
+			if s.ID == id && s.Address == address && s.Suffrage == raft.Voter {
 				return fmt.Errorf("node with id %s and address %s already exists", id, address)
 			}
 		}
@@ -211,11 +358,113 @@ func (r *Raft) RemoveServer(meta memberlist.NodeMeta) error {
 	return nil
 }
 
+// State returns the raft state of the local node, e.g. "Leader", "Follower" or "Candidate".
+func (r *Raft) State() string {
+	return r.raft.State().String()
+}
+
+// CommitIndex returns the index of the latest raft log entry known to be committed.
+func (r *Raft) CommitIndex() uint64 {
+	return r.raft.CommitIndex()
+}
+
+// Servers returns the servers in the latest raft configuration, including the current node.
+func (r *Raft) Servers() ([]raft.Server, error) {
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, errors.New("could not retrieve raft config")
+	}
+	return future.Configuration().Servers, nil
+}
+
+// server returns the server with the provided ID from the latest raft configuration.
+func (r *Raft) server(id raft.ServerID) (raft.Server, error) {
+	servers, err := r.Servers()
+	if err != nil {
+		return raft.Server{}, err
+	}
+	for _, server := range servers {
+		if server.ID == id {
+			return server, nil
+		}
+	}
+	return raft.Server{}, fmt.Errorf("unknown node %s", id)
+}
+
+// AddNonvoter adds a server that receives the raft log without taking part in elections or commits.
+// Must be called on the leader.
+func (r *Raft) AddNonvoter(id raft.ServerID, address raft.ServerAddress) error {
+	if !r.IsRaftLeader() {
+		return memberlist.ErrNotLeader
+	}
+	if server, err := r.server(id); err == nil {
+		return fmt.Errorf("node with id %s and address %s already exists", server.ID, server.Address)
+	}
+	return r.raft.AddNonvoter(id, address, 0, 0).Error()
+}
+
+// Promote turns the non-voter with the provided ID into a voter. Must be called on the leader.
+func (r *Raft) Promote(id raft.ServerID) error {
+	if !r.IsRaftLeader() {
+		return memberlist.ErrNotLeader
+	}
+	server, err := r.server(id)
+	if err != nil {
+		return err
+	}
+	if server.Suffrage == raft.Voter {
+		return fmt.Errorf("node %s is already a voter", id)
+	}
+	return r.raft.AddVoter(server.ID, server.Address, 0, 0).Error()
+}
+
+// RemoveServerByID removes the server with the provided ID from the raft configuration.
+// Must be called on the leader.
+func (r *Raft) RemoveServerByID(id raft.ServerID) error {
+	if !r.IsRaftLeader() {
+		return memberlist.ErrNotLeader
+	}
+	if id == raft.ServerID(r.options.Config.ServerID) {
+		return errors.New("cannot remove the current node")
+	}
+	if _, err := r.server(id); err != nil {
+		return err
+	}
+	return r.raft.RemoveServer(id, 0, 0).Error()
+}
+
+// TransferLeadership hands over leadership to the voter with the provided ID.
+// When the ID is empty, the most up-to-date voter is picked. Must be called on the leader.
+func (r *Raft) TransferLeadership(id raft.ServerID) error {
+	if !r.IsRaftLeader() {
+		return memberlist.ErrNotLeader
+	}
+	if id == "" {
+		return r.raft.LeadershipTransfer().Error()
+	}
+	if id == raft.ServerID(r.options.Config.ServerID) {
+		return errors.New("node is already the cluster leader")
+	}
+	server, err := r.server(id)
+	if err != nil {
+		return err
+	}
+	if server.Suffrage != raft.Voter {
+		return fmt.Errorf("node %s is not a voter", id)
+	}
+	return r.raft.LeadershipTransferToServer(server.ID, server.Address).Error()
+}
+
 func (r *Raft) TakeSnapshot() error {
 	return r.raft.Snapshot().Error()
 }
 
 func (r *Raft) RaftShutdown() {
+	// Stop batching commands, which stops the goroutine of the batcher once the queued commands are committed.
+	if r.batcher != nil {
+		defer r.batcher.close()
+	}
+
 	// Leadership transfer if current node is the leader.
 	if r.IsRaftLeader() {
 		err := r.raft.LeadershipTransfer().Error()
@@ -0,0 +1,212 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/echovault/sugardb/internal/config"
+	"github.com/hashicorp/raft"
+)
+
+// tlsCertificates holds the certificates and certificate authorities used by the raft TLS stream layer.
+// The files are reloaded on the next handshake after any of them is modified, so certificates can be rotated
+// without restarting the node. While rotating the certificate authority, both the old and the new authority
+// should be configured until every node uses a certificate signed by the new one.
+type tlsCertificates struct {
+	certKeyPairs [][]string
+	caFiles      []string
+
+	mut     sync.Mutex
+	modTime time.Time // The latest modification time of the loaded files.
+	certs   []tls.Certificate
+	pool    *x509.CertPool // Nil when no certificate authority is configured, in which case the system pool is used.
+}
+
+func newTLSCertificates(certKeyPairs [][]string, caFiles []string) (*tlsCertificates, error) {
+	if len(certKeyPairs) == 0 {
+		return nil, errors.New("must provide certificate and key file paths for raft TLS")
+	}
+	c := &tlsCertificates{certKeyPairs: certKeyPairs, caFiles: caFiles}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// current returns the loaded certificates and certificate authorities, reloading them first if a file changed.
+// If the reload fails, the previously loaded files are kept.
+func (c *tlsCertificates) current() ([]tls.Certificate, *x509.CertPool) {
+	if err := c.reload(); err != nil {
+		log.Printf("raft tls reload: %v\n", err)
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.certs, c.pool
+}
+
+func (c *tlsCertificates) reload() error {
+	var modTime time.Time
+	for _, file := range append(append([]string{}, c.caFiles...), flatten(c.certKeyPairs)...) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if !modTime.After(c.modTime) {
+		return nil
+	}
+
+	certs := make([]tls.Certificate, 0, len(c.certKeyPairs))
+	for _, pair := range c.certKeyPairs {
+		cert, err := tls.LoadX509KeyPair(pair[0], pair[1])
+		if err != nil {
+			return fmt.Errorf("load raft certificate %s: %v", pair[0], err)
+		}
+		certs = append(certs, cert)
+	}
+
+	var pool *x509.CertPool
+	if len(c.caFiles) > 0 {
+		pool = x509.NewCertPool()
+		for _, file := range c.caFiles {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return fmt.Errorf("no certificates found in raft CA %s", file)
+			}
+		}
+	}
+
+	c.certs, c.pool, c.modTime = certs, pool, modTime
+	return nil
+}
+
+func flatten(pairs [][]string) []string {
+	var files []string
+	for _, pair := range pairs {
+		files = append(files, pair...)
+	}
+	return files
+}
+
+// tlsStreamLayer is a raft stream layer that encrypts the connections between raft nodes with TLS.
+// With mTLS, nodes only accept connections from nodes presenting a certificate signed by a trusted authority.
+type tlsStreamLayer struct {
+	listener   net.Listener
+	advertise  net.Addr
+	serverName string
+	mtls       bool
+	certs      *tlsCertificates
+}
+
+func newTLSStreamLayer(conf config.Config, bindAddr string, advertise net.Addr) (*tlsStreamLayer, error) {
+	certs, err := newTLSCertificates(conf.RaftCertKeyPairs, conf.RaftCAs)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &tlsStreamLayer{
+		advertise:  advertise,
+		serverName: conf.RaftTLSServerName,
+		mtls:       conf.RaftMTLS,
+		certs:      certs,
+	}
+
+	listener, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	layer.listener = tls.NewListener(listener, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return layer.serverConfig(), nil
+		},
+	})
+
+	return layer, nil
+}
+
+func (layer *tlsStreamLayer) serverConfig() *tls.Config {
+	certs, pool := layer.certs.current()
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: certs,
+		ClientAuth:   tls.NoClientCert,
+	}
+	if layer.mtls {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conf.ClientCAs = pool
+		if pool == nil {
+			// Without authorities, the system roots would be trusted. Reject every client instead.
+			conf.ClientCAs = x509.NewCertPool()
+		}
+	}
+	return conf
+}
+
+func (layer *tlsStreamLayer) clientConfig(address string) *tls.Config {
+	certs, pool := layer.certs.current()
+	serverName := layer.serverName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(address)
+	}
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    pool,
+		ServerName: serverName,
+	}
+	if layer.mtls {
+		conf.Certificates = certs
+	}
+	return conf
+}
+
+// Accept waits for and returns the next TLS connection from another raft node.
+func (layer *tlsStreamLayer) Accept() (net.Conn, error) {
+	return layer.listener.Accept()
+}
+
+// Close closes the listener.
+func (layer *tlsStreamLayer) Close() error {
+	return layer.listener.Close()
+}
+
+// Addr returns the address advertised to the other raft nodes.
+func (layer *tlsStreamLayer) Addr() net.Addr {
+	return layer.advertise
+}
+
+// Dial opens a TLS connection to another raft node.
+func (layer *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), layer.clientConfig(string(address)))
+}
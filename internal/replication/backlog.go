@@ -0,0 +1,265 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replication implements asynchronous primary/replica replication for standalone instances.
+//
+// The primary keeps a backlog of the replication stream, which is the sequence of RESP encoded write commands
+// it has executed, with a SELECT command logged whenever the database changes. Each position in the stream
+// is identified by the replication ID of the primary and a byte offset.
+//
+// A replica connects to the primary and sends PSYNC with the replication ID and offset it has processed:
+//
+//	PSYNC <replication-id> <offset>
+//
+// If the primary's backlog still holds the stream from that offset, it replies with +CONTINUE and streams
+// the commands from the offset (partial resynchronisation):
+//
+//	+CONTINUE <replication-id>
+//
+// Otherwise, it replies with +FULLRESYNC followed by a snapshot of its state, encoded as a backup archive,
+// and then streams the commands written after the snapshot was taken:
+//
+//	+FULLRESYNC <replication-id> <offset> <database>
+//	$<length>
+//	<archive>
+//
+// When the primary requires authentication, the replica sends AUTH before PSYNC. Once the stream starts,
+// the replica reports the offset it has applied, which the primary uses to answer WAIT:
+//
+//	REPLCONF ACK <offset>
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/echovault/sugardb/internal"
+)
+
+var (
+	// ErrBacklogReset is returned when a stream is interrupted because the backlog was reset by a full
+	// resynchronisation with a new primary.
+	ErrBacklogReset = errors.New("replication backlog was reset")
+	// ErrOffsetNotInBacklog is returned when the requested stream offset is no longer held by the backlog.
+	ErrOffsetNotInBacklog = errors.New("offset is not in the replication backlog")
+)
+
+// NewReplicationID returns a random 40 character replication ID.
+func NewReplicationID() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Backlog is a fixed size circular buffer holding the latest bytes of the replication stream.
+type Backlog struct {
+	mut        sync.Mutex
+	id         string        // The current replication ID.
+	prevID     string        // The replication ID of the previous primary, before this node was promoted.
+	prevOffset uint64        // The offset up to which the previous replication ID is valid.
+	start      uint64        // The offset of the oldest byte in the buffer.
+	offset     uint64        // The offset of the end of the stream.
+	database   int           // The database selected at the end of the stream. -1 forces the next write to SELECT.
+	generation uint64        // Incremented whenever the backlog is reset.
+	size       uint64        // The capacity of the buffer.
+	buf        []byte        // Allocated when the first replica connects, or when the node becomes a replica.
+	updated    chan struct{} // Closed and replaced whenever the stream grows or the backlog is reset.
+}
+
+// NewBacklog returns a backlog that holds up to size bytes of the replication stream.
+func NewBacklog(size uint64) *Backlog {
+	if size == 0 {
+		size = 1024 * 1024
+	}
+	return &Backlog{
+		id:       NewReplicationID(),
+		database: -1,
+		size:     size,
+		updated:  make(chan struct{}),
+	}
+}
+
+// Activate allocates the buffer. Writes made before the backlog is activated are not recorded.
+func (backlog *Backlog) Activate() {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	if backlog.buf == nil {
+		backlog.buf = make([]byte, backlog.size)
+		backlog.start = backlog.offset
+	}
+}
+
+// Position returns the replication ID, the offset of the end of the stream and the database selected
+// at the end of the stream.
+func (backlog *Backlog) Position() (string, uint64, int) {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	database := backlog.database
+	if database < 0 {
+		database = 0
+	}
+	return backlog.id, backlog.offset, database
+}
+
+// Write appends the command executed on the database to the stream.
+// A SELECT command is written first if the database differs from the one selected at the end of the stream.
+// It returns the offset of the end of the stream, or 0 when the backlog is not activated.
+func (backlog *Backlog) Write(database int, command []byte) uint64 {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	if backlog.buf == nil {
+		return 0
+	}
+	if database != backlog.database {
+		backlog.append(internal.EncodeCommand([]string{"SELECT", strconv.Itoa(database)}))
+		backlog.database = database
+	}
+	backlog.append(command)
+	backlog.notify()
+	return backlog.offset
+}
+
+// Append appends bytes received from the primary to the stream. The database is the database selected
+// after the bytes are processed.
+func (backlog *Backlog) Append(data []byte, database int) {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	if backlog.buf == nil {
+		backlog.buf = make([]byte, backlog.size)
+		backlog.start = backlog.offset
+	}
+	backlog.append(data)
+	backlog.database = database
+	backlog.notify()
+}
+
+// Reset discards the content of the backlog and continues the stream of another primary from the offset.
+// Streams served from the backlog are interrupted.
+func (backlog *Backlog) Reset(id string, offset uint64, database int) {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	if backlog.buf == nil {
+		backlog.buf = make([]byte, backlog.size)
+	}
+	backlog.id, backlog.prevID, backlog.prevOffset = id, "", 0
+	backlog.start, backlog.offset = offset, offset
+	backlog.database = database
+	backlog.generation += 1
+	backlog.notify()
+}
+
+// Shift replaces the replication ID, while keeping the previous ID valid up to the current offset.
+// This allows the replicas of a former primary to partially resynchronise with a promoted replica.
+func (backlog *Backlog) Shift(id string) {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	if id == backlog.id {
+		return
+	}
+	backlog.prevID, backlog.prevOffset = backlog.id, backlog.offset
+	backlog.id = id
+}
+
+// CanContinue returns true if the stream identified by the replication ID can be continued from the offset.
+func (backlog *Backlog) CanContinue(id string, offset uint64) bool {
+	backlog.mut.Lock()
+	defer backlog.mut.Unlock()
+	if backlog.buf == nil {
+		return false
+	}
+	if id != backlog.id && (id != backlog.prevID || offset > backlog.prevOffset) {
+		return false
+	}
+	return backlog.holds(offset)
+}
+
+// Stream writes the stream from the offset to w, waiting for new writes once the end of the stream is reached.
+// It returns when the context is done, when writing to w fails, when the offset is overwritten before it could
+// be sent, or when the backlog is reset. The sent function is called with the offset of the data sent so far.
+func (backlog *Backlog) Stream(ctx context.Context, w io.Writer, offset uint64, sent func(offset uint64)) error {
+	backlog.mut.Lock()
+	generation := backlog.generation
+	backlog.mut.Unlock()
+
+	for {
+		backlog.mut.Lock()
+		if backlog.generation != generation {
+			backlog.mut.Unlock()
+			return ErrBacklogReset
+		}
+		if !backlog.holds(offset) {
+			backlog.mut.Unlock()
+			return ErrOffsetNotInBacklog
+		}
+		data := backlog.read(offset)
+		updated := backlog.updated
+		backlog.mut.Unlock()
+
+		if len(data) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-updated:
+				continue
+			}
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		offset += uint64(len(data))
+		if sent != nil {
+			sent(offset)
+		}
+	}
+}
+
+// holds returns true if the data from the offset to the end of the stream is in the buffer.
+func (backlog *Backlog) holds(offset uint64) bool {
+	return offset >= backlog.start && offset <= backlog.offset
+}
+
+func (backlog *Backlog) append(data []byte) {
+	// Only the last size bytes of the data fit in the buffer.
+	if uint64(len(data)) > backlog.size {
+		backlog.offset += uint64(len(data)) - backlog.size
+		data = data[uint64(len(data))-backlog.size:]
+	}
+	pos := backlog.offset % backlog.size
+	n := copy(backlog.buf[pos:], data)
+	copy(backlog.buf, data[n:])
+	backlog.offset += uint64(len(data))
+	if backlog.offset-backlog.start > backlog.size {
+		backlog.start = backlog.offset - backlog.size
+	}
+}
+
+// read returns a copy of the data from the offset to the end of the stream.
+func (backlog *Backlog) read(offset uint64) []byte {
+	data := make([]byte, backlog.offset-offset)
+	pos := offset % backlog.size
+	n := copy(data, backlog.buf[pos:])
+	copy(data[n:], backlog.buf)
+	return data
+}
+
+func (backlog *Backlog) notify() {
+	close(backlog.updated)
+	backlog.updated = make(chan struct{})
+}
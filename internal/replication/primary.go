@@ -0,0 +1,75 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// Snapshot is a point-in-time copy of the primary's state along with the matching stream position.
+type Snapshot struct {
+	ID       string // The replication ID at the time of the copy.
+	Offset   uint64 // The stream offset at the time of the copy.
+	Database int    // The database selected in the stream at the time of the copy.
+	// Write writes the copy of the state to w as a backup archive.
+	Write func(w io.Writer) error
+}
+
+// Serve answers a PSYNC request for the stream identified by the replication ID, from the offset.
+// If the backlog can't continue the stream, a full resynchronisation is sent using the snapshot function.
+// Serve then streams new writes to w until the context is done or the link is broken.
+func Serve(
+	ctx context.Context,
+	w io.Writer,
+	backlog *Backlog,
+	id string,
+	offset uint64,
+	snapshot func() (Snapshot, error),
+	sent func(offset uint64),
+) error {
+	backlog.Activate()
+
+	if backlog.CanContinue(id, offset) {
+		current, _, _ := backlog.Position()
+		if _, err := fmt.Fprintf(w, "+CONTINUE %s\r\n", current); err != nil {
+			return err
+		}
+		return backlog.Stream(ctx, w, offset, sent)
+	}
+
+	s, err := snapshot()
+	if err != nil {
+		return err
+	}
+	// Encode the archive before sending it, as the length has to be sent first.
+	buf := new(bytes.Buffer)
+	if err = s.Write(buf); err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(w, "+FULLRESYNC %s %d %d\r\n$%d\r\n", s.ID, s.Offset, s.Database, buf.Len()); err != nil {
+		return err
+	}
+	if _, err = buf.WriteTo(w); err != nil {
+		return err
+	}
+	if sent != nil {
+		sent(s.Offset)
+	}
+
+	return backlog.Stream(ctx, w, s.Offset, sent)
+}
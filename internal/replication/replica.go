@@ -0,0 +1,365 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/tidwall/resp"
+)
+
+// The states of the link between a replica and its primary, as reported by the ROLE command.
+const (
+	StateConnect    = "connect"    // Waiting to reconnect to the primary.
+	StateConnecting = "connecting" // Connecting to the primary and negotiating the resynchronisation.
+	StateSync       = "sync"       // Receiving the snapshot of a full resynchronisation.
+	StateConnected  = "connected"  // Receiving the live stream of write commands.
+)
+
+// Stream records the position of the stream processed by a replica.
+// It's implemented by Backlog, which also keeps the stream so that other replicas can be chained, and by Cursor.
+type Stream interface {
+	// Position returns the replication ID, offset and selected database at the end of the processed stream.
+	Position() (string, uint64, int)
+	// Append records the data processed from the stream and the database selected after it.
+	Append(data []byte, database int)
+	// Reset continues the stream of another primary from the offset.
+	Reset(id string, offset uint64, database int)
+	// Shift replaces the replication ID of the stream.
+	Shift(id string)
+}
+
+// Cursor is a Stream that only records the position in the stream, without keeping its content.
+type Cursor struct {
+	mut      sync.Mutex
+	id       string
+	offset   uint64
+	database int
+}
+
+// NewCursor returns a cursor positioned before the start of any stream.
+func NewCursor() *Cursor {
+	return &Cursor{id: "?"}
+}
+
+func (cursor *Cursor) Position() (string, uint64, int) {
+	cursor.mut.Lock()
+	defer cursor.mut.Unlock()
+	return cursor.id, cursor.offset, cursor.database
+}
+
+func (cursor *Cursor) Append(data []byte, database int) {
+	cursor.mut.Lock()
+	defer cursor.mut.Unlock()
+	cursor.offset += uint64(len(data))
+	cursor.database = database
+}
+
+func (cursor *Cursor) Reset(id string, offset uint64, database int) {
+	cursor.mut.Lock()
+	defer cursor.mut.Unlock()
+	cursor.id, cursor.offset, cursor.database = id, offset, database
+}
+
+func (cursor *Cursor) Shift(id string) {
+	cursor.mut.Lock()
+	defer cursor.mut.Unlock()
+	cursor.id = id
+}
+
+// ReplicaOpts holds the functions the replica uses to update the local state.
+type ReplicaOpts struct {
+	// Backlog records the stream received from the primary. When it's a Backlog, the stream is kept so that
+	// other replicas can be chained to this one. The position is used to continue the stream after a reconnection.
+	Backlog Stream
+	// SyncCommand is the command sent to the primary to request the stream, followed by the replication ID and
+	// the offset. The default is PSYNC.
+	SyncCommand []string
+	// Locker is held while the local state and the backlog are updated, so that a state copy taken under the
+	// same lock always matches the backlog position.
+	Locker sync.Locker
+	// FullSync replaces the local state with the backup archive read from r.
+	FullSync func(r io.Reader) error
+	// ApplyCommand applies a write command received from the primary to the database.
+	ApplyCommand func(database int, command []string) error
+	// RetryInterval is the time to wait before reconnecting after the link to the primary is lost.
+	RetryInterval time.Duration
+	// Dial opens the connection to the primary at address. The default is a plain TCP connection.
+	Dial func(ctx context.Context, address string) (net.Conn, error)
+	// Auth is the AUTH command sent to the primary before the sync command. Nothing is sent when it's empty.
+	Auth []string
+}
+
+// ReplicaStatus describes the link between a replica and its primary.
+type ReplicaStatus struct {
+	Host   string
+	Port   int
+	State  string
+	Offset uint64 // The offset of the stream processed by the replica.
+}
+
+// Replica replicates the state of a primary.
+type Replica struct {
+	opts   ReplicaOpts
+	host   string
+	port   int
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mut   sync.Mutex
+	state string
+}
+
+// StartReplica starts replicating the primary at host:port in the background until Stop is called.
+func StartReplica(host string, port int, opts ReplicaOpts) *Replica {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+	if len(opts.SyncCommand) == 0 {
+		opts.SyncCommand = []string{"PSYNC"}
+	}
+	if opts.Dial == nil {
+		opts.Dial = func(ctx context.Context, address string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: 5 * time.Second}
+			return dialer.DialContext(ctx, "tcp", address)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	replica := &Replica{
+		opts:   opts,
+		host:   host,
+		port:   port,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		state:  StateConnect,
+	}
+	go replica.run(ctx)
+	return replica
+}
+
+// Stop closes the link to the primary and waits for the replica to stop applying commands.
+func (replica *Replica) Stop() {
+	replica.cancel()
+	<-replica.done
+}
+
+// Status returns the current status of the link to the primary.
+func (replica *Replica) Status() ReplicaStatus {
+	replica.mut.Lock()
+	state := replica.state
+	replica.mut.Unlock()
+	_, offset, _ := replica.opts.Backlog.Position()
+	return ReplicaStatus{Host: replica.host, Port: replica.port, State: state, Offset: offset}
+}
+
+func (replica *Replica) setState(state string) {
+	replica.mut.Lock()
+	defer replica.mut.Unlock()
+	replica.state = state
+}
+
+func (replica *Replica) run(ctx context.Context) {
+	defer close(replica.done)
+	for {
+		err := replica.sync(ctx)
+		replica.setState(StateConnect)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("replication link with %s:%d lost: %v\n", replica.host, replica.port, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replica.opts.RetryInterval):
+		}
+	}
+}
+
+// sync connects to the primary, resynchronises and applies the stream until the link is lost or
+// the context is done.
+func (replica *Replica) sync(ctx context.Context) error {
+	replica.setState(StateConnecting)
+
+	conn, err := replica.opts.Dial(ctx, net.JoinHostPort(replica.host, strconv.Itoa(replica.port)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	// Unblock reads from the connection when the replica is stopped.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	r := bufio.NewReader(conn)
+
+	if len(replica.opts.Auth) > 0 {
+		if _, err = conn.Write(internal.EncodeCommand(replica.opts.Auth)); err != nil {
+			return err
+		}
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if line != "+OK" {
+			return fmt.Errorf("authentication with the primary failed: %s", strings.TrimPrefix(line, "-"))
+		}
+	}
+
+	id, offset, database := replica.opts.Backlog.Position()
+	command := append(slices.Clone(replica.opts.SyncCommand), id, strconv.FormatUint(offset, 10))
+	if _, err = conn.Write(internal.EncodeCommand(command)); err != nil {
+		return err
+	}
+
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 4 && fields[0] == "+FULLRESYNC":
+		if database, err = replica.fullSync(r, fields[1:]); err != nil {
+			return fmt.Errorf("full resync: %v", err)
+		}
+	case len(fields) == 2 && fields[0] == "+CONTINUE":
+		// The primary may have been promoted since the last sync, in which case its replication ID changed.
+		replica.opts.Backlog.Shift(fields[1])
+	case strings.HasPrefix(line, "-"):
+		return errors.New(strings.TrimPrefix(line, "-"))
+	default:
+		return fmt.Errorf("unexpected %s response %q", command[0], line)
+	}
+
+	replica.setState(StateConnected)
+	if err = replica.ack(conn); err != nil {
+		return err
+	}
+
+	rd := resp.NewReader(r)
+	for {
+		value, _, err := rd.ReadValue()
+		if err != nil {
+			return err
+		}
+		var command []string
+		for _, token := range value.Array() {
+			command = append(command, token.String())
+		}
+		if len(command) == 0 {
+			continue
+		}
+		if database, err = replica.apply(database, command); err != nil {
+			return err
+		}
+		// Acknowledge once the received commands are applied, rather than after each one of a burst.
+		if r.Buffered() == 0 {
+			if err = replica.ack(conn); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ack reports the offset of the stream processed by the replica to the primary.
+func (replica *Replica) ack(w io.Writer) error {
+	_, offset, _ := replica.opts.Backlog.Position()
+	_, err := w.Write(internal.EncodeCommand([]string{"REPLCONF", "ACK", strconv.FormatUint(offset, 10)}))
+	return err
+}
+
+// fullSync loads the snapshot sent by the primary and resets the backlog to the primary's position.
+// The fields are the replication ID, offset and database from the +FULLRESYNC line.
+func (replica *Replica) fullSync(r *bufio.Reader, fields []string) (int, error) {
+	id := fields[0]
+	offset, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	database, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, err
+	}
+
+	replica.setState(StateSync)
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return 0, fmt.Errorf("unexpected snapshot header %q", line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	snapshot := io.LimitReader(r, size)
+	replica.opts.Locker.Lock()
+	defer replica.opts.Locker.Unlock()
+	if err = replica.opts.FullSync(snapshot); err != nil {
+		return 0, err
+	}
+	// Make sure the whole snapshot is consumed before reading the stream.
+	if _, err = io.Copy(io.Discard, snapshot); err != nil {
+		return 0, err
+	}
+	replica.opts.Backlog.Reset(id, offset, database)
+
+	return database, nil
+}
+
+// apply applies a command from the stream and appends it to the local backlog.
+// It returns the database selected after the command.
+func (replica *Replica) apply(database int, command []string) (int, error) {
+	replica.opts.Locker.Lock()
+	defer replica.opts.Locker.Unlock()
+
+	if strings.EqualFold(command[0], "SELECT") && len(command) == 2 {
+		db, err := strconv.Atoi(command[1])
+		if err != nil {
+			return database, fmt.Errorf("invalid SELECT in replication stream: %v", err)
+		}
+		database = db
+	} else if err := replica.opts.ApplyCommand(database, command); err != nil {
+		// The primary executed the command successfully, so failures are logged and the stream continues.
+		log.Printf("replication apply %s: %v\n", command[0], err)
+	}
+
+	replica.opts.Backlog.Append(internal.EncodeCommand(command), database)
+	return database, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
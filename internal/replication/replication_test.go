@@ -0,0 +1,139 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/replication"
+)
+
+func Test_Backlog(t *testing.T) {
+	backlog := replication.NewBacklog(64)
+	set := internal.EncodeCommand([]string{"SET", "a", "1"}) // 25 bytes.
+
+	// Writes are not recorded until the backlog is activated.
+	backlog.Write(0, set)
+	if _, offset, _ := backlog.Position(); offset != 0 {
+		t.Errorf("expected offset 0 before activation, got %d", offset)
+	}
+
+	backlog.Activate()
+	id, _, _ := backlog.Position()
+	backlog.Write(0, set)
+	selectLen := len(internal.EncodeCommand([]string{"SELECT", "0"}))
+	if _, offset, _ := backlog.Position(); offset != uint64(selectLen+len(set)) {
+		t.Errorf("expected the first write to select the database, got offset %d", offset)
+	}
+	if !backlog.CanContinue(id, uint64(selectLen)) {
+		t.Error("expected backlog to continue from the start of the command")
+	}
+	if backlog.CanContinue("other", uint64(selectLen)) {
+		t.Error("expected backlog not to continue a stream with another replication ID")
+	}
+
+	// Overflow the buffer so that the first command is overwritten.
+	backlog.Write(0, set)
+	backlog.Write(0, set)
+	_, end, _ := backlog.Position()
+	if backlog.CanContinue(id, uint64(selectLen)) {
+		t.Error("expected overwritten offset not to be continued")
+	}
+	if !backlog.CanContinue(id, end-uint64(len(set))) {
+		t.Error("expected the latest command to be continued")
+	}
+
+	// The previous replication ID is valid up to the offset where the ID was shifted.
+	backlog.Shift("new-id")
+	if !backlog.CanContinue(id, end) {
+		t.Error("expected the previous replication ID to be continued")
+	}
+	backlog.Write(0, set)
+	if !backlog.CanContinue("new-id", end) || backlog.CanContinue(id, end+uint64(len(set))) {
+		t.Error("expected the previous replication ID to be valid only up to the shift")
+	}
+
+	// The stream returns the data written from the offset, across the end of the buffer.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	buf := new(bytes.Buffer)
+	err := backlog.Stream(ctx, buf, end-uint64(len(set)), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected stream to end with the context, got %v", err)
+	}
+	if want := string(set) + string(set); buf.String() != want {
+		t.Errorf("expected stream %q, got %q", want, buf.String())
+	}
+
+	// Resetting the backlog interrupts streams.
+	done := make(chan error)
+	go func() {
+		done <- backlog.Stream(context.Background(), io.Discard, end, nil)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	backlog.Reset("primary-id", 1000, 2)
+	if err = <-done; !errors.Is(err, replication.ErrBacklogReset) {
+		t.Errorf("expected stream to be interrupted by the reset, got %v", err)
+	}
+	if id, offset, database := backlog.Position(); id != "primary-id" || offset != 1000 || database != 2 {
+		t.Errorf("expected position after reset to be primary-id 1000 2, got %s %d %d", id, offset, database)
+	}
+}
+
+func Test_Serve(t *testing.T) {
+	backlog := replication.NewBacklog(1024)
+	snapshot := func() (replication.Snapshot, error) {
+		id, offset, database := backlog.Position()
+		return replication.Snapshot{
+			ID: id, Offset: offset, Database: database,
+			Write: func(w io.Writer) error {
+				_, err := w.Write([]byte("state"))
+				return err
+			},
+		}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	buf := new(bytes.Buffer)
+	_ = replication.Serve(ctx, buf, backlog, "?", 0, snapshot, nil)
+	id, _, _ := backlog.Position()
+	if want := "+FULLRESYNC " + id + " 0 0\r\n$5\r\nstate"; buf.String() != want {
+		t.Errorf("expected full resync %q, got %q", want, buf.String())
+	}
+
+	set := internal.EncodeCommand([]string{"SET", "a", "1"})
+	backlog.Write(1, set)
+	_, offset, _ := backlog.Position()
+	backlog.Write(1, set)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	buf.Reset()
+	_ = replication.Serve(ctx, buf, backlog, id, offset, snapshot, nil)
+	if want := "+CONTINUE " + id + "\r\n" + string(set); buf.String() != want {
+		t.Errorf("expected partial resync %q, got %q", want, buf.String())
+	}
+	if strings.Contains(buf.String(), "SELECT") {
+		t.Error("expected partial resync to continue after the SELECT command")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// Shard is the announcement of a shard's slots made by the shard leader.
+type Shard struct {
+	ID     string  `json:"ID"`
+	Epoch  uint64  `json:"Epoch"`  // The configuration epoch of the shard's slots.
+	Term   uint64  `json:"Term"`   // The raft term of the leader that made the announcement.
+	Leader string  `json:"Leader"` // The server ID of the shard leader.
+	Ranges []Range `json:"Ranges"`
+}
+
+// Node is a node of a shard.
+type Node struct {
+	ID     string
+	Host   string
+	Port   int
+	Leader bool
+}
+
+// ShardInfo describes a shard, the slots it owns and its nodes.
+type ShardInfo struct {
+	ID     string
+	Ranges []Range
+	Nodes  []Node // The shard's nodes, starting with the leader.
+}
+
+// newer returns true if the announcement supersedes the other announcement of the same shard.
+func (shard Shard) newer(other Shard) bool {
+	if shard.Epoch != other.Epoch {
+		return shard.Epoch > other.Epoch
+	}
+	return shard.Term > other.Term
+}
+
+// Map is the cluster-wide view of the shards and the slots they own.
+// When more than one shard claims a slot, the shard with the highest epoch owns it.
+type Map struct {
+	mut    sync.RWMutex
+	shards map[string]Shard
+	owners [Count]string // The ID of the shard that owns each slot.
+}
+
+func NewMap() *Map {
+	return &Map{
+		mut:    sync.RWMutex{},
+		shards: make(map[string]Shard),
+	}
+}
+
+// Update merges the shard announcements into the map.
+// Announcements that are older than the ones already in the map are ignored.
+// It returns true if the map has changed.
+func (m *Map) Update(shards ...Shard) bool {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	changed := false
+	for _, shard := range shards {
+		if current, ok := m.shards[shard.ID]; ok && !shard.newer(current) {
+			continue
+		}
+		m.shards[shard.ID] = shard
+		changed = true
+	}
+
+	if changed {
+		var epochs [Count]uint64
+		m.owners = [Count]string{}
+		for _, shard := range m.shards {
+			for _, slot := range FromRanges(shard.Ranges) {
+				if m.owners[slot] == "" || shard.Epoch > epochs[slot] {
+					m.owners[slot] = shard.ID
+					epochs[slot] = shard.Epoch
+				}
+			}
+		}
+	}
+
+	return changed
+}
+
+// Owner returns the shard that owns the slot.
+func (m *Map) Owner(slot int) (Shard, bool) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	if m.owners[slot] == "" {
+		return Shard{}, false
+	}
+	return m.shards[m.owners[slot]], true
+}
+
+// Shard returns the latest announcement of the shard.
+func (m *Map) Shard(id string) (Shard, bool) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	shard, ok := m.shards[id]
+	return shard, ok
+}
+
+// Shards returns the latest announcements of all the known shards, sorted by shard ID.
+// The ranges of each shard only include the slots the shard owns.
+func (m *Map) Shards() []Shard {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+
+	owned := make(map[string][]int)
+	for slot, owner := range m.owners {
+		if owner != "" {
+			owned[owner] = append(owned[owner], slot)
+		}
+	}
+
+	shards := slices.Collect(maps.Values(m.shards))
+	for i := range shards {
+		shards[i].Ranges = ToRanges(owned[shards[i].ID])
+	}
+	slices.SortFunc(shards, func(a, b Shard) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return shards
+}
+
+// Announcements returns the shard announcements as received, so that they can be gossiped to other nodes.
+func (m *Map) Announcements() []Shard {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	return slices.Collect(maps.Values(m.shards))
+}
+
+// MaxEpoch returns the highest epoch in the cluster.
+func (m *Map) MaxEpoch() uint64 {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	var epoch uint64
+	for _, shard := range m.shards {
+		epoch = max(epoch, shard.Epoch)
+	}
+	return epoch
+}
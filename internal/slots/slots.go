@@ -0,0 +1,175 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package slots implements Redis Cluster compatible hash slot sharding.
+//
+// The keyspace is split into 16384 hash slots. Each slot is owned by one shard, and each shard is a separate
+// raft group. The State type holds the slots owned by the local shard and is replicated through the shard's
+// raft log. The Map type holds the cluster-wide view of which shard owns each slot and is propagated by gossip.
+package slots
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Count is the number of hash slots in the cluster.
+const Count = 16384
+
+var crc16Table [256]uint16
+
+func init() {
+	// CRC16-CCITT (XMODEM), the checksum used by Redis Cluster.
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^c]
+	}
+	return crc
+}
+
+// KeySlot returns the hash slot of the key.
+// If the key contains a non-empty hashtag between the first "{" and the following "}",
+// only the hashtag is hashed so that related keys can be stored in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16([]byte(key)) % Count)
+}
+
+// ParseSlot parses a slot number and checks that it's within the slot range.
+func ParseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= Count {
+		return 0, fmt.Errorf("invalid slot %s, slots must be integers between 0 and %d", s, Count-1)
+	}
+	return slot, nil
+}
+
+// ParseRange parses a range in the format "start-end", or a single slot.
+func ParseRange(s string) (Range, error) {
+	start, end, found := strings.Cut(s, "-")
+	if !found {
+		end = start
+	}
+	r := Range{}
+	var err error
+	if r.Start, err = ParseSlot(start); err != nil {
+		return Range{}, err
+	}
+	if r.End, err = ParseSlot(end); err != nil {
+		return Range{}, err
+	}
+	if r.Start > r.End {
+		return Range{}, fmt.Errorf("invalid slot range %s, the start slot must not be greater than the end slot", s)
+	}
+	return r, nil
+}
+
+// Range is an inclusive range of slots.
+type Range struct {
+	Start int `json:"Start"`
+	End   int `json:"End"`
+}
+
+func (r Range) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ToRanges returns the sorted ranges covering the provided slots.
+func ToRanges(slots []int) []Range {
+	slots = slices.Clone(slots)
+	slices.Sort(slots)
+	slots = slices.Compact(slots)
+
+	var ranges []Range
+	for _, slot := range slots {
+		if len(ranges) > 0 && ranges[len(ranges)-1].End == slot-1 {
+			ranges[len(ranges)-1].End = slot
+			continue
+		}
+		ranges = append(ranges, Range{Start: slot, End: slot})
+	}
+	return ranges
+}
+
+// FromRanges returns the slots covered by the ranges.
+func FromRanges(ranges []Range) []int {
+	var slots []int
+	for _, r := range ranges {
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// Redirection types returned to clients that send a command to a node that does not serve the slot.
+const (
+	Moved = "MOVED" // The slot is permanently served by another shard.
+	Ask   = "ASK"   // The slot is being migrated and the key should be requested from the importing shard.
+)
+
+// RedirectError tells the client which node serves the slot.
+// It's written to the client without the generic error prefix so that cluster-aware clients can follow it.
+type RedirectError struct {
+	Type string
+	Slot int
+	Addr string
+}
+
+func (err *RedirectError) Error() string {
+	return fmt.Sprintf("%s %d %s", err.Type, err.Slot, err.Addr)
+}
+
+var (
+	// ErrCrossSlot is returned when the keys of a command hash to different slots.
+	ErrCrossSlot = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	// ErrClusterDown is returned when no reachable shard serves the slot.
+	ErrClusterDown = errors.New("CLUSTERDOWN Hash slot not served")
+	// ErrTryAgain is returned when a key of the command is being moved to another shard.
+	ErrTryAgain = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+	// ErrShardingDisabled is returned by cluster slot commands when the node is not part of a sharded cluster.
+	ErrShardingDisabled = errors.New("this instance has cluster sharding disabled")
+)
+
+// IsClusterError returns true if the error must be written to the client as is, so that cluster-aware
+// clients can handle it.
+func IsClusterError(err error) bool {
+	var redirect *RedirectError
+	return errors.As(err, &redirect) ||
+		errors.Is(err, ErrCrossSlot) || errors.Is(err, ErrClusterDown) || errors.Is(err, ErrTryAgain)
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/echovault/sugardb/internal/slots"
+)
+
+func Test_KeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		// Slots computed by Redis Cluster.
+		{key: "foo", want: 12182},
+		{key: "bar", want: 5061},
+		{key: "123456789", want: 12739},
+		{key: "{user1000}.following", want: slots.KeySlot("user1000")},
+		{key: "{user1000}.followers", want: slots.KeySlot("user1000")},
+		// Empty hashtags are ignored, the whole key is hashed.
+		{key: "foo{}{bar}", want: slots.KeySlot("foo{}{bar}")},
+		// Only the first hashtag is used.
+		{key: "foo{{bar}}zap", want: slots.KeySlot("{bar")},
+		{key: "foo{bar}{zap}", want: slots.KeySlot("bar")},
+	}
+	for _, test := range tests {
+		if got := slots.KeySlot(test.key); got != test.want {
+			t.Errorf("expected slot of %q to be %d, got %d", test.key, test.want, got)
+		}
+	}
+	if slots.KeySlot("foo{}{bar}") == slots.KeySlot("bar") {
+		t.Errorf("expected empty hashtag to be ignored")
+	}
+}
+
+func Test_Ranges(t *testing.T) {
+	ranges := slots.ToRanges([]int{5, 1, 2, 3, 3, 9, 10, 16383})
+	want := []slots.Range{{Start: 1, End: 3}, {Start: 5, End: 5}, {Start: 9, End: 10}, {Start: 16383, End: 16383}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("expected ranges %v, got %v", want, ranges)
+	}
+	if got := slots.FromRanges(ranges); !reflect.DeepEqual(got, []int{1, 2, 3, 5, 9, 10, 16383}) {
+		t.Errorf("expected slots from ranges, got %v", got)
+	}
+
+	parseTests := []struct {
+		s       string
+		want    slots.Range
+		wantErr bool
+	}{
+		{s: "100", want: slots.Range{Start: 100, End: 100}},
+		{s: "0-16383", want: slots.Range{Start: 0, End: 16383}},
+		{s: "10-5", wantErr: true},
+		{s: "0-16384", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "a-b", wantErr: true},
+	}
+	for _, test := range parseTests {
+		got, err := slots.ParseRange(test.s)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parse range %q: expected error %v, got %v", test.s, test.wantErr, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parse range %q: expected %v, got %v", test.s, test.want, got)
+		}
+	}
+}
+
+func Test_Map(t *testing.T) {
+	m := slots.NewMap()
+
+	if !m.Update(
+		slots.Shard{ID: "a", Epoch: 1, Term: 1, Leader: "a1", Ranges: []slots.Range{{Start: 0, End: 99}}},
+		slots.Shard{ID: "b", Epoch: 2, Term: 1, Leader: "b1", Ranges: []slots.Range{{Start: 100, End: 199}}},
+	) {
+		t.Fatal("expected map to change")
+	}
+
+	// Stale announcements are ignored.
+	if m.Update(slots.Shard{ID: "a", Epoch: 1, Term: 1, Leader: "a2"}) {
+		t.Error("expected stale announcement to be ignored")
+	}
+
+	// A new leader with the same epoch replaces the announcement.
+	m.Update(slots.Shard{ID: "a", Epoch: 1, Term: 2, Leader: "a2", Ranges: []slots.Range{{Start: 0, End: 99}}})
+	if owner, ok := m.Owner(50); !ok || owner.Leader != "a2" {
+		t.Errorf("expected slot 50 to be owned by shard a with leader a2, got %+v", owner)
+	}
+
+	// The shard with the highest epoch owns a slot claimed by both shards.
+	m.Update(slots.Shard{ID: "b", Epoch: 3, Term: 1, Leader: "b1", Ranges: []slots.Range{{Start: 50, End: 199}}})
+	if owner, ok := m.Owner(50); !ok || owner.ID != "b" {
+		t.Errorf("expected slot 50 to be owned by shard b, got %+v", owner)
+	}
+	if _, ok := m.Owner(200); ok {
+		t.Error("expected slot 200 to have no owner")
+	}
+
+	shards := m.Shards()
+	if len(shards) != 2 ||
+		!reflect.DeepEqual(shards[0].Ranges, []slots.Range{{Start: 0, End: 49}}) ||
+		!reflect.DeepEqual(shards[1].Ranges, []slots.Range{{Start: 50, End: 199}}) {
+		t.Errorf("unexpected shards %+v", shards)
+	}
+	if epoch := m.MaxEpoch(); epoch != 3 {
+		t.Errorf("expected max epoch 3, got %d", epoch)
+	}
+}
+
+func Test_State(t *testing.T) {
+	state := slots.NewState()
+
+	if err := state.AddSlots([]int{1, 2, 3}, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.AddSlots([]int{3}, 6); err == nil {
+		t.Error("expected error when adding an owned slot")
+	}
+	if err := state.SetMigrating(4, "b"); err == nil {
+		t.Error("expected error when migrating a slot that's not owned")
+	}
+	if err := state.SetMigrating(3, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetImporting(10, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := slots.NewState()
+	if err = json.Unmarshal(b, restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Epoch() != 5 || !reflect.DeepEqual(restored.Ranges(), []slots.Range{{Start: 1, End: 3}}) {
+		t.Errorf("expected restored state to match, got epoch %d and ranges %v", restored.Epoch(), restored.Ranges())
+	}
+	if target, ok := restored.Migrating(3); !ok || target != "b" {
+		t.Errorf("expected slot 3 to be migrating to b, got %q", target)
+	}
+	if source, ok := restored.Importing(10); !ok || source != "c" {
+		t.Errorf("expected slot 10 to be importing from c, got %q", source)
+	}
+
+	// Handing over a slot removes it and makes it stable.
+	restored.SetOwner(3, false, 4)
+	if restored.Owns(3) || restored.Epoch() != 6 {
+		t.Errorf("expected slot 3 to be removed and the epoch to be incremented, got epoch %d", restored.Epoch())
+	}
+	if _, ok := restored.Migrating(3); ok {
+		t.Error("expected slot 3 to be stable")
+	}
+	restored.SetOwner(10, true, 9)
+	if !restored.Owns(10) || restored.Epoch() != 9 {
+		t.Errorf("expected slot 10 to be owned with epoch 9, got epoch %d", restored.Epoch())
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slots
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// State holds the slots owned by the local shard along with the slots being migrated in and out of the shard.
+// Every change to the state is applied through the shard's raft log so that all the nodes of the shard agree on it.
+type State struct {
+	mut       sync.RWMutex
+	epoch     uint64         // Incremented whenever the slots owned by the shard change.
+	owned     map[int]bool   // The slots owned by the shard.
+	migrating map[int]string // The slots being migrated out of the shard, mapped to the target shard.
+	importing map[int]string // The slots being migrated into the shard, mapped to the source shard.
+}
+
+type stateJSON struct {
+	Epoch     uint64         `json:"Epoch"`
+	Ranges    []Range        `json:"Ranges"`
+	Migrating map[int]string `json:"Migrating,omitempty"`
+	Importing map[int]string `json:"Importing,omitempty"`
+}
+
+func NewState() *State {
+	return &State{
+		mut:       sync.RWMutex{},
+		owned:     make(map[int]bool),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+	}
+}
+
+// Epoch returns the configuration epoch of the shard.
+func (state *State) Epoch() uint64 {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	return state.epoch
+}
+
+// Ranges returns the slot ranges owned by the shard.
+func (state *State) Ranges() []Range {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	return ToRanges(slices.Collect(maps.Keys(state.owned)))
+}
+
+// Owns returns true if the shard owns the slot.
+func (state *State) Owns(slot int) bool {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	return state.owned[slot]
+}
+
+// Migrating returns the shard the slot is being migrated to, if any.
+func (state *State) Migrating(slot int) (string, bool) {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	shard, ok := state.migrating[slot]
+	return shard, ok
+}
+
+// MigratingSlots returns the slots being migrated out of the shard, mapped to the target shard.
+func (state *State) MigratingSlots() map[int]string {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	return maps.Clone(state.migrating)
+}
+
+// Importing returns the shard the slot is being migrated from, if any.
+func (state *State) Importing(slot int) (string, bool) {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	shard, ok := state.importing[slot]
+	return shard, ok
+}
+
+// AddSlots assigns the slots to the shard and sets the shard's epoch.
+func (state *State) AddSlots(slots []int, epoch uint64) error {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	for _, slot := range slots {
+		if state.owned[slot] {
+			return fmt.Errorf("slot %d is already owned by this shard", slot)
+		}
+	}
+	for _, slot := range slots {
+		state.owned[slot] = true
+	}
+	state.setEpoch(epoch)
+	return nil
+}
+
+// SetMigrating marks the owned slot as being migrated to the target shard.
+func (state *State) SetMigrating(slot int, target string) error {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	if !state.owned[slot] {
+		return fmt.Errorf("slot %d is not owned by this shard", slot)
+	}
+	state.migrating[slot] = target
+	return nil
+}
+
+// SetImporting marks the slot as being migrated from the source shard.
+func (state *State) SetImporting(slot int, source string) error {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	if state.owned[slot] {
+		return fmt.Errorf("slot %d is already owned by this shard", slot)
+	}
+	state.importing[slot] = source
+	return nil
+}
+
+// SetStable clears the migrating and importing state of the slot.
+func (state *State) SetStable(slot int) {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	delete(state.migrating, slot)
+	delete(state.importing, slot)
+}
+
+// SetOwner finalises the migration of the slot. If owned is true, the slot is assigned to the shard.
+// Otherwise, it's removed from the shard. In both cases the slot becomes stable and the epoch is updated.
+func (state *State) SetOwner(slot int, owned bool, epoch uint64) {
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	if owned {
+		state.owned[slot] = true
+	} else {
+		delete(state.owned, slot)
+	}
+	delete(state.migrating, slot)
+	delete(state.importing, slot)
+	state.setEpoch(epoch)
+}
+
+func (state *State) setEpoch(epoch uint64) {
+	if epoch > state.epoch {
+		state.epoch = epoch
+	} else {
+		state.epoch++
+	}
+}
+
+// MarshalJSON encodes the state so that it can be included in raft snapshots.
+func (state *State) MarshalJSON() ([]byte, error) {
+	state.mut.RLock()
+	defer state.mut.RUnlock()
+	return json.Marshal(stateJSON{
+		Epoch:     state.epoch,
+		Ranges:    ToRanges(slices.Collect(maps.Keys(state.owned))),
+		Migrating: state.migrating,
+		Importing: state.importing,
+	})
+}
+
+// UnmarshalJSON replaces the state with the encoded state.
+func (state *State) UnmarshalJSON(b []byte) error {
+	var s stateJSON
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	state.mut.Lock()
+	defer state.mut.Unlock()
+	state.epoch = s.Epoch
+	state.owned = make(map[int]bool)
+	for _, slot := range FromRanges(s.Ranges) {
+		state.owned[slot] = true
+	}
+	state.migrating = make(map[int]string)
+	maps.Copy(state.migrating, s.Migrating)
+	state.importing = make(map[int]string)
+	maps.Copy(state.importing, s.Importing)
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
+	"github.com/echovault/sugardb/internal/encryption"
 	"io"
 	"io/fs"
 	"log"
@@ -42,11 +43,12 @@ type Engine struct {
 	clock                     clock.Clock
 	changeCount               atomic.Uint64
 	directory                 string
+	keyring                   *encryption.Keyring
 	snapshotInterval          time.Duration
 	snapshotThreshold         uint64
 	startSnapshotFunc         func()
 	finishSnapshotFunc        func()
-	getStateFunc              func() map[int]map[string]internal.KeyData
+	getStateFunc              func() (map[int]map[string]internal.KeyData, error)
 	setLatestSnapshotTimeFunc func(msec int64)
 	getLatestSnapshotTimeFunc func() int64
 	setKeyDataFunc            func(database int, key string, data internal.KeyData)
@@ -64,6 +66,13 @@ func WithDirectory(directory string) func(engine *Engine) {
 	}
 }
 
+// WithKeyring encrypts new snapshots with the keyring's active key.
+func WithKeyring(keyring *encryption.Keyring) func(engine *Engine) {
+	return func(engine *Engine) {
+		engine.keyring = keyring
+	}
+}
+
 func WithInterval(interval time.Duration) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.snapshotInterval = interval
@@ -88,7 +97,7 @@ func WithFinishSnapshotFunc(f func()) func(engine *Engine) {
 	}
 }
 
-func WithGetStateFunc(f func() map[int]map[string]internal.KeyData) func(engine *Engine) {
+func WithGetStateFunc(f func() (map[int]map[string]internal.KeyData, error)) func(engine *Engine) {
 	return func(engine *Engine) {
 		engine.getStateFunc = f
 	}
@@ -121,8 +130,8 @@ func NewSnapshotEngine(options ...func(engine *Engine)) *Engine {
 		snapshotThreshold:  1000,
 		startSnapshotFunc:  func() {},
 		finishSnapshotFunc: func() {},
-		getStateFunc: func() map[int]map[string]internal.KeyData {
-			return make(map[int]map[string]internal.KeyData)
+		getStateFunc: func() (map[int]map[string]internal.KeyData, error) {
+			return make(map[int]map[string]internal.KeyData), nil
 		},
 		setKeyDataFunc:            func(database int, key string, data internal.KeyData) {},
 		setLatestSnapshotTimeFunc: func(msec int64) {},
@@ -217,8 +226,13 @@ func (engine *Engine) TakeSnapshot() error {
 	}
 
 	// Get current state
+	state, err := engine.getStateFunc()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	snapshotObject := internal.SnapshotObject{
-		State:                      internal.FilterExpiredKeys(engine.clock.Now(), engine.getStateFunc()),
+		State:                      internal.FilterExpiredKeys(engine.clock.Now(), state),
 		LatestSnapshotMilliseconds: engine.getLatestSnapshotTimeFunc(),
 	}
 	out, err := json.Marshal(snapshotObject)
@@ -228,7 +242,7 @@ func (engine *Engine) TakeSnapshot() error {
 	}
 
 	snapshotHash := md5.Sum(out)
-	if snapshotHash == manifest.LatestSnapshotHash {
+	if snapshotHash == manifest.LatestSnapshotHash && engine.usesActiveKey(manifest.LatestSnapshotMilliseconds) {
 		return errors.New("nothing new to snapshot")
 	}
 
@@ -277,7 +291,7 @@ func (engine *Engine) TakeSnapshot() error {
 	}
 
 	// Create snapshot file
-	f, err := os.OpenFile(path.Join(dirname, "state.bin"), os.O_WRONLY|os.O_CREATE, os.ModePerm)
+	f, err := os.OpenFile(path.Join(dirname, "state.bin"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -288,6 +302,11 @@ func (engine *Engine) TakeSnapshot() error {
 		}
 	}()
 
+	// Encrypt the state if encryption at rest is enabled.
+	if out, err = engine.keyring.Encrypt(out); err != nil {
+		return err
+	}
+
 	// Write state to file
 	if _, err = f.Write(out); err != nil {
 		return err
@@ -305,6 +324,21 @@ func (engine *Engine) TakeSnapshot() error {
 	return nil
 }
 
+// usesActiveKey reports whether the snapshot was written with the active encryption key.
+// An unchanged state is snapshotted again after a key rotation so that it is re-encrypted with the new key.
+func (engine *Engine) usesActiveKey(msec int64) bool {
+	f, err := os.Open(path.Join(engine.directory, "snapshots", fmt.Sprintf("%d", msec), "state.bin"))
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	header := make([]byte, 64)
+	n, _ := io.ReadFull(f, header)
+	return engine.keyring.UsesActiveKey(header[:n])
+}
+
 func (engine *Engine) Restore() error {
 	mf, err := os.Open(path.Join(engine.directory, "snapshots", "manifest.bin"))
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
@@ -356,6 +390,10 @@ func (engine *Engine) Restore() error {
 		return nil
 	}
 
+	if sd, err = engine.keyring.Decrypt(sd); err != nil {
+		return err
+	}
+
 	snapshotObject := new(internal.SnapshotObject)
 	if err = json.Unmarshal(sd, snapshotObject); err != nil {
 		return err
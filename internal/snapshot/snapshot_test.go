@@ -15,11 +15,15 @@
 package snapshot_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
+	"github.com/echovault/sugardb/internal/encryption"
 	"github.com/echovault/sugardb/internal/snapshot"
 	"os"
+	"path"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -61,8 +65,8 @@ func Test_SnapshotEngine(t *testing.T) {
 		},
 	}
 
-	getStateFunc := func() map[int]map[string]internal.KeyData {
-		return state
+	getStateFunc := func() (map[int]map[string]internal.KeyData, error) {
+		return state, nil
 	}
 
 	restoredState := make(map[int]map[string]internal.KeyData)
@@ -134,3 +138,82 @@ func Test_SnapshotEngine(t *testing.T) {
 
 	_ = os.RemoveAll(directory)
 }
+
+func Test_SnapshotEngineEncryption(t *testing.T) {
+	directory := "./testdata/encryption"
+	t.Cleanup(func() {
+		_ = os.RemoveAll("./testdata")
+	})
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	state := map[int]map[string]internal.KeyData{
+		0: {"key1": {Value: "secret-value"}},
+	}
+
+	newEngine := func(keyring *encryption.Keyring, restored map[string]internal.KeyData) *snapshot.Engine {
+		return snapshot.NewSnapshotEngine(
+			snapshot.WithClock(clock.NewClock()),
+			snapshot.WithDirectory(directory),
+			snapshot.WithInterval(0),
+			snapshot.WithKeyring(keyring),
+			snapshot.WithGetStateFunc(func() (map[int]map[string]internal.KeyData, error) {
+				return state, nil
+			}),
+			snapshot.WithSetKeyDataFunc(func(database int, key string, data internal.KeyData) {
+				restored[key] = data
+			}),
+		)
+	}
+
+	readSnapshot := func() []byte {
+		entries, err := os.ReadDir(path.Join(directory, "snapshots"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				b, err := os.ReadFile(path.Join(directory, "snapshots", entry.Name(), "state.bin"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return b
+			}
+		}
+		t.Fatal("snapshot not found")
+		return nil
+	}
+
+	oldKeyring, _ := encryption.NewKeyring(oldKey)
+	if err := newEngine(oldKeyring, nil).TakeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if b := readSnapshot(); bytes.Contains(b, []byte("secret-value")) || !oldKeyring.UsesActiveKey(b) {
+		t.Errorf("expected snapshot to be encrypted with the old key, got %q", b)
+	}
+
+	// After a key rotation, the unchanged state is snapshotted again with the new key.
+	rotatedKeyring, _ := encryption.NewKeyring(newKey, oldKey)
+	if err := newEngine(rotatedKeyring, nil).TakeSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if b := readSnapshot(); !rotatedKeyring.UsesActiveKey(b) {
+		t.Errorf("expected snapshot to be re-encrypted with the new key, got %q", b)
+	}
+
+	// The snapshot can be restored with only the new key.
+	newKeyring, _ := encryption.NewKeyring(newKey)
+	restored := make(map[string]internal.KeyData)
+	if err := newEngine(newKeyring, restored).Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if restored["key1"].Value != "secret-value" {
+		t.Errorf("expected restored value \"secret-value\", got %v", restored["key1"].Value)
+	}
+
+	// Restoring without the key must fail.
+	if err := newEngine(nil, make(map[string]internal.KeyData)).Restore(); !errors.Is(err, encryption.ErrNoKey) {
+		t.Errorf("expected error %v, got %v", encryption.ErrNoKey, err)
+	}
+}
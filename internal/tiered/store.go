@@ -0,0 +1,133 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tiered implements the store on disk of the values that are spilled from memory in tiered mode.
+package tiered
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/echovault/sugardb/internal/encryption"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned by Get when there is no value with the id.
+var ErrNotFound = errors.New("spilled value not found")
+
+var bucket = []byte("values")
+
+// Store holds the spilled values in a bolt database, keyed by the id assigned to each value when it's spilled.
+// The values are encrypted with the active key of the keyring when encryption at rest is enabled.
+type Store struct {
+	db      *bolt.DB
+	keyring *encryption.Keyring
+}
+
+// Open creates the store at the path. The spilled values are only referenced by the keyspace of the running
+// server, so the values left behind by a previous run are discarded.
+func Open(path string, keyring *encryption.Keyring) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("open tiered store: %v", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("open tiered store: %v", err)
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open tiered store: %v", err)
+	}
+	// The values are discarded on startup, so they don't need to be synced to survive a crash.
+	db.NoSync = true
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open tiered store: %v", err)
+	}
+	return &Store{db: db, keyring: keyring}, nil
+}
+
+func key(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// Put writes the value with the id.
+func (store *Store) Put(id uint64, value []byte) error {
+	value, err := store.keyring.Encrypt(value)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key(id), value)
+	})
+}
+
+// Get reads the value with the id.
+func (store *Store) Get(id uint64) ([]byte, error) {
+	var value []byte
+	if err := store.db.View(func(tx *bolt.Tx) error {
+		// The value returned by bolt is only valid for the duration of the transaction.
+		if b := tx.Bucket(bucket).Get(key(id)); b != nil {
+			value = append([]byte{}, b...)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return store.keyring.Decrypt(value)
+}
+
+// Delete removes the values with the ids.
+func (store *Store) Delete(ids ...uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			if err := tx.Bucket(bucket).Delete(key(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Size returns the size of the store on disk in bytes.
+func (store *Store) Size() int64 {
+	var size int64
+	_ = store.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size
+}
+
+// Close closes the store and removes it from disk.
+func (store *Store) Close() error {
+	path := store.db.Path()
+	if err := store.db.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tiered_test
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/echovault/sugardb/internal/encryption"
+	"github.com/echovault/sugardb/internal/tiered"
+)
+
+func Test_Store(t *testing.T) {
+	keyring, err := encryption.NewKeyring(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keyring *encryption.Keyring
+	}{
+		{name: "1. Store without encryption"},
+		{name: "2. Store with encryption", keyring: keyring},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tiered", "values.db")
+			// A file left behind by a previous run is discarded.
+			if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("stale"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := tiered.Open(path, test.keyring)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values := map[uint64][]byte{
+				1: []byte("value1"),
+				2: []byte("value2"),
+				3: bytes.Repeat([]byte("value3"), 1024),
+			}
+			for id, value := range values {
+				if err = store.Put(id, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for id, want := range values {
+				got, err := store.Get(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("expected value %d to be %q, got %q", id, want, got)
+				}
+			}
+			if store.Size() <= 0 {
+				t.Errorf("expected a positive size, got %d", store.Size())
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contains := bytes.Contains(b, []byte("value1")); contains != (test.keyring == nil) {
+				t.Errorf("expected the file to contain the plaintext %v, got %v", test.keyring == nil, contains)
+			}
+
+			if err = store.Delete(1, 3); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []uint64{1, 3, 4} {
+				if _, err = store.Get(id); !errors.Is(err, tiered.ErrNotFound) {
+					t.Errorf("expected value %d not to be found, got error %v", id, err)
+				}
+			}
+			if got, err := store.Get(2); err != nil || !bytes.Equal(got, values[2]) {
+				t.Errorf("expected value 2 to be %q, got %q (%v)", values[2], got, err)
+			}
+
+			if err = store.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected the store to be removed on close, got error %v", err)
+			}
+		})
+	}
+}
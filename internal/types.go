@@ -16,70 +16,42 @@ package internal
 
 import (
 	"context"
-	"errors"
-	"fmt"
+	"encoding/json"
+	"io"
 	"net"
-	"reflect"
 	"time"
 	"unsafe"
 
 	"github.com/echovault/sugardb/internal/clock"
-	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/slots"
 )
 
 type KeyData struct {
 	Value    interface{}
 	ExpireAt time.Time
+	// Access holds the LRU clock or the LFU counter of the key, depending on the eviction policy.
+	Access uint32 `json:"-"`
+	// Mem holds the memory used by the key as it was accounted for in the memory usage of the store.
+	Mem int64 `json:"-"`
 }
 
-func (k *KeyData) GetMem() (int64, error) {
-	var size int64
-	size = int64(unsafe.Sizeof(k.ExpireAt))
-
-	// check type of Value field
-	switch v := k.Value.(type) {
-	case nil:
-		size += 0
-	// AdaptType() will always ensure data type is of string, float64 or int.
-	case int:
-		size += int64(unsafe.Sizeof(v))
-	// int64 data type used with module.SET
-	case float64, int64:
-		size += 8
-	case string:
-		// Add the size of the header and the number of bytes of the string
-		size += int64(unsafe.Sizeof(v))
-		size += int64(len(v))
-
-	// handle list
-	case []string:
-		for _, s := range v {
-			size += int64(unsafe.Sizeof(s))
-			size += int64(len(s))
-		}
-
-	// handle non primitive datatypes like hash, set, and sorted set
-	case constants.CompositeType:
-		size += k.Value.(constants.CompositeType).GetMem()
-
-	default:
-		return 0, errors.New(fmt.Sprintf("ERROR: type %v is not supported in method KeyData.GetMem()", reflect.TypeOf(v)))
-	}
-
-	return size, nil
+// GetMem returns the memory used by the key data, including the memory referenced by its value.
+func (k *KeyData) GetMem() int64 {
+	return int64(unsafe.Sizeof(*k)) + ValueSize(k.Value)
 }
 
 type ContextServerID string
 type ContextConnID string
 
 type ApplyRequest struct {
-	Type         string   `json:"Type"` // command | delete-key
+	Type         string   `json:"Type"` // command | delete-key | slots | import-keys
 	ServerID     string   `json:"ServerID"`
 	ConnectionID string   `json:"ConnectionID"`
 	Protocol     int      `json:"Protocol"`
 	Database     int      `json:"Database"`
 	CMD          []string `json:"CMD"`
-	Key          string   `json:"Key"` // Optional: Used with delete-key type to specify which key to delete.
+	Key          string   `json:"Key"`            // Optional: Used with delete-key type to specify which key to delete.
+	Data         []byte   `json:"Data,omitempty"` // Optional: Used with import-keys type to specify the encoded imported keys.
 }
 
 type ApplyResponse struct {
@@ -90,6 +62,8 @@ type ApplyResponse struct {
 type SnapshotObject struct {
 	State                      map[int]map[string]KeyData
 	LatestSnapshotMilliseconds int64
+	LatestSnapshotIndex        uint64          `json:",omitempty"` // The index of the last raft log entry in the snapshot.
+	Slots                      json.RawMessage `json:",omitempty"` // The hash slots owned by the shard in cluster mode.
 }
 
 // ServerInfo holds information about the server/node.
@@ -104,12 +78,126 @@ type ServerInfo struct {
 	MaxMemory  uint64
 }
 
+// MemoryStats holds the memory usage of the store, as reported by the MEMORY STATS command.
+type MemoryStats struct {
+	PeakAllocated  int64  // The highest memory usage of the store since startup.
+	TotalAllocated int64  // The memory usage of the store, which is compared with the max memory.
+	MaxMemory      uint64 // The max memory, 0 when there's no limit.
+	DatasetBytes   int64  // The memory used by the keys and their values.
+	OverheadBytes  int64  // The memory used by the keyspace maps and the indexes of the keys with an expiry.
+	Keys           int    // The number of keys in all the databases.
+	// The number of values detached from the keyspace that are waiting to be released on the lazy free goroutine.
+	LazyFreePendingObjects int64
+	// The number of values released on the lazy free goroutine since startup.
+	LazyFreedObjects int64
+	// The activity of the tiered mode. It's zeroed when the tiered mode is disabled.
+	Tiered TieredStats
+	// The conflict resolution metadata of active-active replication. It's zeroed when there are no peers.
+	ActiveActive ActiveActiveStats
+	// The memory usage of each database.
+	Databases map[int]DatabaseMemoryStats
+	// The memory held by the Go runtime. It includes memory that is not used by the store,
+	// such as connection buffers and garbage that hasn't been collected yet.
+	HeapAlloc uint64
+	HeapSys   uint64
+}
+
+// TieredStats holds the activity of the tiered mode, which spills the values of cold keys to a store on disk.
+type TieredStats struct {
+	Keys      int64 // The number of keys whose value is spilled to disk.
+	Hits      int64 // The number of reads of a value held in memory since startup.
+	Misses    int64 // The number of reads of a spilled value since startup.
+	Faults    int64 // The number of spilled values loaded back into memory since startup.
+	Spills    int64 // The number of values spilled to disk since startup.
+	DiskBytes int64 // The size of the store on disk.
+}
+
+// ActiveActiveStats holds the memory usage of the conflict resolution metadata of active-active replication.
+// The memory is added to the memory usage of the store whenever the expired metadata is pruned.
+type ActiveActiveStats struct {
+	Keys  int   // The number of keys with metadata, including the deleted keys whose metadata isn't pruned yet.
+	Bytes int64 // The memory used by the metadata.
+}
+
+// DatabaseMemoryStats holds the memory usage of a database.
+type DatabaseMemoryStats struct {
+	Keys            int   // The number of keys in the database.
+	VolatileKeys    int   // The number of keys in the index of the keys with an expiry.
+	DatasetBytes    int64 // The memory used by the keys of the database and their values.
+	OverheadMain    int64 // The memory used by the buckets of the keyspace map.
+	OverheadExpires int64 // The memory used by the index of the keys with an expiry.
+}
+
+// MemoryQuotaStats holds the usage of a memory quota, as reported by the MEMORY QUOTAS command.
+type MemoryQuotaStats struct {
+	Database  int    // The database of the quota.
+	Prefix    string // The prefix of the keys of the quota. Empty for a quota on all the keys of the database.
+	MaxMemory uint64 // The max memory of the quota.
+	Policy    string // The eviction policy of the quota, noeviction when the writes are rejected.
+	Used      int64  // The memory used by the keys of the quota and their values.
+	Evicted   int64  // The number of keys evicted to enforce the quota since startup.
+	Rejected  int64  // The number of writes rejected by the quota since startup.
+}
+
+// ClusterInfo holds information about the raft state of the node in cluster mode.
+type ClusterInfo struct {
+	Enabled      bool   // Whether the node is running in cluster mode.
+	ServerID     string // The raft server ID of the node.
+	ShardID      string // The shard the node belongs to. Empty when sharding is disabled.
+	State        string // The raft state of the node, e.g. "Leader" or "Follower".
+	Suffrage     string // Either "voter" or "nonvoter".
+	LeaderID     string // The ID of the current leader, empty if there's no known leader.
+	Term         uint64
+	CommitIndex  uint64
+	AppliedIndex uint64
+	LastIndex    uint64
+	Voters       int // The number of voters in the raft configuration.
+	Nonvoters    int // The number of non-voters in the raft configuration.
+	Members      int // The number of live nodes known by the memberlist.
+}
+
+// ClusterNodeInfo holds information about a node in the raft configuration of the local node.
+type ClusterNodeInfo struct {
+	ID         string
+	ClientAddr string // The address clients connect to. Empty if the node is unreachable.
+	RaftAddr   string
+	Suffrage   string // Either "voter" or "nonvoter".
+	Leader     bool
+	Myself     bool   // Whether this is the node that's handling the request.
+	Health     string // Either "online", "suspect" or "offline".
+}
+
+// ReplicationInfo holds information about the standalone replication role of the node.
+type ReplicationInfo struct {
+	Role          string // Either "master" or "replica".
+	ReplicationID string // The ID of the replication stream.
+	Offset        uint64 // The offset of the end of the replication stream.
+	// The replicas connected to the node along with the offset of the stream sent to each of them.
+	Replicas    []ReplicaInfo
+	PrimaryHost string // The host of the primary, when the node is a replica.
+	PrimaryPort int    // The port of the primary, when the node is a replica.
+	// The state of the link to the primary, when the node is a replica.
+	// Either "connect", "connecting", "sync" or "connected".
+	LinkState string
+}
+
+// ReplicaInfo holds information about a replica connected to the node.
+type ReplicaInfo struct {
+	Addr   string
+	Offset uint64 // The offset of the stream sent to the replica.
+	Acked  uint64 // The offset of the stream the replica has acknowledged applying.
+}
+
 // ConnectionInfo holds information about the connection
 type ConnectionInfo struct {
 	Id       uint64 // Connection id.
 	Name     string // Alias name for this connection.
 	Protocol int    // The RESP protocol used by the client. Can be either 2 or 3.
 	Database int    // Database index currently being used by the connection.
+	// The consistency level of reads in cluster mode. Can be "stale", "leader" or "linearizable".
+	ReadConsistency string
+	// Set by the ASKING command. Allows the next command to access a slot being imported by the shard.
+	Asking bool
 }
 
 // KeyExtractionFuncResult is the return type of the KeyExtractionFunc for the command/subcommand.
@@ -125,6 +213,19 @@ type KeyExtractionFuncResult struct {
 // The cmd parameter is a string slice of the command. All the keys are extracted from this command.
 type KeyExtractionFunc func(cmd []string) (KeyExtractionFuncResult, error)
 
+// RewriteFuncResult is the return type of the RewriteFunc for the command/subcommand.
+type RewriteFuncResult struct {
+	Command  []string // The deterministic form of the command. This is executed, logged and replicated in place of the original.
+	Response []byte   // Optional: When set, this response is returned to the client instead of the rewritten command's response.
+}
+
+// RewriteFunc is optionally included with write commands whose effect depends on the current time or on a random
+// choice (e.g. EXPIRE, SPOP). It returns a deterministic form of the command so that replaying the AOF log and
+// applying the raft log on followers produces exactly the same state as the original execution.
+// If the command cannot be rewritten (e.g. it is malformed), the original command should be returned so that
+// the handler can produce the appropriate response.
+type RewriteFunc func(params HandlerFuncParams) (RewriteFuncResult, error)
+
 // HandlerFuncParams is the object passed to a command handler when a command is triggered.
 // These params are provided to commands by the SugarDB engine to help the command hook into functions from the
 // echovault package.
@@ -141,9 +242,11 @@ type HandlerFuncParams struct {
 	// GetExpiry returns the expiry time of a key.
 	GetExpiry func(ctx context.Context, key string) time.Time
 	// GetHashExpiry returns the expiry time of a field in a key whose value is a hash.
-	GetHashExpiry func(ctx context.Context, key string, field string) time.Time
+	GetHashExpiry func(ctx context.Context, key string, field string) (time.Time, error)
 	// DeleteKey deletes the specified key. Returns an error if the deletion was unsuccessful.
 	DeleteKey func(ctx context.Context, key string) error
+	// UnlinkKey deletes the specified key like DeleteKey, but a large value is released on a background goroutine.
+	UnlinkKey func(ctx context.Context, key string) error
 	// GetValues retrieves the values from the specified keys.
 	// Non-existent keys will be nil.
 	GetValues func(ctx context.Context, keys []string) map[string]interface{}
@@ -171,6 +274,8 @@ type HandlerFuncParams struct {
 	TakeSnapshot func() error
 	// RewriteAOF triggers a compaction of the commands logs by the SugarDB instance.
 	RewriteAOF func() error
+	// Backup writes a consistent backup archive of the SugarDB instance to the writer.
+	Backup func(w io.Writer) error
 	// GetLatestSnapshotTime returns the latest snapshot timestamp.
 	GetLatestSnapshotTime func() int64
 	// LoadModule loads the provided module with the given args passed to the module's
@@ -185,6 +290,8 @@ type HandlerFuncParams struct {
 	SetConnectionInfo func(conn *net.Conn, clientname string, protocol int, database int)
 	// GetConnectionInfo returns information about the current connection.
 	GetConnectionInfo func(conn *net.Conn) ConnectionInfo
+	// SetReadConsistency sets the consistency level of reads made by the connection in cluster mode.
+	SetReadConsistency func(conn *net.Conn, level string)
 	// GetServerInfo returns information about the server when requested by commands such as HELLO.
 	GetServerInfo func() ServerInfo
 	// SwapDBs swaps two databases,
@@ -194,6 +301,8 @@ type HandlerFuncParams struct {
 	// FlushDB flushes the specified database keys. It accepts the integer index of the database to be flushed.
 	// If -1 is passed as the index, then all databases will be flushed.
 	Flush func(database int)
+	// FlushAsync flushes the specified database keys like Flush, but the keys are released on a background goroutine.
+	FlushAsync func(database int)
 	// RandomKey returns a random key
 	RandomKey func(ctx context.Context) string
 	// DBSize returns the number of keys in the currently selected database.
@@ -207,12 +316,65 @@ type HandlerFuncParams struct {
 	// GetObjectIdleTime retrieves the time in seconds since the last access of a key.
 	// Can only be used with LRU type eviction policies.
 	GetObjectIdleTime func(ctx context.Context, keys string) (float64, error)
+	// GetObjectEncoding returns the encoding of the value of a key. Returns false if the key does not exist.
+	GetObjectEncoding func(ctx context.Context, key string) (string, bool, error)
+	// GetMemoryUsage returns the memory used by a key and its value. Returns false if the key does not exist.
+	GetMemoryUsage func(ctx context.Context, key string) (int64, bool)
+	// GetMemoryStats returns the memory usage of the store.
+	GetMemoryStats func() MemoryStats
+	// GetMemoryQuotas returns the usage of each memory quota.
+	GetMemoryQuotas func() []MemoryQuotaStats
 	// AddScript adds a script to SugarDB that isn't associated with a command.
 	// This script is triggered using the EVAL or EVALSHA commands.
 	// engine defines the interpreter to be used. Possible values: "LUA"
 	// scriptType is either "FILE" or "RAW".
 	// content contains the file path if scriptType is "FILE" and the raw script if scriptType is "RAW"
 	AddScript func(engine string, scriptType string, content string, args []string) error
+	// WaitForReplicas blocks until at least numReplicas replicas have applied the latest write made by the
+	// connection, or until the context is done. It returns the number of replicas that applied the write.
+	WaitForReplicas func(ctx context.Context, conn *net.Conn, numReplicas int) (int, error)
+	// WaitForAOF blocks until the latest write made by the connection has been persisted to the local append only
+	// log, or until the context is done. The context's error is returned when the context is done first.
+	WaitForAOF func(ctx context.Context, conn *net.Conn) error
+	// GetShards returns the shards of a sharded cluster along with the slots and nodes of each shard.
+	GetShards func() ([]slots.ShardInfo, error)
+	// GetShardID returns the ID of the shard the node belongs to.
+	GetShardID func() (string, error)
+	// AddSlots assigns the slots to the node's shard. Can only be called on the shard leader.
+	AddSlots func(slotList []int) error
+	// SetSlot changes the migration state of the slot. The state is either "MIGRATING", "IMPORTING", "STABLE"
+	// or "NODE". The shardID is the target shard for "MIGRATING", the source shard for "IMPORTING" and the new
+	// owner for "NODE". Can only be called on the shard leader.
+	SetSlot func(slot int, state string, shardID string) error
+	// GetKeysInSlot returns up to count keys of the current database that hash to the slot.
+	// If count is less than 1, all the keys in the slot are returned.
+	GetKeysInSlot func(ctx context.Context, slot int, count int) []string
+	// SetAsking allows the next command of the connection to access a slot being imported by the shard.
+	SetAsking func(conn *net.Conn)
+	// GetClusterInfo returns the raft state of the node.
+	GetClusterInfo func() (ClusterInfo, error)
+	// GetClusterNodes returns the nodes in the raft configuration of the node, including the node itself.
+	GetClusterNodes func() ([]ClusterNodeInfo, error)
+	// ForgetNode removes the node with the provided ID from the raft configuration. Can only be called on the leader.
+	ForgetNode func(id string) error
+	// TransferLeadership hands over leadership to the voter with the provided ID, or to the most up-to-date voter
+	// if the ID is empty. Can only be called on the leader.
+	TransferLeadership func(id string) error
+	// AddNonvoter adds a node that replicates the raft log without voting. Can only be called on the leader.
+	AddNonvoter func(id string, raftAddr string) error
+	// PromoteNode turns the non-voter with the provided ID into a voter. Can only be called on the leader.
+	PromoteNode func(id string) error
+	// ReplicaOf makes the node a replica of the primary at host:port in standalone mode.
+	// An empty host stops the replication and turns the node into a primary.
+	ReplicaOf func(host string, port int) error
+	// SyncReplica answers a PSYNC request from a replica on the connection, and then streams the writes to the
+	// replica until the connection is closed. The offset is -1 when the replica has never been synchronised.
+	SyncReplica func(ctx context.Context, conn *net.Conn, replicationID string, offset int64) error
+	// GetReplicationInfo returns the standalone replication role of the node.
+	GetReplicationInfo func() (ReplicationInfo, error)
+	// SyncPeer answers a PEERSYNC request from the peer site on the connection, and then streams the effects of
+	// the local writes to the peer until the connection is closed.
+	SyncPeer func(ctx context.Context, conn *net.Conn, siteID string, replicationID string, offset int64) error
 }
 
 // HandlerFunc is a functions described by a command where the bulk of the command handling is done.
@@ -231,6 +393,7 @@ type Command struct {
 	Type        string       // The type of command ("BUILT_IN", "GO_MODULE", "LUA_SCRIPT", "JS_SCRIPT").
 	KeyExtractionFunc
 	HandlerFunc
+	RewriteFunc // Optional: Only set for write commands that are not deterministic.
 }
 
 type SubCommand struct {
@@ -241,4 +404,5 @@ type SubCommand struct {
 	Sync        bool     // Specifies if sub-command should be synced across replication cluster.
 	KeyExtractionFunc
 	HandlerFunc
+	RewriteFunc // Optional: Only set for write sub-commands that are not deterministic.
 }
@@ -26,7 +26,6 @@ import (
 	"math/big"
 	"net"
 	"reflect"
-	"runtime"
 	"slices"
 	"strconv"
 	"strings"
@@ -151,6 +150,12 @@ func IsWriteCommand(command Command, subCommand SubCommand) bool {
 	return slices.Contains(append(command.Categories, subCommand.Categories...), constants.WriteCategory)
 }
 
+// IsReadCommand returns true if the command or subcommand only reads data.
+func IsReadCommand(command Command, subCommand SubCommand) bool {
+	categories := append(command.Categories, subCommand.Categories...)
+	return slices.Contains(categories, constants.ReadCategory) && !slices.Contains(categories, constants.WriteCategory)
+}
+
 func AbsInt(n int) int {
 	if n < 0 {
 		return -n
@@ -192,16 +197,6 @@ func IsMaxMemoryExceeded(memUsed int64, maxMemory uint64) bool {
 		return false
 	}
 
-	// If we're currently using less than the configured max memory, return false.
-	if uint64(memUsed) < maxMemory {
-		return false
-	}
-
-	// If we're currently using more than max memory, force a garbage collection before we start deleting keys.
-	// This measure is to prevent deleting keys that may be important when some memory can be reclaimed
-	// by just collecting garbage.
-	runtime.GC()
-
 	// Return true when whe are above or equal to max memory.
 	return uint64(memUsed) >= maxMemory
 }
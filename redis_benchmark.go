@@ -22,21 +22,48 @@ type Metrics struct {
 	P50Latency        string
 }
 
-func getCommandArgs() (string, bool) {
+func getCommandArgs() (string, bool, time.Duration) {
 	defaultCommands := "ping,set,get,incr,lpush,rpush,lpop,rpop,sadd,hset,zpopmin,lrange,mset"
 	commands := flag.String("commands", defaultCommands, "Commands to run")
 	useLocal := flag.Bool("use_local_server", false, "Run benchamark using local SugarDB server")
+	snapshotInterval := flag.Duration("snapshot_interval", 0, "Take a snapshot at this interval while the benchmark runs")
 	flag.Parse()
 	fmt.Printf("Provided commands: %s\n", *commands)
 	if *useLocal {
 		fmt.Println("Using local running SugarDB server")
 	}
-	return *commands, *useLocal
+	if *snapshotInterval > 0 {
+		fmt.Printf("Taking a snapshot every %s\n", *snapshotInterval)
+	}
+	return *commands, *useLocal, *snapshotInterval
 }
 
-func runBenchmark(port string, commands string) ([]Metrics, error) {
+// takeSnapshots sends the snapshot command to the server at every interval until done is closed, so that the
+// benchmark measures the commands while snapshots are taken.
+func takeSnapshots(port string, snapshot string, interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if output, err := exec.Command("redis-cli", "-h", Host, "-p", port, snapshot).CombinedOutput(); err != nil {
+				fmt.Printf("Error running %s: %v %s\n", snapshot, err, output)
+			}
+		}
+	}
+}
+
+func runBenchmark(port string, commands string, snapshot string, snapshotInterval time.Duration) ([]Metrics, error) {
 	var results []Metrics
 
+	if snapshotInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go takeSnapshots(port, snapshot, snapshotInterval, done)
+	}
+
 	// Run redis-benchmark
 	cmd := exec.Command("redis-benchmark", "-h", Host, "-p", port, "-q", "-t", commands)
 	output, err := cmd.CombinedOutput()
@@ -96,7 +123,7 @@ func createDisplayTable(redisResults []Metrics, sugarDBResults []Metrics) {
 
 func main() {
 
-	commands, useLocal := getCommandArgs()
+	commands, useLocal, snapshotInterval := getCommandArgs()
 
 	// Start a local Redis server, wait a few seconds for it to start
 	exec.Command("redis-server", "--port", RedisPort).Start()
@@ -104,7 +131,8 @@ func main() {
 
 	// Run benchmark on local Redis server
 	fmt.Println("-------Running Redis Benchmarks------")
-	redisResults, err := runBenchmark(RedisPort, commands)
+	// SAVE blocks the Redis server, so its snapshots are taken in the background with BGSAVE.
+	redisResults, err := runBenchmark(RedisPort, commands, "BGSAVE", snapshotInterval)
 	if err != nil {
 		fmt.Println("Error running benchmark on Redis server:", err)
 		return
@@ -118,7 +146,7 @@ func main() {
 
 	// Run benchmark on SugarDB server
 	fmt.Println("-------Running SugarDB Benchmarks------")
-	sugarDBResults, err := runBenchmark(SugarDBPort, commands)
+	sugarDBResults, err := runBenchmark(SugarDBPort, commands, "SAVE", snapshotInterval)
 	if err != nil {
 		fmt.Println("Error running benchmark on SugarDB server:", err)
 		fmt.Println("Check that the SugarDB server is running")
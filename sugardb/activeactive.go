@@ -0,0 +1,309 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/activeactive"
+	"github.com/echovault/sugardb/internal/modules/hash"
+	"github.com/echovault/sugardb/internal/modules/set"
+	"github.com/echovault/sugardb/internal/replication"
+	"github.com/tidwall/resp"
+)
+
+// errActiveActiveCluster is returned when peers are configured in cluster mode.
+var errActiveActiveCluster = errors.New("active-active replication is only supported in standalone mode")
+
+func (server *SugarDB) isActiveActive() bool {
+	return server.activeActive.state != nil
+}
+
+// startPeers starts pulling the effect streams of the peer sites.
+func (server *SugarDB) startPeers() error {
+	if !server.isActiveActive() {
+		return nil
+	}
+	for _, peer := range server.config.Peers {
+		host, p, err := net.SplitHostPort(peer)
+		if err != nil {
+			return fmt.Errorf("peer: %v", err)
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("peer: %v", err)
+		}
+		server.activeActive.peers = append(server.activeActive.peers, replication.StartReplica(host, port, replication.ReplicaOpts{
+			Backlog:      replication.NewCursor(),
+			SyncCommand:  []string{"PEERSYNC", server.config.SiteID},
+			Locker:       &server.replication.applyMut,
+			FullSync:     server.peerFullSync,
+			ApplyCommand: server.applyPeerEffect,
+			Dial:         server.dialPrimary,
+			Auth:         server.primaryAuth(),
+		}))
+		log.Printf("active-active replication with peer %s\n", peer)
+	}
+
+	// Prune the metadata that expired, and account for the rest in the memory usage.
+	go func() {
+		ticker := time.NewTicker(min(server.config.TombstoneTTL, time.Minute))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-server.replication.ctx.Done():
+				return
+			case <-ticker.C:
+				server.pruneActiveActive()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// pruneActiveActive removes the conflict resolution metadata written before the tombstone TTL, and updates the
+// memory usage with the size of the remaining metadata.
+func (server *SugarDB) pruneActiveActive() {
+	cutoff := server.clock.Now().Add(-server.config.TombstoneTTL).UnixMilli()
+	server.activeActive.state.Prune(cutoff)
+	_, size := server.activeActive.state.Stats()
+	server.addMemUsed(size - server.activeActive.accounted)
+	server.activeActive.accounted = size
+}
+
+// stopPeers closes the links to the peer sites.
+func (server *SugarDB) stopPeers() {
+	for _, peer := range server.activeActive.peers {
+		peer.Stop()
+	}
+	server.activeActive.peers = nil
+}
+
+// syncPeer answers the PEERSYNC request of a peer site on the connection and streams the effects of the local
+// writes to it. It only returns once the stream ends, with io.EOF so that the connection is closed.
+func (server *SugarDB) syncPeer(ctx context.Context, conn *net.Conn, siteID string, replicationID string, offset int64) error {
+	if server.isInCluster() {
+		return errActiveActiveCluster
+	}
+	if !server.isActiveActive() {
+		return errors.New("active-active replication is not enabled")
+	}
+	if conn == nil {
+		return errors.New("PEERSYNC requires a TCP connection")
+	}
+	if siteID == server.config.SiteID {
+		return errors.New("peer has the same site id")
+	}
+
+	log.Printf("peer %s connected from %s\n", siteID, (*conn).RemoteAddr())
+	err := server.serveStream(ctx, conn, server.activeActive.backlog, replicationID, offset, server.peerSnapshot, nil, nil)
+	log.Printf("peer %s disconnected: %v\n", siteID, err)
+
+	return io.EOF
+}
+
+// peerSnapshot encodes the whole state as effects, along with the matching position of the effect stream.
+func (server *SugarDB) peerSnapshot() (replication.Snapshot, error) {
+	// Block local writes and the streams of the peers until the state is encoded.
+	server.replication.applyMut.Lock()
+	defer server.replication.applyMut.Unlock()
+
+	var s replication.Snapshot
+	s.ID, s.Offset, s.Database = server.activeActive.backlog.Position()
+
+	buf := new(bytes.Buffer)
+	state, err := server.getState()
+	if err != nil {
+		return replication.Snapshot{}, err
+	}
+	for _, database := range slices.Sorted(maps.Keys(state)) {
+		buf.Write(internal.EncodeCommand([]string{"SELECT", strconv.Itoa(database)}))
+		for key, data := range state[database] {
+			value, err := server.activeActiveValue(data.(internal.KeyData), true)
+			if err != nil {
+				return replication.Snapshot{}, fmt.Errorf("key %s: %v", key, err)
+			}
+			for _, effect := range server.activeActive.state.Snapshot(database, key, value) {
+				buf.Write(internal.EncodeCommand(effect))
+			}
+		}
+	}
+
+	s.Write = func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return s, nil
+}
+
+// peerFullSync merges the state of a peer site, encoded as effects.
+func (server *SugarDB) peerFullSync(r io.Reader) error {
+	rd := resp.NewReader(r)
+	database := 0
+	for {
+		value, _, err := rd.ReadValue()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var effect []string
+		for _, token := range value.Array() {
+			effect = append(effect, token.String())
+		}
+		if len(effect) == 2 && strings.EqualFold(effect[0], "SELECT") {
+			if database, err = strconv.Atoi(effect[1]); err != nil {
+				return fmt.Errorf("invalid SELECT in peer snapshot: %v", err)
+			}
+			continue
+		}
+		if err = server.applyPeerEffect(database, effect); err != nil {
+			log.Printf("active-active apply %v: %v\n", effect, err)
+		}
+	}
+}
+
+// applyPeerEffect merges an effect received from a peer site and executes the commands that bring the key in line
+// with the merged state. The commands are logged to the AOF log and streamed to the replicas.
+func (server *SugarDB) applyPeerEffect(database int, effect []string) error {
+	if len(effect) < 2 {
+		return fmt.Errorf("invalid effect %v", effect)
+	}
+	values, err := server.activeActiveValues(database, []string{effect[1]})
+	if err != nil {
+		return err
+	}
+	commands, err := server.activeActive.state.Apply(database, effect, values[effect[1]])
+	if err != nil {
+		return err
+	}
+	for _, command := range commands {
+		if err = server.applyReplicatedCommand(database, command); err != nil {
+			return err
+		}
+		server.replication.backlog.Write(database, internal.EncodeCommand(command))
+	}
+	return nil
+}
+
+// recordLocalWrite records the effects of a local write command on its keys, from their values before the
+// command, and appends them to the effect stream.
+func (server *SugarDB) recordLocalWrite(database int, cmd []string, before map[string]activeactive.Value) error {
+	after, err := server.activeActiveValues(database, slices.Collect(maps.Keys(before)))
+	if err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(before)) {
+		for _, effect := range server.activeActive.state.Local(database, key, before[key], after[key], cmd) {
+			server.activeActive.backlog.Write(database, internal.EncodeCommand(effect))
+		}
+	}
+	return nil
+}
+
+// writeKeys returns the keys written by the command.
+func (server *SugarDB) writeKeys(cmd []string) []string {
+	command, err := server.getCommand(cmd[0])
+	if err != nil {
+		return nil
+	}
+	keyFunc := command.KeyExtractionFunc
+	if sc, err := internal.GetSubCommand(command, cmd); err == nil {
+		if subCommand, ok := sc.(internal.SubCommand); ok {
+			keyFunc = subCommand.KeyExtractionFunc
+		}
+	}
+	keys, err := keyFunc(cmd)
+	if err != nil {
+		return nil
+	}
+	return keys.WriteKeys
+}
+
+// activeActiveValues returns a copy of the values of the keys. Expired keys are treated as missing.
+func (server *SugarDB) activeActiveValues(database int, keys []string) (map[string]activeactive.Value, error) {
+	values := make(map[string]activeactive.Value, len(keys))
+	db := server.getDatabase(database)
+	if db == nil {
+		for _, key := range keys {
+			values[key] = activeactive.Value{}
+		}
+		return values, nil
+	}
+	unlock := db.rLock(keys...)
+	defer unlock()
+	for _, key := range keys {
+		data, ok := db.get(key)
+		value, err := server.activeActiveValue(data, ok)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (server *SugarDB) activeActiveValue(data internal.KeyData, ok bool) (activeactive.Value, error) {
+	if !ok || (data.ExpireAt != (time.Time{}) && data.ExpireAt.Before(server.clock.Now())) {
+		return activeactive.Value{}, nil
+	}
+	value := activeactive.Value{Kind: activeactive.KindOther}
+	if data.ExpireAt != (time.Time{}) {
+		value.ExpireAt = data.ExpireAt.UnixMilli()
+	}
+	loaded, err := server.readSpilled(data.Value)
+	if err != nil {
+		return activeactive.Value{}, err
+	}
+	switch v := decodeValue(loaded).(type) {
+	case string, int, int64, float64:
+		value.Kind = activeactive.KindString
+		value.String = formatScalar(v)
+	case *set.Set:
+		value.Kind = activeactive.KindSet
+		value.Members = v.GetAll()
+		slices.Sort(value.Members)
+	case hash.Hash:
+		value.Kind = activeactive.KindHash
+		value.Fields = make(map[string]string, len(v))
+		for field, fieldValue := range v {
+			value.Fields[field] = formatScalar(fieldValue.Value)
+		}
+	}
+	return value, nil
+}
+
+func formatScalar(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
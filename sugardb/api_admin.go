@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
+	"io"
 	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -194,6 +196,46 @@ func (server *SugarDB) LastSave() (int, error) {
 	return internal.ParseIntegerResponse(b)
 }
 
+// Backup writes a consistent backup archive of the data, ACL users and loaded libraries to w.
+// The archive is a tar file that can be restored on startup with the WithRestoreBackup option or
+// the --restore-backup flag.
+//
+// Writes are only blocked while the data is copied in memory, the archive is written from the copy.
+func (server *SugarDB) Backup(w io.Writer) error {
+	return server.backup(w)
+}
+
+// ReplicaOf makes the instance a read-only replica of the primary at host:port. The replica receives a snapshot of
+// the primary's state, replacing its own data, followed by the stream of the primary's write commands.
+// If the link is lost, the replica reconnects and continues the stream from where it stopped when the primary's
+// replication backlog still holds it. Only works in standalone mode.
+//
+// Returns: true if the replication was started.
+func (server *SugarDB) ReplicaOf(host string, port int) (bool, error) {
+	b, err := server.handleCommand(
+		server.context,
+		internal.EncodeCommand([]string{"REPLICAOF", host, strconv.Itoa(port)}),
+		nil, false, true,
+	)
+	if err != nil {
+		return false, err
+	}
+	res, err := internal.ParseStringResponse(b)
+	return strings.EqualFold(res, "ok"), err
+}
+
+// ReplicaOfNoOne stops the replication and turns the instance into a primary that keeps its current data.
+//
+// Returns: true if the instance is now a primary.
+func (server *SugarDB) ReplicaOfNoOne() (bool, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"REPLICAOF", "NO", "ONE"}), nil, false, true)
+	if err != nil {
+		return false, err
+	}
+	res, err := internal.ParseStringResponse(b)
+	return strings.EqualFold(res, "ok"), err
+}
+
 // RewriteAOF triggers a compaction of the AOF file.
 func (server *SugarDB) RewriteAOF() (string, error) {
 	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"REWRITEAOF"}), nil, false, true)
@@ -203,6 +245,180 @@ func (server *SugarDB) RewriteAOF() (string, error) {
 	return internal.ParseStringResponse(b)
 }
 
+// MemoryStats describes the memory usage of the store, as returned by MemoryStats.
+//
+// `PeakAllocated` - int64 - The highest memory usage of the store since startup.
+//
+// `TotalAllocated` - int64 - The memory usage of the store, which is compared with the max memory.
+//
+// `MaxMemory` - uint64 - The max memory, 0 when there's no limit.
+//
+// `DatasetBytes` - int64 - The memory used by the keys and their values.
+//
+// `OverheadBytes` - int64 - The memory used by the keyspace maps and the indexes of the keys with an expiry.
+//
+// `Keys` - int - The number of keys in all the databases.
+//
+// `Databases` - map[int]DatabaseMemoryStats - The memory usage of each database.
+//
+// `Tiered` - TieredStats - The activity of the tiered mode. It's zeroed when the tiered mode is disabled.
+//
+// `ActiveActive` - ActiveActiveStats - The conflict resolution metadata of active-active replication.
+// It's zeroed when there are no peers.
+//
+// `HeapAlloc` - uint64 - The bytes of allocated heap objects reported by the Go runtime.
+//
+// `HeapSys` - uint64 - The bytes of heap memory obtained from the OS by the Go runtime.
+type MemoryStats struct {
+	PeakAllocated  int64
+	TotalAllocated int64
+	MaxMemory      uint64
+	DatasetBytes   int64
+	OverheadBytes  int64
+	Keys           int
+	Databases      map[int]DatabaseMemoryStats
+	Tiered         TieredStats
+	ActiveActive   ActiveActiveStats
+	HeapAlloc      uint64
+	HeapSys        uint64
+}
+
+// TieredStats describes the activity of the tiered mode, which spills the values of cold keys to a store on disk.
+//
+// `Keys` - int64 - The number of keys whose value is spilled to disk.
+//
+// `Hits` - int64 - The number of reads of a value held in memory since startup.
+//
+// `Misses` - int64 - The number of reads of a spilled value since startup.
+//
+// `Faults` - int64 - The number of spilled values loaded back into memory since startup.
+//
+// `Spills` - int64 - The number of values spilled to disk since startup.
+//
+// `DiskBytes` - int64 - The size of the store on disk.
+type TieredStats struct {
+	Keys      int64
+	Hits      int64
+	Misses    int64
+	Faults    int64
+	Spills    int64
+	DiskBytes int64
+}
+
+// ActiveActiveStats describes the memory usage of the conflict resolution metadata of active-active replication.
+// The memory is added to the memory usage of the store whenever the expired metadata is pruned.
+//
+// `Keys` - int - The number of keys with metadata, including the deleted keys whose metadata isn't pruned yet.
+//
+// `Bytes` - int64 - The memory used by the metadata.
+type ActiveActiveStats struct {
+	Keys  int
+	Bytes int64
+}
+
+// DatabaseMemoryStats describes the memory usage of a database.
+//
+// `Keys` - int - The number of keys in the database.
+//
+// `VolatileKeys` - int - The number of keys in the index of the keys with an expiry.
+//
+// `DatasetBytes` - int64 - The memory used by the keys of the database and their values.
+//
+// `OverheadMain` - int64 - The memory used by the buckets of the keyspace map.
+//
+// `OverheadExpires` - int64 - The memory used by the index of the keys with an expiry.
+type DatabaseMemoryStats struct {
+	Keys            int
+	VolatileKeys    int
+	DatasetBytes    int64
+	OverheadMain    int64
+	OverheadExpires int64
+}
+
+// MemoryQuotaStats describes the usage of a memory quota, as returned by MemoryQuotas.
+//
+// `Database` - int - The database of the quota.
+//
+// `Prefix` - string - The prefix of the keys of the quota. Empty for a quota on all the keys of the database.
+//
+// `MaxMemory` - uint64 - The max memory of the quota.
+//
+// `Policy` - string - The eviction policy of the quota. With noeviction, the writes to the keys of the quota are
+// rejected once the quota is reached.
+//
+// `Used` - int64 - The memory used by the keys of the quota and their values.
+//
+// `Evicted` - int64 - The number of keys evicted to enforce the quota since startup.
+//
+// `Rejected` - int64 - The number of writes rejected by the quota since startup.
+type MemoryQuotaStats struct {
+	Database  int
+	Prefix    string
+	MaxMemory uint64
+	Policy    string
+	Used      int64
+	Evicted   int64
+	Rejected  int64
+}
+
+// MemoryUsage returns the number of bytes used by the key and its value.
+//
+// Parameters:
+//
+// `key` - string - The key to measure.
+//
+// Returns: The number of bytes used by the key, or 0 if the key does not exist.
+func (server *SugarDB) MemoryUsage(key string) (int, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"MEMORY", "USAGE", key}), nil, false, true)
+	if err != nil {
+		return 0, err
+	}
+	return internal.ParseIntegerResponse(b)
+}
+
+// MemoryStats returns the memory usage of the store and of each database. The memory usage is accounted for on
+// every write, so the stats don't require a scan of the keyspace.
+func (server *SugarDB) MemoryStats() MemoryStats {
+	stats := server.memoryStats()
+	res := MemoryStats{
+		PeakAllocated:  stats.PeakAllocated,
+		TotalAllocated: stats.TotalAllocated,
+		MaxMemory:      stats.MaxMemory,
+		DatasetBytes:   stats.DatasetBytes,
+		OverheadBytes:  stats.OverheadBytes,
+		Keys:           stats.Keys,
+		Databases:      make(map[int]DatabaseMemoryStats, len(stats.Databases)),
+		Tiered:         TieredStats(stats.Tiered),
+		ActiveActive:   ActiveActiveStats(stats.ActiveActive),
+		HeapAlloc:      stats.HeapAlloc,
+		HeapSys:        stats.HeapSys,
+	}
+	for database, db := range stats.Databases {
+		res.Databases[database] = DatabaseMemoryStats(db)
+	}
+	return res
+}
+
+// MemoryQuotas returns the usage of each memory quota, ordered by database and prefix. The usage is accounted for
+// on every write, like the memory usage of the store.
+func (server *SugarDB) MemoryQuotas() []MemoryQuotaStats {
+	quotas := server.memoryQuotas()
+	res := make([]MemoryQuotaStats, 0, len(quotas))
+	for _, quota := range quotas {
+		res = append(res, MemoryQuotaStats(quota))
+	}
+	return res
+}
+
+// MemoryDoctor returns a report of the memory issues detected in the store, with advice on how to address them.
+func (server *SugarDB) MemoryDoctor() (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"MEMORY", "DOCTOR"}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
 // AddCommand adds a new command to SugarDB. The added command can be executed using the ExecuteCommand method.
 //
 // Parameters:
@@ -16,8 +16,10 @@ package sugardb
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
 	"github.com/echovault/sugardb/internal/constants"
 	"github.com/tidwall/resp"
@@ -681,3 +683,193 @@ func TestSugarDB_LastSave(t *testing.T) {
 		})
 	}
 }
+
+func TestSugarDB_Backup(t *testing.T) {
+	dataDir := path.Join(".", "testdata", "test_backup")
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dataDir)
+	})
+
+	conf := DefaultConfig()
+	conf.DataDir = path.Join(dataDir, "source")
+	conf.EvictionPolicy = constants.NoEviction
+	server := createSugarDBWithConfig(conf)
+
+	if _, _, err := server.Set("key1", "value1", SETOptions{}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := server.setValues(context.WithValue(context.Background(), "Database", 1),
+		map[string]interface{}{"key2": "value2"}); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := server.ACLSetUser(User{
+		Username:          "backup-user",
+		Enabled:           true,
+		AddPlainPasswords: []string{"password"},
+		IncludeCategories: []string{"*"},
+		IncludeCommands:   []string{"*"},
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := server.LoadModule(path.Join("..", "internal", "volumes", "modules", "lua", "hash.lua")); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Take the backup with the embedded API.
+	buf := new(bytes.Buffer)
+	if err := server.Backup(buf); err != nil {
+		t.Error(err)
+		return
+	}
+	archive := path.Join(dataDir, "backup.tar")
+	if err := os.WriteFile(archive, buf.Bytes(), os.ModePerm); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Take the backup with the BACKUP command.
+	commandArchive := path.Join(dataDir, "command", "backup.tar")
+	res, err := server.ExecuteCommand("BACKUP", commandArchive)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if rv, _, err := resp.NewReader(bytes.NewReader(res)).ReadValue(); err != nil || rv.String() != "OK" {
+		t.Errorf("expected BACKUP response OK, got %q, %v", string(res), err)
+		return
+	}
+
+	server.ShutDown()
+
+	for _, a := range []string{archive, commandArchive} {
+		t.Run(a, func(t *testing.T) {
+			conf := DefaultConfig()
+			conf.DataDir = path.Join(dataDir, "restored", path.Base(path.Dir(a)))
+			conf.EvictionPolicy = constants.NoEviction
+			conf.RestoreBackup = a
+			restored, err := NewSugarDB(WithConfig(conf))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer restored.ShutDown()
+
+			for database, want := range map[string]map[string]string{"0": {"key1": "value1"}, "1": {"key2": "value2"}} {
+				for key, value := range want {
+					got, err := getValue(restored, context.Background(), key, database)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if got != value {
+						t.Errorf("expected value %q for key %s in database %s, got %v", value, key, database, got)
+					}
+				}
+			}
+
+			users, err := restored.ACLUsers()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !slices.Contains(users, "backup-user") {
+				t.Errorf("expected restored ACL users to contain backup-user, got %v", users)
+			}
+
+			if !slices.ContainsFunc(restored.getCommands(), func(command internal.Command) bool {
+				return strings.EqualFold(command.Command, "LUA.HASH")
+			}) {
+				t.Error("expected restored server to load the LUA.HASH library")
+			}
+		})
+	}
+}
+
+func TestSugarDB_Memory(t *testing.T) {
+	server := createSugarDB()
+	t.Cleanup(func() {
+		server.ShutDown()
+	})
+
+	checkTotal := func(stats MemoryStats) {
+		t.Helper()
+		if stats.TotalAllocated != stats.DatasetBytes+stats.OverheadBytes {
+			t.Errorf("expected total %d to be the sum of the dataset %d and the overhead %d",
+				stats.TotalAllocated, stats.DatasetBytes, stats.OverheadBytes)
+		}
+		if stats.PeakAllocated < stats.TotalAllocated {
+			t.Errorf("expected peak %d to be at least the total %d", stats.PeakAllocated, stats.TotalAllocated)
+		}
+	}
+
+	empty := server.MemoryStats()
+	checkTotal(empty)
+	if empty.DatasetBytes != 0 {
+		t.Errorf("expected empty dataset, got %d bytes", empty.DatasetBytes)
+	}
+
+	if _, _, err := server.Set("key", "value", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := server.MemoryUsage("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The key, the key data struct, and the value.
+	if want := 16 + 3 + 56 + 16 + 5; usage != want {
+		t.Errorf("expected memory usage %d, got %d", want, usage)
+	}
+	stats := server.MemoryStats()
+	checkTotal(stats)
+	if stats.DatasetBytes != int64(usage) {
+		t.Errorf("expected dataset of %d bytes, got %d", usage, stats.DatasetBytes)
+	}
+
+	// Values mutated in place are accounted for.
+	if _, err = server.HSet("hash", map[string]string{"field1": "value1"}); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := server.MemoryUsage("hash")
+	if _, err = server.HSet("hash", map[string]string{"field2": strings.Repeat("x", 1000)}); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := server.MemoryUsage("hash")
+	if after < before+1000 {
+		t.Errorf("expected memory usage of the hash to grow from %d by at least 1000 bytes, got %d", before, after)
+	}
+	stats = server.MemoryStats()
+	checkTotal(stats)
+	if stats.DatasetBytes != int64(usage+after) {
+		t.Errorf("expected dataset of %d bytes, got %d", usage+after, stats.DatasetBytes)
+	}
+	if db := stats.Databases[0]; db.Keys != 2 || db.DatasetBytes != stats.DatasetBytes {
+		t.Errorf("expected database 0 to hold 2 keys and the whole dataset, got %+v", db)
+	}
+
+	if report, err := server.MemoryDoctor(); err != nil || report == "" {
+		t.Errorf("expected a memory doctor report, got %q (%v)", report, err)
+	}
+
+	if _, err = server.Del("key"); err != nil {
+		t.Fatal(err)
+	}
+	if usage, _ = server.MemoryUsage("key"); usage != 0 {
+		t.Errorf("expected memory usage 0 for a deleted key, got %d", usage)
+	}
+	stats = server.MemoryStats()
+	checkTotal(stats)
+	if stats.DatasetBytes != int64(after) {
+		t.Errorf("expected dataset of %d bytes, got %d", after, stats.DatasetBytes)
+	}
+
+	server.Flush(-1)
+	stats = server.MemoryStats()
+	checkTotal(stats)
+	if stats.DatasetBytes != 0 || stats.Keys != 0 {
+		t.Errorf("expected empty dataset after flush, got %d bytes in %d keys", stats.DatasetBytes, stats.Keys)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/echovault/sugardb/internal"
+)
+
+// ClusterNode describes a node in the raft configuration, as returned by ClusterNodes.
+//
+// `ID` - string - The raft server ID of the node.
+//
+// `ClientAddr` - string - The address clients connect to. Empty when the node is unreachable.
+//
+// `RaftAddr` - string - The raft address of the node.
+//
+// `Leader` - bool - Whether the node is the cluster leader.
+//
+// `Myself` - bool - Whether the node is the one that handled the request.
+//
+// `Suffrage` - string - Either "voter" or "nonvoter".
+//
+// `Health` - string - The memberlist health of the node. Either "online", "suspect" or "offline".
+type ClusterNode struct {
+	ID         string
+	ClientAddr string
+	RaftAddr   string
+	Leader     bool
+	Myself     bool
+	Suffrage   string
+	Health     string
+}
+
+// ClusterKeySlot returns the hash slot of the key.
+// If the key contains a hashtag (e.g. "{user1000}.following"), only the hashtag is hashed.
+//
+// Parameters:
+//
+// `key` - string - The key to hash.
+//
+// Returns: The hash slot of the key, between 0 and 16383.
+func (server *SugarDB) ClusterKeySlot(key string) (int, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"CLUSTER", "KEYSLOT", key}), nil, false, true)
+	if err != nil {
+		return 0, err
+	}
+	return internal.ParseIntegerResponse(b)
+}
+
+// ClusterMyShardID returns the ID of the shard the node belongs to.
+//
+// Errors:
+//
+// "this instance has cluster sharding disabled" - When the node is not part of a sharded cluster.
+func (server *SugarDB) ClusterMyShardID() (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"CLUSTER", "MYSHARDID"}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// ClusterAddSlots assigns the hash slots to the node's shard. Must be called on the shard leader.
+//
+// Parameters:
+//
+// `slots` - ...int - The hash slots to assign.
+//
+// Errors:
+//
+// "this instance has cluster sharding disabled" - When the node is not part of a sharded cluster.
+//
+// "not shard leader, cannot carry out command" - When the node is not the shard leader.
+func (server *SugarDB) ClusterAddSlots(slots ...int) (string, error) {
+	cmd := []string{"CLUSTER", "ADDSLOTS"}
+	for _, slot := range slots {
+		cmd = append(cmd, strconv.Itoa(slot))
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// ClusterSetSlot changes the migration state of the hash slot. Must be called on the shard leader.
+//
+// Parameters:
+//
+// `slot` - int - The hash slot.
+//
+// `state` - string - One of "MIGRATING", "IMPORTING", "STABLE" or "NODE". Setting a slot to "MIGRATING" moves the
+// slot's keys to the target shard and then hands the slot over to the target shard.
+//
+// `shardID` - string - The target shard for "MIGRATING", the source shard for "IMPORTING" and the new owner for
+// "NODE". Ignored for "STABLE".
+//
+// Errors:
+//
+// "this instance has cluster sharding disabled" - When the node is not part of a sharded cluster.
+//
+// "not shard leader, cannot carry out command" - When the node is not the shard leader.
+func (server *SugarDB) ClusterSetSlot(slot int, state string, shardID string) (string, error) {
+	cmd := []string{"CLUSTER", "SETSLOT", strconv.Itoa(slot), state}
+	if shardID != "" {
+		cmd = append(cmd, shardID)
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// ClusterCountKeysInSlot returns the number of keys of the current database in the hash slot.
+//
+// Parameters:
+//
+// `slot` - int - The hash slot.
+func (server *SugarDB) ClusterCountKeysInSlot(slot int) (int, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{
+		"CLUSTER", "COUNTKEYSINSLOT", strconv.Itoa(slot),
+	}), nil, false, true)
+	if err != nil {
+		return 0, err
+	}
+	return internal.ParseIntegerResponse(b)
+}
+
+// ClusterGetKeysInSlot returns up to count keys of the current database in the hash slot.
+//
+// Parameters:
+//
+// `slot` - int - The hash slot.
+//
+// `count` - int - The maximum number of keys to return.
+func (server *SugarDB) ClusterGetKeysInSlot(slot int, count int) ([]string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{
+		"CLUSTER", "GETKEYSINSLOT", strconv.Itoa(slot), strconv.Itoa(count),
+	}), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return internal.ParseStringArrayResponse(b)
+}
+
+// ClusterInfo returns the raft state of the node, e.g. "raft_state", "raft_term", "raft_commit_index" and
+// "raft_applied_index". In standalone mode, only "cluster_enabled" is returned with the value "0".
+func (server *SugarDB) ClusterInfo() (map[string]string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"CLUSTER", "INFO"}), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	res, err := internal.ParseStringResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	info := make(map[string]string)
+	for _, line := range strings.Split(res, "\r\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok {
+			info[key] = value
+		}
+	}
+	return info, nil
+}
+
+// ClusterNodes returns the nodes in the raft configuration of the node, including the node itself.
+//
+// Errors:
+//
+// "this instance has cluster mode disabled" - When the node is not part of a cluster.
+func (server *SugarDB) ClusterNodes() ([]ClusterNode, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"CLUSTER", "NODES"}), nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	res, err := internal.ParseStringResponse(b)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []ClusterNode
+	for _, line := range strings.Split(strings.TrimSpace(res), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 6 {
+			continue
+		}
+		node := ClusterNode{
+			ID:       fields[0],
+			RaftAddr: fields[2],
+			Suffrage: fields[4],
+			Health:   fields[5],
+		}
+		if fields[1] != "-" {
+			node.ClientAddr = fields[1]
+		}
+		for _, flag := range strings.Split(fields[3], ",") {
+			switch flag {
+			case "myself":
+				node.Myself = true
+			case "leader":
+				node.Leader = true
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// ClusterForget removes the node from the raft configuration. Must be called on the cluster leader.
+//
+// Parameters:
+//
+// `id` - string - The raft server ID of the node to remove.
+//
+// Errors:
+//
+// "this instance has cluster mode disabled" - When the node is not part of a cluster.
+//
+// "not cluster leader, cannot carry out command" - When the node is not the cluster leader.
+func (server *SugarDB) ClusterForget(id string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"CLUSTER", "FORGET", id}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// ClusterTransferLeadership hands over leadership to another voter. Must be called on the cluster leader.
+//
+// Parameters:
+//
+// `id` - string - The raft server ID of the new leader. If empty, the most up-to-date voter is picked.
+//
+// Errors:
+//
+// "this instance has cluster mode disabled" - When the node is not part of a cluster.
+//
+// "not cluster leader, cannot carry out command" - When the node is not the cluster leader.
+func (server *SugarDB) ClusterTransferLeadership(id string) (string, error) {
+	cmd := []string{"CLUSTER", "TRANSFER-LEADERSHIP"}
+	if id != "" {
+		cmd = append(cmd, id)
+	}
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(cmd), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// ClusterAddNonvoter adds a node that replicates the raft log without taking part in elections.
+// Must be called on the cluster leader.
+//
+// Parameters:
+//
+// `id` - string - The raft server ID of the node.
+//
+// `raftAddr` - string - The raft address of the node.
+//
+// Errors:
+//
+// "this instance has cluster mode disabled" - When the node is not part of a cluster.
+//
+// "not cluster leader, cannot carry out command" - When the node is not the cluster leader.
+func (server *SugarDB) ClusterAddNonvoter(id string, raftAddr string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{
+		"CLUSTER", "ADD-NONVOTER", id, raftAddr,
+	}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
+// ClusterPromote turns the non-voter into a voter that takes part in elections and commits.
+// Must be called on the cluster leader.
+//
+// Parameters:
+//
+// `id` - string - The raft server ID of the non-voter.
+//
+// Errors:
+//
+// "this instance has cluster mode disabled" - When the node is not part of a cluster.
+//
+// "not cluster leader, cannot carry out command" - When the node is not the cluster leader.
+func (server *SugarDB) ClusterPromote(id string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"CLUSTER", "PROMOTE", id}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
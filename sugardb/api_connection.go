@@ -15,8 +15,13 @@
 package sugardb
 
 import (
+	"context"
 	"errors"
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/constants"
 	"slices"
+	"strconv"
+	"strings"
 )
 
 // SetProtocol sets the RESP protocol that's expected from responses to embedded API calls.
@@ -55,11 +60,7 @@ func (server *SugarDB) SelectDB(database int) error {
 		return errors.New("database index must be 0 or higher")
 	}
 	// If the database index does not exist, create the new database.
-	server.storeLock.Lock()
-	if server.store[database] == nil {
-		server.createDatabase(database)
-	}
-	server.storeLock.Unlock()
+	server.getOrCreateDatabase(database)
 
 	// Set the DB.
 	server.connInfo.mut.Lock()
@@ -68,3 +69,93 @@ func (server *SugarDB) SelectDB(database int) error {
 
 	return nil
 }
+
+// SetReadConsistency sets the consistency level of reads made through the embedded API in cluster mode.
+// This does not affect the level used by any of the TCP clients.
+//
+// Parameters:
+//
+// `level` - string - The read consistency level. "stale" serves reads from the local node, "leader" forwards
+// reads to the cluster leader and "linearizable" only serves reads once the local node has applied every write
+// committed by the leader before the read.
+//
+// Errors:
+//
+// "read consistency must be 'stale', 'leader' or 'linearizable'" - When the provided level is not supported.
+func (server *SugarDB) SetReadConsistency(level string) error {
+	level = strings.ToLower(level)
+	if !slices.Contains([]string{
+		constants.ReadConsistencyStale,
+		constants.ReadConsistencyLeader,
+		constants.ReadConsistencyLinearizable,
+	}, level) {
+		return errors.New("read consistency must be 'stale', 'leader' or 'linearizable'")
+	}
+	server.connInfo.mut.Lock()
+	defer server.connInfo.mut.Unlock()
+	server.connInfo.embedded.ReadConsistency = level
+	return nil
+}
+
+// Wait blocks until at least numReplicas replicas have applied the latest write made through the embedded API,
+// or until the context is done.
+//
+// Parameters:
+//
+// `ctx` - context.Context - the context used to stop waiting. If the context has no deadline or cancellation,
+// Wait blocks until numReplicas replicas have applied the write.
+//
+// `numReplicas` - int - the number of replicas that must apply the write.
+//
+// Returns: The number of replicas that applied the write. In standalone mode, the replicas are the nodes that
+// replicate this node with REPLICAOF.
+//
+// Errors:
+//
+// "numreplicas must be an integer >= 0" - when numReplicas is negative.
+//
+// "WAIT cannot be used with replica instances" - when called on a cluster node that is not the leader.
+func (server *SugarDB) Wait(ctx context.Context, numReplicas int) (int, error) {
+	b, err := server.handleCommand(ctx, internal.EncodeCommand([]string{
+		"WAIT", strconv.Itoa(numReplicas), "0",
+	}), nil, false, true)
+	if err != nil {
+		return 0, err
+	}
+	return internal.ParseIntegerResponse(b)
+}
+
+// WaitAOF blocks until the latest write made through the embedded API is durable, or until the context is done.
+//
+// Parameters:
+//
+// `ctx` - context.Context - the context used to stop waiting. If the context has no deadline or cancellation,
+// WaitAOF blocks until the write has been acknowledged by numLocal local nodes and numReplicas replicas.
+//
+// `numLocal` - int - either 0 or 1. When 1, wait for the write to be fsynced to the local append only log.
+//
+// `numReplicas` - int - the number of replicas that must apply the write.
+//
+// Returns: The number of local nodes (0 or 1) that have persisted the write and
+// the number of replicas that applied the write.
+//
+// Errors:
+//
+// "numlocal must be either 0 or 1" - when numLocal is not 0 or 1.
+//
+// "numreplicas must be an integer >= 0" - when numReplicas is negative.
+//
+// "WAITAOF cannot be used when numlocal is set..." - when numLocal is 1 but the log is not persisted to disk.
+func (server *SugarDB) WaitAOF(ctx context.Context, numLocal int, numReplicas int) (int, int, error) {
+	b, err := server.handleCommand(ctx, internal.EncodeCommand([]string{
+		"WAITAOF", strconv.Itoa(numLocal), strconv.Itoa(numReplicas), "0",
+	}), nil, false, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := internal.ParseIntegerArrayResponse(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return res[0], res[1], nil
+}
@@ -17,12 +17,15 @@ package sugardb
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
 	"github.com/echovault/sugardb/internal/modules/connection"
 	"github.com/tidwall/resp"
+	"net"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSugarDB_Hello(t *testing.T) {
@@ -283,3 +286,132 @@ func TestSugarDB_SetProtocol(t *testing.T) {
 		})
 	}
 }
+
+func TestSugarDB_SetReadConsistency(t *testing.T) {
+	t.Parallel()
+	server := createSugarDB()
+	tests := []struct {
+		name    string
+		level   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "1. Change read consistency to linearizable",
+			level: "linearizable",
+			want:  constants.ReadConsistencyLinearizable,
+		},
+		{
+			name:  "2. Change read consistency to leader regardless of case",
+			level: "LEADER",
+			want:  constants.ReadConsistencyLeader,
+		},
+		{
+			name:    "3. Return error when the read consistency is not supported",
+			level:   "eventual",
+			want:    constants.ReadConsistencyLeader,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := server.SetReadConsistency(tt.level)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SetReadConsistency() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if server.connInfo.embedded.ReadConsistency != tt.want {
+				t.Errorf("SetReadConsistency() level = %v, want %v", server.connInfo.embedded.ReadConsistency, tt.want)
+			}
+		})
+	}
+}
+
+func TestSugarDB_SwapDBs(t *testing.T) {
+	t.Parallel()
+	server := createSugarDB()
+
+	var conn1, conn2, conn3 net.Conn
+	server.connInfo.mut.Lock()
+	server.connInfo.tcpClients[&conn1] = internal.ConnectionInfo{
+		Id: 1, Name: "conn1", Protocol: 3, Database: 0,
+		ReadConsistency: constants.ReadConsistencyLinearizable, Asking: true,
+	}
+	server.connInfo.tcpClients[&conn2] = internal.ConnectionInfo{
+		Id: 2, Name: "conn2", Protocol: 2, Database: 1, ReadConsistency: constants.ReadConsistencyLeader,
+	}
+	server.connInfo.tcpClients[&conn3] = internal.ConnectionInfo{Id: 3, Name: "conn3", Protocol: 2, Database: 2}
+	server.connInfo.mut.Unlock()
+
+	server.SwapDBs(0, 1)
+
+	want := map[*net.Conn]internal.ConnectionInfo{
+		&conn1: {
+			Id: 1, Name: "conn1", Protocol: 3, Database: 1,
+			ReadConsistency: constants.ReadConsistencyLinearizable, Asking: true,
+		},
+		&conn2: {Id: 2, Name: "conn2", Protocol: 2, Database: 0, ReadConsistency: constants.ReadConsistencyLeader},
+		&conn3: {Id: 3, Name: "conn3", Protocol: 2, Database: 2},
+	}
+	server.connInfo.mut.RLock()
+	defer server.connInfo.mut.RUnlock()
+	for conn, info := range want {
+		if got := server.connInfo.tcpClients[conn]; got != info {
+			t.Errorf("SwapDBs() connection %d = %+v, want %+v", info.Id, got, info)
+		}
+	}
+}
+
+func TestSugarDB_WaitAOF(t *testing.T) {
+	t.Parallel()
+
+	conf := DefaultConfig()
+	conf.DataDir = t.TempDir()
+	conf.AOFSyncStrategy = "no"
+	conf.EvictionPolicy = constants.NoEviction
+	server := createSugarDBWithConfig(conf)
+	t.Cleanup(func() {
+		server.ShutDown()
+	})
+
+	if _, _, err := server.Set("WaitAOFKey1", "value1", SETOptions{}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The "no" strategy never syncs on its own so a cancelled context must not report the write as durable.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	local, replicas, err := server.WaitAOF(cancelled, 0, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if local != 0 || replicas != 0 {
+		t.Errorf("expected WaitAOF() = (0, 0) before sync, got (%d, %d)", local, replicas)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	local, replicas, err = server.WaitAOF(ctx, 1, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if local != 1 || replicas != 0 {
+		t.Errorf("expected WaitAOF() = (1, 0), got (%d, %d)", local, replicas)
+	}
+
+	n, err := server.Wait(ctx, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 0 {
+		t.Errorf("expected Wait() = 0 in standalone mode, got %d", n)
+	}
+
+	if _, _, err = server.WaitAOF(ctx, 2, 0); err == nil {
+		t.Errorf("expected WaitAOF() to return an error when numLocal is 2")
+	}
+}
@@ -274,6 +274,22 @@ func (server *SugarDB) Del(keys ...string) (int, error) {
 	return internal.ParseIntegerResponse(b)
 }
 
+// Unlink removes the given keys from the store like Del. The keys are removed from the keyspace right away, and large
+// values are released on a background goroutine.
+//
+// Parameters:
+//
+// `keys` - []string - the keys to delete from the store.
+//
+// Returns: The number of keys that were successfully unlinked.
+func (server *SugarDB) Unlink(keys ...string) (int, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand(append([]string{"UNLINK"}, keys...)), nil, false, true)
+	if err != nil {
+		return 0, err
+	}
+	return internal.ParseIntegerResponse(b)
+}
+
 // Persist removes the expiry associated with a key and makes it permanent.
 // Has no effect on a key that is already persistent.
 //
@@ -746,6 +762,23 @@ func (server *SugarDB) ObjectIdleTime(key string) (float64, error) {
 	return internal.ParseFloatResponse(b)
 }
 
+// ObjectEncoding returns the internal encoding of the value stored at <key>. Small hashes, sets, sorted sets and
+// lists are held in the listpack or intset encodings until they grow past the configured size thresholds.
+//
+// Parameters:
+//
+// `key` - string - the key whose encoding should be returned.
+//
+// Returns: The encoding of the value, which is one of int, raw, listpack, intset, hashtable or array.
+// If the key doesn't exist, an empty string is returned.
+func (server *SugarDB) ObjectEncoding(key string) (string, error) {
+	b, err := server.handleCommand(server.context, internal.EncodeCommand([]string{"OBJECT", "ENCODING", key}), nil, false, true)
+	if err != nil {
+		return "", err
+	}
+	return internal.ParseStringResponse(b)
+}
+
 // Type returns the string representation of the type of the value stored at key.
 // The different types that can be returned are: string, integer, float, list, set, zset, and hash.
 //
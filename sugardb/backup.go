@@ -0,0 +1,195 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/backup"
+	"github.com/echovault/sugardb/internal/constants"
+)
+
+// backup writes a backup archive of the current state, ACL users and loaded libraries to w.
+// Writers are only blocked while the state is copied, the archive is written from the copy.
+func (server *SugarDB) backup(w io.Writer) error {
+	createdAt := server.clock.Now().UnixMilli()
+
+	databases, err := server.getState()
+	if err != nil {
+		return fmt.Errorf("backup state: %v", err)
+	}
+	state := make(map[int]map[string]internal.KeyData)
+	for database, data := range databases {
+		state[database] = make(map[string]internal.KeyData)
+		for key, value := range data {
+			if keyData, ok := value.(internal.KeyData); ok {
+				state[database][key] = keyData
+			}
+		}
+	}
+
+	users, err := server.acl.ExportUsers()
+	if err != nil {
+		return fmt.Errorf("backup ACL users: %v", err)
+	}
+
+	libraries, err := server.getLibraries()
+	if err != nil {
+		return err
+	}
+
+	mode := "standalone"
+	if server.isInCluster() {
+		mode = "cluster"
+	}
+
+	return backup.Write(w, backup.Backup{
+		Metadata: backup.Metadata{
+			ServerVersion: constants.Version,
+			ServerID:      server.config.ServerID,
+			Mode:          mode,
+			CreatedAt:     createdAt,
+		},
+		State:     state,
+		Users:     users,
+		Libraries: libraries,
+	}, server.keyring)
+}
+
+// getLibraries returns the content of the scripts and plugins loaded into the server.
+// Commands that are not loaded from a file (e.g. commands added with the AddCommand method) are skipped.
+func (server *SugarDB) getLibraries() ([]backup.Library, error) {
+	server.commandsRWMut.RLock()
+	var paths []string
+	for _, command := range server.commands {
+		if command.Type == "BUILT_IN" || slices.Contains(paths, command.Module) {
+			continue
+		}
+		if info, err := os.Stat(command.Module); err != nil || info.IsDir() {
+			continue
+		}
+		paths = append(paths, command.Module)
+	}
+	server.commandsRWMut.RUnlock()
+
+	libraries := make([]backup.Library, len(paths))
+	for i, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("backup library %s: %v", path, err)
+		}
+		libraries[i] = backup.Library{Path: path, Content: content}
+	}
+
+	return libraries, nil
+}
+
+// restoreBackup restores the state, ACL users and libraries from the backup archive at the provided path.
+func (server *SugarDB) restoreBackup(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("restore backup: %v", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	b, err := backup.Read(f, server.keyring)
+	if err != nil {
+		return fmt.Errorf("restore backup: %v", err)
+	}
+
+	for _, library := range b.Libraries {
+		if err = server.restoreLibrary(library); err != nil {
+			log.Printf("restore backup: %v\n", err)
+		}
+	}
+
+	if len(b.Users) > 0 {
+		if err = server.acl.ImportUsers(b.Users); err != nil {
+			return fmt.Errorf("restore backup ACL users: %v", err)
+		}
+	}
+
+	if err = server.loadState(b.State); err != nil {
+		return fmt.Errorf("restore backup %v", err)
+	}
+
+	log.Printf("successfully restored backup %s created at %d\n", path, b.Metadata.CreatedAt)
+
+	return nil
+}
+
+// loadState loads the unexpired keys of the state into the store.
+// The loaded state is then persisted so that it survives a restart.
+func (server *SugarDB) loadState(state map[int]map[string]internal.KeyData) error {
+	for database, data := range internal.FilterExpiredKeys(server.clock.Now(), state) {
+		ctx := context.WithValue(context.Background(), "Database", database)
+		for key, keyData := range data {
+			if err := server.setValues(ctx, map[string]interface{}{key: keyData.Value}); err != nil {
+				return fmt.Errorf("key %s: %v", key, err)
+			}
+			server.setExpiry(ctx, key, keyData.ExpireAt, false)
+		}
+	}
+
+	server.persistState()
+	return nil
+}
+
+// persistState rewrites the AOF log and takes a snapshot of the state that was loaded, so that it survives a restart.
+func (server *SugarDB) persistState() {
+	if server.config.DataDir == "" {
+		return
+	}
+	if err := server.aofEngine.RewriteLog(); err != nil {
+		log.Printf("load state: %v\n", err)
+	}
+	if err := server.snapshotEngine.TakeSnapshot(); err != nil {
+		log.Printf("load state: %v\n", err)
+	}
+}
+
+// restoreLibrary loads a library from the backup unless a library with the same path is already loaded.
+// The library is extracted to the libraries folder in the data directory before it's loaded.
+func (server *SugarDB) restoreLibrary(library backup.Library) error {
+	if slices.Contains(server.ListModules(), strings.ToLower(library.Path)) {
+		return nil
+	}
+	if server.config.DataDir == "" {
+		return fmt.Errorf("cannot restore library %s without a data directory", library.Path)
+	}
+
+	dirname := filepath.Join(server.config.DataDir, "libraries")
+	if err := os.MkdirAll(dirname, os.ModePerm); err != nil {
+		return err
+	}
+	target := filepath.Join(dirname, filepath.Base(library.Path))
+	if err := os.WriteFile(target, library.Content, 0600); err != nil {
+		return err
+	}
+
+	return server.LoadModule(target)
+}
@@ -17,11 +17,19 @@ package sugardb
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/memberlist"
+	"github.com/hashicorp/raft"
+	"github.com/sethvargo/go-retry"
+	"net"
 	"time"
 )
 
+// errClusterDisabled is returned by the cluster administration commands in standalone mode.
+var errClusterDisabled = errors.New("this instance has cluster mode disabled")
+
 func (server *SugarDB) isInCluster() bool {
 	return server.config.BootstrapCluster || server.config.JoinAddr != ""
 }
@@ -65,40 +73,286 @@ func (server *SugarDB) raftApplyDeleteKey(ctx context.Context, key string) error
 }
 
 func (server *SugarDB) raftApplyCommand(ctx context.Context, cmd []string) ([]byte, error) {
+	res, _, err := server.raftApplyCommandWithIndex(ctx, cmd)
+	return res, err
+}
+
+// raftApplyCommandWithIndex applies the command through the raft log and returns
+// the index of the log entry along with the command's response.
+func (server *SugarDB) raftApplyCommandWithIndex(ctx context.Context, cmd []string) ([]byte, uint64, error) {
 	serverId, _ := ctx.Value(internal.ContextServerID("ServerID")).(string)
 	connectionId, _ := ctx.Value(internal.ContextConnID("ConnectionID")).(string)
 	protocol, _ := ctx.Value("Protocol").(int)
 	database, _ := ctx.Value("Database").(int)
 
-	applyRequest := internal.ApplyRequest{
+	// Rewrite the command into its deterministic form before appending it to the raft log.
+	// This makes sure that every follower applies exactly the same effect as the leader.
+	rewrite, err := server.rewriteCommand(context.WithValue(ctx, "Database", database), cmd, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	cmd = rewrite.Command
+
+	// Concurrent commands are batched into one raft log entry.
+	r, index, err := server.raft.ApplyCommand(ctx, internal.ApplyRequest{
 		Type:         "command",
 		ServerID:     serverId,
 		ConnectionID: connectionId,
 		Protocol:     protocol,
 		Database:     database,
 		CMD:          cmd,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if r.Error != nil {
+		return nil, 0, r.Error
 	}
 
-	b, err := json.Marshal(applyRequest)
+	if rewrite.Response != nil {
+		return rewrite.Response, index, nil
+	}
+
+	return r.Response, index, nil
+}
+
+// forwardCommand forwards the command to the cluster leader and returns the leader's response.
+// Once the leader responds, this waits until the command has also been applied on the local node so that
+// the client can read its own write from this node.
+func (server *SugarDB) forwardCommand(ctx context.Context, message []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, server.config.ForwardTimeout)
+	defer cancel()
+
+	res, err := server.forwardToLeader(ctx, func(ctx context.Context, leader raft.ServerID) memberlist.ForwardResult {
+		return server.memberList.ForwardCommand(ctx, leader, message)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not parse command request for commad: %+v", cmd)
+		return nil, err
 	}
 
-	applyFuture := server.raft.Apply(b, 500*time.Millisecond)
+	// Best effort, the leader's response is still returned if the local node is lagging behind.
+	_ = server.raft.WaitForAppliedIndex(ctx, res.Index)
 
-	if err = applyFuture.Error(); err != nil {
+	return res.Response, nil
+}
+
+// forwardRead forwards the read command to the cluster leader and returns the leader's response.
+func (server *SugarDB) forwardRead(ctx context.Context, message []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, server.config.ForwardTimeout)
+	defer cancel()
+
+	res, err := server.forwardToLeader(ctx, func(ctx context.Context, leader raft.ServerID) memberlist.ForwardResult {
+		return server.memberList.ForwardRead(ctx, leader, message)
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	r, ok := applyFuture.Response().(internal.ApplyResponse)
+	return res.Response, nil
+}
 
-	if !ok {
-		return nil, fmt.Errorf("unprocessable entity %v", r)
+// handleForwardedRead executes a read command forwarded by a follower against the leader's local state.
+func (server *SugarDB) handleForwardedRead(ctx context.Context, cmd []string) ([]byte, error) {
+	handler, err := server.getHandlerFunc(cmd)
+	if err != nil {
+		return nil, err
 	}
+	return handler(server.getHandlerFuncParams(ctx, cmd, nil))
+}
 
-	if r.Error != nil {
-		return nil, r.Error
+// readBarrier blocks until the local node has applied every write committed before the read started.
+// The leader confirms its leadership and provides its commit index, which the local FSM must catch up to.
+func (server *SugarDB) readBarrier(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, server.config.ForwardTimeout)
+	defer cancel()
+
+	var index uint64
+	if server.raft.IsRaftLeader() {
+		var err error
+		if index, err = server.raft.ReadIndex(); err != nil {
+			return fmt.Errorf("linearizable read: %v", err)
+		}
+	} else {
+		res, err := server.forwardToLeader(ctx, func(ctx context.Context, leader raft.ServerID) memberlist.ForwardResult {
+			return server.memberList.ReadIndex(ctx, leader)
+		})
+		if err != nil {
+			return fmt.Errorf("linearizable read: %v", err)
+		}
+		index = res.Index
+	}
+
+	if err := server.raft.WaitForAppliedIndex(ctx, index); err != nil {
+		return fmt.Errorf("linearizable read: %v", err)
+	}
+	return nil
+}
+
+// forwardToLeader sends a request to the cluster leader using the send function.
+// The request is retried while there's no leader or the leader changes, until the context is done.
+// Requests that were delivered to the leader are never retried.
+func (server *SugarDB) forwardToLeader(
+	ctx context.Context,
+	send func(ctx context.Context, leader raft.ServerID) memberlist.ForwardResult,
+) (memberlist.ForwardResult, error) {
+	var res memberlist.ForwardResult
+	var retryErr error
+	backoffPolicy := internal.RetryBackoff(retry.NewFibonacci(50*time.Millisecond), 0, 0, 500*time.Millisecond, 0)
+
+	err := retry.Do(ctx, backoffPolicy, func(ctx context.Context) error {
+		retryErr = nil
+		leader := server.raft.LeaderID()
+		if leader == "" {
+			retryErr = errors.New("no cluster leader")
+			return retry.RetryableError(retryErr)
+		}
+		res = send(ctx, leader)
+		// Only retry when the request has definitely not been handled by the leader.
+		if errors.Is(res.Err, memberlist.ErrNotLeader) || errors.Is(res.Err, memberlist.ErrForwardNotSent) {
+			retryErr = res.Err
+			return retry.RetryableError(retryErr)
+		}
+		return res.Err
+	})
+	if err != nil {
+		if retryErr != nil && errors.Is(err, ctx.Err()) {
+			// The timeout was reached while retrying, so the request was not handled.
+			return res, fmt.Errorf("forward to leader: %v", retryErr)
+		}
+		return res, err
+	}
+
+	return res, nil
+}
+
+// waitForReplicas blocks until at least numReplicas raft followers have applied the latest write made by
+// the connection, or until the context is done. It returns the number of followers that applied the write.
+// In standalone mode, the replicas started with REPLICAOF are counted instead.
+func (server *SugarDB) waitForReplicas(ctx context.Context, conn *net.Conn, numReplicas int) (int, error) {
+	if !server.isInCluster() {
+		return server.waitForReplicaAcks(ctx, conn, numReplicas), nil
+	}
+	if !server.raft.IsRaftLeader() {
+		return 0, errors.New("WAIT cannot be used with replica instances")
+	}
+
+	followers, err := server.raft.Followers()
+	if err != nil {
+		return 0, err
+	}
+
+	server.lastWrite.mut.RLock()
+	index := server.lastWrite.clients[conn]
+	server.lastWrite.mut.RUnlock()
+
+	return server.memberList.WaitForApplied(ctx, followers, index, numReplicas), nil
+}
+
+// getClusterInfo returns the raft state of the node. In standalone mode, only Enabled is set to false.
+func (server *SugarDB) getClusterInfo() (internal.ClusterInfo, error) {
+	if !server.isInCluster() {
+		return internal.ClusterInfo{Enabled: false}, nil
+	}
+
+	servers, err := server.raft.Servers()
+	if err != nil {
+		return internal.ClusterInfo{}, err
 	}
 
-	return r.Response, nil
+	info := internal.ClusterInfo{
+		Enabled:      true,
+		ServerID:     server.config.ServerID,
+		ShardID:      server.config.ShardID,
+		State:        server.raft.State(),
+		LeaderID:     string(server.raft.LeaderID()),
+		Term:         server.raft.Term(),
+		CommitIndex:  server.raft.CommitIndex(),
+		AppliedIndex: server.raft.AppliedIndex(),
+		LastIndex:    server.raft.LastIndex(),
+		Members:      len(server.memberList.Members()),
+	}
+	for _, s := range servers {
+		if s.Suffrage == raft.Voter {
+			info.Voters += 1
+		} else {
+			info.Nonvoters += 1
+		}
+		if string(s.ID) == server.config.ServerID {
+			info.Suffrage = "voter"
+			if s.Suffrage != raft.Voter {
+				info.Suffrage = "nonvoter"
+			}
+		}
+	}
+	return info, nil
+}
+
+// getClusterNodes returns the nodes in the raft configuration of the node along with their memberlist health.
+func (server *SugarDB) getClusterNodes() ([]internal.ClusterNodeInfo, error) {
+	if !server.isInCluster() {
+		return nil, errClusterDisabled
+	}
+
+	servers, err := server.raft.Servers()
+	if err != nil {
+		return nil, err
+	}
+
+	leader := server.raft.LeaderID()
+	health := server.memberList.Health()
+
+	nodes := make([]internal.ClusterNodeInfo, 0, len(servers))
+	for _, s := range servers {
+		node := internal.ClusterNodeInfo{
+			ID:       string(s.ID),
+			RaftAddr: string(s.Address),
+			Suffrage: "voter",
+			Leader:   s.ID == leader,
+			Myself:   string(s.ID) == server.config.ServerID,
+			Health:   "offline",
+		}
+		if s.Suffrage != raft.Voter {
+			node.Suffrage = "nonvoter"
+		}
+		if h, ok := health[s.ID]; ok {
+			node.Health = h
+		}
+		node.ClientAddr, _ = server.memberList.ClientAddr(string(s.ID))
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// forgetNode removes the node from the raft configuration. Must be called on the leader.
+func (server *SugarDB) forgetNode(id string) error {
+	if !server.isInCluster() {
+		return errClusterDisabled
+	}
+	return server.raft.RemoveServerByID(raft.ServerID(id))
+}
+
+// transferLeadership hands over leadership to the voter with the provided ID, or to the most
+// up-to-date voter when the ID is empty. Must be called on the leader.
+func (server *SugarDB) transferLeadership(id string) error {
+	if !server.isInCluster() {
+		return errClusterDisabled
+	}
+	return server.raft.TransferLeadership(raft.ServerID(id))
+}
+
+// addNonvoter adds a node that replicates the raft log without voting. Must be called on the leader.
+func (server *SugarDB) addNonvoter(id string, raftAddr string) error {
+	if !server.isInCluster() {
+		return errClusterDisabled
+	}
+	return server.raft.AddNonvoter(raft.ServerID(id), raft.ServerAddress(raftAddr))
+}
+
+// promoteNode turns the non-voter into a voter. Must be called on the leader.
+func (server *SugarDB) promoteNode(id string) error {
+	if !server.isInCluster() {
+		return errClusterDisabled
+	}
+	return server.raft.Promote(raft.ServerID(id))
 }
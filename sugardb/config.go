@@ -41,6 +41,9 @@ func (server *SugarDB) GetServerInfo() internal.ServerInfo {
 		}(),
 		Role: func() string {
 			if !server.isInCluster() {
+				if server.isReplica() {
+					return "replica"
+				}
 				return "master"
 			}
 			if server.raft.IsRaftLeader() {
@@ -49,7 +52,7 @@ func (server *SugarDB) GetServerInfo() internal.ServerInfo {
 			return "replica"
 		}(),
 		Modules:    server.ListModules(),
-		MemoryUsed: server.memUsed,
+		MemoryUsed: server.memUsed.Load(),
 		MaxMemory:  server.config.MaxMemory,
 	}
 }
@@ -182,6 +185,88 @@ func WithBootstrapCluster(b ...bool) func(sugardb *SugarDB) {
 	}
 }
 
+// WithShardID is an option to the NewSugarDB function that allows you to pass a
+// custom ShardID to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithShardID(shardID string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ShardID = shardID
+	}
+}
+
+// WithRaftRole is an option to the NewSugarDB function that allows you to pass a
+// custom RaftRole to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftRole(raftRole string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RaftRole = raftRole
+	}
+}
+
+// WithRaftTLS is an option to the NewSugarDB function that allows you to pass a
+// custom RaftTLS to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftTLS(b ...bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		if len(b) > 0 {
+			sugardb.config.RaftTLS = b[0]
+		} else {
+			sugardb.config.RaftTLS = true
+		}
+	}
+}
+
+// WithRaftMTLS is an option to the NewSugarDB function that allows you to pass a
+// custom RaftMTLS to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftMTLS(b ...bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		if len(b) > 0 {
+			sugardb.config.RaftMTLS = b[0]
+		} else {
+			sugardb.config.RaftMTLS = true
+		}
+	}
+}
+
+// WithRaftCertKeyPairs is an option to the NewSugarDB function that allows you to pass a
+// custom RaftCertKeyPairs to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftCertKeyPairs(raftCertKeyPairs []CertKeyPair) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		for _, pair := range raftCertKeyPairs {
+			sugardb.config.RaftCertKeyPairs = append(sugardb.config.RaftCertKeyPairs, []string{pair.Cert, pair.Key})
+		}
+	}
+}
+
+// WithRaftCAs is an option to the NewSugarDB function that allows you to pass a
+// custom RaftCAs to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftCAs(raftCAs []string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RaftCAs = raftCAs
+	}
+}
+
+// WithRaftTLSServerName is an option to the NewSugarDB function that allows you to pass a
+// custom RaftTLSServerName to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftTLSServerName(raftTLSServerName string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RaftTLSServerName = raftTLSServerName
+	}
+}
+
+// WithGossipKeyFile is an option to the NewSugarDB function that allows you to pass a
+// custom GossipKeyFile to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithGossipKeyFile(gossipKeyFile string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.GossipKeyFile = gossipKeyFile
+	}
+}
+
 // WithAclConfig is an option to the NewSugarDB function that allows you to pass a
 // custom AclConfig to SugarDB.
 // If not specified, SugarDB will use the default configuration from config.DefaultConfig().
@@ -204,6 +289,51 @@ func WithForwardCommand(b ...bool) func(sugardb *SugarDB) {
 	}
 }
 
+// WithForwardTimeout is an option to the NewSugarDB function that allows you to pass a
+// custom ForwardTimeout to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithForwardTimeout(forwardTimeout time.Duration) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ForwardTimeout = forwardTimeout
+	}
+}
+
+// WithRaftBatchWindow is an option to the NewSugarDB function that allows you to pass a
+// custom RaftBatchWindow to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftBatchWindow(raftBatchWindow time.Duration) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RaftBatchWindow = raftBatchWindow
+	}
+}
+
+// WithRaftBatchSize is an option to the NewSugarDB function that allows you to pass a
+// custom RaftBatchSize to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftBatchSize(raftBatchSize uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RaftBatchSize = raftBatchSize
+	}
+}
+
+// WithRaftApplyTimeout is an option to the NewSugarDB function that allows you to pass a
+// custom RaftApplyTimeout to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRaftApplyTimeout(raftApplyTimeout time.Duration) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RaftApplyTimeout = raftApplyTimeout
+	}
+}
+
+// WithReadConsistency is an option to the NewSugarDB function that allows you to pass a
+// custom ReadConsistency to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithReadConsistency(readConsistency string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ReadConsistency = readConsistency
+	}
+}
+
 // WithRequirePass is an option to the NewSugarDB function that allows you to pass a
 // custom RequirePass to SugarDB.
 // If not specified, SugarDB will use the default configuration from config.DefaultConfig().
@@ -279,6 +409,109 @@ func WithAOFSyncStrategy(aOFSyncStrategy string) func(sugardb *SugarDB) {
 	}
 }
 
+// WithRestoreBackup is an option to the NewSugarDB function that allows you to pass a
+// custom RestoreBackup to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithRestoreBackup(restoreBackup string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.RestoreBackup = restoreBackup
+	}
+}
+
+// WithReplicaOf is an option to the NewSugarDB function that allows you to pass a
+// custom ReplicaOf to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithReplicaOf(replicaOf string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ReplicaOf = replicaOf
+	}
+}
+
+// WithReplBacklogSize is an option to the NewSugarDB function that allows you to pass a
+// custom ReplBacklogSize to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithReplBacklogSize(replBacklogSize uint64) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ReplBacklogSize = replBacklogSize
+	}
+}
+
+// WithPrimaryUser is an option to the NewSugarDB function that allows you to pass a
+// custom PrimaryUser to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithPrimaryUser(primaryUser string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.PrimaryUser = primaryUser
+	}
+}
+
+// WithPrimaryAuth is an option to the NewSugarDB function that allows you to pass a
+// custom PrimaryAuth to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithPrimaryAuth(primaryAuth string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.PrimaryAuth = primaryAuth
+	}
+}
+
+// WithPrimaryTLS is an option to the NewSugarDB function that allows you to pass a
+// custom PrimaryTLS to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithPrimaryTLS(b ...bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		if len(b) > 0 {
+			sugardb.config.PrimaryTLS = b[0]
+		} else {
+			sugardb.config.PrimaryTLS = true
+		}
+	}
+}
+
+// WithPrimaryCAs is an option to the NewSugarDB function that allows you to pass a
+// custom list of PrimaryCAs to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithPrimaryCAs(primaryCAs []string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.PrimaryCAs = primaryCAs
+	}
+}
+
+// WithSiteID is an option to the NewSugarDB function that allows you to pass a
+// custom SiteID to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithSiteID(siteID string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.SiteID = siteID
+	}
+}
+
+// WithPeers is an option to the NewSugarDB function that allows you to pass a
+// custom list of Peers to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithPeers(peers []string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.Peers = peers
+	}
+}
+
+// WithTombstoneTTL is an option to the NewSugarDB function that allows you to pass a
+// custom TombstoneTTL to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithTombstoneTTL(tombstoneTTL time.Duration) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.TombstoneTTL = tombstoneTTL
+	}
+}
+
+// WithEncryptionKeyFile is an option to the NewSugarDB function that allows you to pass a
+// custom EncryptionKeyFile to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithEncryptionKeyFile(encryptionKeyFile string) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.EncryptionKeyFile = encryptionKeyFile
+	}
+}
+
 // WithMaxMemory is an option to the NewSugarDB function that allows you to pass a
 // custom MaxMemory to SugarDB.
 // If not specified, SugarDB will use the default configuration from config.DefaultConfig().
@@ -315,6 +548,163 @@ func WithEvictionInterval(evictionInterval time.Duration) func(sugardb *SugarDB)
 	}
 }
 
+// WithLFULogFactor is an option to the NewSugarDB function that allows you to pass a
+// custom LFULogFactor to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithLFULogFactor(lfuLogFactor uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.LFULogFactor = lfuLogFactor
+	}
+}
+
+// WithLFUDecayTime is an option to the NewSugarDB function that allows you to pass a
+// custom LFUDecayTime to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithLFUDecayTime(lfuDecayTime uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.LFUDecayTime = lfuDecayTime
+	}
+}
+
+// WithLazyFreeEviction is an option to the NewSugarDB function that allows you to pass a
+// custom LazyFreeEviction to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithLazyFreeEviction(lazyFreeEviction bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.LazyFreeEviction = lazyFreeEviction
+	}
+}
+
+// WithLazyFreeExpire is an option to the NewSugarDB function that allows you to pass a
+// custom LazyFreeExpire to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithLazyFreeExpire(lazyFreeExpire bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.LazyFreeExpire = lazyFreeExpire
+	}
+}
+
+// WithLazyFreeServerDel is an option to the NewSugarDB function that allows you to pass a
+// custom LazyFreeServerDel to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithLazyFreeServerDel(lazyFreeServerDel bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.LazyFreeServerDel = lazyFreeServerDel
+	}
+}
+
+// WithHashMaxListpackEntries is an option to the NewSugarDB function that allows you to pass a
+// custom HashMaxListpackEntries to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithHashMaxListpackEntries(hashMaxListpackEntries uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.HashMaxListpackEntries = hashMaxListpackEntries
+	}
+}
+
+// WithHashMaxListpackValue is an option to the NewSugarDB function that allows you to pass a
+// custom HashMaxListpackValue to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithHashMaxListpackValue(hashMaxListpackValue uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.HashMaxListpackValue = hashMaxListpackValue
+	}
+}
+
+// WithSetMaxIntsetEntries is an option to the NewSugarDB function that allows you to pass a
+// custom SetMaxIntsetEntries to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithSetMaxIntsetEntries(setMaxIntsetEntries uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.SetMaxIntsetEntries = setMaxIntsetEntries
+	}
+}
+
+// WithSetMaxListpackEntries is an option to the NewSugarDB function that allows you to pass a
+// custom SetMaxListpackEntries to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithSetMaxListpackEntries(setMaxListpackEntries uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.SetMaxListpackEntries = setMaxListpackEntries
+	}
+}
+
+// WithSetMaxListpackValue is an option to the NewSugarDB function that allows you to pass a
+// custom SetMaxListpackValue to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithSetMaxListpackValue(setMaxListpackValue uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.SetMaxListpackValue = setMaxListpackValue
+	}
+}
+
+// WithZSetMaxListpackEntries is an option to the NewSugarDB function that allows you to pass a
+// custom ZSetMaxListpackEntries to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithZSetMaxListpackEntries(zSetMaxListpackEntries uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ZSetMaxListpackEntries = zSetMaxListpackEntries
+	}
+}
+
+// WithZSetMaxListpackValue is an option to the NewSugarDB function that allows you to pass a
+// custom ZSetMaxListpackValue to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithZSetMaxListpackValue(zSetMaxListpackValue uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ZSetMaxListpackValue = zSetMaxListpackValue
+	}
+}
+
+// WithListMaxListpackEntries is an option to the NewSugarDB function that allows you to pass a
+// custom ListMaxListpackEntries to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithListMaxListpackEntries(listMaxListpackEntries uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ListMaxListpackEntries = listMaxListpackEntries
+	}
+}
+
+// WithListMaxListpackValue is an option to the NewSugarDB function that allows you to pass a
+// custom ListMaxListpackValue to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithListMaxListpackValue(listMaxListpackValue uint) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.ListMaxListpackValue = listMaxListpackValue
+	}
+}
+
+// WithTieredStorage is an option to the NewSugarDB function that allows you to pass a
+// custom TieredStorage to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithTieredStorage(tieredStorage bool) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.TieredStorage = tieredStorage
+	}
+}
+
+// MemoryQuota defines a memory quota on the keys of a logical database, or on the keys of the database that start
+// with the Prefix when it's not empty. When the MaxMemory of the quota is reached, its keys are evicted with the
+// eviction Policy of the quota, or the writes to its keys are rejected when the Policy is noeviction.
+type MemoryQuota struct {
+	Database  int
+	Prefix    string
+	MaxMemory uint64
+	Policy    string
+}
+
+// WithMemoryQuotas is an option to the NewSugarDB function that allows you to pass a
+// custom list of MemoryQuotas to SugarDB.
+// If not specified, SugarDB will use the default configuration from config.DefaultConfig().
+func WithMemoryQuotas(memoryQuotas []MemoryQuota) func(sugardb *SugarDB) {
+	return func(sugardb *SugarDB) {
+		sugardb.config.MemoryQuotas = make([]config.MemoryQuota, 0, len(memoryQuotas))
+		for _, quota := range memoryQuotas {
+			sugardb.config.MemoryQuotas = append(sugardb.config.MemoryQuotas, config.MemoryQuota(quota))
+		}
+	}
+}
+
 // WithModules is an option to the NewSugarDB function that allows you to pass a
 // custom Modules to SugarDB.
 // If not specified, SugarDB will use the default configuration from config.DefaultConfig().
@@ -0,0 +1,180 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/modules/hash"
+	"github.com/echovault/sugardb/internal/modules/list"
+	"github.com/echovault/sugardb/internal/modules/set"
+	"github.com/echovault/sugardb/internal/modules/sorted_set"
+)
+
+// encodeValue returns the value in the most compact encoding that the size thresholds in the config allow.
+// Sets and sorted sets hold their own encoding, as commands update them in place, while small hashes and lists
+// are replaced with a listpack that is decoded again by decodeValue.
+func (server *SugarDB) encodeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case hash.Hash:
+		if lp, ok := hash.NewListpack(
+			v, int(server.config.HashMaxListpackEntries), int(server.config.HashMaxListpackValue)); ok {
+			return lp
+		}
+	case []string:
+		if lp, ok := list.NewListpack(
+			v, int(server.config.ListMaxListpackEntries), int(server.config.ListMaxListpackValue)); ok {
+			return lp
+		}
+	case *set.Set:
+		v.SetLimits(set.Limits{
+			MaxIntsetEntries:   int(server.config.SetMaxIntsetEntries),
+			MaxListpackEntries: int(server.config.SetMaxListpackEntries),
+			MaxListpackValue:   int(server.config.SetMaxListpackValue),
+		})
+	case *sorted_set.SortedSet:
+		v.SetLimits(sorted_set.Limits{
+			MaxListpackEntries: int(server.config.ZSetMaxListpackEntries),
+			MaxListpackValue:   int(server.config.ZSetMaxListpackValue),
+		})
+	}
+	return value
+}
+
+// decodeValue returns the value that is held by a compact encoding in the structure that commands operate on.
+func decodeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *hash.Listpack:
+		return v.Hash()
+	case *list.Listpack:
+		return v.Strings()
+	default:
+		return value
+	}
+}
+
+// encoding returns the name of the encoding of the value, as reported by OBJECT ENCODING.
+func encoding(value interface{}) string {
+	switch v := value.(type) {
+	case int, int64:
+		return constants.EncodingInt
+	case hash.Hash:
+		return constants.EncodingHashtable
+	case []string:
+		return constants.EncodingArray
+	case *hash.Listpack, *list.Listpack:
+		return constants.EncodingListpack
+	case interface{ Encoding() string }:
+		// Sets and sorted sets.
+		return v.Encoding()
+	default:
+		return constants.EncodingRaw
+	}
+}
+
+// getObjectEncoding returns the encoding of the value of the key, and false when the key does not exist.
+// An error is returned when the value is spilled and can't be read from the tiered store.
+func (server *SugarDB) getObjectEncoding(ctx context.Context, key string) (string, bool, error) {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return "", false, nil
+	}
+
+	unlock := db.rLock(key)
+	defer unlock()
+
+	entry, ok := db.get(key)
+	if !ok {
+		return "", false, nil
+	}
+	if _, ok := entry.Value.(spilledValue); ok {
+		// The encoding of a spilled value is the encoding it's loaded back into memory with.
+		value, err := server.readSpilled(entry.Value)
+		if err != nil {
+			return "", false, err
+		}
+		return encoding(server.encodeValue(value)), true, nil
+	}
+	return encoding(entry.Value), true, nil
+}
+
+// The kinds of values held by a valueRecord.
+const (
+	recordString = iota
+	recordHash
+	recordList
+	recordSet
+	recordSortedSet
+	recordInt
+	recordFloat
+)
+
+// valueRecord is the typed shape in which a value is serialized when it's spilled to the tiered store or copied to
+// another shard, so that the value is read back with the same type.
+type valueRecord struct {
+	Kind    int
+	String  string
+	Int     int
+	Float   float64
+	Hash    hash.Hash
+	Members []string // The elements of a list or the members of a set.
+	Scores  []sorted_set.MemberParam
+}
+
+// newValueRecord returns the record of the value.
+func newValueRecord(value interface{}) (valueRecord, error) {
+	switch v := decodeValue(value).(type) {
+	case string:
+		return valueRecord{Kind: recordString, String: v}, nil
+	case int:
+		return valueRecord{Kind: recordInt, Int: v}, nil
+	case float64:
+		return valueRecord{Kind: recordFloat, Float: v}, nil
+	case hash.Hash:
+		return valueRecord{Kind: recordHash, Hash: v}, nil
+	case []string:
+		return valueRecord{Kind: recordList, Members: v}, nil
+	case *set.Set:
+		return valueRecord{Kind: recordSet, Members: v.GetAll()}, nil
+	case *sorted_set.SortedSet:
+		return valueRecord{Kind: recordSortedSet, Scores: v.GetAll()}, nil
+	default:
+		return valueRecord{}, fmt.Errorf("value of type %T can't be serialized", value)
+	}
+}
+
+// value returns the value held by the record.
+func (record valueRecord) value() (interface{}, error) {
+	switch record.Kind {
+	case recordString:
+		return record.String, nil
+	case recordInt:
+		return record.Int, nil
+	case recordFloat:
+		return record.Float, nil
+	case recordHash:
+		return record.Hash, nil
+	case recordList:
+		return record.Members, nil
+	case recordSet:
+		return set.NewSet(record.Members), nil
+	case recordSortedSet:
+		return sorted_set.NewSortedSet(record.Scores), nil
+	default:
+		return nil, fmt.Errorf("unknown kind of value record %d", record.Kind)
+	}
+}
@@ -15,24 +15,25 @@
 package sugardb
 
 import (
-	"container/heap"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"math"
 	"math/rand"
-	"reflect"
-	"runtime"
 	"slices"
 	"strings"
 	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/constants"
 	"github.com/echovault/sugardb/internal/eviction"
 	"github.com/echovault/sugardb/internal/modules/hash"
+	"github.com/echovault/sugardb/internal/modules/set"
+	"github.com/echovault/sugardb/internal/modules/sorted_set"
 )
 
 // SwapDBs swaps every TCP client connection from database1 over to database2.
@@ -46,13 +47,9 @@ func (server *SugarDB) SwapDBs(database1, database2 int) {
 	}
 
 	// If any of the databases does not exist, create them.
-	server.storeLock.Lock()
 	for _, database := range []int{database1, database2} {
-		if server.store[database] == nil {
-			server.createDatabase(database)
-		}
+		server.getOrCreateDatabase(database)
 	}
-	server.storeLock.Unlock()
 
 	// Swap the connections for each database.
 	server.connInfo.mut.Lock()
@@ -60,74 +57,152 @@ func (server *SugarDB) SwapDBs(database1, database2 int) {
 	for connection, info := range server.connInfo.tcpClients {
 		switch info.Database {
 		case database1:
-			server.connInfo.tcpClients[connection] = internal.ConnectionInfo{
-				Id:       info.Id,
-				Name:     info.Name,
-				Protocol: info.Protocol,
-				Database: database2,
-			}
+			info.Database = database2
 		case database2:
-			server.connInfo.tcpClients[connection] = internal.ConnectionInfo{
-				Id:       info.Id,
-				Name:     info.Name,
-				Protocol: info.Protocol,
-				Database: database1,
-			}
+			info.Database = database1
+		default:
+			continue
 		}
+		server.connInfo.tcpClients[connection] = info
 	}
 }
 
 // Flush flushes all the data from the database at the specified index.
 // When -1 is passed, all the logical databases are cleared.
 func (server *SugarDB) Flush(database int) {
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
+	server.flush(database, false)
+}
 
-	server.keysWithExpiry.rwMutex.Lock()
-	defer server.keysWithExpiry.rwMutex.Unlock()
+// FlushAsync flushes all the data from the database at the specified index like Flush, but only detaches the keys
+// from the databases. They're released on a background goroutine.
+// When -1 is passed, all the logical databases are cleared.
+func (server *SugarDB) FlushAsync(database int) {
+	server.flush(database, true)
+}
 
+func (server *SugarDB) flush(database int, lazy bool) {
 	if database == -1 {
-		for db, _ := range server.store {
-			// Clear db store.
-			clear(server.store[db])
-			// Clear db volatile key tracker.
-			clear(server.keysWithExpiry.keys[db])
-			// Clear db LFU cache.
-			server.lfuCache.cache[db].Mutex.Lock()
-			server.lfuCache.cache[db].Flush()
-			server.lfuCache.cache[db].Mutex.Unlock()
-			// Clear db LRU cache.
-			server.lruCache.cache[db].Mutex.Lock()
-			server.lruCache.cache[db].Flush()
-			server.lruCache.cache[db].Mutex.Unlock()
+		for _, db := range *server.store.Load() {
+			server.flushDatabase(db, lazy)
 		}
 		return
 	}
 
-	// Clear db store.
-	clear(server.store[database])
+	if db := server.getDatabase(database); db != nil {
+		server.flushDatabase(db, lazy)
+	}
+}
+
+// flushDatabase clears the database. All the shards are locked in order, so that the flush is not interleaved with
+// writes to multiple keys. When lazy is true, the maps of the shards are replaced with empty ones and released on
+// the lazy free goroutine, so the shards are only locked for as long as it takes to swap the maps.
+func (server *SugarDB) flushDatabase(db *database, lazy bool) {
+	for i := range db.shards {
+		db.shards[i].mut.Lock()
+		defer db.shards[i].mut.Unlock()
+	}
+
+	// The usage of the quotas only changes while the shards are locked, and all the keys are removed.
+	for _, quota := range db.quotas {
+		quota.used.Store(0)
+	}
+
+	if lazy {
+		// The dataset of the database only changes while the shards are locked, so it's the memory usage of all
+		// the keys being detached.
+		mem := db.dataset.Swap(0)
+		server.addMemUsed(-mem)
+	}
+
+	for i := range db.shards {
+		s := &db.shards[i]
+		db.size.Add(-int64(len(s.keys)))
+		if lazy {
+			// The spilled values are released while the shard is locked, as the maps that are detached may be
+			// too small to be handed over to the lazy free goroutine.
+			if server.tiered.store != nil {
+				for _, entry := range s.keys {
+					server.releaseSpilled(entry.Value)
+				}
+			}
+			if keys := s.detach(); keys != nil {
+				server.freeLazily(keys)
+			}
+			server.accountShard(db, s)
+			continue
+		}
+		// Deduct the memory usage of the keys. The maps keep their capacity.
+		var mem int64
+		for _, entry := range s.keys {
+			mem += entry.Mem
+			server.releaseSpilled(entry.Value)
+		}
+		db.dataset.Add(-mem)
+		server.addMemUsed(-mem)
+		s.reset()
+		server.accountShard(db, s)
+	}
+
 	// Clear db volatile key tracker.
-	clear(server.keysWithExpiry.keys[database])
-	// Clear db LFU cache.
-	server.lfuCache.cache[database].Mutex.Lock()
-	server.lfuCache.cache[database].Flush()
-	server.lfuCache.cache[database].Mutex.Unlock()
-	// Clear db LRU cache.
-	server.lruCache.cache[database].Mutex.Lock()
-	server.lruCache.cache[database].Flush()
-	server.lruCache.cache[database].Mutex.Unlock()
+	db.expiry.mut.Lock()
+	defer db.expiry.mut.Unlock()
+	db.expiry.index.Clear()
+	server.accountExpiry(db)
 }
 
-func (server *SugarDB) keysExist(ctx context.Context, keys []string) map[string]bool {
-	server.storeLock.RLock()
-	defer server.storeLock.RUnlock()
+// replaceDatabase replaces the keys of the database with the keys of the staged database, which must not be shared
+// with other goroutines. All the shards are locked in order, so the keys are swapped in at once.
+func (server *SugarDB) replaceDatabase(db *database, staged *database) {
+	for i := range db.shards {
+		db.shards[i].mut.Lock()
+		defer db.shards[i].mut.Unlock()
+	}
+	db.expiry.mut.Lock()
+	defer db.expiry.mut.Unlock()
 
-	database := ctx.Value("Database").(int)
+	// The usage of the quotas and the dataset only change while the shards are locked, and all the keys are
+	// replaced, so the keys are accounted for from scratch.
+	for _, quota := range db.quotas {
+		quota.used.Store(0)
+	}
+	server.addMemUsed(-db.dataset.Swap(0))
 
+	db.expiry.index.Clear()
+	for i := range db.shards {
+		s := &db.shards[i]
+		for _, entry := range s.keys {
+			server.releaseSpilled(entry.Value)
+		}
+		db.size.Add(int64(len(staged.shards[i].keys) - len(s.keys)))
+		s.detach()
+		s.keys = staged.shards[i].keys
+		for key, entry := range s.keys {
+			server.accountKey(db, key)
+			if next := nextExpiry(entry, time.Time{}); next != (time.Time{}) {
+				db.expiry.index.Set(key, next)
+			}
+		}
+		server.accountShard(db, s)
+	}
+	server.accountExpiry(db)
+}
+
+func (server *SugarDB) keysExist(ctx context.Context, keys []string) map[string]bool {
 	exists := make(map[string]bool, len(keys))
 
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		for _, key := range keys {
+			exists[key] = false
+		}
+		return exists
+	}
+
+	unlock := db.rLock(keys...)
+	defer unlock()
+
 	for _, key := range keys {
-		_, ok := server.store[database][key]
+		_, ok := db.get(key)
 		exists[key] = ok
 	}
 
@@ -135,12 +210,15 @@ func (server *SugarDB) keysExist(ctx context.Context, keys []string) map[string]
 }
 
 func (server *SugarDB) getExpiry(ctx context.Context, key string) time.Time {
-	server.storeLock.RLock()
-	defer server.storeLock.RUnlock()
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return time.Time{}
+	}
 
-	database := ctx.Value("Database").(int)
+	unlock := db.rLock(key)
+	defer unlock()
 
-	entry, ok := server.store[database][key]
+	entry, ok := db.get(key)
 	if !ok {
 		return time.Time{}
 	}
@@ -148,161 +226,223 @@ func (server *SugarDB) getExpiry(ctx context.Context, key string) time.Time {
 	return entry.ExpireAt
 }
 
-func (server *SugarDB) getHashExpiry(ctx context.Context, key string, field string) time.Time {
-	server.storeLock.RLock()
-	defer server.storeLock.RUnlock()
+func (server *SugarDB) getHashExpiry(ctx context.Context, key string, field string) (time.Time, error) {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return time.Time{}, nil
+	}
 
-	database := ctx.Value("Database").(int)
+	unlock := db.rLock(key)
+	defer unlock()
 
-	entry, ok := server.store[database][key]
+	entry, ok := db.get(key)
 	if !ok {
-		return time.Time{}
+		return time.Time{}, nil
 	}
 
-	hash := entry.Value.(hash.Hash)
+	value, err := server.readSpilled(entry.Value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	hash, _ := decodeValue(value).(hash.Hash)
 
-	return hash[field].ExpireAt
+	return hash[field].ExpireAt, nil
 }
 
 func (server *SugarDB) getValues(ctx context.Context, keys []string) map[string]interface{} {
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
+	values := make(map[string]interface{}, len(keys))
 
-	database := ctx.Value("Database").(int)
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		for _, key := range keys {
+			values[key] = nil
+		}
+		return values
+	}
 
-	values := make(map[string]interface{}, len(keys))
+	// The shards are only locked for writing when the access metadata of the keys is updated.
+	touch := server.tracksAccess()
+	var unlock func()
+	if touch {
+		unlock = db.lock(keys...)
+	} else {
+		unlock = db.rLock(keys...)
+	}
 
+	var expired []string
 	for _, key := range keys {
-		entry, ok := server.store[database][key]
+		entry, ok := db.get(key)
 		if !ok {
 			values[key] = nil
 			continue
 		}
 
 		if entry.ExpireAt != (time.Time{}) && entry.ExpireAt.Before(server.clock.Now()) {
-			if !server.isInCluster() {
-				// If in standalone mode, delete the key directly.
-				err := server.deleteKey(ctx, key)
-				if err != nil {
-					log.Printf("keyExists: %+v\n", err)
-				}
-			} else if server.isInCluster() && server.raft.IsRaftLeader() {
-				// If we're in a raft cluster, and we're the leader, send command to delete the key in the cluster.
-				err := server.raftApplyDeleteKey(ctx, key)
-				if err != nil {
-					log.Printf("keyExists: %+v\n", err)
-				}
-			} else if server.isInCluster() && !server.raft.IsRaftLeader() {
-				// Forward message to leader to initiate key deletion.
-				// This is always called regardless of ForwardCommand config value
-				// because we always want to remove expired keys.
-				server.memberList.ForwardDeleteKey(ctx, key)
-			}
+			expired = append(expired, key)
 			values[key] = nil
 			continue
 		}
 
-		values[key] = entry.Value
+		if server.tiered.store != nil {
+			// The tiered mode requires an eviction policy that tracks accesses, so the shards are locked for
+			// writing and a spilled value can be loaded back into memory.
+			if _, spilled := entry.Value.(spilledValue); spilled {
+				server.tiered.misses.Add(1)
+				var err error
+				if entry, err = server.restoreSpilled(db, key, entry); err != nil {
+					log.Printf("getValues: load spilled value of key %s: %v\n", key, err)
+					values[key] = nil
+					continue
+				}
+			} else {
+				server.tiered.hits.Add(1)
+			}
+		}
+
+		values[key] = decodeValue(entry.Value)
+		if touch {
+			server.touchKey(db, key, 1)
+		}
 	}
+	unlock()
 
-	// Asynchronously update the keys in the cache.
-	go func(ctx context.Context, keys []string) {
-		if _, err := server.updateKeysInCache(ctx, keys); err != nil {
-			log.Printf("getValues error: %+v\n", err)
+	if len(expired) > 0 {
+		if !server.isInCluster() {
+			// If in standalone mode, delete the keys directly.
+			server.deleteExpiredKeys(db, expired)
+		} else {
+			// In cluster mode, the keys are removed by the leader through the raft log.
+			go server.expireKeys(ctx, expired)
 		}
-	}(ctx, keys)
+	}
 
 	return values
 }
 
-func (server *SugarDB) setValues(ctx context.Context, entries map[string]interface{}) error {
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
+// deleteExpiredKeys deletes the keys that are still expired once their shards are locked for writing.
+func (server *SugarDB) deleteExpiredKeys(db *database, keys []string) {
+	unlock := db.lock(keys...)
+	defer unlock()
+	for _, key := range keys {
+		if entry, ok := db.get(key); ok && entry.ExpireAt != (time.Time{}) && entry.ExpireAt.Before(server.clock.Now()) {
+			server.removeKey(db, key, server.config.LazyFreeExpire)
+		}
+	}
+}
 
-	if internal.IsMaxMemoryExceeded(server.memUsed, server.config.MaxMemory) && server.config.EvictionPolicy == constants.NoEviction {
+func (server *SugarDB) setValues(ctx context.Context, entries map[string]interface{}) error {
+	if internal.IsMaxMemoryExceeded(server.memUsed.Load(), server.config.MaxMemory) && server.config.EvictionPolicy == constants.NoEviction {
 
 		return errors.New("max memory reached, key value not set")
 	}
 
-	database := ctx.Value("Database").(int)
-
 	// If database does not exist, create it.
-	if server.store[database] == nil {
-		server.createDatabase(database)
+	db := server.getOrCreateDatabase(ctx.Value("Database").(int))
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	if err := db.checkQuotas(keys); err != nil {
+		return err
 	}
+	unlock := db.lock(keys...)
 
+	// The values that are replaced by a different value, which are released lazily.
+	var overwritten []interface{}
 	for key, value := range entries {
-		expireAt := time.Time{}
-		if _, ok := server.store[database][key]; ok {
-			expireAt = server.store[database][key].ExpireAt
+		entry, ok := db.get(key)
+		if !ok {
+			entry.Access = server.newAccess()
 		}
-		server.store[database][key] = internal.KeyData{
-			Value:    value,
-			ExpireAt: expireAt,
+		if ok && server.config.LazyFreeServerDel && !sameValue(entry.Value, value) {
+			overwritten = append(overwritten, entry.Value)
 		}
-		data := server.store[database][key]
-		mem, err := data.GetMem()
-		if err != nil {
-			return err
+		server.releaseSpilled(entry.Value)
+		db.put(key, internal.KeyData{
+			Value:    server.encodeValue(value),
+			ExpireAt: entry.ExpireAt,
+			Access:   entry.Access,
+			Mem:      entry.Mem,
+		})
+		if ok {
+			server.touchKey(db, key, 1)
+		} else {
+			server.accountShard(db, db.shard(key))
 		}
-		server.memUsed += mem
-		server.memUsed += int64(unsafe.Sizeof(key))
-		server.memUsed += int64(len(key))
+		server.accountKey(db, key)
 
 		if !server.isInCluster() {
 			server.snapshotEngine.IncrementChangeCount()
 		}
 	}
+	unlock()
 
-	// Asynchronously update the keys in the cache.
-	go func(ctx context.Context, entries map[string]interface{}) {
-		for key, _ := range entries {
-			_, err := server.updateKeysInCache(ctx, []string{key})
-			if err != nil {
-				log.Printf("setValues error: %+v\n", err)
-			}
-		}
-	}(ctx, entries)
+	for _, value := range overwritten {
+		server.freeLazily(value)
+	}
 
+	server.adjustMemoryUsageAsync(ctx, db)
 	return nil
 }
 
-func (server *SugarDB) setExpiry(ctx context.Context, key string, expireAt time.Time, touch bool) {
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
+// adjustMemoryUsageAsync evicts keys on a separate goroutine after a write to the database, so that the write
+// doesn't wait for the evictions.
+func (server *SugarDB) adjustMemoryUsageAsync(ctx context.Context, db *database) {
+	// Followers apply the writes of the leader, which evicts keys when the max memory or a quota is exceeded.
+	if server.config.MaxMemory == 0 && !db.quotasExceeded() || server.isInCluster() && !server.raft.IsRaftLeader() {
+		return
+	}
 
-	database := ctx.Value("Database").(int)
+	go func(ctx context.Context) {
+		if err := server.adjustMemoryUsageAll(ctx); err != nil {
+			log.Printf("adjustMemoryUsageAsync error: %+v\n", err)
+		}
+	}(ctx)
+}
 
-	server.store[database][key] = internal.KeyData{
-		Value:    server.store[database][key].Value,
-		ExpireAt: expireAt,
+func (server *SugarDB) setExpiry(ctx context.Context, key string, expireAt time.Time, touch bool) {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return
 	}
 
-	// If the slice of keys associated with expiry time does not contain the current key, add the key.
-	server.keysWithExpiry.rwMutex.Lock()
-	if !slices.Contains(server.keysWithExpiry.keys[database], key) {
-		server.keysWithExpiry.keys[database] = append(server.keysWithExpiry.keys[database], key)
+	unlock := db.lock(key)
+	defer unlock()
+
+	entry, ok := db.get(key)
+	if !ok {
+		return
 	}
-	server.keysWithExpiry.rwMutex.Unlock()
+	entry.ExpireAt = expireAt
+	db.put(key, entry)
 
-	// If touch is true, update the keys status in the cache.
+	// Update the position of the key in the expiry index.
+	db.expiry.mut.Lock()
+	server.indexExpiry(db, key, time.Time{})
+	db.expiry.mut.Unlock()
+
+	// If touch is true, update the access metadata of the key.
 	if touch {
-		go func(ctx context.Context, key string) {
-			_, err := server.updateKeysInCache(ctx, []string{key})
-			if err != nil {
-				log.Printf("setExpiry error: %+v\n", err)
-			}
-		}(ctx, key)
+		server.touchKey(db, key, 1)
 	}
 }
 
 func (server *SugarDB) setHashExpiry(ctx context.Context, key string, field string, expireAt time.Time) error {
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return fmt.Errorf("setHashExpiry can only be used on keys whose value is a Hash")
+	}
 
-	database := ctx.Value("Database").(int)
+	unlock := db.lock(key)
+	defer unlock()
 
-	hashmap, ok := server.store[database][key].Value.(hash.Hash)
+	entry, _ := db.get(key)
+	entry, err := server.restoreSpilled(db, key, entry)
+	if err != nil {
+		return err
+	}
+	hashmap, ok := decodeValue(entry.Value).(hash.Hash)
 	if !ok {
 		return fmt.Errorf("setHashExpiry can only be used on keys whose value is a Hash")
 	}
@@ -310,260 +450,495 @@ func (server *SugarDB) setHashExpiry(ctx context.Context, key string, field stri
 		Value:    hashmap[field].Value,
 		ExpireAt: expireAt,
 	}
+	// A hash in the listpack encoding is decoded, as fields with an expiry are only held in a hashtable.
+	if _, ok := entry.Value.(*hash.Listpack); ok {
+		entry.Value = hashmap
+		db.put(key, entry)
+		server.accountKey(db, key)
+	}
 
-	server.keysWithExpiry.rwMutex.Lock()
-	if !slices.Contains(server.keysWithExpiry.keys[database], key) {
-		server.keysWithExpiry.keys[database] = append(server.keysWithExpiry.keys[database], key)
+	// The key only needs to be re-indexed when the field expires before the next expiry of the key. When the
+	// expiry of the field is removed or postponed, the key is re-indexed once its current next expiry is reached.
+	db.expiry.mut.Lock()
+	if next, ok := db.expiry.index.Get(key); expireAt != (time.Time{}) && (!ok || expireAt.Before(next)) {
+		db.expiry.index.Set(key, expireAt)
+		server.accountExpiry(db)
 	}
-	server.keysWithExpiry.rwMutex.Unlock()
+	db.expiry.mut.Unlock()
 
 	return nil
 }
 
-func (server *SugarDB) deleteKey(ctx context.Context, key string) error {
-	database := ctx.Value("Database").(int)
+// nextExpiry returns the earliest expiry after the given time among the expiry of the key and the expiries of its
+// hash fields. It returns the zero time when there is no such expiry.
+func nextExpiry(entry internal.KeyData, after time.Time) time.Time {
+	var next time.Time
+	earliest := func(expireAt time.Time) {
+		if expireAt != (time.Time{}) && expireAt.After(after) && (next == (time.Time{}) || expireAt.Before(next)) {
+			next = expireAt
+		}
+	}
+	earliest(entry.ExpireAt)
+	if hashmap, ok := entry.Value.(hash.Hash); ok {
+		for _, v := range hashmap {
+			earliest(v.ExpireAt)
+		}
+	}
+	return next
+}
 
-	// Deduct memory usage in tracker.
-	data := server.store[database][key]
-	mem, err := data.GetMem()
-	if err != nil {
-		return err
+// indexExpiry updates the position of the key in the expiry index with the next expiry of the key after the given
+// time. The key is removed from the index when it has no such expiry.
+// Both the shard of the key and the expiry index must be locked by the caller.
+func (server *SugarDB) indexExpiry(db *database, key string, after time.Time) {
+	entry, ok := db.get(key)
+	if next := nextExpiry(entry, after); ok && next != (time.Time{}) {
+		db.expiry.index.Set(key, next)
+	} else {
+		db.expiry.index.Remove(key)
 	}
-	server.memUsed -= mem
-	server.memUsed -= int64(unsafe.Sizeof(key))
-	server.memUsed -= int64(len(key))
+	server.accountExpiry(db)
+}
 
-	// Delete the key from keyLocks and store.
-	delete(server.store[database], key)
+func (server *SugarDB) deleteKey(ctx context.Context, key string) error {
+	return server.removeKeyFromDatabase(ctx, key, false)
+}
 
-	// Remove key from slice of keys associated with expiry.
-	server.keysWithExpiry.rwMutex.Lock()
-	defer server.keysWithExpiry.rwMutex.Unlock()
-	server.keysWithExpiry.keys[database] = slices.DeleteFunc(server.keysWithExpiry.keys[database], func(k string) bool {
-		return k == key
-	})
+// unlinkKey deletes the key like deleteKey, but a large value is released on the lazy free goroutine.
+func (server *SugarDB) unlinkKey(ctx context.Context, key string) error {
+	return server.removeKeyFromDatabase(ctx, key, true)
+}
 
-	// Remove the key from the cache associated with the database.
-	switch {
-	case slices.Contains([]string{constants.AllKeysLFU, constants.VolatileLFU}, server.config.EvictionPolicy):
-		server.lfuCache.cache[database].Delete(key)
-	case slices.Contains([]string{constants.AllKeysLRU, constants.VolatileLRU}, server.config.EvictionPolicy):
-		server.lruCache.cache[database].Delete(key)
+func (server *SugarDB) removeKeyFromDatabase(ctx context.Context, key string, lazy bool) error {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return nil
 	}
 
-	log.Printf("deleted key %s\n", key)
+	unlock := db.lock(key)
+	defer unlock()
+	server.removeKey(db, key, lazy)
 
 	return nil
 }
 
-func (server *SugarDB) createDatabase(database int) {
-	// Create database store.
-	server.store[database] = make(map[string]internal.KeyData)
+// removeKey deletes the key from the store and from the expiry index. When lazy is true, a large value is released
+// on the lazy free goroutine. The shard of the key must be locked for writing by the caller.
+func (server *SugarDB) removeKey(db *database, key string, lazy bool) {
+	// Deduct memory usage in tracker.
+	server.unaccountKey(db, key)
+
+	// Delete the key from the store.
+	entry, _ := db.get(key)
+	db.remove(key)
+	server.accountShard(db, db.shard(key))
+	if lazy {
+		server.freeLazily(entry.Value)
+	}
+	server.releaseSpilled(entry.Value)
 
-	// Set volatile keys tracker for database.
-	server.keysWithExpiry.rwMutex.Lock()
-	defer server.keysWithExpiry.rwMutex.Unlock()
-	server.keysWithExpiry.keys[database] = make([]string, 0)
+	// Remove key from the expiry index.
+	db.expiry.mut.Lock()
+	defer db.expiry.mut.Unlock()
+	db.expiry.index.Remove(key)
+	server.accountExpiry(db)
 
-	// Create database LFU cache.
-	server.lfuCache.mutex.Lock()
-	defer server.lfuCache.mutex.Unlock()
-	server.lfuCache.cache[database] = eviction.NewCacheLFU()
+	log.Printf("deleted key %s\n", key)
+}
 
-	// Create database LRU cache.
-	server.lruCache.mutex.Lock()
-	defer server.lruCache.mutex.Unlock()
-	server.lruCache.cache[database] = eviction.NewCacheLRU()
+// getState returns a copy of the store at a single point in time.
+func (server *SugarDB) getState() (map[int]map[string]interface{}, error) {
+	return server.copyState(nil)
 }
 
-func (server *SugarDB) getState() map[int]map[string]interface{} {
-	// Wait unit there's no state mutation or copy in progress before starting a new copy process.
-	for {
-		if !server.stateCopyInProgress.Load() && !server.stateMutationInProgress.Load() {
-			server.stateCopyInProgress.Store(true)
-			break
+// copyState returns a copy of the store. When fn is not nil, it's called once the write commands in progress have
+// completed and while new ones are held back, so that it observes the state at the same point in time as the copy.
+func (server *SugarDB) copyState(fn func()) (map[int]map[string]interface{}, error) {
+	server.writeGate.close()
+	opened := false
+	open := func() {
+		if !opened {
+			opened = true
+			server.writeGate.open()
 		}
 	}
-	data := make(map[int]map[string]interface{})
-	for db, store := range server.store {
-		data[db] = make(map[string]interface{})
-		for k, v := range store {
-			data[db][k] = v
+	defer open()
+	// The write commands are let through once the cut is taken and the values they change in place are copied.
+	return server.snapshotState(fn, open)
+}
+
+// snapshotState copies the store at a single point in time without stopping the world. It's called while the write
+// commands are held back. The shards of every database are locked in order, which waits for the background writes
+// in progress, and fn is called once they're all locked. The values that are changed in place, like sets and
+// sorted sets, are copied and the maps of the shards are shared with the snapshot before the shards are unlocked
+// and resume is called, so writers are only held back until then. The rest of the copy is made from the shared
+// maps, which writers copy on write instead of mutating while they're shared. An error is returned when a spilled
+// value can't be read from the tiered store.
+func (server *SugarDB) snapshotState(fn func(), resume func()) (map[int]map[string]interface{}, error) {
+	databases := *server.store.Load()
+	indexes := server.getDatabases()
+	for _, index := range indexes {
+		for i := range databases[index].shards {
+			databases[index].shards[i].mut.Lock()
+		}
+	}
+	if fn != nil {
+		fn()
+	}
+	server.startTieredCopy()
+	defer server.finishTieredCopy()
+	frozen := make(map[int][]map[string]internal.KeyData, len(indexes))
+	copies := make(map[int]map[string]interface{}, len(indexes))
+	var releases []func()
+	for _, index := range indexes {
+		db := databases[index]
+		copies[index] = make(map[string]interface{})
+		for i := range db.shards {
+			keys, release := db.shards[i].share()
+			for k, v := range keys {
+				if value, ok := copyValue(v.Value); ok {
+					copies[index][k] = value
+				}
+			}
+			frozen[index] = append(frozen[index], keys)
+			releases = append(releases, release)
+			db.shards[i].mut.Unlock()
 		}
 	}
-	server.stateCopyInProgress.Store(false)
-	return data
+	resume()
+
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+
+	data := make(map[int]map[string]interface{}, len(indexes))
+	for _, index := range indexes {
+		size := 0
+		for _, keys := range frozen[index] {
+			size += len(keys)
+		}
+		data[index] = make(map[string]interface{}, size)
+		for _, keys := range frozen[index] {
+			for k, v := range keys {
+				if value, ok := copies[index][k]; ok {
+					v.Value = value
+				} else if _, ok := v.Value.(spilledValue); ok {
+					value, err := server.readSpilled(v.Value)
+					if err != nil {
+						return nil, fmt.Errorf("copy key %s: %v", k, err)
+					}
+					v.Value = value
+				}
+				data[index][k] = v
+			}
+		}
+	}
+	return data, nil
+}
+
+// copyValue returns a copy of the values that the commands change in place, and false for the values that are
+// replaced rather than changed.
+func copyValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case *set.Set:
+		return v.Copy(), true
+	case *sorted_set.SortedSet:
+		return v.Copy(), true
+	case hash.Hash:
+		return maps.Clone(v), true
+	case []string:
+		return slices.Clone(v), true
+	default:
+		return nil, false
+	}
 }
 
-// updateKeysInCache updates either the key access count or the most recent access time in the cache
+// updateKeysInCache updates either the access counter or the latest access time of the keys
 // depending on whether an LFU or LRU strategy was used.
 func (server *SugarDB) updateKeysInCache(ctx context.Context, keys []string) (int64, error) {
-	database := ctx.Value("Database").(int)
+	accesses := make(map[string]int, len(keys))
+	for _, key := range keys {
+		accesses[key] += 1
+	}
+	return server.recordAccess(ctx, accesses)
+}
+
+// recordAccess adds the number of accesses of each key to its access metadata and evicts keys if the max memory
+// is exceeded. In cluster mode, only the leader evicts keys. Followers report the keys they serve
+// to the leader instead.
+func (server *SugarDB) recordAccess(ctx context.Context, accesses map[string]int) (int64, error) {
 	var touchCounter int64
 
-	// Only update cache when in standalone mode or when raft leader.
-	if server.isInCluster() || (server.isInCluster() && !server.raft.IsRaftLeader()) {
-		return touchCounter, nil
-	}
-	// If max memory is 0, there's no max so no need to update caches.
+	// If max memory is 0, there's no max so no need to track the accesses.
 	if server.config.MaxMemory == 0 {
 		return touchCounter, nil
 	}
 
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return touchCounter, nil
+	}
 
-	for _, key := range keys {
+	keys := make([]string, 0, len(accesses))
+	for key := range accesses {
+		keys = append(keys, key)
+	}
+	unlock := db.lock(keys...)
+	for key, count := range accesses {
 		// Verify key exists
-		if _, ok := server.store[database][key]; !ok {
+		if _, ok := db.get(key); !ok {
 			continue
 		}
+		touchCounter += int64(count)
+		server.touchKey(db, key, count)
+	}
+	unlock()
 
-		touchCounter++
-
-		switch strings.ToLower(server.config.EvictionPolicy) {
-		case constants.AllKeysLFU:
-			server.lfuCache.cache[database].Mutex.Lock()
-			server.lfuCache.cache[database].Update(key)
-			server.lfuCache.cache[database].Mutex.Unlock()
-		case constants.AllKeysLRU:
-			server.lruCache.cache[database].Mutex.Lock()
-			server.lruCache.cache[database].Update(key)
-			server.lruCache.cache[database].Mutex.Unlock()
-		case constants.VolatileLFU:
-			server.lfuCache.cache[database].Mutex.Lock()
-			if server.store[database][key].ExpireAt != (time.Time{}) {
-				server.lfuCache.cache[database].Update(key)
-			}
-			server.lfuCache.cache[database].Mutex.Unlock()
-		case constants.VolatileLRU:
-			server.lruCache.cache[database].Mutex.Lock()
-			if server.store[database][key].ExpireAt != (time.Time{}) {
-				server.lruCache.cache[database].Update(key)
-			}
-			server.lruCache.cache[database].Mutex.Unlock()
-		}
+	if server.isInCluster() && !server.raft.IsRaftLeader() {
+		return touchCounter, nil
+	}
+
+	if err := server.adjustMemoryUsageAll(ctx); err != nil {
+		return touchCounter, err
+	}
+
+	return touchCounter, nil
+}
+
+// tracksAccess returns true when the eviction policy uses the access metadata of the keys.
+func (server *SugarDB) tracksAccess() bool {
+	switch strings.ToLower(server.config.EvictionPolicy) {
+	case constants.AllKeysLFU, constants.VolatileLFU, constants.AllKeysLRU, constants.VolatileLRU:
+		return true
+	default:
+		return false
 	}
+}
+
+// newAccess returns the access metadata of a new key.
+func (server *SugarDB) newAccess() uint32 {
+	switch strings.ToLower(server.config.EvictionPolicy) {
+	case constants.AllKeysLFU, constants.VolatileLFU:
+		return eviction.NewLFU(time.Now())
+	default:
+		return eviction.LRUClock(time.Now())
+	}
+}
+
+// touchKey records count accesses of the key in its access metadata. Followers of a raft cluster also add
+// them to the report for the leader. The shard of the key must be locked for writing by the caller.
+func (server *SugarDB) touchKey(db *database, key string, count int) {
+	entry, _ := db.get(key)
+	switch strings.ToLower(server.config.EvictionPolicy) {
+	case constants.AllKeysLFU, constants.VolatileLFU:
+		entry.Access = eviction.LFUTouch(entry.Access, count, time.Now(),
+			server.config.LFULogFactor, server.config.LFUDecayTime)
+	case constants.AllKeysLRU, constants.VolatileLRU:
+		entry.Access = eviction.LRUClock(time.Now())
+	default:
+		return
+	}
+	db.put(key, entry)
+
+	if server.config.MaxMemory == 0 || !server.isInCluster() || server.raft.IsRaftLeader() {
+		return
+	}
+	server.accessReport.mut.Lock()
+	defer server.accessReport.mut.Unlock()
+	if server.accessReport.keys[db.index] == nil {
+		server.accessReport.keys[db.index] = make(map[string]int)
+	}
+	server.accessReport.keys[db.index][key] += count
+}
+
+// adjustMemoryUsageAll evicts keys from every database until the memory used is below the max memory.
+func (server *SugarDB) adjustMemoryUsageAll(ctx context.Context) error {
+	databases := server.getDatabases()
 
 	wg := sync.WaitGroup{}
-	errChan := make(chan error)
-	doneChan := make(chan struct{})
+	errChan := make(chan error, len(databases))
 
-	for db, _ := range server.store {
+	for _, db := range databases {
 		wg.Add(1)
 		ctx := context.WithValue(ctx, "Database", db)
-		go func(ctx context.Context, database int, wg *sync.WaitGroup, errChan *chan error) {
+		go func(ctx context.Context, database int) {
+			defer wg.Done()
 			if err := server.adjustMemoryUsage(ctx); err != nil {
-				*errChan <- fmt.Errorf("adjustMemoryUsage database %d, error: %v", database, err)
+				errChan <- fmt.Errorf("adjustMemoryUsage database %d, error: %v", database, err)
 			}
-			wg.Done()
-		}(ctx, db, &wg, &errChan)
+		}(ctx, db)
 	}
-
-	go func() {
-		wg.Wait()
-		doneChan <- struct{}{}
-	}()
+	wg.Wait()
 
 	select {
 	case err := <-errChan:
-		return touchCounter, fmt.Errorf("adjustMemoryUsage error: %+v", err)
-	case <-doneChan:
+		return fmt.Errorf("adjustMemoryUsage error: %+v", err)
+	default:
 	}
 
-	return touchCounter, nil
+	return nil
+}
+
+// reportAccess sends the keys read on this follower since the previous report to the cluster leader.
+func (server *SugarDB) reportAccess() {
+	server.accessReport.mut.Lock()
+	report := server.accessReport.keys
+	server.accessReport.keys = make(map[int]map[string]int)
+	server.accessReport.mut.Unlock()
+
+	if len(report) == 0 {
+		return
+	}
+	leader := server.raft.LeaderID()
+	if leader == "" {
+		return
+	}
+	b, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("report access: %v\n", err)
+		return
+	}
+	if err = server.memberList.ReportAccess(leader, b); err != nil {
+		log.Printf("report access: %v\n", err)
+	}
+}
+
+// applyAccessReport records the keys read on a follower in the leader's cache.
+func (server *SugarDB) applyAccessReport(b []byte) {
+	var report map[int]map[string]int
+	if err := json.Unmarshal(b, &report); err != nil {
+		log.Printf("apply access report: %v\n", err)
+		return
+	}
+	for database, accesses := range report {
+		ctx := context.WithValue(context.Background(), "Database", database)
+		if _, err := server.recordAccess(ctx, accesses); err != nil {
+			log.Printf("apply access report: %v\n", err)
+		}
+	}
+}
+
+// evictKey removes a key evicted by this node. In cluster mode, keys are only evicted by the leader through a
+// delete entry in the raft log, so that every node removes the same keys. Followers never evict keys themselves.
+func (server *SugarDB) evictKey(ctx context.Context, key string) error {
+	if server.isInCluster() {
+		if !server.raft.IsRaftLeader() {
+			return nil
+		}
+		return server.raftApplyDeleteKey(ctx, key)
+	}
+
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return nil
+	}
+	unlock := db.lock(key)
+	defer unlock()
+	if _, ok := db.get(key); !ok {
+		return nil
+	}
+	log.Printf("Evicting key %v from database %v \n", key, ctx.Value("Database"))
+	server.removeKey(db, key, server.config.LazyFreeEviction)
+	return nil
+}
+
+// expireKeys removes keys that have expired in cluster mode. The leader removes the keys that have expired
+// according to its own state through the raft log. Followers ask the leader to remove them.
+func (server *SugarDB) expireKeys(ctx context.Context, keys []string) error {
+	if !server.raft.IsRaftLeader() {
+		leader := server.raft.LeaderID()
+		if leader == "" {
+			return nil
+		}
+		for _, key := range keys {
+			if err := server.memberList.ForwardDeleteKey(ctx, leader, key); err != nil {
+				return fmt.Errorf("expireKeys: %+v", err)
+			}
+		}
+		return nil
+	}
+
+	database, _ := ctx.Value("Database").(int)
+	db := server.getDatabase(database)
+	if db == nil {
+		return nil
+	}
+	unlock := db.rLock(keys...)
+	expired := slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+		entry, ok := db.get(key)
+		return !ok || entry.ExpireAt == (time.Time{}) || !entry.ExpireAt.Before(server.clock.Now())
+	})
+	unlock()
+
+	for _, key := range expired {
+		if err := server.raftApplyDeleteKey(ctx, key); err != nil {
+			return fmt.Errorf("expireKeys: %+v", err)
+		}
+	}
+	return nil
+}
+
+// isMaxMemoryExceeded returns true when the memory used by the store has reached the max memory.
+func (server *SugarDB) isMaxMemoryExceeded() bool {
+	return uint64(server.memUsed.Load()) >= server.config.MaxMemory
 }
 
 // adjustMemoryUsage should only be called from standalone echovault or from raft cluster leader.
 func (server *SugarDB) adjustMemoryUsage(ctx context.Context) error {
+	// Followers never evict keys, they remove the keys evicted by the leader when applying the raft log.
+	if server.isInCluster() && !server.raft.IsRaftLeader() {
+		return nil
+	}
+
+	// The quotas of the database are enforced with their own policy, regardless of the max memory.
+	if err := server.adjustQuotaUsage(ctx); err != nil {
+		return err
+	}
+
 	// If max memory is 0, there's no need to adjust memory usage.
 	if server.config.MaxMemory == 0 {
 		return nil
 	}
 
-	database := ctx.Value("Database").(int)
+	// In tiered mode, the values of the keys picked by the eviction policy are spilled to disk instead.
+	if server.tiered.store != nil {
+		server.wakeTiered()
+		return nil
+	}
+
+	server.evictionMut.Lock()
+	defer server.evictionMut.Unlock()
 
 	// Check if memory usage is above max-memory.
 	// If it is, pop items from the cache until we get under the limit.
 	// If we're using less memory than the max-memory, there's no need to evict.
-	if uint64(server.memUsed) < server.config.MaxMemory {
-		return nil
-	}
-	// Force a garbage collection first before we start evicting keys.
-	runtime.GC()
-	if uint64(server.memUsed) < server.config.MaxMemory {
+	if !server.isMaxMemoryExceeded() {
 		return nil
 	}
 
-	// We've done a GC, but we're still at or above the max memory limit.
-	// Start a loop that evicts keys until either the heap is empty or
+	// Start a loop that evicts keys until either there are no keys left to evict or
 	// we're below the max memory limit.
 
-	log.Printf("Memory used: %v, Max Memory: %v", server.GetServerInfo().MemoryUsed, server.GetServerInfo().MaxMemory)
+	log.Printf("Memory used: %v, Max Memory: %v", server.memUsed.Load(), server.config.MaxMemory)
 	switch {
-	case slices.Contains([]string{constants.AllKeysLFU, constants.VolatileLFU}, strings.ToLower(server.config.EvictionPolicy)):
-		// Remove keys from LFU cache until we're below the max memory limit or
-		// until the LFU cache is empty.
-		server.lfuCache.cache[database].Mutex.Lock()
-		defer server.lfuCache.cache[database].Mutex.Unlock()
+	case slices.Contains([]string{
+		constants.AllKeysLFU, constants.VolatileLFU, constants.AllKeysLRU, constants.VolatileLRU, constants.VolatileTTL,
+	}, strings.ToLower(server.config.EvictionPolicy)):
+		// Remove the best candidates in the samples of the keyspace until we're below the max memory limit or
+		// until there are no keys left to sample.
 		for {
-			// Return if cache is empty
-			if server.lfuCache.cache[database].Len() == 0 {
-				return fmt.Errorf("adjustMemoryUsage -> LFU cache empty")
-			}
-
-			key := heap.Pop(server.lfuCache.cache[database]).(string)
-			if !server.isInCluster() {
-				// If in standalone mode, directly delete the key
-				if err := server.deleteKey(ctx, key); err != nil {
-
-					log.Printf("Evicting key %v from database %v \n", key, database)
-					return fmt.Errorf("adjustMemoryUsage -> LFU cache eviction: %+v", err)
-				}
-			} else if server.isInCluster() && server.raft.IsRaftLeader() {
-				// If in raft cluster, send command to delete key from cluster
-				if err := server.raftApplyDeleteKey(ctx, key); err != nil {
-
-					return fmt.Errorf("adjustMemoryUsage -> LFU cache eviction: %+v", err)
-				}
-			}
-			// Run garbage collection
-			runtime.GC()
-			// Return if we're below max memory
-			if uint64(server.memUsed) < server.config.MaxMemory {
-				return nil
-			}
-		}
-	case slices.Contains([]string{constants.AllKeysLRU, constants.VolatileLRU}, strings.ToLower(server.config.EvictionPolicy)):
-		// Remove keys from th LRU cache until we're below the max memory limit or
-		// until the LRU cache is empty.
-		server.lruCache.cache[database].Mutex.Lock()
-		defer server.lruCache.cache[database].Mutex.Unlock()
-		for {
-			// Return if cache is empty
-			if server.lruCache.cache[database].Len() == 0 {
-				return fmt.Errorf("adjustMemoryUsage -> LRU cache empty")
+			key, ok := server.evictionCandidate(ctx)
+			if !ok {
+				err := errors.New("no keys to evict")
+				return fmt.Errorf("adjustMemoryUsage -> %s: %+v", server.config.EvictionPolicy, err)
 			}
-
-			key := heap.Pop(server.lruCache.cache[database]).(string)
-			if !server.isInCluster() {
-				// If in standalone mode, directly delete the key.
-				if err := server.deleteKey(ctx, key); err != nil {
-					log.Printf("Evicting key %v from database %v \n", key, database)
-					return fmt.Errorf("adjustMemoryUsage -> LRU cache eviction: %+v", err)
-				}
-			} else if server.isInCluster() && server.raft.IsRaftLeader() {
-				// If in cluster mode and the node is a cluster leader,
-				// send command to delete the key from the cluster.
-				if err := server.raftApplyDeleteKey(ctx, key); err != nil {
-					return fmt.Errorf("adjustMemoryUsage -> LRU cache eviction: %+v", err)
-				}
+			if err := server.evictKey(ctx, key); err != nil {
+				return fmt.Errorf("adjustMemoryUsage -> %s: %+v", server.config.EvictionPolicy, err)
 			}
-
-			// Run garbage collection
-			runtime.GC()
 			// Return if we're below max memory
-			if uint64(server.memUsed) < server.config.MaxMemory {
+			if !server.isMaxMemoryExceeded() {
 				return nil
 			}
 		}
@@ -571,70 +946,35 @@ func (server *SugarDB) adjustMemoryUsage(ctx context.Context) error {
 		// Remove random keys until we're below the max memory limit
 		// or there are no more keys remaining.
 		for {
+			key := server.randomKey(ctx)
 			// If there are no keys, return error
-			if len(server.store) == 0 {
+			if key == "" {
 				err := errors.New("no keys to evict")
 				return fmt.Errorf("adjustMemoryUsage -> all keys random: %+v", err)
 			}
-			// Get random key in the database
-			idx := rand.Intn(len(server.store))
-			for db, data := range server.store {
-				if db == database {
-					for key, _ := range data {
-						if idx == 0 {
-							if !server.isInCluster() {
-								// If in standalone mode, directly delete the key
-								if err := server.deleteKey(ctx, key); err != nil {
-									log.Printf("Evicting key %v from database %v \n", key, db)
-
-									return fmt.Errorf("adjustMemoryUsage -> all keys random: %+v", err)
-								}
-							} else if server.isInCluster() && server.raft.IsRaftLeader() {
-								if err := server.raftApplyDeleteKey(ctx, key); err != nil {
-
-									return fmt.Errorf("adjustMemoryUsage -> all keys random: %+v", err)
-								}
-							}
-							// Run garbage collection
-							runtime.GC()
-							// Return if we're below max memory
-							if uint64(server.memUsed) < server.config.MaxMemory {
-								return nil
-							}
-						}
-						idx--
-					}
-				}
+			if err := server.evictKey(ctx, key); err != nil {
+				return fmt.Errorf("adjustMemoryUsage -> all keys random: %+v", err)
+			}
+			// Return if we're below max memory
+			if !server.isMaxMemoryExceeded() {
+				return nil
 			}
 		}
 	case slices.Contains([]string{constants.VolatileRandom}, strings.ToLower(server.config.EvictionPolicy)):
 		// Remove random keys with an associated expiry time until we're below the max memory limit
 		// or there are no more keys with expiry time.
 		for {
-			// Get random volatile key
-			server.keysWithExpiry.rwMutex.RLock()
-			idx := rand.Intn(len(server.keysWithExpiry.keys))
-			key := server.keysWithExpiry.keys[database][idx]
-			server.keysWithExpiry.rwMutex.RUnlock()
-
-			if !server.isInCluster() {
-				// If in standalone mode, directly delete the key
-				if err := server.deleteKey(ctx, key); err != nil {
-					log.Printf("Evicting key %v from database %v \n", key, database)
-
-					return fmt.Errorf("adjustMemoryUsage -> volatile keys random: %+v", err)
-				}
-			} else if server.isInCluster() && server.raft.IsRaftLeader() {
-				if err := server.raftApplyDeleteKey(ctx, key); err != nil {
-
-					return fmt.Errorf("adjustMemoryUsage -> volatile keys randome: %+v", err)
-				}
+			key := server.randomVolatileKey(ctx)
+			// If there are no volatile keys, return error
+			if key == "" {
+				err := errors.New("no volatile keys to evict")
+				return fmt.Errorf("adjustMemoryUsage -> volatile keys random: %+v", err)
+			}
+			if err := server.evictKey(ctx, key); err != nil {
+				return fmt.Errorf("adjustMemoryUsage -> volatile keys random: %+v", err)
 			}
-
-			// Run garbage collection
-			runtime.GC()
 			// Return if we're below max memory
-			if uint64(server.memUsed) < server.config.MaxMemory {
+			if !server.isMaxMemoryExceeded() {
 				return nil
 			}
 		}
@@ -643,181 +983,380 @@ func (server *SugarDB) adjustMemoryUsage(ctx context.Context) error {
 	}
 }
 
-// evictKeysWithExpiredTTL is a function that samples keys with an associated TTL
-// and evicts keys that are currently expired.
-// This function will sample 20 keys from the list of keys with an associated TTL,
-// if the key is expired, it will be evicted.
+// evictionCandidate samples the keys of the database, adds them to the eviction pool of the database, and returns
+// the best candidate in the pool that still exists. With a volatile policy, only the keys with an expiry are sampled.
+func (server *SugarDB) evictionCandidate(ctx context.Context) (string, bool) {
+	database := ctx.Value("Database").(int)
+
+	server.evictionPools.mut.Lock()
+	defer server.evictionPools.mut.Unlock()
+
+	pool, ok := server.evictionPools.pools[database]
+	if !ok {
+		pool = eviction.NewPool()
+		server.evictionPools.pools[database] = pool
+	}
+
+	db := server.getDatabase(database)
+	if db == nil {
+		return "", false
+	}
+
+	// In tiered mode, the keys whose value is spilled already, or can't be spilled, are not candidates.
+	return server.sampleCandidate(db, pool, server.config.EvictionPolicy, nil, func(entry internal.KeyData) bool {
+		return server.tiered.store == nil || spillable(entry.Value)
+	})
+}
+
+// sampleCandidate samples the keys of the database that match, adds them to the pool, and returns the best candidate
+// in the pool that still exists and is kept. A nil match matches all the keys, and a nil keep keeps all the entries.
+// With a volatile policy, only the keys with an expiry are sampled. The LRU score of a key is its idle time, the LFU
+// score decreases as its access counter increases, the TTL score decreases as its expiry time increases, and the
+// random score is random. The pool must be locked by the caller.
+func (server *SugarDB) sampleCandidate(
+	db *database,
+	pool *eviction.Pool,
+	policy string,
+	match func(key string) bool,
+	keep func(entry internal.KeyData) bool,
+) (string, bool) {
+	policy = strings.ToLower(policy)
+	lfu := slices.Contains([]string{constants.AllKeysLFU, constants.VolatileLFU}, policy)
+	volatile := slices.Contains([]string{
+		constants.VolatileLFU, constants.VolatileLRU, constants.VolatileTTL, constants.VolatileRandom,
+	}, policy)
+	random := slices.Contains([]string{constants.AllKeysRandom, constants.VolatileRandom}, policy)
+	samples := max(int(server.config.EvictionSample), 1)
+
+	now := time.Now()
+	candidate := func(entry internal.KeyData) bool {
+		return (!volatile || entry.ExpireAt != (time.Time{})) && (keep == nil || keep(entry))
+	}
+	// The shard of the key must be locked for reading.
+	insert := func(key string) {
+		entry, ok := db.get(key)
+		if !ok || !candidate(entry) {
+			return
+		}
+		switch {
+		case random:
+			pool.Insert(key, rand.Uint64())
+		case policy == constants.VolatileTTL:
+			pool.Insert(key, uint64(math.MaxInt64-entry.ExpireAt.UnixMilli()))
+		case lfu:
+			counter := eviction.LFUCounter(entry.Access, now, server.config.LFUDecayTime)
+			pool.Insert(key, uint64(255-counter))
+		default:
+			pool.Insert(key, uint64(eviction.IdleTime(entry.Access, now).Milliseconds()))
+		}
+	}
+
+	if volatile {
+		// The index is locked after the shards, so the sampled keys are inserted once the index is unlocked.
+		db.expiry.mut.Lock()
+		index := db.expiry.index
+		keys := make([]string, 0, min(index.Len(), samples))
+		switch {
+		case index.Len() <= samples && match == nil:
+			for i := 0; i < index.Len(); i++ {
+				keys = append(keys, index.Key(i))
+			}
+		case match == nil:
+			for i := 0; i < samples; i++ {
+				keys = append(keys, index.Key(rand.Intn(index.Len())))
+			}
+		case index.Len() > 0:
+			// The keys that match are scanned from a random position, as they may be a small part of the index.
+			start := rand.Intn(index.Len())
+			for i := 0; i < index.Len() && len(keys) < samples; i++ {
+				if key := index.Key((start + i) % index.Len()); match(key) {
+					keys = append(keys, key)
+				}
+			}
+		}
+		db.expiry.mut.Unlock()
+		unlock := db.rLock(keys...)
+		for _, key := range keys {
+			insert(key)
+		}
+		unlock()
+	} else {
+		// Sample the shards starting from a random shard. Map iteration starts at a random key, so the first keys
+		// of the iteration of each shard are a sample of the shard.
+		perShard := max(samples/4, 1)
+		start := rand.Intn(shardCount)
+		for i, sampled := 0, 0; i < shardCount && sampled < samples; i++ {
+			s := &db.shards[(start+i)%shardCount]
+			s.mut.RLock()
+			n := 0
+			for key := range s.keys {
+				if n == perShard || sampled == samples {
+					break
+				}
+				if match != nil && !match(key) {
+					continue
+				}
+				insert(key)
+				n++
+				sampled++
+			}
+			s.mut.RUnlock()
+		}
+	}
+
+	for {
+		key, ok := pool.Pop()
+		if !ok {
+			return "", false
+		}
+		unlock := db.rLock(key)
+		entry, ok := db.get(key)
+		unlock()
+		if ok && candidate(entry) {
+			return key, true
+		}
+	}
+}
+
+// expiryThresholdPercentage is the percentage of expired keys in a batch above which another batch is expired.
+const expiryThresholdPercentage = 25
+
+// evictKeysWithExpiredTTL deletes the expired keys and hash fields of the database. The keys are taken from the top
+// of the expiry index in batches of the configured sample size. If more than 25% of a batch is expired, another batch
+// is expired immediately, until a quarter of the eviction interval is spent.
 // This function is only executed in standalone mode or by the raft cluster leader.
+// The leader removes the expired keys and hash fields through the raft log.
 func (server *SugarDB) evictKeysWithExpiredTTL(ctx context.Context) error {
 	// Only execute this if we're in standalone mode, or raft cluster leader.
 	if server.isInCluster() && !server.raft.IsRaftLeader() {
 		return nil
 	}
 
-	server.keysWithExpiry.rwMutex.RLock()
-
-	database := ctx.Value("Database").(int)
+	batchSize := max(int(server.config.EvictionSample), 1)
+	deadline := time.Now().Add(server.config.EvictionInterval / 4)
 
-	// Sample size should be the configured sample size, or the size of the keys with expiry,
-	// whichever one is smaller.
-	sampleSize := int(server.config.EvictionSample)
-	if len(server.keysWithExpiry.keys[database]) < sampleSize {
-		sampleSize = len(server.keysWithExpiry.keys)
+	for {
+		expiredCount, err := server.expireBatch(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		if expiredCount == 0 {
+			return nil
+		}
+		log.Printf("%d keys expired\n", expiredCount)
+		if expiredCount*100 <= batchSize*expiryThresholdPercentage || !time.Now().Before(deadline) {
+			return nil
+		}
 	}
-	keys := make([]string, sampleSize)
-
-	deletedCount := 0
-	thresholdPercentage := 20
+}
 
-	var idx int
-	var key string
-	for i := 0; i < len(keys); i++ {
-		for {
-			// Retry retrieval of a random key until we find a key that is not already in the list of sampled keys.
-			idx = rand.Intn(len(server.keysWithExpiry.keys))
-			key = server.keysWithExpiry.keys[database][idx]
-			if !slices.Contains(keys, key) {
-				keys[i] = key
-				break
-			}
+// expireBatch takes up to batchSize keys whose next expiry is reached from the top of the expiry index of the
+// database, and deletes the ones that are expired and the expired fields of the others. It returns the number of keys
+// taken from the index.
+func (server *SugarDB) expireBatch(ctx context.Context, batchSize int) (int, error) {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return 0, nil
+	}
+	now := server.clock.Now()
+
+	// The index is locked after the shards, so the keys are removed from the index before their shards are locked.
+	// The keys that are not deleted are put back in the index with their next expiry.
+	db.expiry.mut.Lock()
+	var keys []string
+	for len(keys) < batchSize {
+		key, expireAt, ok := db.expiry.index.Peek()
+		if !ok || !expireAt.Before(now) {
+			break
 		}
+		keys = append(keys, key)
+		db.expiry.index.Remove(key)
 	}
-	server.keysWithExpiry.rwMutex.RUnlock()
+	server.accountExpiry(db)
+	db.expiry.mut.Unlock()
 
-	// Loop through the keys and delete them if they're expired
-	server.storeLock.Lock()
-	defer server.storeLock.Unlock()
-	for _, k := range keys {
+	if len(keys) == 0 {
+		return 0, nil
+	}
 
-		// handle keys within a hash type value
-		value := server.store[database][k].Value
-		t := reflect.TypeOf(value)
-		if t.Kind() == reflect.Map {
+	var expiredKeys []string
+	expiredFields := make(map[string][]string)
 
-			hashkey, ok := server.store[database][k].Value.(hash.Hash)
-			if !ok {
-				return fmt.Errorf("Hash value should contain type HashValue, but type %s was found.", t.Elem().Name())
-			}
-
-			for k, v := range hashkey {
-				if v.ExpireAt.Before(time.Now()) {
-					delete(hashkey, k)
+	unlock := db.lock(keys...)
+	for _, key := range keys {
+		entry, ok := db.get(key)
+		if !ok {
+			continue
+		}
+		if entry.ExpireAt != (time.Time{}) && entry.ExpireAt.Before(now) {
+			expiredKeys = append(expiredKeys, key)
+			continue
+		}
+		// handle keys within a hash type value
+		if hashmap, ok := entry.Value.(hash.Hash); ok {
+			for field, v := range hashmap {
+				if v.ExpireAt != (time.Time{}) && v.ExpireAt.Before(now) {
+					expiredFields[key] = append(expiredFields[key], field)
 				}
 			}
-
 		}
+		// Re-index the key with the next expiry of the key and the fields that aren't expired yet.
+		db.expiry.mut.Lock()
+		server.indexExpiry(db, key, now)
+		db.expiry.mut.Unlock()
+	}
 
-		// Check if key is expired, move on if it's not
-		ExpireTime := server.store[database][k].ExpireAt
-		if ExpireTime.Before(time.Now()) {
-			continue
+	if !server.isInCluster() {
+		// In standalone mode, delete the keys and fields directly.
+		defer unlock()
+		for key, fields := range expiredFields {
+			entry, _ := db.get(key)
+			hashmap := entry.Value.(hash.Hash)
+			for _, field := range fields {
+				delete(hashmap, field)
+			}
+			server.accountKey(db, key)
+		}
+		for _, key := range expiredKeys {
+			server.removeKey(db, key, server.config.LazyFreeExpire)
 		}
+		return len(keys), nil
+	}
 
-		// Delete the expired key
-		deletedCount += 1
-		if !server.isInCluster() {
-			if err := server.deleteKey(ctx, k); err != nil {
-				return fmt.Errorf("evictKeysWithExpiredTTL -> standalone delete: %+v", err)
-			}
-		} else if server.isInCluster() && server.raft.IsRaftLeader() {
-			if err := server.raftApplyDeleteKey(ctx, k); err != nil {
-				return fmt.Errorf("evictKeysWithExpiredTTL -> cluster delete: %+v", err)
+	// In cluster mode, the shards must be unlocked before the deletions are applied through the raft log.
+	unlock()
+	for key, fields := range expiredFields {
+		if _, err := server.raftApplyCommand(ctx, append([]string{"HDEL", key}, fields...)); err != nil {
+			return len(keys), fmt.Errorf("evictKeysWithExpiredTTL -> cluster hash field delete: %+v", err)
+		}
+	}
+	for i, key := range expiredKeys {
+		if err := server.raftApplyDeleteKey(ctx, key); err != nil {
+			// Put the keys that are not deleted back in the index, so that they're expired in the next cycle.
+			unlock = db.lock(expiredKeys[i:]...)
+			db.expiry.mut.Lock()
+			for _, k := range expiredKeys[i:] {
+				server.indexExpiry(db, k, time.Time{})
 			}
+			db.expiry.mut.Unlock()
+			unlock()
+			return len(keys), fmt.Errorf("evictKeysWithExpiredTTL -> cluster delete: %+v", err)
 		}
 	}
+	return len(keys), nil
+}
 
-	// If sampleSize is 0, there's no need to calculate deleted percentage.
-	if sampleSize == 0 {
-		return nil
+func (server *SugarDB) randomKey(ctx context.Context) string {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return ""
 	}
 
-	log.Printf("%d keys sampled, %d keys deleted\n", sampleSize, deletedCount)
+	_max := db.len()
+	if _max == 0 {
+		return ""
+	}
 
-	// If the deleted percentage is over 20% of the sample size, execute the function again immediately.
-	if (deletedCount/sampleSize)*100 >= thresholdPercentage {
-		log.Printf("deletion ratio (%d percent) reached threshold (%d percent), sampling again\n",
-			(deletedCount/sampleSize)*100, thresholdPercentage)
-		return server.evictKeysWithExpiredTTL(ctx)
+	// Find the shard that holds the key at a random position of the keyspace.
+	randnum := rand.Intn(_max)
+	for i := range db.shards {
+		s := &db.shards[i]
+		s.mut.RLock()
+		if randnum >= len(s.keys) {
+			randnum -= len(s.keys)
+			s.mut.RUnlock()
+			continue
+		}
+		j := 0
+		var randkey string
+		for key, _ := range s.keys {
+			if j == randnum {
+				randkey = key
+				break
+			} else {
+				j++
+			}
+		}
+		s.mut.RUnlock()
+		return randkey
 	}
 
-	return nil
+	return ""
 }
 
-func (server *SugarDB) randomKey(ctx context.Context) string {
-	server.storeLock.RLock()
-	defer server.storeLock.RUnlock()
-
-	database := ctx.Value("Database").(int)
-
-	_max := len(server.store[database])
-	if _max == 0 {
+// randomVolatileKey samples the keys with an expiry of the database, and returns the first sampled key that still
+// has an expiry. It returns an empty string when no such key is found.
+func (server *SugarDB) randomVolatileKey(ctx context.Context) string {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
 		return ""
 	}
 
-	randnum := rand.Intn(_max)
-	i := 0
-	var randkey string
+	// Hashes are indexed for the expiry of their fields, so more than one key is sampled.
+	db.expiry.mut.Lock()
+	var keys []string
+	for i := 0; i < max(int(server.config.EvictionSample), 1) && db.expiry.index.Len() > 0; i++ {
+		keys = append(keys, db.expiry.index.Key(rand.Intn(db.expiry.index.Len())))
+	}
+	db.expiry.mut.Unlock()
 
-	for key, _ := range server.store[database] {
-		if i == randnum {
-			randkey = key
-			break
-		} else {
-			i++
+	unlock := db.rLock(keys...)
+	defer unlock()
+	for _, key := range keys {
+		if entry, ok := db.get(key); ok && entry.ExpireAt != (time.Time{}) {
+			return key
 		}
-
 	}
 
-	return randkey
+	return ""
 }
 
 func (server *SugarDB) dbSize(ctx context.Context) int {
-	server.storeLock.RLock()
-	defer server.storeLock.RUnlock()
-
-	database := ctx.Value("Database").(int)
-	return len(server.store[database])
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return 0
+	}
+	return db.len()
 }
 
 func (server *SugarDB) getObjectFreq(ctx context.Context, key string) (int, error) {
-	database := ctx.Value("Database").(int)
-
-	var freq int
-	var err error
-	if server.lfuCache.cache != nil {
-		server.lfuCache.cache[database].Mutex.Lock()
-		freq, err = server.lfuCache.cache[database].GetCount(key)
-		server.lfuCache.cache[database].Mutex.Unlock()
-	} else {
+	if !slices.Contains([]string{constants.AllKeysLFU, constants.VolatileLFU}, strings.ToLower(server.config.EvictionPolicy)) {
 		return -1, errors.New("error: eviction policy must be a type of LFU")
 	}
 
-	if err != nil {
-		return -1, err
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return -1, fmt.Errorf("key %s not found", key)
+	}
+
+	unlock := db.rLock(key)
+	defer unlock()
+
+	entry, ok := db.get(key)
+	if !ok {
+		return -1, fmt.Errorf("key %s not found", key)
 	}
 
-	return freq, nil
+	return int(eviction.LFUCounter(entry.Access, time.Now(), server.config.LFUDecayTime)), nil
 }
 
 func (server *SugarDB) getObjectIdleTime(ctx context.Context, key string) (float64, error) {
-	database := ctx.Value("Database").(int)
-
-	var accessTime int64
-	var err error
-	if server.lruCache.cache != nil {
-		server.lruCache.cache[database].Mutex.Lock()
-		accessTime, err = server.lruCache.cache[database].GetTime(key)
-		server.lruCache.cache[database].Mutex.Unlock()
-	} else {
+	if !slices.Contains([]string{constants.AllKeysLRU, constants.VolatileLRU}, strings.ToLower(server.config.EvictionPolicy)) {
 		return -1, errors.New("error: eviction policy must be a type of LRU")
 	}
 
-	if err != nil {
-		return -1, err
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return -1, fmt.Errorf("key %s not found", key)
 	}
 
-	lastAccess := time.UnixMilli(accessTime)
-	secs := time.Now().Sub(lastAccess).Seconds()
+	unlock := db.rLock(key)
+	defer unlock()
+
+	entry, ok := db.get(key)
+	if !ok {
+		return -1, fmt.Errorf("key %s not found", key)
+	}
 
-	return secs, nil
+	return eviction.IdleTime(entry.Access, time.Now()).Seconds(), nil
 }
@@ -0,0 +1,107 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"reflect"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/modules/hash"
+)
+
+// lazyFreeThreshold is the number of elements above which a detached value is released on the lazy free goroutine.
+// Smaller values are released right away, as handing them over costs about as much as releasing them.
+const lazyFreeThreshold = 64
+
+// freeEffort returns the number of elements of the value, which is the amount of work needed to release it.
+func freeEffort(value interface{}) int {
+	switch v := value.(type) {
+	case hash.Hash:
+		return len(v)
+	case []string:
+		return len(v)
+	case map[string]internal.KeyData:
+		return len(v)
+	case interface{ Cardinality() int }:
+		// Sets and sorted sets.
+		return v.Cardinality()
+	case interface{ Len() int }:
+		// Hashes and lists in the listpack encoding.
+		return v.Len()
+	default:
+		return 1
+	}
+}
+
+// sameValue returns true when both values reference the same data. Commands mutate composite values in place
+// and set them back, in which case the value that is overwritten must not be released.
+func sameValue(a, b interface{}) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() || va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Map, reflect.Pointer, reflect.Slice:
+		return va.UnsafePointer() == vb.UnsafePointer()
+	default:
+		// Other values are copied when they're stored.
+		return false
+	}
+}
+
+// freeLazily hands the value over to the lazy free goroutine when it's large enough. The value must already be
+// detached from the keyspace and deducted from the memory usage.
+func (server *SugarDB) freeLazily(value interface{}) {
+	if freeEffort(value) <= lazyFreeThreshold {
+		return
+	}
+	server.lazyFree.mut.Lock()
+	server.lazyFree.pending.Add(1)
+	server.lazyFree.queue = append(server.lazyFree.queue, value)
+	server.lazyFree.mut.Unlock()
+	// Wake up the goroutine, unless it was already woken up and hasn't taken the queue yet.
+	select {
+	case server.lazyFree.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runLazyFree releases the values handed over by freeLazily until the server is shut down.
+func (server *SugarDB) runLazyFree() {
+	for {
+		select {
+		case <-server.lazyFree.stop:
+			return
+		case <-server.lazyFree.wake:
+		}
+
+		server.lazyFree.mut.Lock()
+		queue := server.lazyFree.queue
+		server.lazyFree.queue = nil
+		server.lazyFree.mut.Unlock()
+
+		for i, value := range queue {
+			// The maps detached from the shards by an asynchronous flush are not referenced anywhere else, so
+			// their entries are cleared here rather than while the shards are locked. Other values may still be
+			// referenced by a snapshot, so only the reference is dropped and the garbage collector reclaims them.
+			if keys, ok := value.(map[string]internal.KeyData); ok {
+				clear(keys)
+			}
+			queue[i] = nil
+			server.lazyFree.pending.Add(-1)
+			server.lazyFree.freed.Add(1)
+		}
+	}
+}
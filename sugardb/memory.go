@@ -0,0 +1,185 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"context"
+	"runtime"
+	"unsafe"
+
+	"github.com/echovault/sugardb/internal"
+)
+
+// keySlotSize is the size of an entry in the map of a shard of the keyspace.
+const keySlotSize = internal.StringHeaderSize + int64(unsafe.Sizeof(internal.KeyData{}))
+
+// addMemUsed adds delta to the memory usage of the store, and updates the peak memory usage.
+func (server *SugarDB) addMemUsed(delta int64) {
+	used := server.memUsed.Add(delta)
+	for {
+		peak := server.memPeak.Load()
+		if used <= peak || server.memPeak.CompareAndSwap(peak, used) {
+			return
+		}
+	}
+}
+
+// accountKey updates the memory usage with the current size of the key. The difference with the size accounted for
+// the key the previous time is added to the memory usage. Values that are mutated in place by the command handlers
+// are recounted by accountWrite once the command is executed. The shard of the key must be locked for writing by
+// the caller.
+func (server *SugarDB) accountKey(db *database, key string) {
+	entry, ok := db.get(key)
+	if !ok {
+		return
+	}
+	mem := internal.StringHeaderSize + int64(len(key)) + entry.GetMem()
+	db.dataset.Add(mem - entry.Mem)
+	db.accountQuotas(key, mem-entry.Mem)
+	server.addMemUsed(mem - entry.Mem)
+	entry.Mem = mem
+	db.put(key, entry)
+}
+
+// accountWrite recounts the memory used by the keys written by the command once it's executed. The handlers update
+// sets, sorted sets and other collections in place without storing them again, so their growth is accounted for
+// here, and keys are evicted when the growth takes the memory usage over the max memory or a quota.
+func (server *SugarDB) accountWrite(ctx context.Context, cmd []string) {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	keys := server.writeKeys(cmd)
+	if db == nil || len(keys) == 0 {
+		return
+	}
+	unlock := db.lock(keys...)
+	for _, key := range keys {
+		server.accountKey(db, key)
+	}
+	unlock()
+	server.adjustMemoryUsageAsync(ctx, db)
+}
+
+// unaccountKey removes the size of the key from the memory usage before the key is deleted.
+// The shard of the key must be locked for writing by the caller.
+func (server *SugarDB) unaccountKey(db *database, key string) {
+	entry, ok := db.get(key)
+	if !ok {
+		return
+	}
+	db.dataset.Add(-entry.Mem)
+	db.accountQuotas(key, -entry.Mem)
+	server.addMemUsed(-entry.Mem)
+}
+
+// accountShard updates the memory usage with the current size of the map of the shard. It must be called after
+// keys are added or removed from the shard. The shard must be locked for writing by the caller.
+func (server *SugarDB) accountShard(db *database, s *shard) {
+	keys := len(s.keys)
+	s.peakKeys = max(s.peakKeys, keys)
+	overhead := internal.MapSize(s.peakKeys, internal.StringHeaderSize, int64(unsafe.Sizeof(internal.KeyData{})))
+	// The slots of the keys are part of the dataset.
+	overhead -= int64(keys) * keySlotSize
+	db.overheadMain.Add(overhead - s.overhead)
+	server.addMemUsed(overhead - s.overhead)
+	s.overhead = overhead
+}
+
+// accountExpiry updates the memory usage with the current size of the expiry index of the database. It must be
+// called after keys are added or removed from the index. The index must be locked by the caller.
+func (server *SugarDB) accountExpiry(db *database) {
+	index := db.expiry.index
+	db.expiry.peakKeys = max(db.expiry.peakKeys, index.Len())
+	// The map of the index, the heap of pointers to the entries, and the entries holding the key, the expiry,
+	// and the position in the heap.
+	overhead := internal.MapSize(db.expiry.peakKeys, internal.StringHeaderSize, 8) +
+		internal.SliceHeaderSize + int64(index.Cap())*8 + int64(index.Len())*(internal.StringHeaderSize+16)
+	server.addMemUsed(overhead - db.expiry.overhead)
+	db.expiry.overhead = overhead
+}
+
+// accountOverhead accounts for the maps of all the shards and the expiry index of a new database.
+func (server *SugarDB) accountOverhead(db *database) {
+	for i := range db.shards {
+		db.shards[i].mut.Lock()
+		server.accountShard(db, &db.shards[i])
+		db.shards[i].mut.Unlock()
+	}
+	db.expiry.mut.Lock()
+	server.accountExpiry(db)
+	db.expiry.mut.Unlock()
+}
+
+// memoryUsage returns the memory used by the key and its value.
+func (server *SugarDB) memoryUsage(ctx context.Context, key string) (int64, bool) {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return 0, false
+	}
+	unlock := db.rLock(key)
+	defer unlock()
+
+	entry, ok := db.get(key)
+	if !ok {
+		return 0, false
+	}
+	return entry.Mem, true
+}
+
+// memoryStats returns the memory usage of the store and of each database.
+func (server *SugarDB) memoryStats() internal.MemoryStats {
+	var runtimeStats runtime.MemStats
+	runtime.ReadMemStats(&runtimeStats)
+
+	databases := *server.store.Load()
+	stats := internal.MemoryStats{
+		PeakAllocated:  server.memPeak.Load(),
+		TotalAllocated: server.memUsed.Load(),
+		MaxMemory:      server.config.MaxMemory,
+		Databases:      make(map[int]internal.DatabaseMemoryStats, len(databases)),
+		HeapAlloc:      runtimeStats.HeapAlloc,
+		HeapSys:        runtimeStats.HeapSys,
+
+		LazyFreePendingObjects: server.lazyFree.pending.Load(),
+		LazyFreedObjects:       server.lazyFree.freed.Load(),
+	}
+	if server.tiered.store != nil {
+		stats.Tiered = internal.TieredStats{
+			Keys:      server.tiered.keys.Load(),
+			Hits:      server.tiered.hits.Load(),
+			Misses:    server.tiered.misses.Load(),
+			Faults:    server.tiered.faults.Load(),
+			Spills:    server.tiered.spills.Load(),
+			DiskBytes: server.tiered.store.Size(),
+		}
+	}
+	if server.isActiveActive() {
+		stats.ActiveActive.Keys, stats.ActiveActive.Bytes = server.activeActive.state.Stats()
+	}
+	for index, db := range databases {
+		db.expiry.mut.Lock()
+		volatileKeys, overheadExpires := db.expiry.index.Len(), db.expiry.overhead
+		db.expiry.mut.Unlock()
+		stats.Databases[index] = internal.DatabaseMemoryStats{
+			Keys:            db.len(),
+			VolatileKeys:    volatileKeys,
+			DatasetBytes:    db.dataset.Load(),
+			OverheadMain:    db.overheadMain.Load(),
+			OverheadExpires: overheadExpires,
+		}
+		stats.Keys += db.len()
+		stats.DatasetBytes += db.dataset.Load()
+		stats.OverheadBytes += db.overheadMain.Load() + overheadExpires
+	}
+	return stats
+}
@@ -19,10 +19,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"slices"
 	"strings"
 
 	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/activeactive"
 	"github.com/echovault/sugardb/internal/clock"
 	"github.com/echovault/sugardb/internal/constants"
 )
@@ -38,6 +41,47 @@ func (server *SugarDB) getCommand(cmd string) (internal.Command, error) {
 	return internal.Command{}, fmt.Errorf("command %s not supported", cmd)
 }
 
+// getHandlerFunc returns the handler for the command or subcommand in cmd.
+func (server *SugarDB) getHandlerFunc(cmd []string) (internal.HandlerFunc, error) {
+	command, err := server.getCommand(cmd[0])
+	if err != nil {
+		return nil, err
+	}
+	sc, err := internal.GetSubCommand(command, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if subCommand, ok := sc.(internal.SubCommand); ok {
+		return subCommand.HandlerFunc, nil
+	}
+	return command.HandlerFunc, nil
+}
+
+// rewriteCommand returns the deterministic form of cmd if the command or subcommand has a RewriteFunc.
+// Otherwise, cmd is returned unchanged.
+func (server *SugarDB) rewriteCommand(ctx context.Context, cmd []string, conn *net.Conn) (internal.RewriteFuncResult, error) {
+	command, err := server.getCommand(cmd[0])
+	if err != nil {
+		return internal.RewriteFuncResult{}, err
+	}
+
+	rewrite := command.RewriteFunc
+
+	sc, err := internal.GetSubCommand(command, cmd)
+	if err != nil {
+		return internal.RewriteFuncResult{}, err
+	}
+	if subCommand, ok := sc.(internal.SubCommand); ok {
+		rewrite = subCommand.RewriteFunc
+	}
+
+	if rewrite == nil {
+		return internal.RewriteFuncResult{Command: cmd}, nil
+	}
+
+	return rewrite(server.getHandlerFuncParams(ctx, cmd, conn))
+}
+
 func (server *SugarDB) getHandlerFuncParams(ctx context.Context, cmd []string, conn *net.Conn) internal.HandlerFuncParams {
 	return internal.HandlerFuncParams{
 		Context:               ctx,
@@ -53,6 +97,7 @@ func (server *SugarDB) getHandlerFuncParams(ctx context.Context, cmd []string, c
 		TakeSnapshot:          server.takeSnapshot,
 		GetLatestSnapshotTime: server.getLatestSnapshotTime,
 		RewriteAOF:            server.rewriteAOF,
+		Backup:                server.backup,
 		LoadModule:            server.LoadModule,
 		UnloadModule:          server.UnloadModule,
 		ListModules:           server.ListModules,
@@ -61,19 +106,39 @@ func (server *SugarDB) getHandlerFuncParams(ctx context.Context, cmd []string, c
 		GetAllCommands:        server.getCommands,
 		GetClock:              server.getClock,
 		Flush:                 server.Flush,
+		FlushAsync:            server.FlushAsync,
 		RandomKey:             server.randomKey,
 		DBSize:                server.dbSize,
 		TouchKey:              server.updateKeysInCache,
 		GetObjectFrequency:    server.getObjectFreq,
 		GetObjectIdleTime:     server.getObjectIdleTime,
+		GetObjectEncoding:     server.getObjectEncoding,
+		GetMemoryUsage:        server.memoryUsage,
+		GetMemoryStats:        server.memoryStats,
+		GetMemoryQuotas:       server.memoryQuotas,
 		SwapDBs:               server.SwapDBs,
 		GetServerInfo:         server.GetServerInfo,
 		AddScript:             server.AddScript,
-		DeleteKey: func(ctx context.Context, key string) error {
-			server.storeLock.Lock()
-			defer server.storeLock.Unlock()
-			return server.deleteKey(ctx, key)
-		},
+		WaitForReplicas:       server.waitForReplicas,
+		WaitForAOF:            server.waitForAOF,
+		GetShards:             server.getShards,
+		GetShardID:            server.getShardID,
+		AddSlots:              server.addSlots,
+		SetSlot:               server.setSlot,
+		GetKeysInSlot:         server.getKeysInSlot,
+		SetAsking:             server.setAsking,
+		GetClusterInfo:        server.getClusterInfo,
+		GetClusterNodes:       server.getClusterNodes,
+		ForgetNode:            server.forgetNode,
+		TransferLeadership:    server.transferLeadership,
+		AddNonvoter:           server.addNonvoter,
+		PromoteNode:           server.promoteNode,
+		ReplicaOf:             server.replicaOf,
+		SyncReplica:           server.syncReplica,
+		GetReplicationInfo:    server.getReplicationInfo,
+		SyncPeer:              server.syncPeer,
+		DeleteKey:             server.deleteKey,
+		UnlinkKey:             server.unlinkKey,
 		GetConnectionInfo: func(conn *net.Conn) internal.ConnectionInfo {
 			server.connInfo.mut.RLock()
 			defer server.connInfo.mut.RUnlock()
@@ -94,17 +159,20 @@ func (server *SugarDB) getHandlerFuncParams(ctx context.Context, cmd []string, c
 			}
 
 			// If the database index does not exist, create the new database.
-			server.storeLock.Lock()
-			if server.store[database] == nil {
-				server.createDatabase(database)
-			}
-			server.storeLock.Unlock()
+			server.getOrCreateDatabase(database)
 
 			// Set database index for the current connection.
 			info.Database = database
 
 			server.connInfo.tcpClients[conn] = info
 		},
+		SetReadConsistency: func(conn *net.Conn, level string) {
+			server.connInfo.mut.Lock()
+			defer server.connInfo.mut.Unlock()
+			info := server.connInfo.tcpClients[conn]
+			info.ReadConsistency = level
+			server.connInfo.tcpClients[conn] = info
+		},
 	}
 }
 
@@ -117,12 +185,15 @@ func (server *SugarDB) handleCommand(ctx context.Context, message []byte, conn *
 		ctx = context.WithValue(ctx, "ConnectionName", server.connInfo.embedded.Name)
 		ctx = context.WithValue(ctx, "Protocol", server.connInfo.embedded.Protocol)
 		ctx = context.WithValue(ctx, "Database", server.connInfo.embedded.Database)
-	} else {
+		ctx = context.WithValue(ctx, "ReadConsistency", server.connInfo.embedded.ReadConsistency)
+	} else if !replay {
 		// The call is triggered by a TCP connection.
+		// Replayed commands from the AOF log or the replication stream carry their own protocol and database.
 		// Add TCP connection info to the context of the request.
 		ctx = context.WithValue(ctx, "ConnectionName", server.connInfo.tcpClients[conn].Name)
 		ctx = context.WithValue(ctx, "Protocol", server.connInfo.tcpClients[conn].Protocol)
 		ctx = context.WithValue(ctx, "Database", server.connInfo.tcpClients[conn].Database)
+		ctx = context.WithValue(ctx, "ReadConsistency", server.connInfo.tcpClients[conn].ReadConsistency)
 	}
 	server.connInfo.mut.RUnlock()
 
@@ -166,29 +237,111 @@ func (server *SugarDB) handleCommand(ctx context.Context, message []byte, conn *
 		}
 	}
 
-	// If the command is a write command, wait for state copy to finish.
-	if internal.IsWriteCommand(command, subCommand) {
-		for {
-			if !server.stateCopyInProgress.Load() {
-				server.stateMutationInProgress.Store(true)
-				break
+	// Replicas only accept writes from the replication stream of their primary.
+	if !replay && internal.IsWriteCommand(command, subCommand) && server.isReplica() {
+		return nil, errReadOnlyReplica
+	}
+
+	// Redirect commands for keys in hash slots that are not served by this node's shard.
+	if server.isSharded() && !replay {
+		release, err := server.checkSlot(ctx, conn, embedded, command, subCommand, cmd)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	// Apply the read consistency level of the connection to reads in cluster mode.
+	if server.isInCluster() && internal.IsReadCommand(command, subCommand) {
+		switch ctx.Value("ReadConsistency") {
+		case constants.ReadConsistencyLeader:
+			if !server.raft.IsRaftLeader() {
+				return server.forwardRead(ctx, message)
 			}
+		case constants.ReadConsistencyLinearizable:
+			if err = server.readBarrier(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Reject the writes to the keys of a quota that is reached before they're executed or appended to the raft log.
+	if internal.IsWriteCommand(command, subCommand) && !replay && (!server.isInCluster() || server.raft.IsRaftLeader()) {
+		if err = server.checkWriteQuotas(ctx, cmd); err != nil {
+			return nil, err
 		}
 	}
 
 	if !server.isInCluster() || !synchronize {
+		recordEffects := internal.IsWriteCommand(command, subCommand) && !replay && server.isActiveActive()
+		if recordEffects {
+			// Serialise the local writes with the effects received from the peers, so that the effects of each
+			// write are recorded from the values it changed.
+			server.replication.applyMut.Lock()
+			defer server.replication.applyMut.Unlock()
+		}
+
+		if internal.IsWriteCommand(command, subCommand) {
+			// Let a state copy wait for the write to be logged and streamed to the replicas.
+			server.writeGate.enter()
+			defer server.writeGate.exit()
+		}
+
+		var rewrite internal.RewriteFuncResult
+		if internal.IsWriteCommand(command, subCommand) && !replay {
+			// Rewrite commands whose effect depends on the current time or on a random choice into their
+			// deterministic form. The rewritten command is executed and logged so that replaying the AOF log
+			// reproduces exactly the same state.
+			if rewrite, err = server.rewriteCommand(ctx, cmd, conn); err != nil {
+				return nil, err
+			}
+			if !slices.Equal(rewrite.Command, cmd) {
+				cmd = rewrite.Command
+				message = internal.EncodeCommand(cmd)
+				if handler, err = server.getHandlerFunc(cmd); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		var before map[string]activeactive.Value
+		if recordEffects {
+			if before, err = server.activeActiveValues(ctx.Value("Database").(int), server.writeKeys(cmd)); err != nil {
+				return nil, err
+			}
+		}
+
 		res, err := handler(server.getHandlerFuncParams(ctx, cmd, conn))
+		if internal.IsWriteCommand(command, subCommand) {
+			server.accountWrite(ctx, cmd)
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		if internal.IsWriteCommand(command, subCommand) && !replay {
-			server.connInfo.mut.RLock()
-			server.aofEngine.LogCommand(server.connInfo.tcpClients[conn].Database, message)
-			server.connInfo.mut.RUnlock()
+		if recordEffects {
+			// The command is applied, so it's still logged and streamed when its effects can't be recorded.
+			if err = server.recordLocalWrite(ctx.Value("Database").(int), cmd, before); err != nil {
+				log.Printf("record local write %v: %v\n", cmd, err)
+			}
 		}
 
-		server.stateMutationInProgress.Store(false)
+		if rewrite.Response != nil {
+			res = rewrite.Response
+		}
+
+		if internal.IsWriteCommand(command, subCommand) && !replay {
+			database := ctx.Value("Database").(int)
+			// With the "always" strategy, LogCommand only returns once the command is durable.
+			offset := server.aofEngine.LogCommand(database, message)
+			server.setLastWrite(conn, offset)
+			// Stream the write to the replicas.
+			if offset = server.replication.backlog.Write(database, internal.EncodeCommand(cmd)); offset > 0 {
+				server.lastWrite.mut.Lock()
+				server.lastWrite.replication[conn] = offset
+				server.lastWrite.mut.Unlock()
+			}
+		}
 
 		return res, err
 	}
@@ -196,22 +349,60 @@ func (server *SugarDB) handleCommand(ctx context.Context, message []byte, conn *
 	// Handle other commands that need to be synced across the cluster
 	if server.raft.IsRaftLeader() {
 		var res []byte
-		res, err = server.raftApplyCommand(ctx, cmd)
+		var index uint64
+		res, index, err = server.raftApplyCommandWithIndex(ctx, cmd)
 		if err != nil {
 			return nil, err
 		}
+		server.setLastWrite(conn, index)
 		return res, err
 	}
 
-	// Forward message to leader and return immediate OK response
+	// Forward message to leader and return the leader's response
 	if server.config.ForwardCommand {
-		server.memberList.ForwardDataMutation(ctx, message)
-		return []byte(constants.OkResponse), nil
+		return server.forwardCommand(ctx, message)
 	}
 
 	return nil, errors.New("not cluster leader, cannot carry out command")
 }
 
+// setLastWrite records the position of the latest write made by the connection.
+func (server *SugarDB) setLastWrite(conn *net.Conn, position uint64) {
+	if position == 0 {
+		return
+	}
+	server.lastWrite.mut.Lock()
+	defer server.lastWrite.mut.Unlock()
+	if position > server.lastWrite.clients[conn] {
+		server.lastWrite.clients[conn] = position
+	}
+}
+
+// waitForAOF blocks until the latest write made by the connection is durable on the local node,
+// or until the context is done.
+// In standalone mode, this waits for the AOF log to be fsynced. In cluster mode, raft log entries are
+// persisted before they are applied so the write is already durable if the raft log is stored on disk.
+func (server *SugarDB) waitForAOF(ctx context.Context, conn *net.Conn) error {
+	if server.isInCluster() {
+		if server.config.DataDir == "" {
+			return errors.New("WAITAOF cannot be used when numlocal is set but the raft log is stored in memory")
+		}
+		return nil
+	}
+
+	server.lastWrite.mut.RLock()
+	offset := server.lastWrite.clients[conn]
+	server.lastWrite.mut.RUnlock()
+
+	if err := server.aofEngine.WaitForSync(ctx, offset); err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		return fmt.Errorf("WAITAOF cannot be used when numlocal is set: %v", err)
+	}
+	return nil
+}
+
 func (server *SugarDB) getCommands() []internal.Command {
 	return server.commands
 }
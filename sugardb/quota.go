@@ -0,0 +1,217 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/config"
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/eviction"
+)
+
+// memoryQuota limits the memory used by the keys of a database, or by the keys of the database with a prefix.
+type memoryQuota struct {
+	config.MemoryQuota
+	used     atomic.Int64 // The memory used by the keys of the quota and their values.
+	evicted  atomic.Int64 // The number of keys evicted to enforce the quota since startup.
+	rejected atomic.Int64 // The number of writes rejected by the quota since startup.
+	// The evictions of the quota are serialised, so that concurrent writes don't evict more keys than needed.
+	// The mutex also guards the eviction pool of the quota.
+	mut  sync.Mutex
+	pool *eviction.Pool
+}
+
+func (quota *memoryQuota) String() string {
+	if quota.Prefix == "" {
+		return fmt.Sprintf("db %d", quota.Database)
+	}
+	return fmt.Sprintf("db %d prefix %s", quota.Database, quota.Prefix)
+}
+
+// matches returns true when the key belongs to the quota.
+func (quota *memoryQuota) matches(key string) bool {
+	return strings.HasPrefix(key, quota.Prefix)
+}
+
+// exceeded returns true when the memory used by the keys of the quota has reached the max memory of the quota.
+func (quota *memoryQuota) exceeded() bool {
+	used := quota.used.Load()
+	return used > 0 && uint64(used) >= quota.MaxMemory
+}
+
+// newMemoryQuotas validates the quotas of the config and returns them grouped by database.
+func newMemoryQuotas(conf config.Config) (map[int][]*memoryQuota, error) {
+	policies := []string{
+		constants.NoEviction,
+		constants.AllKeysLFU, constants.AllKeysLRU, constants.AllKeysRandom,
+		constants.VolatileLFU, constants.VolatileLRU, constants.VolatileRandom, constants.VolatileTTL,
+	}
+	// The access metadata of the keys is either an LFU counter or an LRU clock, depending on the eviction policy.
+	lfu := []string{constants.AllKeysLFU, constants.VolatileLFU}
+	lru := []string{constants.AllKeysLRU, constants.VolatileLRU}
+	globalPolicy := strings.ToLower(conf.EvictionPolicy)
+
+	quotas := make(map[int][]*memoryQuota)
+	for _, c := range conf.MemoryQuotas {
+		quota := &memoryQuota{MemoryQuota: c, pool: eviction.NewPool()}
+		quota.Prefix = strings.TrimSuffix(quota.Prefix, "*")
+		quota.Policy = strings.ToLower(quota.Policy)
+		if quota.Policy == "" {
+			quota.Policy = constants.NoEviction
+		}
+		switch {
+		case quota.Database < 0:
+			return nil, fmt.Errorf("memory quota %s: database must be a non-negative integer", quota)
+		case quota.MaxMemory == 0:
+			return nil, fmt.Errorf("memory quota %s: max memory must be greater than 0", quota)
+		case !slices.Contains(policies, quota.Policy):
+			return nil, fmt.Errorf("memory quota %s: policy %s is not a valid policy", quota, quota.Policy)
+		case slices.Contains(lfu, quota.Policy) && !slices.Contains(lfu, globalPolicy),
+			slices.Contains(lru, quota.Policy) && !slices.Contains(lru, globalPolicy):
+			return nil, fmt.Errorf("memory quota %s: policy %s requires an eviction policy that tracks the same accesses",
+				quota, quota.Policy)
+		case slices.ContainsFunc(quotas[quota.Database], func(q *memoryQuota) bool {
+			return q.Prefix == quota.Prefix
+		}):
+			return nil, fmt.Errorf("memory quota %s is defined more than once", quota)
+		}
+		quotas[quota.Database] = append(quotas[quota.Database], quota)
+	}
+	return quotas, nil
+}
+
+// accountQuotas adds delta to the memory used by the quotas that the key belongs to.
+// The shard of the key must be locked for writing by the caller.
+func (db *database) accountQuotas(key string, delta int64) {
+	for _, quota := range db.quotas {
+		if quota.matches(key) {
+			quota.used.Add(delta)
+		}
+	}
+}
+
+// checkQuotas returns an error when one of the keys belongs to a quota that is reached and rejects writes.
+func (db *database) checkQuotas(keys []string) error {
+	for _, quota := range db.quotas {
+		if quota.Policy != constants.NoEviction || !quota.exceeded() || !slices.ContainsFunc(keys, quota.matches) {
+			continue
+		}
+		quota.rejected.Add(1)
+		return fmt.Errorf("memory quota %s reached, key value not set", quota)
+	}
+	return nil
+}
+
+// shrinkingCommands are the write commands that never grow the memory used by their keys. They're let through when
+// a quota is reached, so that the keys of the quota can be removed or trimmed to make room for other writes.
+var shrinkingCommands = []string{
+	"del", "unlink", "getdel", "getex", "persist", "expire", "pexpire", "expireat", "pexpireat",
+	"hdel", "lpop", "rpop", "lrem", "ltrim", "srem", "spop",
+	"zrem", "zremrangebylex", "zremrangebyrank", "zremrangebyscore", "zpopmin", "zpopmax", "zmpop",
+}
+
+// checkWriteQuotas returns an error when the command writes to the keys of a quota that is reached and rejects
+// writes. It's called before the command is executed, as the handlers grow collections in place without going
+// through setValues.
+func (server *SugarDB) checkWriteQuotas(ctx context.Context, cmd []string) error {
+	if slices.Contains(shrinkingCommands, strings.ToLower(cmd[0])) {
+		return nil
+	}
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil || len(db.quotas) == 0 {
+		return nil
+	}
+	return db.checkQuotas(server.writeKeys(cmd))
+}
+
+// quotasExceeded returns true when one of the quotas of the database that evict keys is exceeded.
+func (db *database) quotasExceeded() bool {
+	return slices.ContainsFunc(db.quotas, func(quota *memoryQuota) bool {
+		return quota.Policy != constants.NoEviction && quota.exceeded()
+	})
+}
+
+// adjustQuotaUsage evicts the keys of each quota of the database that is exceeded with the policy of the quota,
+// until the memory used by the keys of the quota is below its max memory.
+func (server *SugarDB) adjustQuotaUsage(ctx context.Context) error {
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return nil
+	}
+	for _, quota := range db.quotas {
+		if quota.Policy == constants.NoEviction || !quota.exceeded() {
+			continue
+		}
+		if err := server.evictQuota(ctx, db, quota); err != nil {
+			return fmt.Errorf("adjustQuotaUsage -> %s: %+v", quota, err)
+		}
+	}
+	return nil
+}
+
+// evictQuota evicts the best candidates among the keys of the quota until the quota is no longer exceeded.
+func (server *SugarDB) evictQuota(ctx context.Context, db *database, quota *memoryQuota) error {
+	quota.mut.Lock()
+	defer quota.mut.Unlock()
+
+	var match func(key string) bool
+	if quota.Prefix != "" {
+		match = quota.matches
+	}
+	for quota.exceeded() {
+		key, ok := server.sampleCandidate(db, quota.pool, quota.Policy, match, nil)
+		if !ok {
+			return errors.New("no keys to evict")
+		}
+		if err := server.evictKey(ctx, key); err != nil {
+			return err
+		}
+		quota.evicted.Add(1)
+	}
+	return nil
+}
+
+// memoryQuotas returns the usage of each quota, ordered by database and prefix.
+func (server *SugarDB) memoryQuotas() []internal.MemoryQuotaStats {
+	var stats []internal.MemoryQuotaStats
+	for _, quotas := range server.quotas {
+		for _, quota := range quotas {
+			stats = append(stats, internal.MemoryQuotaStats{
+				Database:  quota.Database,
+				Prefix:    quota.Prefix,
+				MaxMemory: quota.MaxMemory,
+				Policy:    quota.Policy,
+				Used:      quota.used.Load(),
+				Evicted:   quota.evicted.Load(),
+				Rejected:  quota.rejected.Load(),
+			})
+		}
+	}
+	slices.SortFunc(stats, func(a, b internal.MemoryQuotaStats) int {
+		if a.Database != b.Database {
+			return a.Database - b.Database
+		}
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+	return stats
+}
@@ -0,0 +1,406 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/backup"
+	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/replication"
+	"github.com/tidwall/resp"
+)
+
+var (
+	// errReplicationCluster is returned by the replication commands in cluster mode,
+	// where the raft log replicates the state instead.
+	errReplicationCluster = errors.New("replication is only supported in standalone mode")
+	// errReadOnlyReplica is returned when a client sends a write command to a replica.
+	errReadOnlyReplica = errors.New("READONLY You can't write against a read only replica.")
+)
+
+func (server *SugarDB) isReplica() bool {
+	if server.isInCluster() {
+		return false
+	}
+	server.replication.replicaMut.RLock()
+	defer server.replication.replicaMut.RUnlock()
+	return server.replication.replica != nil
+}
+
+// replicaOf starts replicating the primary at host:port, replacing the link to the current primary.
+// When the host is empty, the replication is stopped and the node becomes a primary that keeps its state.
+func (server *SugarDB) replicaOf(host string, port int) error {
+	if server.isInCluster() {
+		return errReplicationCluster
+	}
+
+	if host != "" && server.isActiveActive() {
+		return errors.New("replica of is not supported with active-active replication")
+	}
+
+	server.replication.replicaMut.Lock()
+	defer server.replication.replicaMut.Unlock()
+
+	current := server.replication.replica
+	if current != nil {
+		if status := current.Status(); host != "" && status.Host == host && status.Port == port {
+			return nil
+		}
+		current.Stop()
+		server.replication.replica = nil
+	}
+
+	if host == "" {
+		if current != nil {
+			// Keep the previous replication ID valid so that the other replicas of the former primary
+			// can continue their stream from this node.
+			server.replication.backlog.Shift(replication.NewReplicationID())
+			log.Println("replication stopped, the node is now a primary")
+		}
+		return nil
+	}
+
+	server.replication.replica = replication.StartReplica(host, port, replication.ReplicaOpts{
+		Backlog:      server.replication.backlog,
+		Locker:       &server.replication.applyMut,
+		FullSync:     server.replicationFullSync,
+		ApplyCommand: server.applyReplicatedCommand,
+		Dial:         server.dialPrimary,
+		Auth:         server.primaryAuth(),
+	})
+	log.Printf("replicating primary %s\n", net.JoinHostPort(host, strconv.Itoa(port)))
+
+	return nil
+}
+
+// dialPrimary opens a link to a primary or to an active-active peer, over TLS when primary-tls is set.
+func (server *SugarDB) dialPrimary(ctx context.Context, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	if !server.config.PrimaryTLS {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host}
+	// The certificates are presented when the primary runs in mTLS mode.
+	for _, certKeyPair := range server.config.CertKeyPairs {
+		c, err := tls.LoadX509KeyPair(certKeyPair[0], certKeyPair[1])
+		if err != nil {
+			return nil, fmt.Errorf("load cert key pair: %v", err)
+		}
+		config.Certificates = append(config.Certificates, c)
+	}
+	// Without certificate authorities, the primary is verified with the system roots.
+	if len(server.config.PrimaryCAs) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		for _, path := range server.config.PrimaryCAs {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("primary ca: %v", err)
+			}
+			if !config.RootCAs.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("primary ca: no certificates found in %s", path)
+			}
+		}
+	}
+
+	return (&tls.Dialer{NetDialer: &dialer, Config: config}).DialContext(ctx, "tcp", address)
+}
+
+// primaryAuth returns the AUTH command that authenticates the links to the primary and to the active-active
+// peers, or nil when no password is configured.
+func (server *SugarDB) primaryAuth() []string {
+	switch {
+	case server.config.PrimaryAuth == "":
+		return nil
+	case server.config.PrimaryUser == "":
+		return []string{"AUTH", server.config.PrimaryAuth}
+	default:
+		return []string{"AUTH", server.config.PrimaryUser, server.config.PrimaryAuth}
+	}
+}
+
+// stopReplication closes the link to the primary and ends the streams to the replicas.
+func (server *SugarDB) stopReplication() {
+	server.replication.replicaMut.Lock()
+	defer server.replication.replicaMut.Unlock()
+	if server.replication.replica != nil {
+		server.replication.replica.Stop()
+		server.replication.replica = nil
+	}
+	server.replication.cancel()
+}
+
+// syncReplica answers the PSYNC request of a replica on the connection and streams the writes to it.
+// It only returns once the stream ends, with io.EOF so that the connection is closed.
+func (server *SugarDB) syncReplica(ctx context.Context, conn *net.Conn, replicationID string, offset int64) error {
+	if server.isInCluster() {
+		return errReplicationCluster
+	}
+	if conn == nil {
+		return errors.New("PSYNC requires a TCP connection")
+	}
+
+	addr := (*conn).RemoteAddr().String()
+	server.replication.replicasMut.Lock()
+	server.replication.replicas[conn] = internal.ReplicaInfo{Addr: addr}
+	server.replication.replicasMut.Unlock()
+	defer func() {
+		server.replication.replicasMut.Lock()
+		delete(server.replication.replicas, conn)
+		server.replication.replicasMut.Unlock()
+	}()
+
+	log.Printf("replica %s connected\n", addr)
+	err := server.serveStream(ctx, conn, server.replication.backlog, replicationID, offset, server.replicationSnapshot,
+		func(offset uint64) {
+			server.replication.replicasMut.Lock()
+			defer server.replication.replicasMut.Unlock()
+			info := server.replication.replicas[conn]
+			info.Offset = offset
+			server.replication.replicas[conn] = info
+		},
+		func(offset uint64) {
+			server.replication.replicasMut.Lock()
+			defer server.replication.replicasMut.Unlock()
+			info := server.replication.replicas[conn]
+			info.Acked = offset
+			server.replication.replicas[conn] = info
+			close(server.replication.acked)
+			server.replication.acked = make(chan struct{})
+		},
+	)
+	log.Printf("replica %s disconnected: %v\n", addr, err)
+
+	return io.EOF
+}
+
+// serveStream answers a request for the stream of the backlog from the replication ID and offset on the
+// connection, and then streams the backlog until the link is closed or the node shuts down.
+// The acked function, when not nil, receives the offsets acknowledged by the other end with REPLCONF ACK.
+func (server *SugarDB) serveStream(
+	ctx context.Context,
+	conn *net.Conn,
+	backlog *replication.Backlog,
+	replicationID string,
+	offset int64,
+	snapshot func() (replication.Snapshot, error),
+	sent func(offset uint64),
+	acked func(offset uint64),
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(server.replication.ctx, cancel)
+	defer stop()
+	// The other end only sends acknowledgements after the request, and the read fails once the link is closed.
+	// This ends the stream without waiting for the next write to fail.
+	go func() {
+		defer cancel()
+		rd := resp.NewReader(*conn)
+		for {
+			value, _, err := rd.ReadValue()
+			if err != nil {
+				return
+			}
+			command := value.Array()
+			if len(command) != 3 || !strings.EqualFold(command[0].String(), "REPLCONF") ||
+				!strings.EqualFold(command[1].String(), "ACK") || acked == nil {
+				continue
+			}
+			if offset, err := strconv.ParseUint(command[2].String(), 10, 64); err == nil {
+				acked(offset)
+			}
+		}
+	}()
+
+	if offset < 0 {
+		// The other end has no stream to continue.
+		replicationID = "?"
+	}
+
+	return replication.Serve(ctx, *conn, backlog, replicationID, uint64(max(offset, 0)), snapshot, sent)
+}
+
+// waitForReplicaAcks blocks until at least numReplicas replicas have acknowledged the replication stream up to
+// the latest write made by the connection, or until the context is done. It returns the number of replicas
+// that acknowledged the write.
+func (server *SugarDB) waitForReplicaAcks(ctx context.Context, conn *net.Conn, numReplicas int) int {
+	server.lastWrite.mut.RLock()
+	offset := server.lastWrite.replication[conn]
+	server.lastWrite.mut.RUnlock()
+
+	for {
+		count := 0
+		server.replication.replicasMut.Lock()
+		for _, replica := range server.replication.replicas {
+			if replica.Acked >= offset {
+				count++
+			}
+		}
+		acked := server.replication.acked
+		server.replication.replicasMut.Unlock()
+
+		if count >= numReplicas {
+			return count
+		}
+		select {
+		case <-ctx.Done():
+			return count
+		case <-acked:
+		}
+	}
+}
+
+// replicationSnapshot copies the state along with the matching position of the replication stream.
+func (server *SugarDB) replicationSnapshot() (replication.Snapshot, error) {
+	// When this node is itself a replica, block the stream of its primary until the state is copied.
+	server.replication.applyMut.Lock()
+	defer server.replication.applyMut.Unlock()
+
+	var s replication.Snapshot
+	data, err := server.copyState(func() {
+		s.ID, s.Offset, s.Database = server.replication.backlog.Position()
+	})
+	if err != nil {
+		return replication.Snapshot{}, err
+	}
+	createdAt := server.clock.Now().UnixMilli()
+
+	state := make(map[int]map[string]internal.KeyData)
+	for database, store := range data {
+		state[database] = make(map[string]internal.KeyData)
+		for key, value := range store {
+			if keyData, ok := value.(internal.KeyData); ok {
+				state[database][key] = keyData
+			}
+		}
+	}
+
+	s.Write = func(w io.Writer) error {
+		return backup.Write(w, backup.Backup{
+			Metadata: backup.Metadata{
+				ServerVersion: constants.Version,
+				ServerID:      server.config.ServerID,
+				Mode:          "standalone",
+				CreatedAt:     createdAt,
+			},
+			State: state,
+		}, nil)
+	}
+
+	return s, nil
+}
+
+// replicationFullSync replaces the state with the snapshot sent by the primary. The snapshot is decoded and loaded
+// into new databases first, so the current state is left intact when the snapshot can't be read. The databases are
+// then swapped in while the write commands are held back, so clients never observe a partially loaded state.
+func (server *SugarDB) replicationFullSync(r io.Reader) error {
+	b, err := backup.Read(r, nil)
+	if err != nil {
+		return err
+	}
+
+	staged := make(map[int]*database)
+	for index, data := range internal.FilterExpiredKeys(server.clock.Now(), b.State) {
+		db := newDatabase(index, nil)
+		for key, keyData := range data {
+			db.put(key, internal.KeyData{
+				Value:    server.encodeValue(keyData.Value),
+				ExpireAt: keyData.ExpireAt,
+				Access:   server.newAccess(),
+			})
+		}
+		staged[index] = db
+	}
+
+	server.writeGate.close()
+	// The databases that are not in the snapshot are emptied.
+	for _, index := range server.getDatabases() {
+		if _, ok := staged[index]; !ok {
+			staged[index] = newDatabase(index, nil)
+		}
+	}
+	for index, db := range staged {
+		server.replaceDatabase(server.getOrCreateDatabase(index), db)
+	}
+	server.writeGate.open()
+
+	server.persistState()
+	return nil
+}
+
+// applyReplicatedCommand executes a write command from the replication stream of the primary
+// and logs it to the AOF log.
+func (server *SugarDB) applyReplicatedCommand(database int, command []string) error {
+	ctx := context.WithValue(server.context, "Protocol", 2)
+	ctx = context.WithValue(ctx, "Database", database)
+
+	server.getOrCreateDatabase(database)
+
+	message := internal.EncodeCommand(command)
+	if _, err := server.handleCommand(ctx, message, nil, true, true); err != nil {
+		return err
+	}
+	server.aofEngine.LogCommand(database, message)
+
+	return nil
+}
+
+func (server *SugarDB) getReplicationInfo() (internal.ReplicationInfo, error) {
+	if server.isInCluster() {
+		return internal.ReplicationInfo{}, errReplicationCluster
+	}
+
+	id, offset, _ := server.replication.backlog.Position()
+	info := internal.ReplicationInfo{Role: "master", ReplicationID: id, Offset: offset}
+
+	server.replication.replicaMut.RLock()
+	if replica := server.replication.replica; replica != nil {
+		status := replica.Status()
+		info.Role = "replica"
+		info.PrimaryHost = status.Host
+		info.PrimaryPort = status.Port
+		info.LinkState = status.State
+	}
+	server.replication.replicaMut.RUnlock()
+
+	server.replication.replicasMut.Lock()
+	for _, replica := range server.replication.replicas {
+		info.Replicas = append(info.Replicas, replica)
+	}
+	server.replication.replicasMut.Unlock()
+	slices.SortFunc(info.Replicas, func(a, b internal.ReplicaInfo) int {
+		return strings.Compare(a.Addr, b.Addr)
+	})
+
+	return info, nil
+}
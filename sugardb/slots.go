@@ -0,0 +1,731 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/slots"
+	"github.com/hashicorp/raft"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slotMigrationBatchSize is the number of keys moved to the target shard in each slot migration request.
+const slotMigrationBatchSize = 100
+
+// slotRequest is sent by the leader of the shard migrating a slot to the leader of the target shard.
+type slotRequest struct {
+	Op       string `json:"Op"` // IMPORTING | IMPORT | NODE
+	Slot     int    `json:"Slot"`
+	Shard    string `json:"Shard"` // The ID of the source shard.
+	Database int    `json:"Database"`
+	Data     []byte `json:"Data,omitempty"` // The keys imported with the IMPORT op, encoded by encodeMigratedKeys.
+}
+
+// migratedKey is a key copied to the target shard of a slot migration.
+type migratedKey struct {
+	Key      string
+	Record   valueRecord
+	ExpireAt time.Time
+}
+
+// movingKey identifies a key that's being moved to another shard.
+type movingKey struct {
+	database int
+	key      string
+}
+
+// isSharded returns true if the node is part of a cluster that's sharded by hash slot.
+func (server *SugarDB) isSharded() bool {
+	return server.isInCluster() && server.config.ShardID != ""
+}
+
+// raftApplyRequest appends the request to the raft log and returns the response once it has been applied.
+func (server *SugarDB) raftApplyRequest(request internal.ApplyRequest) ([]byte, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s request: %v", request.Type, err)
+	}
+
+	applyFuture := server.raft.Apply(b, 500*time.Millisecond)
+	if err = applyFuture.Error(); err != nil {
+		return nil, err
+	}
+
+	r, ok := applyFuture.Response().(internal.ApplyResponse)
+	if !ok {
+		return nil, fmt.Errorf("unprocessable entity %v", r)
+	}
+
+	return r.Response, r.Error
+}
+
+// raftApplySlots applies a change to the slots of the shard through the raft log.
+func (server *SugarDB) raftApplySlots(cmd []string) error {
+	_, err := server.raftApplyRequest(internal.ApplyRequest{
+		Type:         "slots",
+		ServerID:     server.config.ServerID,
+		ConnectionID: "nil",
+		CMD:          cmd,
+	})
+	return err
+}
+
+// applySlots is called by the FSM on every node of the shard to apply a change to the shard's slots.
+// The supported changes are:
+//
+//	ADDSLOTS epoch range [range ...]
+//	MIGRATING slot target-shard
+//	IMPORTING slot source-shard
+//	STABLE slot
+//	NODE slot owner-shard epoch
+func (server *SugarDB) applySlots(cmd []string) error {
+	if len(cmd) < 2 {
+		return fmt.Errorf("invalid slots change %v", cmd)
+	}
+
+	switch strings.ToUpper(cmd[0]) {
+	default:
+		return fmt.Errorf("unsupported slots change %s", cmd[0])
+
+	case "ADDSLOTS":
+		epoch, err := strconv.ParseUint(cmd[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid epoch %s", cmd[1])
+		}
+		var ranges []slots.Range
+		for _, s := range cmd[2:] {
+			r, err := slots.ParseRange(s)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, r)
+		}
+		return server.slotState.AddSlots(slots.FromRanges(ranges), epoch)
+
+	case "MIGRATING", "IMPORTING", "STABLE", "NODE":
+		slot, err := slots.ParseSlot(cmd[1])
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.EqualFold(cmd[0], "STABLE"):
+			server.slotState.SetStable(slot)
+			return nil
+		case len(cmd) < 3:
+			return fmt.Errorf("invalid slots change %v", cmd)
+		case strings.EqualFold(cmd[0], "MIGRATING"):
+			return server.slotState.SetMigrating(slot, cmd[2])
+		case strings.EqualFold(cmd[0], "IMPORTING"):
+			return server.slotState.SetImporting(slot, cmd[2])
+		case len(cmd) < 4:
+			return fmt.Errorf("invalid slots change %v", cmd)
+		}
+		epoch, err := strconv.ParseUint(cmd[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid epoch %s", cmd[3])
+		}
+		server.slotState.SetOwner(slot, cmd[2] == server.config.ShardID, epoch)
+		return nil
+	}
+}
+
+func (server *SugarDB) getSlotState() json.RawMessage {
+	b, err := json.Marshal(server.slotState)
+	if err != nil {
+		log.Printf("slot state: %v\n", err)
+		return nil
+	}
+	return b
+}
+
+func (server *SugarDB) restoreSlotState(state json.RawMessage) error {
+	return server.slotState.UnmarshalJSON(state)
+}
+
+// nextEpoch returns an epoch that's greater than any epoch known by the node.
+// The shard that takes over a slot must claim it with a greater epoch than the previous owner.
+func (server *SugarDB) nextEpoch() uint64 {
+	return max(server.slotMap.MaxEpoch(), server.slotState.Epoch()) + 1
+}
+
+// announceShard gossips the slots owned by the shard to the rest of the cluster.
+// It must only be called by the shard leader.
+func (server *SugarDB) announceShard() slots.Shard {
+	shard := slots.Shard{
+		ID:     server.config.ShardID,
+		Epoch:  server.slotState.Epoch(),
+		Term:   server.raft.Term(),
+		Leader: server.config.ServerID,
+		Ranges: server.slotState.Ranges(),
+	}
+	server.slotMap.Update(shard)
+	server.memberList.BroadcastShard(shard)
+	return shard
+}
+
+// runSlotLoop announces the shard's slots whenever the node becomes the shard leader or the slots change,
+// and drives the migration of the slots that are being moved to other shards.
+// Migrations are resumed by the new leader if the previous leader fails mid-migration.
+func (server *SugarDB) runSlotLoop() {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	var term, epoch uint64
+	for {
+		select {
+		case <-server.stopSlots:
+			return
+		case <-ticker.C:
+		}
+
+		if !server.raft.IsRaftLeader() {
+			term, epoch = 0, 0
+			continue
+		}
+
+		if server.raft.Term() != term || server.slotState.Epoch() != epoch {
+			shard := server.announceShard()
+			term, epoch = shard.Term, shard.Epoch
+		}
+
+		for slot, target := range server.slotState.MigratingSlots() {
+			server.slotMigrations.mut.Lock()
+			if !server.slotMigrations.active[slot] {
+				server.slotMigrations.active[slot] = true
+				go server.migrateSlot(slot, target)
+			}
+			server.slotMigrations.mut.Unlock()
+		}
+	}
+}
+
+// migrateSlot moves the keys of the slot to the target shard and then hands the slot over to the target shard.
+// If the migration fails, it's retried by the next iteration of the slot loop.
+func (server *SugarDB) migrateSlot(slot int, target string) {
+	defer func() {
+		server.slotMigrations.mut.Lock()
+		delete(server.slotMigrations.active, slot)
+		server.slotMigrations.mut.Unlock()
+	}()
+
+	ctx := context.WithValue(server.context, internal.ContextServerID("ServerID"), server.config.ServerID)
+
+	if _, err := server.sendSlotRequest(ctx, target, slotRequest{Op: "IMPORTING", Slot: slot}); err != nil {
+		log.Printf("migrate slot %d: %v\n", slot, err)
+		return
+	}
+
+	databases := server.getDatabases()
+
+	for _, database := range databases {
+		dbCtx := context.WithValue(ctx, "Database", database)
+		// The keys written by commands that were in progress when the slot started migrating are picked up by
+		// the next scan of the slot.
+		for {
+			keys := server.getKeysInSlot(dbCtx, slot, 0)
+			if len(keys) == 0 {
+				break
+			}
+			for batch := range slices.Chunk(keys, slotMigrationBatchSize) {
+				if err := server.migrateKeys(dbCtx, slot, target, batch); err != nil {
+					log.Printf("migrate slot %d: %v\n", slot, err)
+					return
+				}
+			}
+		}
+	}
+
+	// The target shard claims the slot first, the keys that are not found locally until the slot
+	// is removed from this shard are redirected to the target shard with ASK.
+	res, err := server.sendSlotRequest(ctx, target, slotRequest{Op: "NODE", Slot: slot})
+	if err != nil {
+		log.Printf("migrate slot %d: %v\n", slot, err)
+		return
+	}
+	var shard slots.Shard
+	if err = json.Unmarshal(res, &shard); err != nil {
+		log.Printf("migrate slot %d: %v\n", slot, err)
+		return
+	}
+	server.slotMap.Update(shard)
+
+	if err = server.raftApplySlots([]string{
+		"NODE", strconv.Itoa(slot), target, strconv.FormatUint(shard.Epoch, 10),
+	}); err != nil {
+		log.Printf("migrate slot %d: %v\n", slot, err)
+		return
+	}
+	server.announceShard()
+}
+
+// migrateKeys copies the keys to the target shard and deletes them from this shard.
+// Commands for the keys are rejected with TRYAGAIN while they're being moved.
+func (server *SugarDB) migrateKeys(ctx context.Context, slot int, target string, keys []string) error {
+	database := ctx.Value("Database").(int)
+
+	server.slotMigrations.mut.Lock()
+	for _, key := range keys {
+		server.slotMigrations.moving[movingKey{database: database, key: key}] = true
+	}
+	// Wait for the writes that were allowed before the keys were marked as moving,
+	// so that they are not lost between the copy and the deletion of the keys.
+	for _, key := range keys {
+		for server.slotMigrations.writing[movingKey{database: database, key: key}] > 0 {
+			server.slotMigrations.released.Wait()
+		}
+	}
+	server.slotMigrations.mut.Unlock()
+	defer func() {
+		server.slotMigrations.mut.Lock()
+		for _, key := range keys {
+			delete(server.slotMigrations.moving, movingKey{database: database, key: key})
+		}
+		server.slotMigrations.mut.Unlock()
+	}()
+
+	data, err := server.encodeMigratedKeys(database, keys)
+	if err != nil {
+		return err
+	}
+
+	if _, err = server.sendSlotRequest(ctx, target, slotRequest{
+		Op:       "IMPORT",
+		Slot:     slot,
+		Database: database,
+		Data:     data,
+	}); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err = server.raftApplyDeleteKey(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMigratedKeys encodes the keys that have not expired along with their values and expiry.
+// The values are encoded as records, as JSON would not preserve their types.
+func (server *SugarDB) encodeMigratedKeys(database int, keys []string) ([]byte, error) {
+	migrated := make([]migratedKey, 0, len(keys))
+	if db := server.getDatabase(database); db != nil {
+		now := server.clock.Now()
+		unlock := db.rLock(keys...)
+		for _, key := range keys {
+			entry, ok := db.get(key)
+			if !ok || entry.ExpireAt != (time.Time{}) && entry.ExpireAt.Before(now) {
+				continue
+			}
+			record, err := newValueRecord(entry.Value)
+			if err != nil {
+				unlock()
+				return nil, fmt.Errorf("key %s: %v", key, err)
+			}
+			migrated = append(migrated, migratedKey{Key: key, Record: record, ExpireAt: entry.ExpireAt})
+		}
+		unlock()
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(migrated); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// importKeys is called by the FSM on every node of the shard to set the keys migrated from another shard.
+func (server *SugarDB) importKeys(ctx context.Context, data []byte) error {
+	var migrated []migratedKey
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&migrated); err != nil {
+		return err
+	}
+	for _, key := range migrated {
+		value, err := key.Record.value()
+		if err != nil {
+			return fmt.Errorf("key %s: %v", key.Key, err)
+		}
+		if err = server.setValues(ctx, map[string]interface{}{key.Key: value}); err != nil {
+			return err
+		}
+		server.setExpiry(ctx, key.Key, key.ExpireAt, false)
+	}
+	return nil
+}
+
+// sendSlotRequest sends the slot migration request to the leader of the target shard.
+func (server *SugarDB) sendSlotRequest(ctx context.Context, target string, request slotRequest) ([]byte, error) {
+	shard, ok := server.slotMap.Shard(target)
+	if !ok {
+		return nil, fmt.Errorf("unknown shard %s", target)
+	}
+
+	request.Shard = server.config.ShardID
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, server.config.ForwardTimeout)
+	defer cancel()
+
+	res := server.memberList.SlotRequest(ctx, raft.ServerID(shard.Leader), b)
+	return res.Response, res.Err
+}
+
+// applySlotRequest handles a slot migration request on the leader of the target shard.
+func (server *SugarDB) applySlotRequest(content []byte) ([]byte, error) {
+	if !server.isSharded() {
+		return nil, slots.ErrShardingDisabled
+	}
+
+	var request slotRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return nil, err
+	}
+
+	switch request.Op {
+	default:
+		return nil, fmt.Errorf("unsupported slot request %s", request.Op)
+
+	case "IMPORTING":
+		// The request is retried when a migration is resumed, so it must be idempotent.
+		if server.slotState.Owns(request.Slot) {
+			return []byte("OK"), nil
+		}
+		if source, ok := server.slotState.Importing(request.Slot); ok && source == request.Shard {
+			return []byte("OK"), nil
+		}
+		return []byte("OK"), server.raftApplySlots([]string{"IMPORTING", strconv.Itoa(request.Slot), request.Shard})
+
+	case "IMPORT":
+		return server.raftApplyRequest(internal.ApplyRequest{
+			Type:         "import-keys",
+			ServerID:     server.config.ServerID,
+			ConnectionID: "nil",
+			Database:     request.Database,
+			Data:         request.Data,
+		})
+
+	case "NODE":
+		if err := server.raftApplySlots([]string{
+			"NODE", strconv.Itoa(request.Slot), server.config.ShardID, strconv.FormatUint(server.nextEpoch(), 10),
+		}); err != nil {
+			return nil, err
+		}
+		return json.Marshal(server.announceShard())
+	}
+}
+
+// checkSlot returns a redirection error if the keys accessed by the command are not served by this shard.
+// Writes to the keys of a slot being migrated are tracked until the returned release function is called,
+// so that the keys are not moved while they're being written.
+func (server *SugarDB) checkSlot(
+	ctx context.Context,
+	conn *net.Conn,
+	embedded bool,
+	command internal.Command,
+	subCommand internal.SubCommand,
+	cmd []string,
+) (func(), error) {
+	release := func() {}
+
+	// The ASKING flag only applies to the command that follows it.
+	asking := false
+	if !strings.EqualFold(command.Command, "asking") {
+		asking = server.takeAsking(conn, embedded)
+	}
+
+	keyExtractionFunc := command.KeyExtractionFunc
+	if subCommand.KeyExtractionFunc != nil {
+		keyExtractionFunc = subCommand.KeyExtractionFunc
+	}
+	if keyExtractionFunc == nil {
+		return release, nil
+	}
+	// Malformed commands are left to the handler to report.
+	result, err := keyExtractionFunc(cmd)
+	if err != nil {
+		return release, nil
+	}
+	keys := append(result.ReadKeys, result.WriteKeys...)
+	if len(keys) == 0 {
+		return release, nil
+	}
+
+	slot := slots.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if slots.KeySlot(key) != slot {
+			return release, slots.ErrCrossSlot
+		}
+	}
+
+	if server.slotState.Owns(slot) {
+		database, _ := ctx.Value("Database").(int)
+		server.slotMigrations.mut.Lock()
+		for _, key := range keys {
+			if server.slotMigrations.moving[movingKey{database: database, key: key}] {
+				server.slotMigrations.mut.Unlock()
+				return release, slots.ErrTryAgain
+			}
+		}
+		if internal.IsWriteCommand(command, subCommand) {
+			// The slot may start migrating before the write is done.
+			for _, key := range keys {
+				server.slotMigrations.writing[movingKey{database: database, key: key}] += 1
+			}
+			release = func() {
+				server.slotMigrations.mut.Lock()
+				defer server.slotMigrations.mut.Unlock()
+				for _, key := range keys {
+					k := movingKey{database: database, key: key}
+					if server.slotMigrations.writing[k] -= 1; server.slotMigrations.writing[k] == 0 {
+						delete(server.slotMigrations.writing, k)
+					}
+				}
+				server.slotMigrations.released.Broadcast()
+			}
+		}
+		server.slotMigrations.mut.Unlock()
+
+		target, migrating := server.slotState.Migrating(slot)
+		if !migrating {
+			return release, nil
+		}
+
+		// Keys that are no longer in this shard have already been moved to the target shard.
+		missing := 0
+		for _, exists := range server.keysExist(ctx, keys) {
+			if !exists {
+				missing += 1
+			}
+		}
+		switch missing {
+		case 0:
+			return release, nil
+		case len(keys):
+			release()
+			return func() {}, server.redirect(slots.Ask, slot, target)
+		default:
+			release()
+			return func() {}, slots.ErrTryAgain
+		}
+	}
+
+	if _, importing := server.slotState.Importing(slot); importing && asking {
+		return release, nil
+	}
+
+	owner, ok := server.slotMap.Owner(slot)
+	if !ok || owner.ID == server.config.ShardID {
+		return release, slots.ErrClusterDown
+	}
+	return release, server.redirect(slots.Moved, slot, owner.ID)
+}
+
+// redirect returns an error that redirects the client to the leader of the shard.
+func (server *SugarDB) redirect(redirectType string, slot int, shardID string) error {
+	shard, ok := server.slotMap.Shard(shardID)
+	if !ok {
+		return slots.ErrClusterDown
+	}
+	addr, ok := server.memberList.ClientAddr(shard.Leader)
+	if !ok {
+		return slots.ErrClusterDown
+	}
+	return &slots.RedirectError{Type: redirectType, Slot: slot, Addr: addr}
+}
+
+// takeAsking returns the ASKING flag of the connection and clears it.
+func (server *SugarDB) takeAsking(conn *net.Conn, embedded bool) bool {
+	server.connInfo.mut.Lock()
+	defer server.connInfo.mut.Unlock()
+	if embedded {
+		asking := server.connInfo.embedded.Asking
+		server.connInfo.embedded.Asking = false
+		return asking
+	}
+	info, ok := server.connInfo.tcpClients[conn]
+	if !ok || !info.Asking {
+		return false
+	}
+	info.Asking = false
+	server.connInfo.tcpClients[conn] = info
+	return true
+}
+
+func (server *SugarDB) setAsking(conn *net.Conn) {
+	server.connInfo.mut.Lock()
+	defer server.connInfo.mut.Unlock()
+	if conn == nil {
+		server.connInfo.embedded.Asking = true
+		return
+	}
+	info := server.connInfo.tcpClients[conn]
+	info.Asking = true
+	server.connInfo.tcpClients[conn] = info
+}
+
+// getShards returns the shards known by the node along with their slots and nodes.
+// The leader of each shard is listed first.
+func (server *SugarDB) getShards() ([]slots.ShardInfo, error) {
+	if !server.isSharded() {
+		return nil, slots.ErrShardingDisabled
+	}
+
+	members := server.memberList.Members()
+
+	var shards []slots.ShardInfo
+	for _, shard := range server.slotMap.Shards() {
+		info := slots.ShardInfo{ID: shard.ID, Ranges: shard.Ranges}
+		for _, meta := range members {
+			if meta.ShardID != shard.ID {
+				continue
+			}
+			host, p, err := net.SplitHostPort(meta.ClientAddr)
+			if err != nil {
+				continue
+			}
+			port, _ := strconv.Atoi(p)
+			info.Nodes = append(info.Nodes, slots.Node{
+				ID:     string(meta.ServerID),
+				Host:   host,
+				Port:   port,
+				Leader: string(meta.ServerID) == shard.Leader,
+			})
+		}
+		slices.SortFunc(info.Nodes, func(a, b slots.Node) int {
+			if a.Leader != b.Leader {
+				if a.Leader {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(a.ID, b.ID)
+		})
+		shards = append(shards, info)
+	}
+	return shards, nil
+}
+
+func (server *SugarDB) getShardID() (string, error) {
+	if !server.isSharded() {
+		return "", slots.ErrShardingDisabled
+	}
+	return server.config.ShardID, nil
+}
+
+// addSlots assigns the slots to the node's shard.
+func (server *SugarDB) addSlots(slotList []int) error {
+	if !server.isSharded() {
+		return slots.ErrShardingDisabled
+	}
+	if !server.raft.IsRaftLeader() {
+		return errors.New("not shard leader, cannot carry out command")
+	}
+	for _, slot := range slotList {
+		if owner, ok := server.slotMap.Owner(slot); ok && owner.ID != server.config.ShardID {
+			return fmt.Errorf("slot %d is already owned by shard %s", slot, owner.ID)
+		}
+	}
+
+	cmd := []string{"ADDSLOTS", strconv.FormatUint(server.nextEpoch(), 10)}
+	for _, r := range slots.ToRanges(slotList) {
+		cmd = append(cmd, r.String())
+	}
+	if err := server.raftApplySlots(cmd); err != nil {
+		return err
+	}
+	server.announceShard()
+	return nil
+}
+
+// setSlot changes the migration state of the slot. Once a slot is set to MIGRATING, the shard leader moves the
+// keys of the slot to the target shard and hands over the slot to the target shard.
+func (server *SugarDB) setSlot(slot int, state string, shardID string) error {
+	if !server.isSharded() {
+		return slots.ErrShardingDisabled
+	}
+	if !server.raft.IsRaftLeader() {
+		return errors.New("not shard leader, cannot carry out command")
+	}
+
+	switch strings.ToUpper(state) {
+	default:
+		return fmt.Errorf("invalid slot state %s", state)
+	case "MIGRATING":
+		if shardID == server.config.ShardID {
+			return errors.New("cannot migrate a slot to its own shard")
+		}
+		if _, ok := server.slotMap.Shard(shardID); !ok {
+			return fmt.Errorf("unknown shard %s", shardID)
+		}
+		return server.raftApplySlots([]string{"MIGRATING", strconv.Itoa(slot), shardID})
+	case "IMPORTING":
+		return server.raftApplySlots([]string{"IMPORTING", strconv.Itoa(slot), shardID})
+	case "STABLE":
+		return server.raftApplySlots([]string{"STABLE", strconv.Itoa(slot)})
+	case "NODE":
+		if err := server.raftApplySlots([]string{
+			"NODE", strconv.Itoa(slot), shardID, strconv.FormatUint(server.nextEpoch(), 10),
+		}); err != nil {
+			return err
+		}
+		server.announceShard()
+		return nil
+	}
+}
+
+// getKeysInSlot returns up to count keys of the current database that hash to the slot.
+// If count is less than 1, all the keys in the slot are returned.
+func (server *SugarDB) getKeysInSlot(ctx context.Context, slot int, count int) []string {
+	keys := make([]string, 0)
+
+	db := server.getDatabase(ctx.Value("Database").(int))
+	if db == nil {
+		return keys
+	}
+
+	for i := range db.shards {
+		s := &db.shards[i]
+		s.mut.RLock()
+		for key := range s.keys {
+			if slots.KeySlot(key) != slot {
+				continue
+			}
+			keys = append(keys, key)
+			if count > 0 && len(keys) == count {
+				break
+			}
+		}
+		s.mut.RUnlock()
+		if count > 0 && len(keys) == count {
+			break
+		}
+	}
+	return keys
+}
@@ -0,0 +1,311 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/eviction"
+)
+
+// shardCount is the number of shards of the keyspace of a database. It must be a power of two.
+const shardCount = 64
+
+// shard holds the keys of a database whose hash maps to the shard. Each shard has its own lock, so that
+// commands on keys of different shards don't contend with each other.
+type shard struct {
+	mut  sync.RWMutex
+	keys map[string]internal.KeyData
+	// Maps never shrink, so the buckets of the map are sized for the peak number of keys of the shard.
+	peakKeys int
+	// The memory used by the empty slots and the buckets of the map, as it was last accounted for.
+	overhead int64
+	// The number of snapshots that share the map. The map is copied before it's written to while it's shared.
+	shared int
+	// The generation of the map, which is incremented whenever the map is replaced.
+	generation uint64
+}
+
+// own makes sure the map of the shard is not shared with a snapshot before it's written to. A shared map is
+// frozen, so it's copied and the copy replaces it. The shard must be locked for writing by the caller.
+func (s *shard) own() {
+	if s.shared == 0 {
+		return
+	}
+	s.keys = maps.Clone(s.keys)
+	s.peakKeys = len(s.keys)
+	s.shared = 0
+	s.generation++
+}
+
+// share freezes the map of the shard for a snapshot, and returns the map along with the function that releases it.
+// The shard must be locked for writing by the caller.
+func (s *shard) share() (map[string]internal.KeyData, func()) {
+	s.shared++
+	generation := s.generation
+	return s.keys, func() {
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		// When the map was replaced, the snapshot is the last one to hold the frozen map.
+		if s.generation == generation {
+			s.shared--
+		}
+	}
+}
+
+// detach replaces the map of the shard with an empty one and returns the map that was replaced, or nil when it's
+// shared with a snapshot. The shard must be locked for writing by the caller.
+func (s *shard) detach() map[string]internal.KeyData {
+	keys := s.keys
+	if s.shared > 0 {
+		keys = nil
+	}
+	s.keys = make(map[string]internal.KeyData)
+	s.peakKeys = 0
+	s.shared = 0
+	s.generation++
+	return keys
+}
+
+// reset empties the shard. A shared map is replaced with a new one rather than cleared.
+// The shard must be locked for writing by the caller.
+func (s *shard) reset() {
+	if s.shared == 0 {
+		clear(s.keys)
+		return
+	}
+	s.keys = make(map[string]internal.KeyData)
+	s.peakKeys = 0
+	s.shared = 0
+	s.generation++
+}
+
+// database is a logical database. The keyspace is split into shards, and the keys with an expiry are indexed by
+// their next expiry.
+type database struct {
+	index  int
+	shards [shardCount]shard
+	// The number of keys in the database.
+	size atomic.Int64
+
+	expiry struct {
+		// The index has its own lock, which is always acquired after the locks of the shards.
+		mut   sync.Mutex
+		index *eviction.ExpiryIndex
+		// Maps never shrink, so the map of the index is sized for the peak number of keys in the index.
+		peakKeys int
+		// The memory used by the index, as it was last accounted for.
+		overhead int64
+	}
+
+	// The memory used by the keys and their values.
+	dataset atomic.Int64
+	// The memory used by the empty slots and the buckets of the maps of the shards.
+	overheadMain atomic.Int64
+
+	// The memory quotas on the keys of the database.
+	quotas []*memoryQuota
+}
+
+func newDatabase(index int, quotas []*memoryQuota) *database {
+	db := &database{index: index, quotas: quotas}
+	for i := range db.shards {
+		db.shards[i].keys = make(map[string]internal.KeyData)
+	}
+	db.expiry.index = eviction.NewExpiryIndex()
+	return db
+}
+
+// shardIndex returns the index of the shard of the key, using the 32-bit FNV-1a hash of the key.
+func shardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h & (shardCount - 1))
+}
+
+// shard returns the shard of the key.
+func (db *database) shard(key string) *shard {
+	return &db.shards[shardIndex(key)]
+}
+
+// shardIndexes returns the indexes of the shards of the keys in ascending order, without duplicates.
+func shardIndexes(keys []string) []int {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, shardIndex(key))
+	}
+	slices.Sort(indexes)
+	return slices.Compact(indexes)
+}
+
+// lock locks the shards of the keys for writing and returns the function that unlocks them.
+// The shards are always locked in ascending order so that operations on multiple keys don't deadlock.
+func (db *database) lock(keys ...string) func() {
+	indexes := shardIndexes(keys)
+	for _, i := range indexes {
+		db.shards[i].mut.Lock()
+	}
+	return func() {
+		for _, i := range indexes {
+			db.shards[i].mut.Unlock()
+		}
+	}
+}
+
+// rLock locks the shards of the keys for reading and returns the function that unlocks them.
+// The shards are always locked in ascending order so that operations on multiple keys don't deadlock.
+func (db *database) rLock(keys ...string) func() {
+	indexes := shardIndexes(keys)
+	for _, i := range indexes {
+		db.shards[i].mut.RLock()
+	}
+	return func() {
+		for _, i := range indexes {
+			db.shards[i].mut.RUnlock()
+		}
+	}
+}
+
+// get returns the data of the key. The shard of the key must be locked by the caller.
+func (db *database) get(key string) (internal.KeyData, bool) {
+	data, ok := db.shard(key).keys[key]
+	return data, ok
+}
+
+// put stores the data of the key. The shard of the key must be locked for writing by the caller.
+func (db *database) put(key string, data internal.KeyData) {
+	s := db.shard(key)
+	s.own()
+	if _, ok := s.keys[key]; !ok {
+		db.size.Add(1)
+	}
+	s.keys[key] = data
+}
+
+// remove deletes the key. The shard of the key must be locked for writing by the caller.
+func (db *database) remove(key string) {
+	s := db.shard(key)
+	if _, ok := s.keys[key]; ok {
+		s.own()
+		db.size.Add(-1)
+		delete(s.keys, key)
+	}
+}
+
+// len returns the number of keys in the database.
+func (db *database) len() int {
+	return int(db.size.Load())
+}
+
+// getDatabase returns the database, or nil when it doesn't exist.
+func (server *SugarDB) getDatabase(index int) *database {
+	return (*server.store.Load())[index]
+}
+
+// getOrCreateDatabase returns the database, and creates it when it doesn't exist.
+func (server *SugarDB) getOrCreateDatabase(index int) *database {
+	if db := server.getDatabase(index); db != nil {
+		return db
+	}
+	server.storeLock.Lock()
+	defer server.storeLock.Unlock()
+	if db := server.getDatabase(index); db != nil {
+		return db
+	}
+	// The map of databases is copied on write, so that it's read without locking.
+	databases := make(map[int]*database, len(*server.store.Load())+1)
+	for i, db := range *server.store.Load() {
+		databases[i] = db
+	}
+	db := newDatabase(index, server.quotas[index])
+	databases[index] = db
+	server.store.Store(&databases)
+	server.accountOverhead(db)
+	return db
+}
+
+// getDatabases returns the indexes of the databases in ascending order.
+func (server *SugarDB) getDatabases() []int {
+	databases := *server.store.Load()
+	indexes := make([]int, 0, len(databases))
+	for i := range databases {
+		indexes = append(indexes, i)
+	}
+	slices.Sort(indexes)
+	return indexes
+}
+
+// writeGate lets write commands run concurrently with each other, and lets a state copy wait for the writes in
+// progress to complete and hold back new writes while it takes its cut of the state.
+type writeGate struct {
+	mut    sync.Mutex
+	cond   *sync.Cond
+	active int
+	closed bool
+}
+
+func newWriteGate() *writeGate {
+	gate := &writeGate{}
+	gate.cond = sync.NewCond(&gate.mut)
+	return gate
+}
+
+// enter waits until the gate is open and registers a write in progress.
+func (gate *writeGate) enter() {
+	gate.mut.Lock()
+	defer gate.mut.Unlock()
+	for gate.closed {
+		gate.cond.Wait()
+	}
+	gate.active++
+}
+
+// exit registers the end of a write.
+func (gate *writeGate) exit() {
+	gate.mut.Lock()
+	defer gate.mut.Unlock()
+	gate.active--
+	if gate.active == 0 {
+		gate.cond.Broadcast()
+	}
+}
+
+// close waits until the gate is open, closes it, and waits for the writes in progress to complete.
+func (gate *writeGate) close() {
+	gate.mut.Lock()
+	defer gate.mut.Unlock()
+	for gate.closed {
+		gate.cond.Wait()
+	}
+	gate.closed = true
+	for gate.active > 0 {
+		gate.cond.Wait()
+	}
+}
+
+// open reopens the gate and wakes up the writes waiting for it.
+func (gate *writeGate) open() {
+	gate.mut.Lock()
+	defer gate.mut.Unlock()
+	gate.closed = false
+	gate.cond.Broadcast()
+}
@@ -21,14 +21,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/activeactive"
 	"github.com/echovault/sugardb/internal/aof"
 	"github.com/echovault/sugardb/internal/clock"
 	"github.com/echovault/sugardb/internal/config"
 	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/encryption"
 	"github.com/echovault/sugardb/internal/eviction"
 	"github.com/echovault/sugardb/internal/memberlist"
 	"github.com/echovault/sugardb/internal/modules/acl"
 	"github.com/echovault/sugardb/internal/modules/admin"
+	"github.com/echovault/sugardb/internal/modules/cluster"
 	"github.com/echovault/sugardb/internal/modules/connection"
 	"github.com/echovault/sugardb/internal/modules/generic"
 	"github.com/echovault/sugardb/internal/modules/hash"
@@ -38,13 +41,17 @@ import (
 	"github.com/echovault/sugardb/internal/modules/sorted_set"
 	str "github.com/echovault/sugardb/internal/modules/string"
 	"github.com/echovault/sugardb/internal/raft"
+	"github.com/echovault/sugardb/internal/replication"
+	"github.com/echovault/sugardb/internal/slots"
 	"github.com/echovault/sugardb/internal/snapshot"
+	"github.com/echovault/sugardb/internal/tiered"
 	lua "github.com/yuin/gopher-lua"
 	"io"
 	"log"
 	"net"
 	"os"
 	"slices"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -70,37 +77,75 @@ type SugarDB struct {
 		embedded   internal.ConnectionInfo               // Information for the embedded connection.
 	}
 
-	// Global read-write mutex for entire store.
-	storeLock *sync.RWMutex
+	// lastWrite holds the position of the latest write made by each client. The embedded client's key is nil.
+	// In standalone mode, this is the AOF log offset. In cluster mode, this is the raft log index.
+	// It is used by WAIT and WAITAOF to determine which writes must be acknowledged.
+	// In standalone mode, replication holds the offset of the replication stream after the latest write.
+	lastWrite struct {
+		mut         sync.RWMutex
+		clients     map[*net.Conn]uint64
+		replication map[*net.Conn]uint64
+	}
+
+	// Serialises the creation of databases. The keys of each database are locked by their shard.
+	storeLock sync.Mutex
 
 	// Data store to hold the keys and their associated data, expiry time, etc.
-	// The int key on the outer map represents the database index.
-	// Each database has a map that has a string key and the key data (value and expiry time).
-	store map[int]map[string]internal.KeyData
+	// The int key of the map represents the database index. The map is copied on write,
+	// as databases are rarely created, so that it's read without locking.
+	store atomic.Pointer[map[int]*database]
 
 	// memUsed tracks the memory usage of the data in the store.
-	memUsed int64
-
-	// Holds all the keys that are currently associated with an expiry.
-	keysWithExpiry struct {
-		// Mutex as only one process should be able to update this list at a time.
-		rwMutex sync.RWMutex
-		// A map holding a string slice of the volatile keys for each database.
-		keys map[int][]string
-	}
-	// LFU cache used when eviction policy is allkeys-lfu or volatile-lfu.
-	lfuCache struct {
-		// Mutex as only one goroutine can edit the LFU cache at a time.
-		mutex *sync.Mutex
-		// LFU cache for each database represented by a min heap.
-		cache map[int]*eviction.CacheLFU
-	}
-	// LRU cache used when eviction policy is allkeys-lru or volatile-lru.
-	lruCache struct {
-		// Mutex as only one goroutine can edit the LRU at a time.
-		mutex *sync.Mutex
-		// LRU cache represented by a max heap.
-		cache map[int]*eviction.CacheLRU
+	memUsed atomic.Int64
+	// memPeak is the highest memory usage of the store since startup.
+	memPeak atomic.Int64
+	// evictionMut serialises the evictions, so that concurrent writes don't evict more keys than needed.
+	evictionMut sync.Mutex
+
+	// Eviction pools used when the eviction policy is a type of LRU or LFU.
+	evictionPools struct {
+		// Mutex as only one goroutine can pick the keys to evict at a time.
+		mut sync.Mutex
+		// The best eviction candidates found in the samples of each database.
+		pools map[int]*eviction.Pool
+	}
+
+	// accessReport holds the number of reads of each key served by this node since its latest report to the
+	// leader. Only used by the followers of a raft cluster, as the leader makes the eviction decisions.
+	accessReport struct {
+		mut  sync.Mutex
+		keys map[int]map[string]int
+	}
+
+	// lazyFree holds the values detached from the keyspace that are released on a background goroutine.
+	lazyFree struct {
+		mut     sync.Mutex
+		queue   []interface{}
+		wake    chan struct{} // Signals the goroutine that values were added to the queue.
+		stop    chan struct{} // Signals the goroutine to stop execution.
+		pending atomic.Int64  // The number of values waiting to be released.
+		freed   atomic.Int64  // The number of values released since startup.
+	}
+
+	// quotas holds the memory quotas of each database. They're attached to the databases when they're created.
+	quotas map[int][]*memoryQuota
+
+	// tiered holds the state of the tiered mode, which spills the values of cold keys to a store on disk.
+	tiered struct {
+		store  *tiered.Store // Nil when the tiered mode is disabled.
+		nextID atomic.Uint64 // The id of the latest spilled value.
+		wake   chan struct{} // Signals the goroutine to spill values or delete the values that are released.
+		stop   chan struct{} // Signals the goroutine to stop execution.
+		mut    sync.Mutex
+		// The number of state copies in progress. The values on disk may be read by a copy, so the values that are
+		// released in the meantime are only deleted once the copies are complete.
+		copies  int
+		garbage []uint64     // The ids of the released values that are not deleted yet.
+		keys    atomic.Int64 // The number of keys whose value is spilled.
+		hits    atomic.Int64 // The number of reads of a value in memory since startup.
+		misses  atomic.Int64 // The number of reads of a spilled value since startup.
+		faults  atomic.Int64 // The number of spilled values loaded back into memory since startup.
+		spills  atomic.Int64 // The number of values spilled since startup.
 	}
 
 	commandsRWMut sync.RWMutex       // Mutex used for modifying/reading the list of commands in the instance.
@@ -114,6 +159,18 @@ type SugarDB struct {
 	raft       *raft.Raft             // The raft replication layer for SugarDB.
 	memberList *memberlist.MemberList // The memberlist layer for SugarDB.
 
+	slotState *slots.State // The hash slots owned by this node's shard. Replicated through the shard's raft log.
+	slotMap   *slots.Map   // The cluster-wide view of the shards and their slots. Propagated by gossip.
+	// slotMigrations tracks the slots being migrated by this node when it's the shard leader.
+	slotMigrations struct {
+		mut      sync.Mutex
+		active   map[int]bool       // The slots with a migration in progress.
+		moving   map[movingKey]bool // The keys currently being copied to the target shard.
+		writing  map[movingKey]int  // The number of writes in progress for each key of the slots being migrated.
+		released *sync.Cond         // Signalled when the writes of a key are done.
+	}
+	stopSlots chan struct{} // Channel that signals the slot loop to stop execution.
+
 	context context.Context
 
 	acl    *acl.ACL
@@ -121,12 +178,38 @@ type SugarDB struct {
 
 	snapshotInProgress         atomic.Bool      // Atomic boolean that's true when actively taking a snapshot.
 	rewriteAOFInProgress       atomic.Bool      // Atomic boolean that's true when actively rewriting AOF file is in progress.
-	stateCopyInProgress        atomic.Bool      // Atomic boolean that's true when actively copying state for snapshotting or preamble generation.
-	stateMutationInProgress    atomic.Bool      // Atomic boolean that is set to true when state mutation is in progress.
+	writeGate                  *writeGate       // Holds back write commands while a state copy takes its cut of the state.
 	latestSnapshotMilliseconds atomic.Int64     // Unix epoch in milliseconds.
 	snapshotEngine             *snapshot.Engine // Snapshot engine for standalone mode.
 	aofEngine                  *aof.Engine      // AOF engine for standalone mode.
 
+	keyring *encryption.Keyring // The keys used for encryption at rest. Nil when encryption at rest is disabled.
+
+	// replication holds the state of the primary/replica replication in standalone mode.
+	replication struct {
+		backlog *replication.Backlog // The latest write commands streamed to replicas.
+		// applyMut is held while a replica applies the stream of its primary, and while a snapshot is
+		// taken for a full resynchronisation, so that the snapshot matches the backlog position.
+		applyMut   sync.Mutex
+		replicaMut sync.RWMutex
+		replica    *replication.Replica // The link to the primary. Nil when the node is a primary.
+		// The replicas streaming from this node along with the offset of the stream sent to each of them.
+		replicasMut sync.Mutex
+		replicas    map[*net.Conn]internal.ReplicaInfo
+		acked       chan struct{}   // Closed and replaced whenever a replica acknowledges an offset.
+		ctx         context.Context // Cancelled on shutdown to end the streams to replicas.
+		cancel      context.CancelFunc
+	}
+
+	// activeActive holds the state of the active-active replication with the peer sites in standalone mode.
+	activeActive struct {
+		state   *activeactive.State  // The conflict resolution metadata. Nil when there are no peers.
+		backlog *replication.Backlog // The latest effects of the local writes streamed to the peers.
+		peers   []*replication.Replica
+		// The memory of the metadata added to the memory usage the last time it was pruned.
+		accounted int64
+	}
+
 	listener atomic.Value  // Holds the TCP listener.
 	quit     chan struct{} // Channel that signals the closing of all client connections.
 	stopTTL  chan struct{} // Channel that signals the TTL sampling goroutine to stop execution.
@@ -153,21 +236,21 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 				Database: 0,
 			},
 		},
-		storeLock: &sync.RWMutex{},
-		store:     make(map[int]map[string]internal.KeyData),
-		memUsed:   0,
-		keysWithExpiry: struct {
-			rwMutex sync.RWMutex
-			keys    map[int][]string
+		lastWrite: struct {
+			mut         sync.RWMutex
+			clients     map[*net.Conn]uint64
+			replication map[*net.Conn]uint64
 		}{
-			rwMutex: sync.RWMutex{},
-			keys:    make(map[int][]string),
+			mut:         sync.RWMutex{},
+			clients:     make(map[*net.Conn]uint64),
+			replication: make(map[*net.Conn]uint64),
 		},
 		commandsRWMut: sync.RWMutex{},
 		commands: func() []internal.Command {
 			var commands []internal.Command
 			commands = append(commands, acl.Commands()...)
 			commands = append(commands, admin.Commands()...)
+			commands = append(commands, cluster.Commands()...)
 			commands = append(commands, connection.Commands()...)
 			commands = append(commands, generic.Commands()...)
 			commands = append(commands, hash.Commands()...)
@@ -178,14 +261,44 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 			commands = append(commands, str.Commands()...)
 			return commands
 		}(),
-		quit:    make(chan struct{}),
-		stopTTL: make(chan struct{}),
+		slotState: slots.NewState(),
+		slotMap:   slots.NewMap(),
+		slotMigrations: struct {
+			mut      sync.Mutex
+			active   map[int]bool
+			moving   map[movingKey]bool
+			writing  map[movingKey]int
+			released *sync.Cond
+		}{
+			mut:     sync.Mutex{},
+			active:  make(map[int]bool),
+			moving:  make(map[movingKey]bool),
+			writing: make(map[movingKey]int),
+		},
+		stopSlots: make(chan struct{}),
+		quit:      make(chan struct{}),
+		stopTTL:   make(chan struct{}),
+		writeGate: newWriteGate(),
 	}
+	sugarDB.accessReport.keys = make(map[int]map[string]int)
+	sugarDB.lazyFree.wake = make(chan struct{}, 1)
+	sugarDB.lazyFree.stop = make(chan struct{})
+	sugarDB.tiered.wake = make(chan struct{}, 1)
+	sugarDB.tiered.stop = make(chan struct{})
+	sugarDB.evictionPools.pools = make(map[int]*eviction.Pool)
+	sugarDB.slotMigrations.released = sync.NewCond(&sugarDB.slotMigrations.mut)
+	sugarDB.store.Store(&map[int]*database{})
 
 	for _, option := range options {
 		option(sugarDB)
 	}
 
+	sugarDB.connInfo.embedded.ReadConsistency = sugarDB.config.ReadConsistency
+	if sugarDB.config.ForwardTimeout <= 0 {
+		// Forwarded commands and reads would time out immediately.
+		sugarDB.config.ForwardTimeout = 5 * time.Second
+	}
+
 	sugarDB.context = context.WithValue(
 		sugarDB.context, "ServerID",
 		internal.ContextServerID(sugarDB.config.ServerID),
@@ -200,6 +313,13 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 		log.Printf("loaded plugin %s\n", path)
 	}
 
+	// Load the keys used for encryption at rest.
+	keyring, err := encryption.LoadKeyring(sugarDB.config.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	sugarDB.keyring = keyring
+
 	// Set up ACL module
 	sugarDB.acl = acl.NewACL(sugarDB.config)
 
@@ -216,46 +336,77 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 			FinishSnapshot:        sugarDB.finishSnapshot,
 			SetLatestSnapshotTime: sugarDB.setLatestSnapshot,
 			GetHandlerFuncParams:  sugarDB.getHandlerFuncParams,
+			Keyring:               keyring,
 			DeleteKey: func(ctx context.Context, key string) error {
-				sugarDB.storeLock.Lock()
-				defer sugarDB.storeLock.Unlock()
 				return sugarDB.deleteKey(ctx, key)
 			},
-			GetState: func() map[int]map[string]internal.KeyData {
+			GetState: func() (map[int]map[string]internal.KeyData, error) {
+				data, err := sugarDB.getState()
+				if err != nil {
+					return nil, err
+				}
 				state := make(map[int]map[string]internal.KeyData)
-				for database, store := range sugarDB.getState() {
+				for database, store := range data {
+					state[database] = make(map[string]internal.KeyData)
 					for k, v := range store {
 						if data, ok := v.(internal.KeyData); ok {
 							state[database][k] = data
 						}
 					}
 				}
-				return state
+				return state, nil
+			},
+			ApplySlots:       sugarDB.applySlots,
+			GetSlotState:     sugarDB.getSlotState,
+			RestoreSlotState: sugarDB.restoreSlotState,
+			ImportKeys:       sugarDB.importKeys,
+			AccountWrite:     sugarDB.accountWrite,
+			EnterWrite: func() func() {
+				sugarDB.writeGate.enter()
+				return sugarDB.writeGate.exit
 			},
 		})
 		sugarDB.memberList = memberlist.NewMemberList(memberlist.Opts{
 			Config:           sugarDB.config,
 			HasJoinedCluster: sugarDB.raft.HasJoinedCluster,
 			AddVoter:         sugarDB.raft.AddVoter,
+			AddNonvoter:      sugarDB.raft.AddNonvoter,
 			RemoveRaftServer: sugarDB.raft.RemoveServer,
 			IsRaftLeader:     sugarDB.raft.IsRaftLeader,
 			ApplyMutate:      sugarDB.raftApplyCommand,
-			ApplyDeleteKey:   sugarDB.raftApplyDeleteKey,
+			ApplyDeleteKey: func(ctx context.Context, key string) error {
+				return sugarDB.expireKeys(ctx, []string{key})
+			},
+			ApplyAccessReport: sugarDB.applyAccessReport,
+			AppliedIndex:      sugarDB.raft.AppliedIndex,
+			ApplyCommand:      sugarDB.raftApplyCommandWithIndex,
+			ReadCommand:       sugarDB.handleForwardedRead,
+			ReadIndex:         sugarDB.raft.ReadIndex,
+			GetShards:         sugarDB.slotMap.Announcements,
+			UpdateShards: func(shards []slots.Shard) {
+				sugarDB.slotMap.Update(shards...)
+			},
+			ApplySlotRequest: sugarDB.applySlotRequest,
 		})
 	} else {
 		// Set up standalone snapshot engine
 		sugarDB.snapshotEngine = snapshot.NewSnapshotEngine(
 			snapshot.WithClock(sugarDB.clock),
 			snapshot.WithDirectory(sugarDB.config.DataDir),
+			snapshot.WithKeyring(keyring),
 			snapshot.WithThreshold(sugarDB.config.SnapShotThreshold),
 			snapshot.WithInterval(sugarDB.config.SnapshotInterval),
 			snapshot.WithStartSnapshotFunc(sugarDB.startSnapshot),
 			snapshot.WithFinishSnapshotFunc(sugarDB.finishSnapshot),
 			snapshot.WithSetLatestSnapshotTimeFunc(sugarDB.setLatestSnapshot),
 			snapshot.WithGetLatestSnapshotTimeFunc(sugarDB.getLatestSnapshotTime),
-			snapshot.WithGetStateFunc(func() map[int]map[string]internal.KeyData {
+			snapshot.WithGetStateFunc(func() (map[int]map[string]internal.KeyData, error) {
+				databases, err := sugarDB.getState()
+				if err != nil {
+					return nil, err
+				}
 				state := make(map[int]map[string]internal.KeyData)
-				for database, data := range sugarDB.getState() {
+				for database, data := range databases {
 					state[database] = make(map[string]internal.KeyData)
 					for key, value := range data {
 						if keyData, ok := value.(internal.KeyData); ok {
@@ -263,7 +414,7 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 						}
 					}
 				}
-				return state
+				return state, nil
 			}),
 			snapshot.WithSetKeyDataFunc(func(database int, key string, data internal.KeyData) {
 				ctx := context.WithValue(context.Background(), "Database", database)
@@ -279,11 +430,16 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 			aof.WithClock(sugarDB.clock),
 			aof.WithDirectory(sugarDB.config.DataDir),
 			aof.WithStrategy(sugarDB.config.AOFSyncStrategy),
+			aof.WithKeyring(keyring),
 			aof.WithStartRewriteFunc(sugarDB.startRewriteAOF),
 			aof.WithFinishRewriteFunc(sugarDB.finishRewriteAOF),
-			aof.WithGetStateFunc(func() map[int]map[string]internal.KeyData {
+			aof.WithGetStateFunc(func() (map[int]map[string]internal.KeyData, error) {
+				databases, err := sugarDB.getState()
+				if err != nil {
+					return nil, err
+				}
 				state := make(map[int]map[string]internal.KeyData)
-				for database, data := range sugarDB.getState() {
+				for database, data := range databases {
 					state[database] = make(map[string]internal.KeyData)
 					for key, value := range data {
 						if keyData, ok := value.(internal.KeyData); ok {
@@ -291,7 +447,7 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 						}
 					}
 				}
-				return state
+				return state, nil
 			}),
 			aof.WithSetKeyDataFunc(func(database int, key string, value internal.KeyData) {
 				ctx := context.WithValue(context.Background(), "Database", database)
@@ -313,10 +469,84 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 			return nil, err
 		}
 		sugarDB.aofEngine = aofEngine
+
+		// Set up the replication backlog. It only records writes once a replica connects.
+		sugarDB.replication.backlog = replication.NewBacklog(sugarDB.config.ReplBacklogSize)
+		sugarDB.replication.replicas = make(map[*net.Conn]internal.ReplicaInfo)
+		sugarDB.replication.acked = make(chan struct{})
+		sugarDB.replication.ctx, sugarDB.replication.cancel = context.WithCancel(sugarDB.context)
+
+		if len(sugarDB.config.Peers) > 0 {
+			if sugarDB.config.SiteID == "" {
+				return nil, errors.New("site id is required for active-active replication")
+			}
+			if sugarDB.config.ReplicaOf != "" {
+				return nil, errors.New("replica of is not supported with active-active replication")
+			}
+			if sugarDB.config.TombstoneTTL <= 0 {
+				return nil, errors.New("tombstone ttl must be greater than 0")
+			}
+			sugarDB.activeActive.state = activeactive.NewState(
+				sugarDB.config.SiteID,
+				activeactive.NewClock(sugarDB.config.SiteID, sugarDB.clock),
+			)
+			sugarDB.activeActive.backlog = replication.NewBacklog(sugarDB.config.ReplBacklogSize)
+		}
+	}
+
+	if sugarDB.config.TLS && len(sugarDB.config.CertKeyPairs) <= 0 {
+		return nil, errors.New("must provide certificate and key file paths for TLS mode")
+	}
+
+	if (sugarDB.config.RaftTLS || sugarDB.config.RaftMTLS) && len(sugarDB.config.RaftCertKeyPairs) <= 0 {
+		return nil, errors.New("must provide certificate and key file paths for raft TLS mode")
+	}
+
+	if sugarDB.config.RaftMTLS && len(sugarDB.config.RaftCAs) <= 0 {
+		return nil, errors.New("must provide certificate authority file paths for raft mTLS mode")
+	}
+
+	if sugarDB.config.BootstrapCluster && sugarDB.config.RaftRole == constants.RaftRoleNonvoter {
+		return nil, errors.New("the node that bootstraps the cluster must be a voter")
+	}
+
+	if sugarDB.isInCluster() && sugarDB.config.ReplicaOf != "" {
+		return nil, errors.New("replica of is only supported in standalone mode")
+	}
+
+	if sugarDB.isInCluster() && len(sugarDB.config.Peers) > 0 {
+		return nil, errActiveActiveCluster
 	}
 
-	// If eviction policy is not noeviction, start a goroutine to evict keys at the configured interval.
-	if sugarDB.config.EvictionPolicy != constants.NoEviction {
+	if sugarDB.quotas, err = newMemoryQuotas(sugarDB.config); err != nil {
+		return nil, err
+	}
+
+	if sugarDB.config.TieredStorage {
+		if err = sugarDB.openTieredStore(); err != nil {
+			return nil, err
+		}
+	}
+
+	if sugarDB.isInCluster() {
+		// Initialise raft and memberlist
+		sugarDB.raft.RaftInit(sugarDB.context)
+		sugarDB.memberList.MemberListInit(sugarDB.context)
+		if sugarDB.isSharded() {
+			go sugarDB.runSlotLoop()
+		}
+	}
+
+	// Start the goroutine that releases large values detached from the keyspace.
+	go sugarDB.runLazyFree()
+
+	// Start the goroutine that spills the values of cold keys in tiered mode.
+	if sugarDB.tiered.store != nil {
+		go sugarDB.runTiered()
+	}
+
+	// Start a goroutine to expire keys at the configured interval, regardless of the eviction policy.
+	if sugarDB.config.EvictionInterval > 0 {
 		go func() {
 			ticker := time.NewTicker(sugarDB.config.EvictionInterval)
 			defer func() {
@@ -325,9 +555,10 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 			for {
 				select {
 				case <-ticker.C:
-					// Run key eviction for each database that has volatile keys.
+					// Run key expiry for each database that has volatile keys.
+					databases := sugarDB.getDatabases()
 					wg := sync.WaitGroup{}
-					for database, _ := range sugarDB.keysWithExpiry.keys {
+					for _, database := range databases {
 						wg.Add(1)
 						ctx := context.WithValue(context.Background(), "Database", database)
 						go func(ctx context.Context, wg *sync.WaitGroup) {
@@ -338,42 +569,59 @@ func NewSugarDB(options ...func(sugarDB *SugarDB)) (*SugarDB, error) {
 						}(ctx, &wg)
 					}
 					wg.Wait()
+					// Followers report the keys they served to the leader, which makes the eviction decisions.
+					if sugarDB.isInCluster() && !sugarDB.raft.IsRaftLeader() {
+						sugarDB.reportAccess()
+					}
 				case <-sugarDB.stopTTL:
-					break
+					return
 				}
 			}
 		}()
 	}
 
-	if sugarDB.config.TLS && len(sugarDB.config.CertKeyPairs) <= 0 {
-		return nil, errors.New("must provide certificate and key file paths for TLS mode")
-	}
-
-	if sugarDB.isInCluster() {
-		// Initialise raft and memberlist
-		sugarDB.raft.RaftInit(sugarDB.context)
-		sugarDB.memberList.MemberListInit(sugarDB.context)
-		// Initialise caches
-		sugarDB.initialiseCaches()
+	if sugarDB.isInCluster() && sugarDB.config.RestoreBackup != "" {
+		log.Println("restore backup is only supported in standalone mode, skipping backup restore")
 	}
 
 	if !sugarDB.isInCluster() {
-		sugarDB.initialiseCaches()
-		// Restore from AOF by default if it's enabled
-		if sugarDB.config.RestoreAOF {
+		// Restoring a backup takes precedence over restoring from AOF or snapshot.
+		if sugarDB.config.RestoreBackup != "" {
+			if err := sugarDB.restoreBackup(sugarDB.config.RestoreBackup); err != nil {
+				return nil, err
+			}
+		} else if sugarDB.config.RestoreAOF {
+			// Restore from AOF by default if it's enabled
 			err := sugarDB.aofEngine.Restore()
 			if err != nil {
 				log.Println(err)
 			}
-		}
-
-		// Restore from snapshot if snapshot restore is enabled and AOF restore is disabled
-		if sugarDB.config.RestoreSnapshot && !sugarDB.config.RestoreAOF {
+		} else if sugarDB.config.RestoreSnapshot {
+			// Restore from snapshot if snapshot restore is enabled and AOF restore is disabled
 			err := sugarDB.snapshotEngine.Restore()
 			if err != nil {
 				log.Println(err)
 			}
 		}
+		// Start replicating the primary once the local state is restored.
+		// The local state is replaced by the primary's state on the first synchronisation.
+		if sugarDB.config.ReplicaOf != "" {
+			host, p, err := net.SplitHostPort(sugarDB.config.ReplicaOf)
+			if err != nil {
+				return nil, fmt.Errorf("replica of: %v", err)
+			}
+			port, err := strconv.Atoi(p)
+			if err != nil {
+				return nil, fmt.Errorf("replica of: %v", err)
+			}
+			if err = sugarDB.replicaOf(host, port); err != nil {
+				return nil, err
+			}
+		}
+		// Start pulling the effects of the writes of the peer sites once the local state is restored.
+		if err := sugarDB.startPeers(); err != nil {
+			return nil, err
+		}
 	}
 
 	return sugarDB, nil
@@ -482,14 +730,20 @@ func (server *SugarDB) handleConnection(conn net.Conn) {
 	// Set the default connection information
 	server.connInfo.mut.Lock()
 	server.connInfo.tcpClients[&conn] = internal.ConnectionInfo{
-		Id:       cid,
-		Name:     "",
-		Protocol: 2,
-		Database: 0,
+		Id:              cid,
+		Name:            "",
+		Protocol:        2,
+		Database:        0,
+		ReadConsistency: server.config.ReadConsistency,
 	}
 	server.connInfo.mut.Unlock()
 
 	defer func() {
+		server.lastWrite.mut.Lock()
+		delete(server.lastWrite.clients, &conn)
+		delete(server.lastWrite.replication, &conn)
+		server.lastWrite.mut.Unlock()
+
 		log.Printf("closing connection %d...", cid)
 		if err := conn.Close(); err != nil {
 			log.Println(err)
@@ -514,6 +768,13 @@ func (server *SugarDB) handleConnection(conn net.Conn) {
 		if err != nil && errors.Is(err, io.EOF) {
 			break
 		}
+		if err != nil && slots.IsClusterError(err) {
+			// Cluster errors are written without the generic prefix so that cluster-aware clients can handle them.
+			if _, err = w.Write([]byte(fmt.Sprintf("-%s\r\n", err.Error()))); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
 		if err != nil {
 			log.Println(err)
 			if _, err = w.Write([]byte(fmt.Sprintf("-Error %s\r\n", err.Error()))); err != nil {
@@ -627,7 +888,6 @@ func (server *SugarDB) rewriteAOF() error {
 func (server *SugarDB) ShutDown() {
 	if server.listener.Load() != nil {
 		go func() { server.quit <- struct{}{} }()
-		go func() { server.stopTTL <- struct{}{} }()
 
 		log.Println("closing tcp listener...")
 		if err := server.listener.Load().(net.Listener).Close(); err != nil {
@@ -635,6 +895,14 @@ func (server *SugarDB) ShutDown() {
 		}
 	}
 
+	// Stop the active expiry of keys.
+	if server.config.EvictionInterval > 0 {
+		go func() { server.stopTTL <- struct{}{} }()
+	}
+
+	// Stop releasing the values detached from the keyspace.
+	go func() { server.lazyFree.stop <- struct{}{} }()
+
 	// Shutdown all script VMs
 	log.Println("shutting down script vms...")
 	server.commandsRWMut.Lock()
@@ -660,34 +928,16 @@ func (server *SugarDB) ShutDown() {
 
 	if !server.isInCluster() {
 		// Server is not in cluster, run standalone-only shutdown processes.
+		server.stopPeers()
+		server.stopReplication()
 		server.aofEngine.Close()
+		server.closeTieredStore()
 	} else {
 		// Server is in cluster, run cluster-only shutdown processes.
+		if server.isSharded() {
+			close(server.stopSlots)
+		}
 		server.raft.RaftShutdown()
 		server.memberList.MemberListShutdown()
 	}
 }
-
-func (server *SugarDB) initialiseCaches() {
-	// Set up LFU cache.
-	server.lfuCache = struct {
-		mutex *sync.Mutex
-		cache map[int]*eviction.CacheLFU
-	}{
-		mutex: &sync.Mutex{},
-		cache: make(map[int]*eviction.CacheLFU),
-	}
-	// set up LRU cache.
-	server.lruCache = struct {
-		mutex *sync.Mutex
-		cache map[int]*eviction.CacheLRU
-	}{
-		mutex: &sync.Mutex{},
-		cache: make(map[int]*eviction.CacheLRU),
-	}
-	// Initialise caches for each preloaded database.
-	for database, _ := range server.store {
-		server.lfuCache.cache[database] = eviction.NewCacheLFU()
-		server.lruCache.cache[database] = eviction.NewCacheLRU()
-	}
-}
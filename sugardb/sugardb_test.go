@@ -17,22 +17,38 @@ package sugardb
 import (
 	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/echovault/sugardb/internal"
 	"github.com/echovault/sugardb/internal/clock"
 	"github.com/echovault/sugardb/internal/config"
 	"github.com/echovault/sugardb/internal/constants"
+	"github.com/echovault/sugardb/internal/eviction"
+	"github.com/echovault/sugardb/internal/modules/hash"
+	"github.com/echovault/sugardb/internal/modules/set"
+	"github.com/echovault/sugardb/internal/modules/sorted_set"
+	"github.com/echovault/sugardb/internal/replication"
+	"github.com/echovault/sugardb/internal/tiered"
 	"github.com/go-test/deep"
 	"github.com/tidwall/resp"
 	"io"
 	"math"
+	"math/big"
 	"net"
 	"os"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -571,6 +587,152 @@ func Test_Cluster(t *testing.T) {
 		}
 	})
 
+	t.Run("Test_ForwardCommandResponse", func(t *testing.T) {
+		// Forwarded commands should return the leader's actual response and the write
+		// should be readable from the follower as soon as the response is received.
+		follower := nodes[1]
+		commands := []struct {
+			cmd     []string
+			want    string
+			wantErr string
+		}{
+			{cmd: []string{"SET", "forward-counter", "10"}, want: "OK"},
+			{cmd: []string{"INCR", "forward-counter"}, want: "11"},
+			{cmd: []string{"GET", "forward-counter"}, want: "11"},
+			{cmd: []string{"SET", "forward-string", "value"}, want: "OK"},
+			{cmd: []string{"INCR", "forward-string"}, wantErr: "value is not an integer or out of range"},
+		}
+		for i, command := range commands {
+			var values []resp.Value
+			for _, token := range command.cmd {
+				values = append(values, resp.StringValue(token))
+			}
+			if err := follower.client.WriteArray(values); err != nil {
+				t.Errorf("could not write command %d to follower: %v", i, err)
+				return
+			}
+			res, _, err := follower.client.ReadValue()
+			if err != nil {
+				t.Errorf("could not read response %d from follower: %v", i, err)
+				return
+			}
+			if command.wantErr != "" {
+				if res.Type() != resp.Error || !strings.Contains(res.String(), command.wantErr) {
+					t.Errorf("expected error containing \"%s\" for command %d, got %v", command.wantErr, i, res)
+				}
+				continue
+			}
+			if res.String() != command.want {
+				t.Errorf("expected response \"%s\" for command %d, got \"%s\"", command.want, i, res.String())
+			}
+		}
+	})
+
+	t.Run("Test_ReadConsistency", func(t *testing.T) {
+		// Writes on the leader must be visible on a follower immediately with the leader
+		// and linearizable read consistency levels.
+		leader := &nodes[0]
+		follower := &nodes[2]
+		for i, level := range []string{constants.ReadConsistencyLinearizable, constants.ReadConsistencyLeader} {
+			value := fmt.Sprintf("consistency-value-%d", i)
+			commands := []struct {
+				node *ClientServerPair
+				cmd  []string
+				want string
+			}{
+				{node: follower, cmd: []string{"READCONSISTENCY", level}, want: "OK"},
+				{node: leader, cmd: []string{"SET", "consistency-key", value}, want: "OK"},
+				{node: follower, cmd: []string{"GET", "consistency-key"}, want: value},
+				{node: follower, cmd: []string{"READCONSISTENCY", constants.ReadConsistencyStale}, want: "OK"},
+			}
+			for j, command := range commands {
+				var values []resp.Value
+				for _, token := range command.cmd {
+					values = append(values, resp.StringValue(token))
+				}
+				if err := command.node.client.WriteArray(values); err != nil {
+					t.Errorf("could not write command %d (level %s): %v", j, level, err)
+					return
+				}
+				res, _, err := command.node.client.ReadValue()
+				if err != nil {
+					t.Errorf("could not read response %d (level %s): %v", j, level, err)
+					return
+				}
+				if res.String() != command.want {
+					t.Errorf("expected response \"%s\" for command %d (level %s), got \"%s\"",
+						command.want, j, level, res.String())
+				}
+			}
+		}
+	})
+
+	t.Run("Test_Wait", func(t *testing.T) {
+		// Write to the leader and wait for all the followers to apply the write.
+		leader := nodes[0]
+		if err := leader.client.WriteArray([]resp.Value{
+			resp.StringValue("SET"), resp.StringValue("key13"), resp.StringValue("value13"),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, _, err := leader.client.ReadValue(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := leader.client.WriteArray([]resp.Value{
+			resp.StringValue("WAIT"), resp.StringValue(strconv.Itoa(len(nodes) - 1)), resp.StringValue("5000"),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, _, err := leader.client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if res.Integer() != len(nodes)-1 {
+			t.Errorf("expected %d replicas to acknowledge the write, got %d", len(nodes)-1, res.Integer())
+		}
+
+		// Once WAIT returns, the write must be visible on all the followers.
+		for i := 1; i < len(nodes); i++ {
+			if err = nodes[i].client.WriteArray([]resp.Value{
+				resp.StringValue("GET"), resp.StringValue("key13"),
+			}); err != nil {
+				t.Error(err)
+				return
+			}
+			res, _, err = nodes[i].client.ReadValue()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.String() != "value13" {
+				t.Errorf("expected node %d to have value \"value13\" at key13, got \"%s\"", i, res.String())
+			}
+		}
+
+		// WAIT cannot be used on a follower.
+		follower := nodes[1]
+		if err = follower.client.WriteArray([]resp.Value{
+			resp.StringValue("WAIT"), resp.StringValue("1"), resp.StringValue("100"),
+		}); err != nil {
+			t.Error(err)
+			return
+		}
+		res, _, err = follower.client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := "WAIT cannot be used with replica instances"
+		if res.Error() == nil || !strings.Contains(res.Error().Error(), expected) {
+			t.Errorf("expected error response to contain \"%s\", got \"%s\"", expected, res.String())
+		}
+	})
+
 	t.Run("Test_NotLeaderError", func(t *testing.T) {
 		node := nodes[len(nodes)-1]
 		err := node.client.WriteArray([]resp.Value{
@@ -579,88 +741,2437 @@ func Test_Cluster(t *testing.T) {
 			resp.StringValue("value"),
 		})
 		if err != nil {
-			t.Error(err)
-			return
+			t.Error(err)
+			return
+		}
+		res, _, err := node.client.ReadValue()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := "not cluster leader, cannot carry out command"
+		if !strings.Contains(res.Error().Error(), expected) {
+			t.Errorf("expected response to contain \"%s\", got \"%s\"", expected, res.Error().Error())
+		}
+	})
+
+	t.Run("Test_SnapshotRestore", func(t *testing.T) {
+		// TODO: Test snapshot creation and restoration on the cluster.
+	})
+
+	t.Run("Test_EvictExpiredTTL", func(t *testing.T) {
+		// TODO: Implement test for evicting expired keys on the cluster.
+	})
+
+	t.Run("Test_GetServerInfo", func(t *testing.T) {
+		nodeInfo := []internal.ServerInfo{
+			{
+				Server:     "sugardb",
+				Version:    constants.Version,
+				Id:         nodes[0].serverId,
+				Mode:       "cluster",
+				Role:       "master",
+				Modules:    nodes[0].server.ListModules(),
+				MemoryUsed: nodes[0].server.memUsed.Load(),
+				MaxMemory:  nodes[0].server.config.MaxMemory,
+			},
+			{
+				Server:     "sugardb",
+				Version:    constants.Version,
+				Id:         nodes[1].serverId,
+				Mode:       "cluster",
+				Role:       "replica",
+				Modules:    nodes[1].server.ListModules(),
+				MemoryUsed: nodes[1].server.memUsed.Load(),
+				MaxMemory:  nodes[1].server.config.MaxMemory,
+			},
+			{
+				Server:     "sugardb",
+				Version:    constants.Version,
+				Id:         nodes[2].serverId,
+				Mode:       "cluster",
+				Role:       "replica",
+				Modules:    nodes[2].server.ListModules(),
+				MemoryUsed: nodes[2].server.memUsed.Load(),
+				MaxMemory:  nodes[2].server.config.MaxMemory,
+			},
+			{
+				Server:     "sugardb",
+				Version:    constants.Version,
+				Id:         nodes[3].serverId,
+				Mode:       "cluster",
+				Role:       "replica",
+				Modules:    nodes[3].server.ListModules(),
+				MemoryUsed: nodes[3].server.memUsed.Load(),
+				MaxMemory:  nodes[3].server.config.MaxMemory,
+			},
+			{
+				Server:     "sugardb",
+				Version:    constants.Version,
+				Id:         nodes[4].serverId,
+				Mode:       "cluster",
+				Role:       "replica",
+				Modules:    nodes[4].server.ListModules(),
+				MemoryUsed: nodes[4].server.memUsed.Load(),
+				MaxMemory:  nodes[4].server.config.MaxMemory,
+			},
+		}
+		for i := 0; i < len(nodes); i++ {
+			if diff := deep.Equal(nodes[i].server.GetServerInfo(), nodeInfo[i]); diff != nil {
+				t.Errorf("GetServerInfo() - node %d: %+v expected %v got %v", i, err, nodes[i].server.GetServerInfo(), nodeInfo[i])
+				return
+			}
+		}
+	})
+
+	t.Run("Test_ClusterAdmin", func(t *testing.T) {
+		// This test changes the cluster leader, so it must run last.
+		info, err := nodes[0].server.ClusterInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info["raft_state"] != "leader" || info["raft_leader"] != nodes[0].serverId || info["raft_voters"] != "5" {
+			t.Errorf("expected node 0 to be the leader of 5 voters, got %v", info)
+		}
+
+		clusterNodes, err := nodes[1].server.ClusterNodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clusterNodes) != len(nodes) {
+			t.Fatalf("expected %d nodes, got %d", len(nodes), len(clusterNodes))
+		}
+		for _, node := range clusterNodes {
+			if node.Leader != (node.ID == nodes[0].serverId) || node.Myself != (node.ID == nodes[1].serverId) ||
+				node.Suffrage != "voter" || node.Health != "online" {
+				t.Errorf("unexpected node %+v", node)
+			}
+		}
+
+		// Membership and leadership changes must be made on the leader.
+		if _, err = nodes[1].server.ClusterForget(nodes[4].serverId); err == nil ||
+			!strings.Contains(err.Error(), "not cluster leader") {
+			t.Errorf("expected not leader error, got %v", err)
+		}
+		if _, err = nodes[0].server.ClusterForget(nodes[0].serverId); err == nil {
+			t.Error("expected error when forgetting the leader itself")
+		}
+
+		if _, err = nodes[0].server.ClusterTransferLeadership(nodes[1].serverId); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(10 * time.Second); !nodes[1].server.raft.IsRaftLeader(); {
+			if time.Now().After(deadline) {
+				t.Fatal("expected node 1 to become the leader")
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		// The latest log entries are the no-op of the election and then the membership change, which are not
+		// applied to the FSM. Linearizable reads must not wait for a later write.
+		readLinearizable := func(t *testing.T, stage string) {
+			for i := 1; i < 4; i++ {
+				for _, cmd := range [][]string{
+					{"READCONSISTENCY", constants.ReadConsistencyLinearizable},
+					{"GET", "admin-key"},
+					{"READCONSISTENCY", constants.ReadConsistencyStale},
+				} {
+					var values []resp.Value
+					for _, token := range cmd {
+						values = append(values, resp.StringValue(token))
+					}
+					if err := nodes[i].client.WriteArray(values); err != nil {
+						t.Fatal(err)
+					}
+					res, _, err := nodes[i].client.ReadValue()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if res.Type() == resp.Error {
+						t.Errorf("expected %s on node %d to succeed %s, got error %s", cmd[0], i, stage, res.Error())
+					}
+				}
+			}
+		}
+		readLinearizable(t, "after the election")
+
+		if _, err = nodes[1].server.ClusterForget(nodes[4].serverId); err != nil {
+			t.Fatal(err)
+		}
+		if clusterNodes, err = nodes[1].server.ClusterNodes(); err != nil || len(clusterNodes) != len(nodes)-1 {
+			t.Errorf("expected %d nodes after forgetting a node, got %v (%v)", len(nodes)-1, clusterNodes, err)
+		}
+		readLinearizable(t, "after the membership change")
+	})
+}
+
+func Test_NonvoterCluster(t *testing.T) {
+	// Set up a voter that bootstraps the cluster and a non-voter that joins it.
+	var nodes [2]*SugarDB
+	var clients [2]*resp.Conn
+	var serverIDs [2]string
+	var joinAddr string
+	for i := range nodes {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
+		}
+		discoveryPort, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free memberlist port: %v", err)
+		}
+
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = getBindAddr().String()
+		conf.Port = uint16(port)
+		conf.ServerID = fmt.Sprintf("NONVOTER-TEST-%d", i)
+		conf.DiscoveryPort = uint16(discoveryPort)
+		conf.EvictionPolicy = constants.NoEviction
+		if i == 0 {
+			conf.BootstrapCluster = true
+			joinAddr = fmt.Sprintf("%s/%s:%d", conf.ServerID, conf.BindAddr, discoveryPort)
+		} else {
+			conf.JoinAddr = joinAddr
+			conf.RaftRole = constants.RaftRoleNonvoter
+		}
+
+		server, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
+		}
+		go server.Start()
+		for i == 0 && !server.raft.IsRaftLeader() || i > 0 && !server.raft.HasJoinedCluster() {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		conn, err := internal.GetConnection(conf.BindAddr, port)
+		if err != nil {
+			t.Fatalf("could not open tcp connection: %v", err)
+		}
+		nodes[i], clients[i], serverIDs[i] = server, resp.NewConn(conn), conf.ServerID
+	}
+	t.Cleanup(func() {
+		for i := len(nodes) - 1; i > -1; i-- {
+			nodes[i].ShutDown()
+		}
+	})
+
+	do := func(client *resp.Conn, cmd ...string) resp.Value {
+		command := make([]resp.Value, len(cmd))
+		for i, c := range cmd {
+			command[i] = resp.StringValue(c)
+		}
+		if err := client.WriteArray(command); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := client.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	if _, err := NewSugarDB(WithBootstrapCluster(), WithRaftRole(constants.RaftRoleNonvoter)); err == nil {
+		t.Error("expected error when bootstrapping the cluster with a non-voter")
+	}
+
+	info, err := nodes[0].ClusterInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["raft_voters"] != "1" || info["raft_nonvoters"] != "1" {
+		t.Errorf("expected 1 voter and 1 non-voter, got %v", info)
+	}
+
+	// The non-voter serves reads of writes replicated from the leader.
+	if res := do(clients[0], "SET", "key1", "value1"); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if res := do(clients[1], "GET", "key1"); res.String() == "value1" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected non-voter to serve the replicated value, got %s", res.String())
+		}
+	}
+
+	// Writes are rejected by the non-voter when command forwarding is disabled.
+	if res := do(clients[1], "SET", "key2", "value2"); res.Error() == nil ||
+		!strings.Contains(res.Error().Error(), "not cluster leader") {
+		t.Errorf("expected not leader error, got %s", res.String())
+	}
+
+	// Only the leader can promote the non-voter.
+	if res := do(clients[1], "CLUSTER", "PROMOTE", serverIDs[1]); res.Error() == nil {
+		t.Errorf("expected error promoting from the non-voter, got %s", res.String())
+	}
+	if res := do(clients[0], "CLUSTER", "PROMOTE", serverIDs[0]); res.Error() == nil ||
+		!strings.Contains(res.Error().Error(), "already a voter") {
+		t.Errorf("expected already a voter error, got %s", res.String())
+	}
+	if _, err = nodes[0].ClusterPromote(serverIDs[1]); err != nil {
+		t.Fatal(err)
+	}
+	clusterNodes, err := nodes[0].ClusterNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range clusterNodes {
+		if node.Suffrage != "voter" {
+			t.Errorf("expected all nodes to be voters after promotion, got %+v", node)
+		}
+	}
+}
+
+func Test_SampledEviction(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{name: "1. Evict the least recently used keys", policy: constants.AllKeysLRU},
+		{name: "2. Evict the least frequently used keys", policy: constants.AllKeysLFU},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := createSugarDBWithConfig(config.Config{
+				DataDir:          "",
+				EvictionPolicy:   tt.policy,
+				EvictionSample:   20,
+				EvictionInterval: 30 * time.Second,
+				MaxMemory:        12400,
+			})
+			t.Cleanup(server.ShutDown)
+
+			value := strings.Repeat("x", 1000)
+			for i := 0; i < 5; i++ {
+				if _, _, err := server.Set(fmt.Sprintf("key%d", i), value, SETOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Wait for the LRU clock to tick, then access key0 so that it's the most recently and frequently used key.
+			time.Sleep(2 * eviction.LRUClockResolution)
+			for i := 0; i < 5; i++ {
+				if _, err := server.Get("key0"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Exceed the max memory.
+			if _, _, err := server.Set("key5", value, SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			for deadline := time.Now().Add(5 * time.Second); server.isMaxMemoryExceeded(); time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("expected keys to be evicted")
+				}
+			}
+
+			if exists, err := server.Exists("key0"); err != nil || exists != 1 {
+				t.Errorf("expected key0 to be kept, got %d, %v", exists, err)
+			}
+			exists, err := server.Exists("key0", "key1", "key2", "key3", "key4", "key5")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != 5 {
+				t.Errorf("expected 1 key to be evicted, got %d keys", exists)
+			}
+		})
+	}
+
+	// Keys are also evicted when the workload only grows existing sets and sorted sets in place.
+	for _, tt := range tests {
+		t.Run(tt.name+" that grow in place", func(t *testing.T) {
+			t.Parallel()
+			server := createSugarDBWithConfig(config.Config{
+				DataDir:          "",
+				EvictionPolicy:   tt.policy,
+				EvictionSample:   20,
+				EvictionInterval: 30 * time.Second,
+				MaxMemory:        12400,
+			})
+			t.Cleanup(server.ShutDown)
+
+			member := strings.Repeat("x", 50)
+			if _, err := server.SAdd("set", member); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.ZAdd("zset", map[string]float64{member: 0}, ZAddOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			// Grow both keys until the max memory is exceeded or one of them is evicted.
+			for i := 1; !server.isMaxMemoryExceeded(); i++ {
+				if exists, _ := server.Exists("set", "zset"); exists != 2 {
+					break
+				}
+				if i > 1000 {
+					t.Fatal("expected the growth of the keys to exceed the max memory")
+				}
+				if _, err := server.SAdd("set", fmt.Sprintf("%s-%d", member, i)); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := server.ZAdd("zset", map[string]float64{fmt.Sprintf("%s-%d", member, i): float64(i)}, ZAddOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for deadline := time.Now().Add(5 * time.Second); server.isMaxMemoryExceeded(); time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("expected keys to be evicted")
+				}
+			}
+
+			if exists, err := server.Exists("set", "zset"); err != nil || exists != 1 {
+				t.Errorf("expected 1 of the keys to be evicted, got %d keys (%v)", exists, err)
+			}
+		})
+	}
+}
+
+func Test_VolatileEviction(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		evicted string // The key expected to be evicted, if the policy is deterministic.
+	}{
+		{name: "1. Evict the keys closest to expiring", policy: constants.VolatileTTL, evicted: "key1"},
+		{name: "2. Evict random keys with an expiry", policy: constants.VolatileRandom},
+		{name: "3. Evict the least recently used keys with an expiry", policy: constants.VolatileLRU},
+		{name: "4. Evict the least frequently used keys with an expiry", policy: constants.VolatileLFU},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := createSugarDBWithConfig(config.Config{
+				DataDir:          "",
+				EvictionPolicy:   tt.policy,
+				EvictionSample:   20,
+				EvictionInterval: 30 * time.Second,
+				MaxMemory:        12400,
+			})
+			t.Cleanup(server.ShutDown)
+
+			// key0 has no expiry, so it's never evicted. key1 expires first, and key5 expires last.
+			value := strings.Repeat("x", 1000)
+			for i := 0; i < 6; i++ {
+				key := fmt.Sprintf("key%d", i)
+				if _, _, err := server.Set(key, value, SETOptions{}); err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					continue
+				}
+				if _, err := server.Expire(key, i*100); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for deadline := time.Now().Add(5 * time.Second); server.isMaxMemoryExceeded(); time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("expected keys to be evicted")
+				}
+			}
+
+			var kept []string
+			for i := 0; i < 6; i++ {
+				key := fmt.Sprintf("key%d", i)
+				if exists, err := server.Exists(key); err != nil {
+					t.Fatal(err)
+				} else if exists == 1 {
+					kept = append(kept, key)
+				}
+			}
+			if len(kept) != 5 {
+				t.Errorf("expected 1 key to be evicted, got keys %v", kept)
+			}
+			if !slices.Contains(kept, "key0") {
+				t.Errorf("expected key0 without expiry to be kept, got keys %v", kept)
+			}
+			if tt.evicted != "" && slices.Contains(kept, tt.evicted) {
+				t.Errorf("expected %s to be evicted, got keys %v", tt.evicted, kept)
+			}
+		})
+	}
+
+	t.Run("5. Evict the keys closest to expiring when they grow in place", func(t *testing.T) {
+		t.Parallel()
+		server := createSugarDBWithConfig(config.Config{
+			DataDir:          "",
+			EvictionPolicy:   constants.VolatileTTL,
+			EvictionSample:   20,
+			EvictionInterval: 30 * time.Second,
+			MaxMemory:        12400,
+		})
+		t.Cleanup(server.ShutDown)
+
+		// The set has no expiry, so it's never evicted. The sorted set expires before the other set.
+		member := strings.Repeat("x", 50)
+		if _, err := server.SAdd("set", member); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.SAdd("volatile-set", member); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.ZAdd("volatile-zset", map[string]float64{member: 0}, ZAddOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Expire("volatile-set", 200); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Expire("volatile-zset", 100); err != nil {
+			t.Fatal(err)
+		}
+
+		// Grow the keys with an expiry until the max memory is exceeded or the sorted set is evicted.
+		for i := 1; !server.isMaxMemoryExceeded(); i++ {
+			if exists, _ := server.Exists("volatile-zset"); exists != 1 {
+				break
+			}
+			if i > 1000 {
+				t.Fatal("expected the growth of the keys to exceed the max memory")
+			}
+			if _, err := server.SAdd("volatile-set", fmt.Sprintf("%s-%d", member, i)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.ZAdd("volatile-zset", map[string]float64{fmt.Sprintf("%s-%d", member, i): float64(i)}, ZAddOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for deadline := time.Now().Add(5 * time.Second); server.isMaxMemoryExceeded(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("expected keys to be evicted")
+			}
+		}
+
+		if exists, _ := server.Exists("volatile-zset"); exists != 0 {
+			t.Error("expected volatile-zset to be evicted")
+		}
+		if exists, _ := server.Exists("set", "volatile-set"); exists != 2 {
+			t.Errorf("expected set and volatile-set to be kept, got %d keys", exists)
+		}
+	})
+}
+
+func Test_ActiveExpiry(t *testing.T) {
+	// Keys expire actively without an eviction policy. The sample size is smaller than the number of expired keys,
+	// so the expiry is repeated within a cycle.
+	server := createSugarDBWithConfig(config.Config{
+		DataDir:          "",
+		EvictionPolicy:   constants.NoEviction,
+		EvictionSample:   4,
+		EvictionInterval: 20 * time.Millisecond,
+	})
+	t.Cleanup(server.ShutDown)
+	ctx := context.WithValue(context.Background(), "Database", 0)
+
+	now := server.clock.Now()
+	for i := 0; i < 20; i++ {
+		presetKeyData(server, ctx, fmt.Sprintf("expired%d", i), internal.KeyData{
+			Value:    "value",
+			ExpireAt: now.Add(-time.Duration(i+1) * time.Second),
+		})
+	}
+	presetKeyData(server, ctx, "volatile", internal.KeyData{Value: "value", ExpireAt: now.Add(time.Hour)})
+	presetKeyData(server, ctx, "persisted", internal.KeyData{Value: "value", ExpireAt: now.Add(time.Hour)})
+	server.setExpiry(ctx, "persisted", time.Time{}, false)
+
+	// The expired field is removed from the hash, which is kept in the index for its other field.
+	if _, err := server.HSet("hash", map[string]string{"field1": "value1", "field2": "value2", "field3": "value3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.setHashExpiry(ctx, "hash", "field1", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.setHashExpiry(ctx, "hash", "field2", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		hashLen, err := server.HLen("hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if server.dbSize(ctx) == 3 && hashLen == 2 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected expired keys and fields to be removed, got %d keys and %d fields", server.dbSize(ctx), hashLen)
+		}
+	}
+
+	db := server.getDatabase(0)
+	db.expiry.mut.Lock()
+	defer db.expiry.mut.Unlock()
+	index := db.expiry.index
+	if index.Len() != 2 {
+		t.Errorf("expected 2 keys in the expiry index, got %d", index.Len())
+	}
+	if expireAt, ok := index.Get("hash"); !ok || !expireAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected hash to be indexed at the expiry of field2, got %v", expireAt)
+	}
+	if _, ok := index.Get("volatile"); !ok {
+		t.Error("expected volatile key to be in the expiry index")
+	}
+	if _, ok := index.Get("persisted"); ok {
+		t.Error("expected persisted key to be removed from the expiry index")
+	}
+}
+
+func Test_ConsistentState(t *testing.T) {
+	server := createSugarDB()
+	t.Cleanup(server.ShutDown)
+
+	// Each writer sets pairs of keys that map to different shards to the same value in a single write.
+	var pairs [][2]string
+	for i := 0; len(pairs) < 32; i++ {
+		a, b := fmt.Sprintf("a%d", i), fmt.Sprintf("b%d", i)
+		if shardIndex(a) != shardIndex(b) {
+			pairs = append(pairs, [2]string{a, b})
+		}
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, pair := range pairs {
+		wg.Add(1)
+		go func(pair [2]string) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				value := strconv.Itoa(i)
+				if _, err := server.MSet(map[string]string{pair[0]: value, pair[1]: value}); err != nil {
+					t.Error(err)
+					return
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}(pair)
+	}
+
+	// Every copy of the state holds both keys of a pair with the same value.
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		databases, err := server.getState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := databases[0]
+		for _, pair := range pairs {
+			a, aok := state[pair[0]].(internal.KeyData)
+			b, bok := state[pair[1]].(internal.KeyData)
+			if aok != bok || a.Value != b.Value {
+				t.Errorf("expected %s and %s to be copied with the same value, got %v and %v", pair[0], pair[1], a.Value, b.Value)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if size := server.dbSize(context.WithValue(context.Background(), "Database", 0)); size != 2*len(pairs) {
+		t.Errorf("expected %d keys, got %d", 2*len(pairs), size)
+	}
+}
+
+func Test_CopyOnWriteSnapshot(t *testing.T) {
+	server := createSugarDB()
+	t.Cleanup(server.ShutDown)
+
+	if _, _, err := server.Set("key", "value1", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	db := server.getDatabase(0)
+	s := db.shard("key")
+
+	// A write to a shared map copies it, and the snapshot keeps the value of the key at the time it was taken.
+	s.mut.Lock()
+	frozen, release := s.share()
+	s.mut.Unlock()
+	if _, _, err := server.Set("key", "value2", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if value := frozen["key"].Value; value != "value1" {
+		t.Errorf("expected the snapshot to hold \"value1\", got %v", value)
+	}
+	if value, err := server.Get("key"); err != nil || value != "value2" {
+		t.Errorf("expected \"value2\", got %v (%v)", value, err)
+	}
+	release()
+
+	// A map that's released before it's written to is not copied.
+	s.mut.Lock()
+	_, release = s.share()
+	generation := s.generation
+	s.mut.Unlock()
+	release()
+	if _, _, err := server.Set("key", "value3", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s.mut.Lock()
+	if s.shared != 0 || s.generation != generation {
+		t.Errorf("expected the map not to be copied, got %d snapshots sharing generation %d (expected %d)",
+			s.shared, s.generation, generation)
+	}
+	s.mut.Unlock()
+
+	// The values that commands change in place are copied, so the state doesn't change with the writes after it.
+	if _, err := server.SAdd("set", "one", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.ZAdd("zset", map[string]float64{"one": 1, "two": 2}, ZAddOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	databases, err := server.getState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.SAdd("set", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.ZAdd("zset", map[string]float64{"three": 3}, ZAddOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := databases[0]["set"].(internal.KeyData).Value.(*set.Set).Cardinality(); n != 2 {
+		t.Errorf("expected the state to hold a set of 2 members, got %d", n)
+	}
+	if n := databases[0]["zset"].(internal.KeyData).Value.(*sorted_set.SortedSet).Cardinality(); n != 2 {
+		t.Errorf("expected the state to hold a sorted set of 2 members, got %d", n)
+	}
+}
+
+func Test_CopyStatePosition(t *testing.T) {
+	server := createSugarDB()
+	t.Cleanup(server.ShutDown)
+	server.replication.backlog.Activate()
+
+	// Each write appends a command of the same length to the stream, so the position of the stream
+	// tells the number of writes that are part of the state.
+	if _, _, err := server.Set("init", "00000000", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, start, _ := server.replication.backlog.Position()
+	length := uint64(len(internal.EncodeCommand([]string{"SET", "key0", "00000000"})))
+
+	// Each writer sets its own key to the number of writes it made before.
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				if _, _, err := server.Set(key, fmt.Sprintf("%08d", n), SETOptions{}); err != nil {
+					t.Error(err)
+					return
+				}
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}(fmt.Sprintf("key%d", i))
+	}
+
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		var offset uint64
+		databases, err := server.copyState(func() {
+			_, offset, _ = server.replication.backlog.Position()
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := databases[0]
+		writes := 0
+		for i := 0; i < 8; i++ {
+			if data, ok := state[fmt.Sprintf("key%d", i)].(internal.KeyData); ok {
+				n, _ := strconv.Atoi(fmt.Sprint(data.Value))
+				writes += n + 1
+			}
+		}
+		if expected := int((offset - start) / length); writes != expected {
+			t.Errorf("expected the state to hold the %d writes in the stream, got %d", expected, writes)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func Test_LazyFree(t *testing.T) {
+	server := createSugarDBWithConfig(config.Config{
+		DataDir:           "",
+		EvictionPolicy:    constants.NoEviction,
+		LazyFreeServerDel: true,
+	})
+	t.Cleanup(server.ShutDown)
+	server.getOrCreateDatabase(0)
+	empty := server.memUsed.Load()
+
+	// waitForFreed waits until the lazy free goroutine released the given number of values since startup.
+	waitForFreed := func(freed int64) {
+		t.Helper()
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if server.lazyFree.pending.Load() == 0 && server.lazyFree.freed.Load() == freed {
+				return
+			}
+		}
+		t.Fatalf("expected %d values to be released with none pending, got %d released and %d pending",
+			freed, server.lazyFree.freed.Load(), server.lazyFree.pending.Load())
+	}
+	largeHash := func(key string) {
+		t.Helper()
+		fields := make(map[string]string, 2*lazyFreeThreshold)
+		for i := 0; i < 2*lazyFreeThreshold; i++ {
+			fields[fmt.Sprintf("field%d", i)] = "value"
+		}
+		if _, err := server.HSet(key, fields); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unlinking a large value removes its memory from the memory usage right away.
+	largeHash("hash")
+	before := server.memUsed.Load()
+	mem, _ := server.memoryUsage(context.WithValue(context.Background(), "Database", 0), "hash")
+	// The slot of the key in the map of its shard becomes overhead once the key is removed.
+	mem -= keySlotSize
+	if n, err := server.Unlink("hash", "missing"); err != nil || n != 1 {
+		t.Fatalf("expected 1 key to be unlinked, got %d (%v)", n, err)
+	}
+	if used := server.memUsed.Load(); used != before-mem {
+		t.Errorf("expected memory usage of %d after unlinking, got %d", before-mem, used)
+	}
+	if n, _ := server.Exists("hash"); n != 0 {
+		t.Error("expected the unlinked key to be removed")
+	}
+	waitForFreed(1)
+
+	// Small values are not handed over to the lazy free goroutine.
+	if _, _, err := server.Set("small", "value", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Unlink("small"); err != nil {
+		t.Fatal(err)
+	}
+	waitForFreed(1)
+
+	// A value mutated in place and set again is not released, while a value that is overwritten is.
+	largeHash("hash")
+	ctx := context.WithValue(context.Background(), "Database", 0)
+	value := server.getValues(ctx, []string{"hash"})["hash"].(hash.Hash)
+	value["another"] = hash.HashValue{Value: "value"}
+	if err := server.setValues(ctx, map[string]interface{}{"hash": value}); err != nil {
+		t.Fatal(err)
+	}
+	waitForFreed(1)
+	if _, _, err := server.Set("hash", "value", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForFreed(2)
+
+	// An asynchronous flush hands over the maps of the shards that hold many keys, and restores the memory usage
+	// of an empty database.
+	for i := 0; i < shardCount*2*lazyFreeThreshold; i++ {
+		if _, _, err := server.Set(fmt.Sprintf("key%d", i), "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.FlushAsync(0)
+	if size := server.dbSize(context.WithValue(context.Background(), "Database", 0)); size != 0 {
+		t.Errorf("expected no keys after the flush, got %d", size)
+	}
+	if used := server.memUsed.Load(); used != empty {
+		t.Errorf("expected the memory usage of an empty database of %d after the flush, got %d", empty, used)
+	}
+	waitForFreed(2 + shardCount)
+}
+
+func Test_CompactEncodings(t *testing.T) {
+	compact := createSugarDBWithConfig(config.Config{
+		DataDir:                "",
+		EvictionPolicy:         constants.NoEviction,
+		HashMaxListpackEntries: 4,
+		HashMaxListpackValue:   16,
+		SetMaxIntsetEntries:    4,
+		SetMaxListpackEntries:  4,
+		SetMaxListpackValue:    16,
+		ZSetMaxListpackEntries: 4,
+		ZSetMaxListpackValue:   16,
+		ListMaxListpackEntries: 4,
+		ListMaxListpackValue:   16,
+	})
+	t.Cleanup(compact.ShutDown)
+	full := createSugarDBWithConfig(config.Config{DataDir: "", EvictionPolicy: constants.NoEviction})
+	t.Cleanup(full.ShutDown)
+	ctx := context.WithValue(context.Background(), "Database", 0)
+
+	expectEncoding := func(key string, expected string) {
+		t.Helper()
+		if encoding, err := compact.ObjectEncoding(key); err != nil || encoding != expected {
+			t.Errorf("expected encoding of %s to be %s, got %s (%v)", key, expected, encoding, err)
+		}
+	}
+	// expectSmaller checks that the compact encoding of the key uses less memory than the full structure.
+	expectSmaller := func(key string) {
+		t.Helper()
+		compactSize, _ := compact.memoryUsage(ctx, key)
+		fullSize, _ := full.memoryUsage(ctx, key)
+		if compactSize >= fullSize {
+			t.Errorf("expected %s to use less than %d bytes in the compact encoding, got %d", key, fullSize, compactSize)
+		}
+	}
+	both := func(fn func(server *SugarDB) error) {
+		t.Helper()
+		for _, server := range []*SugarDB{compact, full} {
+			if err := fn(server); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("hash", func(t *testing.T) {
+		both(func(server *SugarDB) error {
+			_, err := server.HSet("hash", map[string]string{"field1": "value1", "field2": "2", "field3": "3.5"})
+			return err
+		})
+		expectEncoding("hash", "listpack")
+		expectSmaller("hash")
+		if values, err := compact.HGet("hash", "field1", "field2", "field3"); err != nil ||
+			!slices.Equal(values, []string{"value1", "2", "3.5"}) {
+			t.Errorf("expected the fields of the compact hash to be read back, got %v (%v)", values, err)
+		}
+		if _, err := compact.HIncrBy("hash", "field2", 3); err != nil {
+			t.Fatal(err)
+		}
+		if values, _ := compact.HGet("hash", "field2"); !slices.Equal(values, []string{"5"}) {
+			t.Errorf("expected the incremented field to be 5, got %v", values)
+		}
+
+		// The hash is converted once it has too many fields, and converted back once it's small enough again.
+		if _, err := compact.HSet("hash", map[string]string{"field4": "value4", "field5": "value5"}); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("hash", "hashtable")
+		if _, err := compact.HDel("hash", "field4", "field5"); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("hash", "listpack")
+
+		// Long values and field expiries are only held in a hashtable.
+		if _, err := compact.HSet("hash", map[string]string{"field4": strings.Repeat("a", 17)}); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("hash", "hashtable")
+		if _, err := compact.HDel("hash", "field4"); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("hash", "listpack")
+		if _, err := compact.HExpire("hash", 100, nil, "field1"); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("hash", "hashtable")
+		if ttl, err := compact.HTTL("hash", "field1"); err != nil || len(ttl) != 1 || ttl[0] <= 0 {
+			t.Errorf("expected the field expiry to be kept, got %v (%v)", ttl, err)
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		both(func(server *SugarDB) error {
+			_, err := server.SAdd("set", "1", "2", "3")
+			return err
+		})
+		expectEncoding("set", "intset")
+		expectSmaller("set")
+
+		// Members that are not integers in their canonical form convert the set to a listpack.
+		if _, err := compact.SAdd("set", "04"); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("set", "listpack")
+		if _, err := compact.SAdd("set", "5"); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("set", "hashtable")
+		members, err := compact.SMembers("set")
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(members)
+		if !slices.Equal(members, []string{"04", "1", "2", "3", "5"}) {
+			t.Errorf("expected the members to be kept across conversions, got %v", members)
+		}
+		if n, err := compact.SRem("set", "04", "5"); err != nil || n != 2 {
+			t.Errorf("expected 2 members to be removed, got %d (%v)", n, err)
+		}
+		if ok, err := compact.SisMember("set", "2"); err != nil || !ok {
+			t.Errorf("expected 2 to be a member of the set, got %v (%v)", ok, err)
+		}
+	})
+
+	t.Run("sorted set", func(t *testing.T) {
+		both(func(server *SugarDB) error {
+			_, err := server.ZAdd("zset", map[string]float64{"one": 1, "two": 2, "three": 3}, ZAddOptions{})
+			return err
+		})
+		expectEncoding("zset", "listpack")
+		expectSmaller("zset")
+		if _, err := compact.ZAdd("zset", map[string]float64{"two": 20}, ZAddOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if score, err := compact.ZScore("zset", "two"); err != nil || score != float64(20) {
+			t.Errorf("expected the score of two to be updated to 20, got %v (%v)", score, err)
+		}
+		if card, err := compact.ZCard("zset"); err != nil || card != 3 {
+			t.Errorf("expected 3 members after the update, got %d (%v)", card, err)
+		}
+		if _, err := compact.ZAdd("zset", map[string]float64{strings.Repeat("a", 17): 4}, ZAddOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("zset", "hashtable")
+		if card, err := compact.ZCard("zset"); err != nil || card != 4 {
+			t.Errorf("expected 4 members after the conversion, got %d (%v)", card, err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		both(func(server *SugarDB) error {
+			_, err := server.RPush("list", "one", "two", "three")
+			return err
+		})
+		expectEncoding("list", "listpack")
+		expectSmaller("list")
+		if _, err := compact.RPush("list", "four", "five"); err != nil {
+			t.Fatal(err)
+		}
+		expectEncoding("list", "array")
+		if values, err := compact.LRange("list", 0, -1); err != nil ||
+			!slices.Equal(values, []string{"one", "two", "three", "four", "five"}) {
+			t.Errorf("expected the elements to be kept in order, got %v (%v)", values, err)
+		}
+	})
+}
+
+func Test_TieredStorage(t *testing.T) {
+	t.Run("config", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			config  config.Config
+			wantErr string
+		}{
+			{
+				name:    "1. Tiered storage requires a data directory",
+				config:  config.Config{EvictionPolicy: constants.AllKeysLRU, MaxMemory: 1 << 20},
+				wantErr: "tiered storage requires a data directory",
+			},
+			{
+				name:    "2. Tiered storage requires a max memory",
+				config:  config.Config{DataDir: t.TempDir(), EvictionPolicy: constants.AllKeysLRU},
+				wantErr: "tiered storage requires a max memory",
+			},
+			{
+				name:    "3. Tiered storage requires an LFU or LRU eviction policy",
+				config:  config.Config{DataDir: t.TempDir(), EvictionPolicy: constants.VolatileTTL, MaxMemory: 1 << 20},
+				wantErr: "tiered storage requires an LFU or LRU eviction policy",
+			},
+		}
+		for _, test := range tests {
+			test.config.TieredStorage = true
+			if _, err := NewSugarDB(WithConfig(test.config)); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: expected error \"%s\", got %v", test.name, test.wantErr, err)
+			}
+		}
+	})
+
+	t.Run("spill and load back values of each type", func(t *testing.T) {
+		server, err := NewSugarDB(WithConfig(config.Config{
+			DataDir:        t.TempDir(),
+			EvictionPolicy: constants.AllKeysLFU,
+			EvictionSample: 20,
+			MaxMemory:      1 << 30,
+			TieredStorage:  true,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.ShutDown)
+
+		if _, _, err = server.Set("string", "value", SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.HSet("hash", map[string]string{"field1": "value1", "field2": "value2"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.RPush("list", "one", "two", "three"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.SAdd("set", "one", "two", "three"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.ZAdd("zset", map[string]float64{"one": 1, "two": 2}, ZAddOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{"string", "hash", "list", "set", "zset"}
+		db := server.getDatabase(0)
+
+		before := server.memUsed.Load()
+		for _, key := range keys {
+			if ok, err := server.spillKey(db, key); err != nil || !ok {
+				t.Fatalf("expected %s to be spilled, got %v (%v)", key, ok, err)
+			}
+		}
+		if used := server.memUsed.Load(); used >= before {
+			t.Errorf("expected the memory usage to drop below %d after spilling, got %d", before, used)
+		}
+		// The keys stay in memory, and the copies of the state read the values from disk.
+		if n, _ := server.Exists(keys...); n != len(keys) {
+			t.Errorf("expected %d keys to exist, got %d", len(keys), n)
+		}
+		state, err := server.getState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := state[0]["string"].(internal.KeyData).Value; value != "value" {
+			t.Errorf("expected the state to hold the spilled value \"value\", got %v", value)
+		}
+		if stats := server.MemoryStats().Tiered; stats.Keys != 5 || stats.Spills != 5 || stats.DiskBytes <= 0 {
+			t.Errorf("expected 5 spilled keys on disk, got %+v", stats)
+		}
+
+		// Reading the keys loads the values back into memory.
+		if value, err := server.Get("string"); err != nil || value != "value" {
+			t.Errorf("expected string to be \"value\", got %q (%v)", value, err)
+		}
+		if values, err := server.HGetAll("hash"); err != nil || len(values) != 4 {
+			t.Errorf("expected hash to hold 2 fields, got %v (%v)", values, err)
+		}
+		if values, err := server.LRange("list", 0, -1); err != nil || !slices.Equal(values, []string{"one", "two", "three"}) {
+			t.Errorf("expected list to hold the elements in order, got %v (%v)", values, err)
+		}
+		if values, err := server.SMembers("set"); err != nil || len(values) != 3 {
+			t.Errorf("expected set to hold 3 members, got %v (%v)", values, err)
+		}
+		if score, err := server.ZScore("zset", "two"); err != nil || score != float64(2) {
+			t.Errorf("expected the score of two to be 2, got %v (%v)", score, err)
+		}
+		stats := server.MemoryStats().Tiered
+		if stats.Keys != 0 || stats.Misses != 5 || stats.Faults != 5 {
+			t.Errorf("expected the 5 values to be loaded back, got %+v", stats)
+		}
+		if _, err = server.Get("string"); err != nil {
+			t.Fatal(err)
+		}
+		if hits := server.MemoryStats().Tiered.Hits; hits != stats.Hits+1 {
+			t.Errorf("expected %d hits, got %d", stats.Hits+1, hits)
+		}
+
+		// The value of a key that is deleted is removed from disk.
+		if _, err = server.spillKey(db, "string"); err != nil {
+			t.Fatal(err)
+		}
+		entry, _ := db.get("string")
+		spilled := entry.Value.(spilledValue)
+		if _, err = server.Del("string"); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			if _, err = server.tiered.store.Get(spilled.id); errors.Is(err, tiered.ErrNotFound) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the value of the deleted key to be removed from disk, got %v", err)
+			}
+		}
+		if keys := server.MemoryStats().Tiered.Keys; keys != 0 {
+			t.Errorf("expected no spilled keys, got %d", keys)
+		}
+
+		// A copy of the state fails when a spilled value can't be read back from disk.
+		if _, err = server.spillKey(db, "set"); err != nil {
+			t.Fatal(err)
+		}
+		entry, _ = db.get("set")
+		if err = server.tiered.store.Delete(entry.Value.(spilledValue).id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = server.getState(); err == nil {
+			t.Error("expected error when copying a spilled value that can't be read")
+		}
+	})
+
+	t.Run("spill values when the max memory is exceeded", func(t *testing.T) {
+		server, err := NewSugarDB(WithConfig(config.Config{
+			DataDir:          t.TempDir(),
+			EvictionPolicy:   constants.AllKeysLRU,
+			EvictionSample:   20,
+			EvictionInterval: 30 * time.Second,
+			MaxMemory:        12400,
+			TieredStorage:    true,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.ShutDown)
+
+		value := strings.Repeat("x", 1000)
+		for i := 0; i < 10; i++ {
+			if _, _, err = server.Set(fmt.Sprintf("key%d", i), value, SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for deadline := time.Now().Add(5 * time.Second); server.isMaxMemoryExceeded(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("expected values to be spilled")
+			}
+		}
+
+		// No key is evicted, and each value is read back.
+		if spilled := server.MemoryStats().Tiered.Keys; spilled == 0 {
+			t.Error("expected values to be spilled")
+		}
+		for i := 0; i < 10; i++ {
+			if got, err := server.Get(fmt.Sprintf("key%d", i)); err != nil || got != value {
+				t.Errorf("expected key%d to hold its value, got %d bytes (%v)", i, len(got), err)
+			}
+		}
+		if faults := server.MemoryStats().Tiered.Faults; faults == 0 {
+			t.Error("expected spilled values to be loaded back")
+		}
+	})
+
+	t.Run("spill a collection that grows in place", func(t *testing.T) {
+		server, err := NewSugarDB(WithConfig(config.Config{
+			DataDir:          t.TempDir(),
+			EvictionPolicy:   constants.AllKeysLRU,
+			EvictionSample:   20,
+			EvictionInterval: 30 * time.Second,
+			MaxMemory:        20000,
+			TieredStorage:    true,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(server.ShutDown)
+
+		member := strings.Repeat("x", 50)
+		for i := 0; i < 500; i++ {
+			if _, err = server.SAdd("set", fmt.Sprintf("%s-%d", member, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for deadline := time.Now().Add(5 * time.Second); server.isMaxMemoryExceeded(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("expected the set to be spilled")
+			}
+		}
+
+		if spills := server.MemoryStats().Tiered.Spills; spills == 0 {
+			t.Error("expected the set to be spilled")
+		}
+		if n, err := server.SCard("set"); err != nil || n != 500 {
+			t.Errorf("expected the set to hold 500 members, got %d (%v)", n, err)
+		}
+	})
+}
+
+func Test_MemoryQuotas(t *testing.T) {
+	t.Run("config", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			policy  string
+			quotas  []MemoryQuota
+			wantErr string
+		}{
+			{
+				name:    "1. The max memory of a quota must be greater than 0",
+				policy:  constants.NoEviction,
+				quotas:  []MemoryQuota{{Database: 0, Prefix: "tenant:"}},
+				wantErr: "memory quota db 0 prefix tenant:: max memory must be greater than 0",
+			},
+			{
+				name:    "2. The policy of a quota must be valid",
+				policy:  constants.NoEviction,
+				quotas:  []MemoryQuota{{Database: 0, MaxMemory: 1000, Policy: "allkeys-fifo"}},
+				wantErr: "memory quota db 0: policy allkeys-fifo is not a valid policy",
+			},
+			{
+				name:    "3. An LFU quota requires an LFU eviction policy",
+				policy:  constants.AllKeysLRU,
+				quotas:  []MemoryQuota{{Database: 0, MaxMemory: 1000, Policy: constants.AllKeysLFU}},
+				wantErr: "requires an eviction policy that tracks the same accesses",
+			},
+			{
+				name:    "4. An LRU quota requires an LRU eviction policy",
+				policy:  constants.NoEviction,
+				quotas:  []MemoryQuota{{Database: 0, MaxMemory: 1000, Policy: constants.VolatileLRU}},
+				wantErr: "requires an eviction policy that tracks the same accesses",
+			},
+			{
+				name:   "5. A quota can't be defined more than once",
+				policy: constants.NoEviction,
+				quotas: []MemoryQuota{
+					{Database: 2, Prefix: "tenant:", MaxMemory: 1000},
+					{Database: 2, Prefix: "tenant:", MaxMemory: 2000},
+				},
+				wantErr: "memory quota db 2 prefix tenant: is defined more than once",
+			},
+		}
+		for _, test := range tests {
+			_, err := NewSugarDB(
+				WithConfig(config.Config{DataDir: "", EvictionPolicy: test.policy}),
+				WithMemoryQuotas(test.quotas),
+			)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: expected error \"%s\", got %v", test.name, test.wantErr, err)
+			}
+		}
+	})
+
+	server, err := NewSugarDB(
+		WithConfig(config.Config{
+			DataDir:          "",
+			EvictionPolicy:   constants.AllKeysLRU,
+			EvictionSample:   20,
+			EvictionInterval: 30 * time.Second,
+		}),
+		WithMemoryQuotas([]MemoryQuota{
+			{Database: 0, Prefix: "tenant:1:*", MaxMemory: 5000, Policy: constants.AllKeysLRU},
+			{Database: 1, MaxMemory: 3000, Policy: constants.VolatileTTL},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.ShutDown)
+	value := strings.Repeat("x", 1000)
+
+	// quota returns the usage of the quota of the database with the prefix.
+	quota := func(t *testing.T, database int, prefix string) MemoryQuotaStats {
+		t.Helper()
+		for _, stats := range server.MemoryQuotas() {
+			if stats.Database == database && stats.Prefix == prefix {
+				return stats
+			}
+		}
+		t.Fatalf("expected a quota on db %d prefix %s, got %v", database, prefix, server.MemoryQuotas())
+		return MemoryQuotaStats{}
+	}
+	waitForQuota := func(t *testing.T, database int, prefix string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if stats := quota(t, database, prefix); stats.Used < int64(stats.MaxMemory) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected keys of the quota on db %d prefix %s to be evicted", database, prefix)
+			}
+		}
+	}
+
+	t.Run("evict the keys of a prefix with the policy of the quota", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			for _, tenant := range []string{"tenant:1", "tenant:2"} {
+				if _, _, err := server.Set(fmt.Sprintf("%s:key%d", tenant, i), value, SETOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if stats := quota(t, 0, "tenant:1:"); stats.Used <= 4000 || stats.Used >= 5000 {
+			t.Fatalf("expected the 4 keys of the prefix to use between 4000 and 5000 bytes, got %d", stats.Used)
+		}
+		// Wait for the LRU clock to tick, then access key0 so that it's the most recently used key of the prefix.
+		time.Sleep(2 * eviction.LRUClockResolution)
+		if _, err := server.Get("tenant:1:key0"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Exceed the quota.
+		if _, _, err := server.Set("tenant:1:key4", value, SETOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		waitForQuota(t, 0, "tenant:1:")
+
+		if n, err := server.Exists("tenant:1:key0", "tenant:1:key4"); err != nil || n != 2 {
+			t.Errorf("expected the most recently used keys to be kept, got %d (%v)", n, err)
+		}
+		if n, _ := server.Exists("tenant:1:key1", "tenant:1:key2", "tenant:1:key3"); n != 2 {
+			t.Errorf("expected 1 of the least recently used keys to be evicted, got %d keys", n)
+		}
+		if n, _ := server.Exists("tenant:2:key0", "tenant:2:key1", "tenant:2:key2", "tenant:2:key3"); n != 4 {
+			t.Errorf("expected the keys outside of the quota to be kept, got %d keys", n)
+		}
+		if evicted := quota(t, 0, "tenant:1:").Evicted; evicted != 1 {
+			t.Errorf("expected 1 key evicted by the quota, got %d", evicted)
+		}
+	})
+
+	t.Run("evict the keys of a database with the policy of the quota", func(t *testing.T) {
+		if err := server.SelectDB(1); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = server.SelectDB(0)
+		})
+		for i, key := range []string{"key0", "key1", "key2"} {
+			if _, _, err := server.Set(key, value, SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			// The keys with an expiry are evicted with the volatile-ttl policy, the closest to expiring first.
+			if i < 2 {
+				if _, err := server.Expire(key, (2-i)*100); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		waitForQuota(t, 1, "")
+
+		if n, _ := server.Exists("key0"); n != 1 {
+			t.Error("expected key0 to be kept")
+		}
+		if n, _ := server.Exists("key1"); n != 0 {
+			t.Error("expected key1 to be evicted")
+		}
+		if n, _ := server.Exists("key2"); n != 1 {
+			t.Error("expected key2 without an expiry to be kept")
+		}
+
+		// The usage of the quota is reset by a flush.
+		server.Flush(1)
+		if used := quota(t, 1, "").Used; used != 0 {
+			t.Errorf("expected no usage after the flush, got %d", used)
+		}
+	})
+
+	t.Run("reject the writes to the keys of a quota", func(t *testing.T) {
+		rejecting, err := NewSugarDB(
+			WithConfig(config.Config{DataDir: "", EvictionPolicy: constants.NoEviction}),
+			WithMemoryQuotas([]MemoryQuota{{Database: 0, Prefix: "tenant:1:", MaxMemory: 2000}}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(rejecting.ShutDown)
+
+		for _, key := range []string{"tenant:1:key0", "tenant:1:key1"} {
+			if _, _, err = rejecting.Set(key, value, SETOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, _, err = rejecting.Set("tenant:1:key2", value, SETOptions{}); err == nil ||
+			!strings.Contains(err.Error(), "memory quota db 0 prefix tenant:1: reached") {
+			t.Errorf("expected the write to be rejected, got %v", err)
+		}
+		if _, _, err = rejecting.Set("tenant:2:key0", value, SETOptions{}); err != nil {
+			t.Errorf("expected the write outside of the quota to succeed, got %v", err)
+		}
+		// Deleting a key of the quota makes room for another write.
+		if _, err = rejecting.Del("tenant:1:key0"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = rejecting.Set("tenant:1:key2", value, SETOptions{}); err != nil {
+			t.Errorf("expected the write to succeed after a delete, got %v", err)
+		}
+		stats := rejecting.MemoryQuotas()
+		if len(stats) != 1 || stats[0].Policy != constants.NoEviction || stats[0].Rejected != 1 || stats[0].Evicted != 0 {
+			t.Errorf("expected 1 write rejected by the quota, got %+v", stats)
+		}
+
+		// Growing an existing set in place is rejected once the quota is reached.
+		if _, err = rejecting.Del("tenant:1:key1", "tenant:1:key2"); err != nil {
+			t.Fatal(err)
+		}
+		var grown error
+		for i := 0; i < 1000 && grown == nil; i++ {
+			_, grown = rejecting.SAdd("tenant:1:set", fmt.Sprintf("%s-%d", value[:100], i))
+		}
+		if grown == nil || !strings.Contains(grown.Error(), "memory quota db 0 prefix tenant:1: reached") {
+			t.Errorf("expected growing the set to be rejected, got %v", grown)
+		}
+		if used := rejecting.MemoryQuotas()[0].Used; used > 2000+200 {
+			t.Errorf("expected the set to stop growing once the quota is reached, got %d bytes used", used)
+		}
+		// Removing members of the set is still allowed.
+		if n, err := rejecting.SRem("tenant:1:set", fmt.Sprintf("%s-%d", value[:100], 0)); err != nil || n != 1 {
+			t.Errorf("expected the member to be removed, got %d (%v)", n, err)
+		}
+	})
+}
+
+func Test_ClusterEviction(t *testing.T) {
+	// Set up a leader and a follower with a max memory that fits about 7 values of 1000 bytes.
+	var nodes [2]*SugarDB
+	var clients [2]*resp.Conn
+	var joinAddr string
+	for i := range nodes {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
+		}
+		discoveryPort, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free memberlist port: %v", err)
+		}
+
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = getBindAddr().String()
+		conf.Port = uint16(port)
+		conf.ServerID = fmt.Sprintf("EVICTION-TEST-%d", i)
+		conf.DiscoveryPort = uint16(discoveryPort)
+		conf.MaxMemory = 14000
+		conf.EvictionPolicy = constants.AllKeysLFU
+		conf.EvictionInterval = 50 * time.Millisecond
+		if i == 0 {
+			conf.BootstrapCluster = true
+			joinAddr = fmt.Sprintf("%s/%s:%d", conf.ServerID, conf.BindAddr, discoveryPort)
+		} else {
+			conf.JoinAddr = joinAddr
+		}
+
+		server, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
+		}
+		go server.Start()
+		for i == 0 && !server.raft.IsRaftLeader() || i > 0 && !server.raft.HasJoinedCluster() {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		conn, err := internal.GetConnection(conf.BindAddr, port)
+		if err != nil {
+			t.Fatalf("could not open tcp connection: %v", err)
+		}
+		nodes[i], clients[i] = server, resp.NewConn(conn)
+	}
+	t.Cleanup(func() {
+		for i := len(nodes) - 1; i > -1; i-- {
+			nodes[i].ShutDown()
+		}
+	})
+
+	do := func(client *resp.Conn, cmd ...string) resp.Value {
+		command := make([]resp.Value, len(cmd))
+		for i, c := range cmd {
+			command[i] = resp.StringValue(c)
+		}
+		if err := client.WriteArray(command); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := client.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	// keys returns the keys that exist on the node once the follower has caught up with the leader.
+	keys := func(node int) []string {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+			if nodes[1].raft.AppliedIndex() >= nodes[0].raft.AppliedIndex() || time.Now().After(deadline) {
+				break
+			}
+		}
+		var res []string
+		for _, key := range []string{
+			"key0", "key1", "key2", "key3", "key4", "key5", "key6", "key7", "key8", "key9", "volatile",
+		} {
+			if do(clients[node], "EXISTS", key).Integer() == 1 {
+				res = append(res, key)
+			}
+		}
+		return res
+	}
+
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 5; i++ {
+		if res := do(clients[0], "SET", fmt.Sprintf("key%d", i), value); res.String() != "OK" {
+			t.Fatalf("expected OK, got %s", res.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Reads served by the follower are reported to the leader, so key0 becomes the most frequently used key.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if res := do(clients[1], "GET", "key0"); res.String() == value {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected follower to serve key0, got %s", res.String())
+		}
+	}
+	for i := 0; i < 5; i++ {
+		do(clients[1], "GET", "key0")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	// Exceed the max memory. The leader evicts the least frequently used keys and the follower removes the same keys.
+	for i := 5; i < 10; i++ {
+		if res := do(clients[0], "SET", fmt.Sprintf("key%d", i), value); res.String() != "OK" {
+			t.Fatalf("expected OK, got %s", res.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var leaderKeys []string
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if leaderKeys = keys(0); len(leaderKeys) < 10 && !nodes[0].isMaxMemoryExceeded() {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected the leader to evict keys, got %v", leaderKeys)
+		}
+	}
+	if !slices.Contains(leaderKeys, "key0") {
+		t.Errorf("expected key0 to be kept, got %v", leaderKeys)
+	}
+	if followerKeys := keys(1); !slices.Equal(leaderKeys, followerKeys) {
+		t.Errorf("expected follower keys %v, got %v", leaderKeys, followerKeys)
+	}
+
+	// Expired keys read on the follower are removed by the leader through the raft log.
+	expireAt := nodes[0].clock.Now().Add(-time.Millisecond).UnixMilli()
+	if res := do(clients[0], "SET", "volatile", "value", "PXAT", strconv.FormatInt(expireAt, 10)); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	if res := do(clients[1], "GET", "volatile"); !res.IsNull() {
+		t.Errorf("expected expired key to be nil, got %s", res.String())
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if !slices.Contains(keys(0), "volatile") && !slices.Contains(keys(1), "volatile") {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("expected the expired key to be removed from every node")
+		}
+	}
+}
+
+// writeRaftCertificates writes a certificate authority and a certificate signed by it for the server name to dir.
+func writeRaftCertificates(t *testing.T, dir, serverName string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sugardb-raft-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile, certFile, keyFile = path.Join(dir, "ca.crt"), path.Join(dir, "node.crt"), path.Join(dir, "node.key")
+	for file, block := range map[string]*pem.Block{
+		caFile:   {Type: "CERTIFICATE", Bytes: caDER},
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err = os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return caFile, certFile, keyFile
+}
+
+func Test_SecureCluster(t *testing.T) {
+	dir := t.TempDir()
+	caFile, certFile, keyFile := writeRaftCertificates(t, dir, "sugardb-raft")
+	gossipKeyFile := path.Join(dir, "gossip.key")
+	if err := os.WriteFile(gossipKeyFile, []byte("# primary key first\n"+strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// mTLS without authorities would trust any certificate signed by the system roots.
+	if _, err := NewSugarDB(
+		WithConfig(DefaultConfig()),
+		WithRaftMTLS(),
+		WithRaftCertKeyPairs([]CertKeyPair{{Cert: certFile, Key: keyFile}}),
+	); err == nil || !strings.Contains(err.Error(), "certificate authority") {
+		t.Errorf("expected certificate authority error for raft mTLS without CAs, got %v", err)
+	}
+
+	// Set up a two node cluster with mTLS between the raft nodes and encrypted gossip.
+	var nodes [2]*SugarDB
+	var clients [2]*resp.Conn
+	var joinAddr string
+	for i := range nodes {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
+		}
+		discoveryPort, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free memberlist port: %v", err)
+		}
+
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = getBindAddr().String()
+		conf.Port = uint16(port)
+		conf.ServerID = fmt.Sprintf("SECURE-TEST-%d", i)
+		conf.DiscoveryPort = uint16(discoveryPort)
+		conf.EvictionPolicy = constants.NoEviction
+		if i == 0 {
+			conf.BootstrapCluster = true
+			joinAddr = fmt.Sprintf("%s/%s:%d", conf.ServerID, conf.BindAddr, discoveryPort)
+		} else {
+			conf.JoinAddr = joinAddr
+		}
+
+		server, err := NewSugarDB(
+			WithConfig(conf),
+			WithRaftMTLS(),
+			WithRaftCertKeyPairs([]CertKeyPair{{Cert: certFile, Key: keyFile}}),
+			WithRaftCAs([]string{caFile}),
+			WithRaftTLSServerName("sugardb-raft"),
+			WithGossipKeyFile(gossipKeyFile),
+		)
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
+		}
+		go server.Start()
+		for deadline := time.Now().Add(10 * time.Second); i == 0 && !server.raft.IsRaftLeader() ||
+			i > 0 && !server.raft.HasJoinedCluster(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %d did not join the cluster", i)
+			}
+		}
+
+		conn, err := internal.GetConnection(conf.BindAddr, port)
+		if err != nil {
+			t.Fatalf("could not open tcp connection: %v", err)
+		}
+		nodes[i], clients[i] = server, resp.NewConn(conn)
+	}
+	t.Cleanup(func() {
+		for i := len(nodes) - 1; i > -1; i-- {
+			nodes[i].ShutDown()
+		}
+	})
+
+	if _, err := NewSugarDB(WithBootstrapCluster(), WithRaftTLS()); err == nil {
+		t.Error("expected error when enabling raft TLS without a certificate")
+	}
+
+	// Writes are replicated over the TLS transport.
+	if err := clients[0].WriteArray([]resp.Value{
+		resp.StringValue("SET"), resp.StringValue("key1"), resp.StringValue("value1"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if res, _, err := clients[0].ReadValue(); err != nil || res.String() != "OK" {
+		t.Fatalf("expected OK, got %v %v", res, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if err := clients[1].WriteArray([]resp.Value{resp.StringValue("GET"), resp.StringValue("key1")}); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := clients[1].ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.String() == "value1" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected follower to have the replicated value, got %s", res.String())
+		}
+	}
+
+	// The raft transport rejects connections without a trusted client certificate.
+	raftAddr := fmt.Sprintf("%s:%d", nodes[0].config.RaftBindAddr, nodes[0].config.RaftBindPort)
+	conn, err := tls.Dial("tcp", raftAddr, &tls.Config{InsecureSkipVerify: true})
+	if err == nil {
+		// With TLS 1.3, the client certificate is verified after the client's handshake completes.
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		_ = conn.Close()
+	}
+	if err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected raft transport to reject a connection without a client certificate, got %v", err)
+	}
+}
+
+func Test_ShardedCluster(t *testing.T) {
+	// Set up two shards with one node each.
+	var shards [2]ClientServerPair
+	for i := range shards {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
+		}
+		discoveryPort, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free memberlist port: %v", err)
+		}
+		shards[i] = ClientServerPair{
+			serverId:         fmt.Sprintf("SHARD-SERVER-%d", i),
+			bindAddr:         getBindAddr().String(),
+			port:             port,
+			discoveryPort:    discoveryPort,
+			bootstrapCluster: true,
+		}
+		if i > 0 {
+			shards[i].joinAddr = fmt.Sprintf("%s/%s:%d", shards[0].serverId, shards[0].bindAddr, shards[0].discoveryPort)
+		}
+
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = shards[i].bindAddr
+		conf.JoinAddr = shards[i].joinAddr
+		conf.Port = uint16(port)
+		conf.ServerID = shards[i].serverId
+		conf.DiscoveryPort = uint16(discoveryPort)
+		conf.BootstrapCluster = true
+		conf.ShardID = fmt.Sprintf("shard-%d", i)
+		conf.EvictionPolicy = constants.NoEviction
+
+		server, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
+		}
+		go server.Start()
+		for !server.raft.IsRaftLeader() {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		conn, err := internal.GetConnection(shards[i].bindAddr, port)
+		if err != nil {
+			t.Fatalf("could not open tcp connection: %v", err)
+		}
+		shards[i].raw = conn
+		shards[i].client = resp.NewConn(conn)
+		shards[i].server = server
+	}
+
+	t.Cleanup(func() {
+		for i := len(shards) - 1; i > -1; i-- {
+			_ = shards[i].raw.Close()
+			shards[i].server.ShutDown()
+		}
+	})
+
+	do := func(node ClientServerPair, cmd ...string) resp.Value {
+		command := make([]resp.Value, len(cmd))
+		for i, c := range cmd {
+			command[i] = resp.StringValue(c)
+		}
+		if err := node.client.WriteArray(command); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := node.client.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	// eventually retries the check until it passes or the timeout is reached.
+	eventually := func(check func() error) {
+		var err error
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			if err = check(); err == nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatal(err)
+	}
+
+	// Slot 5061 ("bar") is assigned to shard-0 and slot 12182 ("foo") is assigned to shard-1.
+	if res := do(shards[0], "CLUSTER", "ADDSLOTSRANGE", "0", "8191"); res.String() != "OK" {
+		t.Fatalf("expected OK adding slots to shard-0, got %s", res.String())
+	}
+	if res := do(shards[1], "CLUSTER", "ADDSLOTSRANGE", "8192", "16383"); res.String() != "OK" {
+		t.Fatalf("expected OK adding slots to shard-1, got %s", res.String())
+	}
+
+	for i := range shards {
+		eventually(func() error {
+			if res := do(shards[i], "CLUSTER", "SLOTS"); len(res.Array()) != 2 {
+				return fmt.Errorf("expected node %d to know the slots of both shards, got %v", i, res.Array())
+			}
+			return nil
+		})
+	}
+
+	if res := do(shards[1], "CLUSTER", "ADDSLOTS", "100"); res.Type() != resp.Error ||
+		!strings.Contains(res.Error().Error(), "already owned by shard shard-0") {
+		t.Errorf("expected error adding a slot owned by another shard, got %s", res.String())
+	}
+
+	shard1Addr := fmt.Sprintf("%s:%d", shards[1].bindAddr, shards[1].port)
+
+	t.Run("Test_Redirection", func(t *testing.T) {
+		if res := do(shards[0], "SET", "bar", "value"); res.String() != "OK" {
+			t.Errorf("expected OK setting key in owned slot, got %s", res.String())
+		}
+		if res := do(shards[0], "SET", "foo", "value"); res.Type() != resp.Error ||
+			res.Error().Error() != fmt.Sprintf("MOVED 12182 %s", shard1Addr) {
+			t.Errorf("expected MOVED redirection to %s, got %s", shard1Addr, res.String())
+		}
+		if res := do(shards[0], "MSET", "foo", "1", "bar", "2"); res.Type() != resp.Error ||
+			!strings.HasPrefix(res.Error().Error(), "CROSSSLOT") {
+			t.Errorf("expected CROSSSLOT error, got %s", res.String())
+		}
+		// Keys with the same hashtag are served by the same shard.
+		if res := do(shards[1], "MSET", "{foo}1", "1", "{foo}2", "2"); res.String() != "OK" {
+			t.Errorf("expected OK setting keys with the same hashtag, got %s", res.String())
+		}
+		if res := do(shards[0], "CLUSTER", "MYSHARDID"); res.String() != "shard-0" {
+			t.Errorf("expected shard ID shard-0, got %s", res.String())
+		}
+	})
+
+	t.Run("Test_SlotMigration", func(t *testing.T) {
+		keys := []string{"bar", "{bar}1", "{bar}2"}
+		for _, key := range keys[1:] {
+			if res := do(shards[0], "SET", key, "value"); res.String() != "OK" {
+				t.Fatalf("expected OK setting key %s, got %s", key, res.String())
+			}
+		}
+		// Values of every type must keep their type when they're migrated.
+		for _, cmd := range [][]string{
+			{"SADD", "{bar}set", "a", "b", "c"},
+			{"ZADD", "{bar}zset", "1", "a", "2.5", "b"},
+			{"HSET", "{bar}hash", "field1", "value1", "field2", "2"},
+			{"RPUSH", "{bar}list", "a", "b", "c"},
+			{"SET", "{bar}int", "10"},
+			{"SET", "{bar}float", "1.5"},
+			{"SET", "{bar}ttl", "value", "EX", "1000"},
+		} {
+			if res := do(shards[0], cmd...); res.Type() == resp.Error {
+				t.Fatalf("expected %v to succeed, got %s", cmd, res.String())
+			}
+		}
+
+		// Increment a counter while the slot is migrating. Every acknowledged increment must be migrated.
+		var acknowledged atomic.Int64
+		writerDone := make(chan struct{})
+		go func() {
+			defer close(writerDone)
+			var clients [2]*resp.Conn
+			for i := range shards {
+				conn, err := internal.GetConnection(shards[i].bindAddr, shards[i].port)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer func() {
+					_ = conn.Close()
+				}()
+				clients[i] = resp.NewConn(conn)
+			}
+			incr := func(client *resp.Conn, cmds ...[]resp.Value) (res resp.Value, err error) {
+				for _, cmd := range cmds {
+					if err = client.WriteArray(cmd); err != nil {
+						return res, err
+					}
+					if res, _, err = client.ReadValue(); err != nil {
+						return res, err
+					}
+				}
+				return res, nil
+			}
+			cmd := []resp.Value{resp.StringValue("INCR"), resp.StringValue("{bar}counter")}
+			for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+				res, err := incr(clients[0], cmd)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Type() == resp.Error && !strings.HasPrefix(res.Error().Error(), "TRYAGAIN") {
+					// The counter has moved to shard-1.
+					if res, err = incr(clients[1], []resp.Value{resp.StringValue("ASKING")}, cmd); err != nil {
+						t.Error(err)
+						return
+					}
+					if res.Type() == resp.Integer {
+						acknowledged.Add(1)
+					}
+					return
+				}
+				if res.Type() == resp.Integer {
+					acknowledged.Add(1)
+				}
+			}
+		}()
+		for acknowledged.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if res := do(shards[0], "CLUSTER", "SETSLOT", "5061", "MIGRATING", "shard-1"); res.String() != "OK" {
+			t.Fatalf("expected OK migrating slot, got %s", res.String())
+		}
+
+		// Once the migration is complete, shard-0 redirects the slot to shard-1.
+		eventually(func() error {
+			if res := do(shards[0], "GET", "bar"); res.Error() == nil ||
+				res.Error().Error() != fmt.Sprintf("MOVED 5061 %s", shard1Addr) {
+				return fmt.Errorf("expected MOVED redirection to %s, got %s", shard1Addr, res.String())
+			}
+			return nil
+		})
+
+		for _, key := range keys {
+			if res := do(shards[1], "GET", key); res.String() != "value" {
+				t.Errorf("expected key %s to be migrated to shard-1, got %s", key, res.String())
+			}
+		}
+		if res := do(shards[1], "SMEMBERS", "{bar}set"); res.Type() != resp.Array || len(res.Array()) != 3 {
+			t.Errorf("expected migrated set to have 3 members, got %s", res.String())
+		}
+		if res := do(shards[1], "ZSCORE", "{bar}zset", "b"); res.Float() != 2.5 {
+			t.Errorf("expected migrated sorted set member b to have score 2.5, got %s", res.String())
+		}
+		if res := do(shards[1], "HGET", "{bar}hash", "field2"); len(res.Array()) != 1 || res.Array()[0].String() != "2" {
+			t.Errorf("expected migrated hash field2 to be 2, got %s", res.String())
+		}
+		if res := do(shards[1], "LRANGE", "{bar}list", "0", "-1"); res.Type() != resp.Array ||
+			len(res.Array()) != 3 || res.Array()[0].String() != "a" || res.Array()[2].String() != "c" {
+			t.Errorf("expected migrated list to be [a b c], got %s", res.String())
+		}
+		if res := do(shards[1], "INCR", "{bar}int"); res.Type() != resp.Integer || res.Integer() != 11 {
+			t.Errorf("expected migrated int to be incremented to 11, got %s", res.String())
+		}
+		if res := do(shards[1], "INCRBYFLOAT", "{bar}float", "1"); res.String() != "2.5" {
+			t.Errorf("expected migrated float to be incremented to 2.5, got %s", res.String())
+		}
+		if res := do(shards[1], "TTL", "{bar}ttl"); res.Integer() <= 0 {
+			t.Errorf("expected migrated key to keep its expiry, got TTL %s", res.String())
+		}
+
+		<-writerDone
+		if res := do(shards[1], "GET", "{bar}counter"); res.Integer() != int(acknowledged.Load()) {
+			t.Errorf("expected migrated counter to be %d, got %s", acknowledged.Load(), res.String())
+		}
+		if count := shards[0].server.getKeysInSlot(context.WithValue(context.Background(), "Database", 0), 5061, 0); len(count) != 0 {
+			t.Errorf("expected migrated keys to be deleted from shard-0, got %v", count)
+		}
+
+		eventually(func() error {
+			owner, ok := shards[0].server.slotMap.Owner(5061)
+			if !ok || owner.ID != "shard-1" {
+				return fmt.Errorf("expected slot 5061 to be owned by shard-1, got %+v", owner)
+			}
+			return nil
+		})
+	})
+}
+
+func Test_Replication(t *testing.T) {
+	var nodes [2]*SugarDB
+	var clients [2]*resp.Conn
+	var ports [2]int
+	for i := range nodes {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
+		}
+
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = "localhost"
+		conf.Port = uint16(port)
+		conf.ServerID = fmt.Sprintf("REPLICATION-TEST-%d", i)
+		conf.EvictionPolicy = constants.NoEviction
+
+		server, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
+		}
+		go server.Start()
+
+		conn, err := internal.GetConnection(conf.BindAddr, port)
+		if err != nil {
+			t.Fatalf("could not open tcp connection: %v", err)
+		}
+		nodes[i], clients[i], ports[i] = server, resp.NewConn(conn), port
+	}
+	t.Cleanup(func() {
+		for i := len(nodes) - 1; i > -1; i-- {
+			nodes[i].ShutDown()
+		}
+	})
+	primary, replica := nodes[0], nodes[1]
+
+	do := func(client *resp.Conn, cmd ...string) resp.Value {
+		command := make([]resp.Value, len(cmd))
+		for i, c := range cmd {
+			command[i] = resp.StringValue(c)
+		}
+		if err := client.WriteArray(command); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := client.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	waitFor := func(client *resp.Conn, key, value string) {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+			if res := do(client, "GET", key); res.String() == value {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expected replica to have %s=%s, got %s", key, value, res.String())
+			}
+		}
+	}
+
+	if _, err := NewSugarDB(WithBootstrapCluster(), WithReplicaOf("localhost:7480")); err == nil {
+		t.Error("expected error when starting a replica in cluster mode")
+	}
+
+	// The replica receives the existing data in a full resynchronisation, replacing its own data.
+	if res := do(clients[0], "SET", "key1", "value1"); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	if res := do(clients[1], "SET", "replica-key", "value"); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	if res := do(clients[1], "REPLICAOF", "localhost", strconv.Itoa(ports[0])); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	waitFor(clients[1], "key1", "value1")
+	if res := do(clients[1], "GET", "replica-key"); !res.IsNull() {
+		t.Errorf("expected the full resynchronisation to replace the replica's data, got %s", res.String())
+	}
+	// A snapshot that can't be read leaves the replica's data intact.
+	if err := replica.replicationFullSync(strings.NewReader("not a snapshot")); err == nil {
+		t.Error("expected error when loading an invalid snapshot")
+	}
+	if res := do(clients[1], "GET", "key1"); res.String() != "value1" {
+		t.Errorf("expected a failed full resynchronisation to keep the replica's data, got %s", res.String())
+	}
+
+	// Live writes are streamed to the replica, in the database they were made in.
+	do(clients[0], "SELECT", "1")
+	do(clients[1], "SELECT", "1")
+	if res := do(clients[0], "SET", "key2", "value2"); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	waitFor(clients[1], "key2", "value2")
+	if res := do(clients[1], "EXISTS", "key1"); res.Integer() != 0 {
+		t.Errorf("expected key1 not to exist in database 1 of the replica")
+	}
+
+	// WAIT counts the replicas that acknowledged the latest write of the connection.
+	if res := do(clients[0], "SET", "key2", "value2"); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	if res := do(clients[0], "WAIT", "1", "5000"); res.Integer() != 1 {
+		t.Errorf("expected WAIT 1 to return 1, got %s", res.String())
+	}
+	if res := do(clients[0], "WAIT", "2", "100"); res.Integer() != 1 {
+		t.Errorf("expected WAIT 2 to time out with 1 replica, got %s", res.String())
+	}
+
+	// The replica rejects writes from clients.
+	if res := do(clients[1], "SET", "key3", "value3"); res.Error() == nil ||
+		!strings.Contains(res.Error().Error(), "READONLY") {
+		t.Errorf("expected READONLY error, got %s", res.String())
+	}
+	if _, _, err := replica.Set("key3", "value3", SETOptions{}); err == nil || !strings.Contains(err.Error(), "READONLY") {
+		t.Errorf("expected READONLY error from the embedded API, got %v", err)
+	}
+
+	// ROLE describes both ends of the link.
+	role := do(clients[0], "ROLE").Array()
+	if len(role) != 3 || role[0].String() != "master" || len(role[2].Array()) != 1 {
+		t.Errorf("expected master role with 1 replica, got %v", role)
+	}
+	role = do(clients[1], "ROLE").Array()
+	if len(role) != 5 || role[0].String() != "replica" || role[2].Integer() != ports[0] ||
+		role[3].String() != "connected" {
+		t.Errorf("expected connected replica role, got %v", role)
+	}
+	if info := replica.GetServerInfo(); info.Role != "replica" {
+		t.Errorf("expected server info role replica, got %s", info.Role)
+	}
+
+	// After a short disconnection, the replica continues the stream from the backlog instead of performing
+	// a full resynchronisation, which would remove a key that only exists on the replica.
+	ctx := context.WithValue(context.Background(), "Database", 1)
+	if err := replica.setValues(ctx, map[string]interface{}{"replica-only": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	primary.replication.replicasMut.Lock()
+	for conn := range primary.replication.replicas {
+		_ = (*conn).Close()
+	}
+	primary.replication.replicasMut.Unlock()
+	if res := do(clients[0], "SET", "key4", "value4"); res.String() != "OK" {
+		t.Fatalf("expected OK, got %s", res.String())
+	}
+	waitFor(clients[1], "key4", "value4")
+	if res := do(clients[1], "GET", "replica-only"); res.String() != "value" {
+		t.Errorf("expected partial resynchronisation to keep the replica's data, got %s", res.String())
+	}
+
+	// REPLICAOF NO ONE turns the replica into a primary that keeps the replicated data.
+	if ok, err := replica.ReplicaOfNoOne(); err != nil || !ok {
+		t.Fatalf("expected ok, got %v %v", ok, err)
+	}
+	if res := do(clients[1], "SET", "key5", "value5"); res.String() != "OK" {
+		t.Errorf("expected OK after REPLICAOF NO ONE, got %s", res.String())
+	}
+	if res := do(clients[1], "GET", "key4"); res.String() != "value4" {
+		t.Errorf("expected the promoted replica to keep its data, got %s", res.String())
+	}
+	if role = do(clients[1], "ROLE").Array(); len(role) != 3 || role[0].String() != "master" {
+		t.Errorf("expected master role after REPLICAOF NO ONE, got %v", role)
+	}
+}
+
+func Test_ReplicationAuth(t *testing.T) {
+	var ports [3]int
+	for i := range ports {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
+		}
+		ports[i] = port
+	}
+
+	// The primary only accepts authenticated TLS connections.
+	conf := DefaultConfig()
+	conf.DataDir = ""
+	conf.BindAddr = "localhost"
+	conf.Port = uint16(ports[0])
+	conf.ServerID = "REPLICATION-AUTH-TEST-0"
+	conf.EvictionPolicy = constants.NoEviction
+	conf.RequirePass = true
+	conf.Password = "password"
+	conf.TLS = true
+	conf.CertKeyPairs = [][]string{{
+		path.Join("..", "openssl", "server", "server1.crt"),
+		path.Join("..", "openssl", "server", "server1.key"),
+	}}
+	primary, err := NewSugarDB(WithConfig(conf))
+	if err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	go primary.Start()
+	t.Cleanup(primary.ShutDown)
+
+	var replicas [2]*SugarDB
+	for i := range replicas {
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = "localhost"
+		conf.Port = uint16(ports[i+1])
+		conf.ServerID = fmt.Sprintf("REPLICATION-AUTH-TEST-%d", i+1)
+		conf.EvictionPolicy = constants.NoEviction
+		conf.ReplicaOf = fmt.Sprintf("localhost:%d", ports[0])
+		conf.PrimaryTLS = true
+		conf.PrimaryCAs = []string{path.Join("..", "openssl", "server", "rootCA.crt")}
+		if i == 0 {
+			conf.PrimaryAuth = "password"
+		}
+		replica, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
+		}
+		go replica.Start()
+		t.Cleanup(replica.ShutDown)
+		replicas[i] = replica
+	}
+
+	if _, _, err = primary.Set("key", "value", SETOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if n, err := primary.Wait(ctx, 1); err != nil || n != 1 {
+		t.Fatalf("expected Wait() = 1, got %d %v", n, err)
+	}
+	if value, err := replicas[0].Get("key"); err != nil || value != "value" {
+		t.Errorf("expected the authenticated replica to have key=value, got %q %v", value, err)
+	}
+
+	// The replica without the password is rejected by the primary.
+	info, err := replicas[1].getReplicationInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.LinkState == replication.StateConnected {
+		t.Errorf("expected the replica without the password not to be connected")
+	}
+	if value, _ := replicas[1].Get("key"); value != "" {
+		t.Errorf("expected the replica without the password not to have key, got %q", value)
+	}
+}
+
+func Test_ActiveActive(t *testing.T) {
+	var ports [2]int
+	for i := range ports {
+		port, err := internal.GetFreePort()
+		if err != nil {
+			t.Fatalf("could not get free port: %v", err)
 		}
-		res, _, err := node.client.ReadValue()
+		ports[i] = port
+	}
+
+	if _, err := NewSugarDB(WithBootstrapCluster(), WithSiteID("a"), WithPeers([]string{"localhost:7480"})); err == nil {
+		t.Error("expected error when starting active-active replication in cluster mode")
+	}
+	if _, err := NewSugarDB(WithPeers([]string{"localhost:7480"})); err == nil {
+		t.Error("expected error when starting active-active replication without a site id")
+	}
+
+	do := func(client *resp.Conn, cmd ...string) resp.Value {
+		command := make([]resp.Value, len(cmd))
+		for i, c := range cmd {
+			command[i] = resp.StringValue(c)
+		}
+		if err := client.WriteArray(command); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := client.ReadValue()
 		if err != nil {
-			t.Error(err)
-			return
+			t.Fatal(err)
 		}
-		expected := "not cluster leader, cannot carry out command"
-		if !strings.Contains(res.Error().Error(), expected) {
-			t.Errorf("expected response to contain \"%s\", got \"%s\"", expected, res.Error().Error())
+		return res
+	}
+
+	var nodes [2]*SugarDB
+	var clients [2]*resp.Conn
+	for i, site := range []string{"a", "b"} {
+		conf := DefaultConfig()
+		conf.DataDir = ""
+		conf.BindAddr = "localhost"
+		conf.Port = uint16(ports[i])
+		conf.ServerID = fmt.Sprintf("ACTIVE-ACTIVE-TEST-%d", i)
+		conf.EvictionPolicy = constants.NoEviction
+		conf.SiteID = site
+		conf.Peers = []string{fmt.Sprintf("localhost:%d", ports[1-i])}
+
+		server, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Fatalf("could not start server: %v", err)
 		}
-	})
+		go server.Start()
 
-	t.Run("Test_SnapshotRestore", func(t *testing.T) {
-		// TODO: Test snapshot creation and restoration on the cluster.
-	})
+		conn, err := internal.GetConnection(conf.BindAddr, ports[i])
+		if err != nil {
+			t.Fatalf("could not open tcp connection: %v", err)
+		}
+		nodes[i], clients[i] = server, resp.NewConn(conn)
 
-	t.Run("Test_EvictExpiredTTL", func(t *testing.T) {
-		// TODO: Implement test for evicting expired keys on the cluster.
+		if i == 0 {
+			// Writes made before the peer is reachable are sent in the first synchronisation.
+			do(clients[0], "SET", "before", "value")
+		}
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.ShutDown()
+		}
 	})
 
-	t.Run("Test_GetServerInfo", func(t *testing.T) {
-		nodeInfo := []internal.ServerInfo{
-			{
-				Server:     "sugardb",
-				Version:    constants.Version,
-				Id:         nodes[0].serverId,
-				Mode:       "cluster",
-				Role:       "master",
-				Modules:    nodes[0].server.ListModules(),
-				MemoryUsed: nodes[0].server.memUsed,
-				MaxMemory:  nodes[0].server.config.MaxMemory,
-			},
-			{
-				Server:     "sugardb",
-				Version:    constants.Version,
-				Id:         nodes[1].serverId,
-				Mode:       "cluster",
-				Role:       "replica",
-				Modules:    nodes[1].server.ListModules(),
-				MemoryUsed: nodes[1].server.memUsed,
-				MaxMemory:  nodes[1].server.config.MaxMemory,
-			},
-			{
-				Server:     "sugardb",
-				Version:    constants.Version,
-				Id:         nodes[2].serverId,
-				Mode:       "cluster",
-				Role:       "replica",
-				Modules:    nodes[2].server.ListModules(),
-				MemoryUsed: nodes[2].server.memUsed,
-				MaxMemory:  nodes[2].server.config.MaxMemory,
-			},
-			{
-				Server:     "sugardb",
-				Version:    constants.Version,
-				Id:         nodes[3].serverId,
-				Mode:       "cluster",
-				Role:       "replica",
-				Modules:    nodes[3].server.ListModules(),
-				MemoryUsed: nodes[3].server.memUsed,
-				MaxMemory:  nodes[3].server.config.MaxMemory,
-			},
-			{
-				Server:     "sugardb",
-				Version:    constants.Version,
-				Id:         nodes[4].serverId,
-				Mode:       "cluster",
-				Role:       "replica",
-				Modules:    nodes[4].server.ListModules(),
-				MemoryUsed: nodes[4].server.memUsed,
-				MaxMemory:  nodes[4].server.config.MaxMemory,
-			},
-		}
-		for i := 0; i < len(nodes); i++ {
-			if diff := deep.Equal(nodes[i].server.GetServerInfo(), nodeInfo[i]); diff != nil {
-				t.Errorf("GetServerInfo() - node %d: %+v expected %v got %v", i, err, nodes[i].server.GetServerInfo(), nodeInfo[i])
-				return
+	waitFor := func(cmd []string, expected string) {
+		for _, client := range clients {
+			for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+				res := do(client, cmd...)
+				var got string
+				if res.Type() == resp.Array {
+					var values []string
+					for _, value := range res.Array() {
+						values = append(values, value.String())
+					}
+					slices.Sort(values)
+					got = strings.Join(values, ",")
+				} else {
+					got = res.String()
+				}
+				if got == expected {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("expected %v to return %s, got %s", cmd, expected, got)
+				}
 			}
 		}
-	})
+	}
+
+	waitFor([]string{"GET", "before"}, "value")
+
+	// Both sites accept writes, which are replicated to the other site.
+	do(clients[0], "SET", "a-key", "a")
+	do(clients[1], "SET", "b-key", "b")
+	waitFor([]string{"GET", "a-key"}, "a")
+	waitFor([]string{"GET", "b-key"}, "b")
+
+	// Writes of the same key are resolved per type.
+	do(clients[0], "INCRBY", "counter", "5")
+	do(clients[1], "DECRBY", "counter", "2")
+	do(clients[0], "SADD", "set", "one", "two")
+	do(clients[1], "SADD", "set", "three")
+	do(clients[0], "HSET", "hash", "f1", "a")
+	do(clients[1], "HSET", "hash", "f2", "b")
+	waitFor([]string{"GET", "counter"}, "3")
+	waitFor([]string{"SMEMBERS", "set"}, "one,three,two")
+	waitFor([]string{"HGETALL", "hash"}, "a,b,f1,f2")
+
+	do(clients[1], "SREM", "set", "two")
+	do(clients[0], "DEL", "a-key")
+	waitFor([]string{"SMEMBERS", "set"}, "one,three")
+	waitFor([]string{"EXISTS", "a-key"}, "0")
+
+	// The metadata is counted in the memory usage, and the metadata of the deleted keys is pruned once it expires.
+	stats := nodes[0].MemoryStats()
+	if stats.ActiveActive.Keys == 0 || stats.ActiveActive.Bytes == 0 {
+		t.Errorf("expected the active-active metadata in the memory stats, got %+v", stats.ActiveActive)
+	}
+	nodes[0].pruneActiveActive()
+	if used := nodes[0].MemoryStats().TotalAllocated; used <= stats.TotalAllocated {
+		t.Errorf("expected the metadata to be added to the memory usage of %d bytes, got %d", stats.TotalAllocated, used)
+	}
+	if pruned := nodes[0].activeActive.state.Prune(time.Now().Add(time.Hour).UnixMilli()); pruned == 0 {
+		t.Error("expected the metadata of a-key to be pruned")
+	}
+	if keys := nodes[0].MemoryStats().ActiveActive.Keys; keys >= stats.ActiveActive.Keys {
+		t.Errorf("expected fewer than %d keys with metadata after pruning, got %d", stats.ActiveActive.Keys, keys)
+	}
+	do(clients[1], "SET", "a-key", "b")
+	waitFor([]string{"GET", "a-key"}, "b")
+
+	// Writes are replicated in the database they were made in.
+	do(clients[1], "SELECT", "1")
+	do(clients[1], "SET", "db1-key", "value")
+	do(clients[0], "SELECT", "1")
+	waitFor([]string{"GET", "db1-key"}, "value")
+
+	// A site can't become a replica.
+	if res := do(clients[0], "REPLICAOF", "localhost", strconv.Itoa(ports[1])); res.Error() == nil {
+		t.Error("expected REPLICAOF to fail with active-active replication")
+	}
 }
 
 func Test_Standalone(t *testing.T) {
@@ -1161,6 +3672,212 @@ func Test_Standalone(t *testing.T) {
 		}
 	})
 
+	t.Run("Test_AOFDeterministicReplay", func(t *testing.T) {
+		t.Parallel()
+
+		dataDir := path.Join(".", "testdata", "test_aof_deterministic")
+		t.Cleanup(func() {
+			_ = os.RemoveAll(dataDir)
+		})
+
+		conf := DefaultConfig()
+		conf.RestoreAOF = true
+		conf.DataDir = dataDir
+		conf.AOFSyncStrategy = "always"
+
+		mockServer, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, _, err = mockServer.Set("key1", "value1", SETOptions{}); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err = mockServer.Expire("key1", 60); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err = mockServer.SAdd("key2", "one", "two", "three", "four", "five", "six"); err != nil {
+			t.Error(err)
+			return
+		}
+		popped, err := mockServer.SPop("key2", 3)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(popped) != 3 {
+			t.Errorf("expected 3 popped members, got %d", len(popped))
+			return
+		}
+
+		wantExpireTime, err := mockServer.PExpireTime("key1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		wantMembers, err := mockServer.SMembers("key2")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		mockServer.ShutDown()
+
+		// The AOF log should only contain the deterministic form of the commands.
+		b, err := os.ReadFile(path.Join(dataDir, "aof", "log.aof"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, want := range []string{"PEXPIREAT", "MEMBERS"} {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("expected AOF log to contain %s, got %q", want, string(b))
+			}
+		}
+		for _, unwanted := range []string{"EXPIRE\r\n", "SPOP\r\n$4\r\nkey2\r\n$1\r\n3\r\n"} {
+			if strings.Contains(string(b), unwanted) {
+				t.Errorf("expected AOF log not to contain %q, got %q", unwanted, string(b))
+			}
+		}
+
+		// Restore the state from the AOF log and make sure it's identical.
+		mockServer, err = NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer mockServer.ShutDown()
+
+		expireTime, err := mockServer.PExpireTime("key1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if expireTime != wantExpireTime {
+			t.Errorf("expected restored expiry time %d, got %d", wantExpireTime, expireTime)
+		}
+
+		members, err := mockServer.SMembers("key2")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = compareSlices(members, wantMembers); err != nil {
+			t.Errorf("restored set members: %v", err)
+		}
+	})
+
+	t.Run("Test_AOFEncryptionAtRest", func(t *testing.T) {
+		t.Parallel()
+
+		dataDir := path.Join(".", "testdata", "test_aof_encryption")
+		t.Cleanup(func() {
+			_ = os.RemoveAll(dataDir)
+		})
+		if err := os.MkdirAll(dataDir, os.ModePerm); err != nil {
+			t.Error(err)
+			return
+		}
+
+		oldKey := strings.Repeat("01", 32)
+		newKey := strings.Repeat("02", 32)
+		keyFile := path.Join(dataDir, "keys")
+		writeKeys := func(keys ...string) {
+			if err := os.WriteFile(keyFile, []byte(strings.Join(keys, "\n")), os.ModePerm); err != nil {
+				t.Error(err)
+			}
+		}
+
+		conf := DefaultConfig()
+		conf.RestoreAOF = true
+		conf.DataDir = dataDir
+		conf.AOFSyncStrategy = "always"
+		conf.EncryptionKeyFile = keyFile
+
+		assertRestored := func(mockServer *SugarDB, values map[string]string) {
+			for key, value := range values {
+				res, err := mockServer.Get(key)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res != value {
+					t.Errorf("expected value at key \"%s\" to be \"%s\", got \"%s\"", key, value, res)
+				}
+			}
+		}
+		assertEncrypted := func() {
+			for _, file := range []string{"preamble.bin", "log.aof"} {
+				b, err := os.ReadFile(path.Join(dataDir, "aof", file))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if strings.Contains(string(b), "secret") {
+					t.Errorf("expected %s to be encrypted, got %q", file, string(b))
+				}
+			}
+		}
+
+		writeKeys(oldKey)
+		mockServer, err := NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, _, err = mockServer.Set("key1", "secret1", SETOptions{}); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err = mockServer.RewriteAOF(); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, _, err = mockServer.Set("key2", "secret2", SETOptions{}); err != nil {
+			t.Error(err)
+			return
+		}
+		mockServer.ShutDown()
+		assertEncrypted()
+
+		// Without the key, the server should refuse to start instead of ignoring the encrypted files.
+		withoutKey := conf
+		withoutKey.EncryptionKeyFile = ""
+		if _, err = NewSugarDB(WithConfig(withoutKey)); err == nil {
+			t.Error("expected error when starting without the encryption key")
+			return
+		}
+
+		// Rotate the key. The files encrypted with the old key can still be read and the next
+		// rewrite re-encrypts them with the new key.
+		writeKeys(newKey, oldKey)
+		mockServer, err = NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		assertRestored(mockServer, map[string]string{"key1": "secret1", "key2": "secret2"})
+		if _, err = mockServer.RewriteAOF(); err != nil {
+			t.Error(err)
+			return
+		}
+		mockServer.ShutDown()
+		assertEncrypted()
+
+		// Retire the old key.
+		writeKeys(newKey)
+		mockServer, err = NewSugarDB(WithConfig(conf))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer mockServer.ShutDown()
+		assertRestored(mockServer, map[string]string{"key1": "secret1", "key2": "secret2"})
+	})
+
 	t.Run("Test_EvictExpiredTTL", func(t *testing.T) {
 		// TODO: Implement test for evicting expired keys in standalone mode.
 	})
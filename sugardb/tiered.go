@@ -0,0 +1,294 @@
+// Copyright 2024 Kelvin Clement Mwinuka
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sugardb
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/echovault/sugardb/internal"
+	"github.com/echovault/sugardb/internal/modules/hash"
+	"github.com/echovault/sugardb/internal/modules/list"
+	"github.com/echovault/sugardb/internal/modules/set"
+	"github.com/echovault/sugardb/internal/modules/sorted_set"
+	"github.com/echovault/sugardb/internal/tiered"
+)
+
+// spilledValue replaces the value of a key in the keyspace once the value is spilled to the tiered store.
+// The key keeps its expiry and access metadata in memory.
+type spilledValue struct {
+	id uint64 // The id of the value in the tiered store.
+}
+
+// openTieredStore validates the config of the tiered mode and opens the tiered store under the data directory.
+func (server *SugarDB) openTieredStore() error {
+	switch {
+	case server.isInCluster():
+		return errors.New("tiered storage is only supported in standalone mode")
+	case server.config.DataDir == "":
+		return errors.New("tiered storage requires a data directory")
+	case server.config.MaxMemory == 0:
+		return errors.New("tiered storage requires a max memory")
+	case !server.tracksAccess():
+		return errors.New("tiered storage requires an LFU or LRU eviction policy")
+	}
+	store, err := tiered.Open(filepath.Join(server.config.DataDir, "tiered", "values.db"), server.keyring)
+	if err != nil {
+		return err
+	}
+	server.tiered.store = store
+	return nil
+}
+
+// closeTieredStore stops the tiered goroutine and removes the tiered store from disk.
+func (server *SugarDB) closeTieredStore() {
+	if server.tiered.store == nil {
+		return
+	}
+	server.tiered.stop <- struct{}{}
+	if err := server.tiered.store.Close(); err != nil {
+		log.Printf("close tiered store: %v\n", err)
+	}
+}
+
+// spillable returns true when the value can be spilled. Numbers take as much memory as the value that replaces
+// them, and the fields of a hash with an expiry must stay in memory to be expired.
+func spillable(value interface{}) bool {
+	switch v := value.(type) {
+	case string, []string, *hash.Listpack, *list.Listpack, *set.Set, *sorted_set.SortedSet:
+		return true
+	case hash.Hash:
+		for _, field := range v {
+			if field.ExpireAt != (time.Time{}) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeSpilled serializes a value that is spillable.
+func encodeSpilled(value interface{}) ([]byte, error) {
+	record, err := newValueRecord(value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSpilled deserializes a value written by encodeSpilled.
+func decodeSpilled(b []byte) (interface{}, error) {
+	var record valueRecord
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&record); err != nil {
+		return nil, err
+	}
+	return record.value()
+}
+
+// loadSpilled reads the spilled value with the id from the tiered store.
+func (server *SugarDB) loadSpilled(id uint64) (interface{}, error) {
+	b, err := server.tiered.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSpilled(b)
+}
+
+// readSpilled returns the value, reading it from the tiered store when it's spilled. The value is not loaded back
+// into memory, so it's used by the reads that don't count as an access to the key.
+func (server *SugarDB) readSpilled(value interface{}) (interface{}, error) {
+	v, ok := value.(spilledValue)
+	if !ok {
+		return value, nil
+	}
+	loaded, err := server.loadSpilled(v.id)
+	if err != nil {
+		return nil, fmt.Errorf("read spilled value: %v", err)
+	}
+	return loaded, nil
+}
+
+// restoreSpilled loads the spilled value of the entry back into memory. It returns the entry unchanged when its
+// value is not spilled. The shard of the key must be locked for writing by the caller.
+func (server *SugarDB) restoreSpilled(db *database, key string, entry internal.KeyData) (internal.KeyData, error) {
+	v, ok := entry.Value.(spilledValue)
+	if !ok {
+		return entry, nil
+	}
+	value, err := server.loadSpilled(v.id)
+	if err != nil {
+		return entry, err
+	}
+	entry.Value = server.encodeValue(value)
+	db.put(key, entry)
+	server.accountKey(db, key)
+	server.releaseSpilled(v)
+	server.tiered.faults.Add(1)
+	// The value loaded back may take the memory usage over the max memory, in which case colder values are spilled.
+	server.wakeTiered()
+	return entry, nil
+}
+
+// spillKey writes the value of the key to the tiered store and replaces it with a reference to the store. It returns
+// false when the value can't be spilled. The write commands must be held back by the caller, as they may hold a
+// reference to the value that they update in place.
+func (server *SugarDB) spillKey(db *database, key string) (bool, error) {
+	unlock := db.lock(key)
+	defer unlock()
+	entry, ok := db.get(key)
+	if !ok || !spillable(entry.Value) {
+		return false, nil
+	}
+	b, err := encodeSpilled(entry.Value)
+	if err != nil {
+		return false, err
+	}
+	id := server.tiered.nextID.Add(1)
+	if err = server.tiered.store.Put(id, b); err != nil {
+		return false, err
+	}
+	entry.Value = spilledValue{id: id}
+	db.put(key, entry)
+	server.accountKey(db, key)
+	server.tiered.keys.Add(1)
+	server.tiered.spills.Add(1)
+	return true, nil
+}
+
+// releaseSpilled deletes the value from the tiered store when it's spilled. It's called once the reference to
+// the store is removed from the keyspace.
+func (server *SugarDB) releaseSpilled(value interface{}) {
+	v, ok := value.(spilledValue)
+	if !ok {
+		return
+	}
+	server.tiered.keys.Add(-1)
+	server.tiered.mut.Lock()
+	server.tiered.garbage = append(server.tiered.garbage, v.id)
+	server.tiered.mut.Unlock()
+	server.wakeTiered()
+}
+
+// startTieredCopy holds back the deletion of the released values while a copy of the state reads the spilled
+// values. It must be called while all the shards are locked, so that the copy only references values on disk.
+func (server *SugarDB) startTieredCopy() {
+	if server.tiered.store == nil {
+		return
+	}
+	server.tiered.mut.Lock()
+	server.tiered.copies++
+	server.tiered.mut.Unlock()
+}
+
+// finishTieredCopy lets the released values be deleted once the copies in progress are complete.
+func (server *SugarDB) finishTieredCopy() {
+	if server.tiered.store == nil {
+		return
+	}
+	server.tiered.mut.Lock()
+	server.tiered.copies--
+	server.tiered.mut.Unlock()
+	server.wakeTiered()
+}
+
+// wakeTiered wakes up the tiered goroutine, unless it was already woken up.
+func (server *SugarDB) wakeTiered() {
+	if server.tiered.store == nil {
+		return
+	}
+	select {
+	case server.tiered.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runTiered deletes the released values from the tiered store and spills the values of the keys picked by the
+// eviction policy while the max memory is exceeded, until the server is shut down.
+func (server *SugarDB) runTiered() {
+	for {
+		select {
+		case <-server.tiered.stop:
+			return
+		case <-server.tiered.wake:
+		}
+
+		server.deleteReleased()
+
+		for server.isMaxMemoryExceeded() {
+			// The write commands are held back for each batch, so that a command doesn't update a value that's
+			// being spilled.
+			server.writeGate.close()
+			spilled := server.spillBatch()
+			server.writeGate.open()
+			if spilled == 0 {
+				// The values of all the sampled keys are spilled already.
+				break
+			}
+		}
+	}
+}
+
+// deleteReleased deletes the released values from the tiered store, unless a copy of the state is in progress.
+func (server *SugarDB) deleteReleased() {
+	server.tiered.mut.Lock()
+	if server.tiered.copies > 0 {
+		server.tiered.mut.Unlock()
+		return
+	}
+	ids := server.tiered.garbage
+	server.tiered.garbage = nil
+	server.tiered.mut.Unlock()
+	if err := server.tiered.store.Delete(ids...); err != nil {
+		log.Printf("delete released values: %v\n", err)
+	}
+}
+
+// spillBatch spills the values of up to the eviction sample size of keys in each database, picked by the eviction
+// policy. It stops once the memory usage is below the max memory and returns the number of values spilled.
+func (server *SugarDB) spillBatch() int {
+	samples := max(int(server.config.EvictionSample), 1)
+	spilled := 0
+	for _, database := range server.getDatabases() {
+		db := server.getDatabase(database)
+		ctx := context.WithValue(context.Background(), "Database", database)
+		for i := 0; i < samples && server.isMaxMemoryExceeded(); i++ {
+			key, ok := server.evictionCandidate(ctx)
+			if !ok {
+				break
+			}
+			ok, err := server.spillKey(db, key)
+			if err != nil {
+				log.Printf("spill key %s: %v\n", key, err)
+				continue
+			}
+			if ok {
+				spilled++
+			}
+		}
+	}
+	return spilled
+}